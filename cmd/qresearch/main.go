@@ -19,6 +19,7 @@ func init() {
 
 	app.Commands = []*cli.Command{
 		superTrendCommand,
+		pairsCommand,
 	}
 	app.Flags = []cli.Flag{
 		utils.ConfigFlag,
@@ -6,6 +6,7 @@ import (
 	"github.com/xyths/hs"
 	"github.com/xyths/qtr/cmd/utils"
 	"github.com/xyths/qtr/research"
+	"github.com/xyths/qtr/trader/rest/pairs"
 	"time"
 )
 
@@ -47,6 +48,58 @@ var (
 	}
 )
 
+var (
+	pairsCommand = &cli.Command{
+		Action: pairsBacktest,
+		Name:   "pairs",
+		Usage:  "Backtest the pairs trading, input is traded against input2",
+		Flags: []cli.Flag{
+			utils.InputCsvFlag,
+			input2Flag,
+			windowFlag,
+			entryFlag,
+			exitFlag,
+			stopFlag,
+			feeFlag,
+			totalFlag,
+			utils.OutputCsvFlag,
+		},
+	}
+)
+
+var (
+	input2Flag = &cli.StringFlag{
+		Name:  "input2",
+		Usage: "read the hedge symbol data from `csv`",
+		Value: "input2.csv",
+	}
+	windowFlag = &cli.IntFlag{
+		Name:  "window",
+		Usage: "rolling `window` of the hedge ratio and z-score",
+		Value: 100,
+	}
+	entryFlag = &cli.Float64Flag{
+		Name:  "entry",
+		Usage: "enter when |z| exceeds `entry`",
+		Value: 2,
+	}
+	exitFlag = &cli.Float64Flag{
+		Name:  "exit",
+		Usage: "exit when |z| reverts within `exit`",
+		Value: 0.5,
+	}
+	stopFlag = &cli.Float64Flag{
+		Name:  "stop",
+		Usage: "stop when |z| exceeds `stop`, 0 means no stop",
+		Value: 4,
+	}
+	feeFlag = &cli.Float64Flag{
+		Name:  "fee",
+		Usage: "`fee` rate of every leg",
+		Value: 0.002,
+	}
+)
+
 var (
 	factorFlag = &cli.Float64Flag{
 		Name:  "factor",
@@ -143,3 +196,24 @@ func window(ctx *cli.Context) error {
 
 	return r.SuperTrendWindow(input, factor, period, start, length, step, initial, output)
 }
+
+func pairsBacktest(ctx *cli.Context) error {
+	cfgFile := ctx.String(utils.ConfigFlag.Name)
+	cfg := research.Config{}
+	if err := hs.ParseJsonConfig(cfgFile, &cfg); err != nil {
+		return err
+	}
+	conf := pairs.BacktestConf{
+		Window: ctx.Int(windowFlag.Name),
+		Entry:  ctx.Float64(entryFlag.Name),
+		Exit:   ctx.Float64(exitFlag.Name),
+		Stop:   ctx.Float64(stopFlag.Name),
+		Fee:    ctx.Float64(feeFlag.Name),
+		Total:  ctx.Float64(totalFlag.Name),
+	}
+	r := research.NewResearch(cfg)
+	if err := r.Init(); err != nil {
+		return err
+	}
+	return r.Pairs(ctx.String(utils.InputCsvFlag.Name), ctx.String(input2Flag.Name), conf, ctx.String(utils.OutputCsvFlag.Name))
+}
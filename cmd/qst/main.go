@@ -27,6 +27,14 @@ func init() {
 			Name:   "print",
 			Usage:  "Print the SuperTrend state",
 		},
+		{
+			Action: superTrendRun,
+			Name:   "run",
+			Usage:  "Run the SuperTrend strategy by the strategy runner, in live, paper or backtest mode",
+			Flags: []cli.Flag{
+				utils.BacktestFlag,
+			},
+		},
 		{
 			Action: superTrendClear,
 			Name:   "clear",
@@ -38,6 +46,7 @@ func init() {
 	}
 	app.Flags = []cli.Flag{
 		utils.ConfigFlag,
+		utils.PaperFlag,
 	}
 }
 
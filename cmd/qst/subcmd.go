@@ -4,19 +4,29 @@ import (
 	"github.com/urfave/cli/v2"
 	"github.com/xyths/hs"
 	"github.com/xyths/qtr/cmd/utils"
+	"github.com/xyths/qtr/sdk"
+	"github.com/xyths/qtr/strategy"
 	"github.com/xyths/qtr/trader/super"
 )
 
+type runConfig struct {
+	sdk.Config
+	Strategy strategy.SuperTrendConf
+}
+
 func superTrend(ctx *cli.Context) error {
 	configFile := ctx.String(utils.ConfigFlag.Name)
 	cfg := super.Config{}
 	if err := hs.ParseJsonConfig(configFile, &cfg); err != nil {
 		return err
 	}
-	t, err := super.NewRestTraderFromConfig(ctx.Context, cfg)
+	t, err := super.NewMultiRestTraderFromConfig(ctx.Context, cfg)
 	if err != nil {
 		return err
 	}
+	if ctx.Bool(utils.PaperFlag.Name) {
+		t.UsePaper()
+	}
 	if err := t.Init(ctx.Context); err != nil {
 		return err
 	}
@@ -31,10 +41,13 @@ func superTrendPrint(ctx *cli.Context) error {
 	if err := hs.ParseJsonConfig(configFile, &cfg); err != nil {
 		return err
 	}
-	t, err := super.NewRestTraderFromConfig(ctx.Context, cfg)
+	t, err := super.NewMultiRestTraderFromConfig(ctx.Context, cfg)
 	if err != nil {
 		return err
 	}
+	if ctx.Bool(utils.PaperFlag.Name) {
+		t.UsePaper()
+	}
 	if err := t.Init(ctx.Context); err != nil {
 		return err
 	}
@@ -50,13 +63,43 @@ func superTrendClear(ctx *cli.Context) error {
 	}
 	dry := ctx.Bool(utils.DryRunFlag.Name)
 	_ = dry
-	t, err := super.NewRestTraderFromConfig(ctx.Context, cfg)
+	t, err := super.NewMultiRestTraderFromConfig(ctx.Context, cfg)
 	if err != nil {
 		return err
 	}
+	if ctx.Bool(utils.PaperFlag.Name) {
+		t.UsePaper()
+	}
 	if err := t.Init(ctx.Context); err != nil {
 		return err
 	}
 	defer t.Close(ctx.Context)
 	return t.Clear(ctx.Context)
 }
+
+func superTrendRun(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	cfg := runConfig{}
+	if err := hs.ParseJsonConfig(configFile, &cfg); err != nil {
+		return err
+	}
+	s, err := strategy.NewSuperTrendStrategy(cfg.Strategy)
+	if err != nil {
+		return err
+	}
+	mode := sdk.ModeLive
+	if ctx.Bool(utils.BacktestFlag.Name) {
+		mode = sdk.ModeBacktest
+	} else if ctx.Bool(utils.PaperFlag.Name) {
+		mode = sdk.ModePaper
+	}
+	r, err := sdk.NewRunner(cfg.Config, s, mode)
+	if err != nil {
+		return err
+	}
+	if err := r.Init(ctx.Context); err != nil {
+		return err
+	}
+	defer r.Close(ctx.Context)
+	return r.Start(ctx.Context)
+}
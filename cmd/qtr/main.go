@@ -23,6 +23,10 @@ func init() {
 	app.Commands = []*cli.Command{
 		gridCommand,
 		multiGridCommand,
+		dcaCommand,
+		pairsCommand,
+		crossCommand,
+		triangleCommand,
 		turtleCommand,
 		superTrendCommand,
 		sniperCommand,
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"github.com/urfave/cli/v2"
 	"github.com/xyths/hs"
 	"github.com/xyths/qtr/cmd/utils"
@@ -61,3 +62,22 @@ func gridScan(ctx *cli.Context) error {
 	}
 	return agent.Grid(ctx.Context, ctx.Args().Slice(), size, output)
 }
+
+func ruleScan(ctx *cli.Context) error {
+	cfgFile := ctx.String(utils.ConfigFlag.Name)
+	cfg := ta.Config{}
+	if err := hs.ParseJsonConfig(cfgFile, &cfg); err != nil {
+		return err
+	}
+	output := ctx.String(utils.OutputCsvFlag.Name)
+	size := ctx.Int64(utils.SizeFlag.Name)
+	text := ctx.String(utils.ScanRuleFlag.Name)
+	if text == "" {
+		return errors.New("rule is required")
+	}
+	agent := ta.NewAgent(cfg)
+	if err := agent.Init(); err != nil {
+		return err
+	}
+	return agent.Rule(ctx.Context, ctx.Args().Slice(), size, text, output)
+}
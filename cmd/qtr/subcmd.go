@@ -1,15 +1,22 @@
 package main
 
 import (
+	"errors"
 	"github.com/urfave/cli/v2"
 	"github.com/xyths/hs"
 	"github.com/xyths/hs/logger"
 	"github.com/xyths/qtr/cmd/utils"
 	"github.com/xyths/qtr/history"
 	"github.com/xyths/qtr/node"
+	"github.com/xyths/qtr/sdk"
+	"github.com/xyths/qtr/strategy"
 	"github.com/xyths/qtr/ta"
 	"github.com/xyths/qtr/ta/atr"
+	"github.com/xyths/qtr/trader/arbitrage"
+	"github.com/xyths/qtr/trader/rest"
+	"github.com/xyths/qtr/trader/rest/dca"
 	"github.com/xyths/qtr/trader/rest/grid"
+	"github.com/xyths/qtr/trader/rest/pairs"
 	"github.com/xyths/qtr/trader/rest/turtle"
 	"github.com/xyths/qtr/trader/super"
 	"github.com/xyths/qtr/trader/ws"
@@ -54,12 +61,12 @@ var (
 			{
 				Action: mGridPrint,
 				Name:   "print",
-				Usage:  "Print the grid generated by strategy parameters",
+				Usage:  "Print the status and PnL of all running grids",
 			},
 			{
 				Action: mGridRebalance,
 				Name:   "rebalance",
-				Usage:  "rebalance before run the grid generated",
+				Usage:  "cancel orders, rebalance and place the orders again for all running grids",
 				Flags: []cli.Flag{
 					utils.DryRunFlag,
 				},
@@ -67,7 +74,7 @@ var (
 			{
 				Action: mGridClear,
 				Name:   "clear",
-				Usage:  "clear all grids, cancel orders and reset base",
+				Usage:  "clear all running grids, cancel orders and sell the coin",
 				Flags: []cli.Flag{
 					utils.DryRunFlag,
 				},
@@ -77,6 +84,70 @@ var (
 			utils.DryRunFlag,
 		},
 	}
+	dcaCommand = &cli.Command{
+		Action: dcaAction,
+		Name:   "dca",
+		Usage:  "Dollar-cost averaging, buy every interval and take profit above the average cost",
+		Subcommands: []*cli.Command{
+			{
+				Action: dcaPrint,
+				Name:   "print",
+				Usage:  "Print the position, average cost and PnL",
+			},
+			{
+				Action: dcaClear,
+				Name:   "clear",
+				Usage:  "clear the position state in database",
+			},
+		},
+		Flags: []cli.Flag{
+			utils.DryRunFlag,
+		},
+	}
+	pairsCommand = &cli.Command{
+		Action: pairsAction,
+		Name:   "pairs",
+		Usage:  "Pairs trading, long and short two correlated symbols when the spread diverges",
+		Subcommands: []*cli.Command{
+			{
+				Action: pairsPrint,
+				Name:   "print",
+				Usage:  "Print the hedge ratio, z-score and the legs",
+			},
+			{
+				Action: pairsClear,
+				Name:   "clear",
+				Usage:  "clear the legs state in database",
+			},
+		},
+		Flags: []cli.Flag{
+			utils.DryRunFlag,
+		},
+	}
+	crossCommand = &cli.Command{
+		Action: crossAction,
+		Name:   "cross",
+		Usage:  "Monitor the spread of the same asset between exchanges, and arbitrage with the pre-funded balances",
+		Subcommands: []*cli.Command{
+			{
+				Action: crossReport,
+				Name:   "report",
+				Usage:  "Print the inventory on every exchange and the transfer to rebalance it",
+			},
+		},
+	}
+	triangleCommand = &cli.Command{
+		Action: triangleAction,
+		Name:   "triangle",
+		Usage:  "Scan the triangular arbitrage on one exchange",
+		Subcommands: []*cli.Command{
+			{
+				Action: trianglePrint,
+				Name:   "print",
+				Usage:  "Scan once and print the best cycles",
+			},
+		},
+	}
 	turtleCommand = &cli.Command{
 		Action: turtleAction,
 		Name:   "turtle",
@@ -157,15 +228,25 @@ var (
 			{
 				Action: rtmClear,
 				Name:   "clear",
-				Usage:  "clear all RTM state in database, cancel pending orders",
+				Usage:  "cancel pending RTM orders",
 				Flags: []cli.Flag{
-					utils.DryRunFlag,
+					utils.PaperFlag,
+				},
+			},
+			{
+				Action: rtmRun,
+				Name:   "run",
+				Usage:  "Run the RTM strategy by the strategy runner, in live, paper or backtest mode",
+				Flags: []cli.Flag{
+					utils.PaperFlag,
+					utils.BacktestFlag,
 				},
 			},
 		},
 		Flags: []cli.Flag{
 			utils.ProtocolFlag,
 			utils.DryRunFlag,
+			utils.PaperFlag,
 		},
 	}
 	taCommand = &cli.Command{
@@ -229,6 +310,14 @@ var (
 				Name:   "grid",
 				Usage:  "Scan for grid strategy, filter by SuperTrend and Squeeze indicator",
 			},
+			{
+				Action: ruleScan,
+				Name:   "rule",
+				Usage:  "Scan by rule, eg. --rule \"squeeze(W).trend == 2 && natr(D, 14) > 3\"",
+				Flags: []cli.Flag{
+					utils.ScanRuleFlag,
+				},
+			},
 		},
 	}
 	historyCommand = &cli.Command{
@@ -340,13 +429,138 @@ func mGridAction(ctx *cli.Context) error {
 }
 
 func mGridPrint(ctx *cli.Context) error {
-	return nil
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	t, err := grid.NewMultipleGridTrader(ctx.Context, configFile, true)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Print(ctx.Context)
 }
+
 func mGridRebalance(ctx *cli.Context) error {
-	return nil
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	dryRun := ctx.Bool(utils.DryRunFlag.Name)
+	t, err := grid.NewMultipleGridTrader(ctx.Context, configFile, dryRun)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	_ = t.Print(ctx.Context)
+	return t.Rebalance(ctx.Context, dryRun)
 }
+
 func mGridClear(ctx *cli.Context) error {
-	return nil
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	dryRun := ctx.Bool(utils.DryRunFlag.Name)
+	t, err := grid.NewMultipleGridTrader(ctx.Context, configFile, dryRun)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Clear(ctx.Context, dryRun)
+}
+
+func dcaAction(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	dry := ctx.Bool(utils.DryRunFlag.Name)
+	t, err := dca.NewTrader(ctx.Context, configFile, dry)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Start(ctx.Context)
+}
+
+func dcaPrint(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	t, err := dca.NewTrader(ctx.Context, configFile, true)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Print(ctx.Context)
+}
+
+func dcaClear(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	t, err := dca.NewTrader(ctx.Context, configFile, true)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Clear(ctx.Context)
+}
+
+func pairsAction(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	dry := ctx.Bool(utils.DryRunFlag.Name)
+	t, err := pairs.NewTrader(ctx.Context, configFile, dry)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Start(ctx.Context)
+}
+
+func pairsPrint(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	t, err := pairs.NewTrader(ctx.Context, configFile, true)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Print(ctx.Context)
+}
+
+func pairsClear(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	t, err := pairs.NewTrader(ctx.Context, configFile, true)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Clear(ctx.Context)
+}
+
+func crossAction(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	t, err := arbitrage.NewCrossTrader(ctx.Context, configFile)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Start(ctx.Context)
+}
+
+func crossReport(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	t, err := arbitrage.NewCrossTrader(ctx.Context, configFile)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Report(ctx.Context)
+}
+
+func triangleAction(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	t, err := arbitrage.NewTriangleTrader(ctx.Context, configFile)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Start(ctx.Context)
+}
+
+func trianglePrint(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	t, err := arbitrage.NewTriangleTrader(ctx.Context, configFile)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Print(ctx.Context)
 }
 
 func pull(ctx *cli.Context) error {
@@ -454,7 +668,7 @@ func turtleClearAction(ctx *cli.Context) error {
 func superTrend(ctx *cli.Context) error {
 	configFile := ctx.String(utils.ConfigFlag.Name)
 	dry := ctx.Bool(utils.DryRunFlag.Name)
-	t, err := super.NewWsTrader(ctx.Context, configFile)
+	t, err := super.NewMultiWsTrader(ctx.Context, configFile)
 	if err != nil {
 		return err
 	}
@@ -467,7 +681,7 @@ func superTrend(ctx *cli.Context) error {
 
 func superTrendPrint(ctx *cli.Context) error {
 	configFile := ctx.String(utils.ConfigFlag.Name)
-	t, err := super.NewWsTrader(ctx.Context, configFile)
+	t, err := super.NewMultiWsTrader(ctx.Context, configFile)
 	if err != nil {
 		return err
 	}
@@ -477,7 +691,7 @@ func superTrendPrint(ctx *cli.Context) error {
 
 func superTrendClear(ctx *cli.Context) error {
 	configFile := ctx.String(utils.ConfigFlag.Name)
-	t, err := super.NewWsTrader(ctx.Context, configFile)
+	t, err := super.NewMultiWsTrader(ctx.Context, configFile)
 	if err != nil {
 		return err
 	}
@@ -488,7 +702,7 @@ func superTrendClear(ctx *cli.Context) error {
 func sniper(ctx *cli.Context) error {
 	configFile := ctx.String(utils.ConfigFlag.Name)
 	dry := ctx.Bool(utils.DryRunFlag.Name)
-	t, err := super.NewWsTrader(ctx.Context, configFile)
+	t, err := super.NewMultiWsTrader(ctx.Context, configFile)
 	if err != nil {
 		return err
 	}
@@ -501,7 +715,7 @@ func sniper(ctx *cli.Context) error {
 
 func sniperPrint(ctx *cli.Context) error {
 	configFile := ctx.String(utils.ConfigFlag.Name)
-	t, err := super.NewWsTrader(ctx.Context, configFile)
+	t, err := super.NewMultiWsTrader(ctx.Context, configFile)
 	if err != nil {
 		return err
 	}
@@ -511,7 +725,7 @@ func sniperPrint(ctx *cli.Context) error {
 
 func sniperClear(ctx *cli.Context) error {
 	configFile := ctx.String(utils.ConfigFlag.Name)
-	t, err := super.NewWsTrader(ctx.Context, configFile)
+	t, err := super.NewMultiWsTrader(ctx.Context, configFile)
 	if err != nil {
 		return err
 	}
@@ -522,7 +736,24 @@ func sniperClear(ctx *cli.Context) error {
 func rtm(ctx *cli.Context) error {
 	configFile := ctx.String(utils.ConfigFlag.Name)
 	dry := ctx.Bool(utils.DryRunFlag.Name)
-	t, err := ws.NewRtmTrader(ctx.Context, configFile, dry)
+	switch ctx.String(utils.ProtocolFlag.Name) {
+	case "w", "ws":
+		return rtmWs(ctx, configFile, dry)
+	}
+	if dry {
+		return errors.New("the rest RTM trader has no dry-run, use --paper instead")
+	}
+	t, err := rest.NewRtmTrader(ctx.Context, configFile, ctx.Bool(utils.PaperFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Start(ctx.Context)
+}
+
+func rtmWs(ctx *cli.Context, configFile string, dry bool) error {
+	paper := ctx.Bool(utils.PaperFlag.Name)
+	t, err := ws.NewRtmTrader(ctx.Context, configFile, dry, paper)
 	if err != nil {
 		return err
 	}
@@ -533,24 +764,65 @@ func rtm(ctx *cli.Context) error {
 	return nil
 }
 
+type rtmRunConfig struct {
+	sdk.Config
+	Strategy strategy.RtmStrategyConf
+}
+
+func rtmRun(ctx *cli.Context) error {
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	cfg := rtmRunConfig{}
+	if err := hs.ParseJsonConfig(configFile, &cfg); err != nil {
+		return err
+	}
+	if cfg.Runner.Total == 0 {
+		cfg.Runner.Total = cfg.Strategy.Total
+	}
+	s, err := strategy.NewRtmSdk(cfg.Strategy)
+	if err != nil {
+		return err
+	}
+	mode := sdk.ModeLive
+	if ctx.Bool(utils.BacktestFlag.Name) {
+		mode = sdk.ModeBacktest
+	} else if ctx.Bool(utils.PaperFlag.Name) {
+		mode = sdk.ModePaper
+	}
+	r, err := sdk.NewRunner(cfg.Config, s, mode)
+	if err != nil {
+		return err
+	}
+	if err := r.Init(ctx.Context); err != nil {
+		return err
+	}
+	defer r.Close(ctx.Context)
+	return r.Start(ctx.Context)
+}
+
 func rtmPrint(ctx *cli.Context) error {
-	//configFile := ctx.String(utils.ConfigFlag.Name)
-	//t, err := super.NewWsTrader(ctx.Context, configFile)
-	//if err != nil {
-	//	return err
-	//}
-	//defer t.Close(ctx.Context)
-	//return t.Print(ctx.Context)
-	return nil
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	switch ctx.String(utils.ProtocolFlag.Name) {
+	case "w", "ws":
+		return nil
+	}
+	t, err := rest.NewRtmTrader(ctx.Context, configFile, ctx.Bool(utils.PaperFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Print(ctx.Context)
 }
 
 func rtmClear(ctx *cli.Context) error {
-	//configFile := ctx.String(utils.ConfigFlag.Name)
-	//t, err := super.NewWsTrader(ctx.Context, configFile)
-	//if err != nil {
-	//	return err
-	//}
-	//defer t.Close(ctx.Context)
-	//return t.Clear(ctx.Context)
-	return nil
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	switch ctx.String(utils.ProtocolFlag.Name) {
+	case "w", "ws":
+		return nil
+	}
+	t, err := rest.NewRtmTrader(ctx.Context, configFile, ctx.Bool(utils.PaperFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Clear(ctx.Context)
 }
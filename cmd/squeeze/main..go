@@ -31,16 +31,16 @@ func init() {
 		{
 			Action: squeezeClear,
 			Name:   "clear",
-			Usage:  "clear all Squeeze state in database, cancel pending orders",
+			Usage:  "clear the Squeeze trend in database, cancel pending orders",
 			Flags: []cli.Flag{
-				utils.DryRunFlag,
+				utils.PaperFlag,
 			},
 		},
 	}
 	app.Flags = []cli.Flag{
 		utils.ConfigFlag,
 		utils.ProtocolFlag,
-		utils.DryRunFlag,
+		utils.PaperFlag,
 	}
 }
 func main() {
@@ -60,44 +60,44 @@ func main() {
 
 func squeeze(ctx *cli.Context) error {
 	configFile := ctx.String(utils.ConfigFlag.Name)
-	dry := ctx.Bool(utils.DryRunFlag.Name)
+	paper := ctx.Bool(utils.PaperFlag.Name)
 	protocol := ctx.String(utils.ProtocolFlag.Name)
 	switch protocol {
 	case "r", "rest":
-		squeezeRest(ctx, configFile, dry)
+		return squeezeRest(ctx, configFile, paper)
 	case "w", "ws":
 		//
 	}
 	return nil
 }
 
-func squeezeRest(ctx *cli.Context, cfg string, dry bool) {
-	t, err := rest.NewSqueezeMomentumTrader(ctx.Context, cfg, dry)
+func squeezeRest(ctx *cli.Context, cfg string, paper bool) error {
+	t, err := rest.NewSqueezeMomentumTrader(ctx.Context, cfg, paper)
 	if err != nil {
-		return
+		return err
 	}
 	defer t.Close(ctx.Context)
 	t.Run(ctx.Context)
+	return nil
 }
 
 func squeezePrint(ctx *cli.Context) error {
-	//configFile := ctx.String(utils.ConfigFlag.Name)
-	//t, err := ws.NewSuperTrendTrader(ctx.Context, configFile)
-	//if err != nil {
-	//	return err
-	//}
-	//defer t.Close(ctx.Context)
-	//return t.Print(ctx.Context)
-	return nil
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	t, err := rest.NewSqueezeMomentumTrader(ctx.Context, configFile, ctx.Bool(utils.PaperFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Print(ctx.Context)
 }
 
 func squeezeClear(ctx *cli.Context) error {
-	//configFile := ctx.String(utils.ConfigFlag.Name)
-	//t, err := ws.NewSuperTrendTrader(ctx.Context, configFile)
-	//if err != nil {
-	//	return err
-	//}
-	//defer t.Close(ctx.Context)
-	//return t.Clear(ctx.Context)
-	return nil
+	configFile := ctx.String(utils.ConfigFlag.Name)
+	paper := ctx.Bool(utils.PaperFlag.Name)
+	t, err := rest.NewSqueezeMomentumTrader(ctx.Context, configFile, paper)
+	if err != nil {
+		return err
+	}
+	defer t.Close(ctx.Context)
+	return t.Clear(ctx.Context)
 }
@@ -74,6 +74,16 @@ var (
 		Value: false,
 		Usage: "do not run, just print the result",
 	}
+	PaperFlag = &cli.BoolFlag{
+		Name:  "paper",
+		Value: false,
+		Usage: "paper trading, simulate fills by live market data (use a separate database)",
+	}
+	BacktestFlag = &cli.BoolFlag{
+		Name:  "backtest",
+		Value: false,
+		Usage: "backtest on history candles, the period is set in config (Runner.From, Runner.To)",
+	}
 
 	// flags used by scan, aliases refer to TradingView
 	SizeFlag = &cli.Int64Flag{
@@ -82,6 +92,10 @@ var (
 		Value:   2000,
 		Usage:   "number of candles when scan",
 	}
+	ScanRuleFlag = &cli.StringFlag{
+		Name:  "rule",
+		Usage: "scan by `rule`, eg. \"squeeze(W).trend == 2 && supertrend(D, 3, 7).up\"",
+	}
 	ScanMonthlyFlag = &cli.BoolFlag{
 		Name:    "month",
 		Aliases: []string{"M"},
@@ -0,0 +1,91 @@
+package exchange
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"time"
+)
+
+// margin mode of the futures position
+const (
+	MarginCross    = "cross"
+	MarginIsolated = "isolated"
+)
+
+// FuturesContract is the trading rule of the USDT-margined perpetual contract
+type FuturesContract struct {
+	Name           string
+	Multiplier     decimal.Decimal // base currency per contract
+	PricePrecision int32
+	MinSize        int64 // min contracts per order
+	MaxLeverage    int
+}
+
+// FuturesPosition of one contract, Size is positive for long and negative for short
+type FuturesPosition struct {
+	Contract      string
+	Size          int64
+	Leverage      int
+	MarginMode    string
+	EntryPrice    decimal.Decimal
+	MarkPrice     decimal.Decimal
+	LiqPrice      decimal.Decimal
+	Margin        decimal.Decimal
+	UnrealisedPnl decimal.Decimal
+}
+
+type FundingRate struct {
+	Rate     decimal.Decimal
+	NextTime time.Time
+}
+
+// FuturesOrderRequest, Size is positive for buy and negative for sell, zero Price means market order
+type FuturesOrderRequest struct {
+	Contract      string
+	ClientOrderId string
+	Size          int64
+	Price         decimal.Decimal
+	ReduceOnly    bool
+}
+
+// FuturesOrder is the order status, Size and Filled have the sign of direction
+type FuturesOrder struct {
+	Id        uint64
+	Contract  string
+	Size      int64
+	Filled    int64
+	Price     decimal.Decimal
+	FillPrice decimal.Decimal
+	Finished  bool
+}
+
+// FuturesExchange is the USDT-margined perpetual futures API, the margin currency is USDT
+type FuturesExchange interface {
+	Name() string
+	Contract(ctx context.Context, contract string) (FuturesContract, error)
+	// Candle return size bars, the last one is forming
+	Candle(ctx context.Context, contract string, period time.Duration, size int) (hs.Candle, error)
+	// Available margin can be used to open position
+	Available(ctx context.Context) (decimal.Decimal, error)
+	Position(ctx context.Context, contract string) (FuturesPosition, error)
+	SetLeverage(ctx context.Context, contract, mode string, leverage int) error
+	MarkPrice(ctx context.Context, contract string) (decimal.Decimal, error)
+	FundingRate(ctx context.Context, contract string) (FundingRate, error)
+
+	PlaceOrder(ctx context.Context, r FuturesOrderRequest) (orderId uint64, err error)
+	GetOrder(ctx context.Context, contract string, orderId uint64) (FuturesOrder, error)
+	CancelOrder(ctx context.Context, contract string, orderId uint64) error
+}
+
+// Precision return the decimal places of the tick, eg. 0.01 => 2
+func Precision(tick decimal.Decimal) int32 {
+	if !tick.IsPositive() {
+		return 0
+	}
+	var p int32
+	for !tick.Equal(tick.Truncate(p)) {
+		p++
+	}
+	return p
+}
@@ -0,0 +1,213 @@
+// Package gateio is the USDT-margined perpetual futures of gate, the contract is like BTC_USDT.
+package gateio
+
+import (
+	"context"
+	"fmt"
+	"github.com/antihax/optional"
+	"github.com/gateio/gateapi-go/v5"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/qtr/exchange"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const settle = "usdt"
+
+var intervals = map[time.Duration]string{
+	time.Minute:        "1m",
+	time.Minute * 5:    "5m",
+	time.Minute * 15:   "15m",
+	time.Minute * 30:   "30m",
+	time.Hour:          "1h",
+	time.Hour * 4:      "4h",
+	time.Hour * 8:      "8h",
+	time.Hour * 24:     "1d",
+	time.Hour * 24 * 7: "7d",
+}
+
+type Futures struct {
+	key    string
+	secret string
+	client *gateapi.APIClient
+}
+
+// NewFutures create the futures client, host is like api.gateio.ws, empty for default
+func NewFutures(key, secret, host string) *Futures {
+	cfg := gateapi.NewConfiguration()
+	if host != "" {
+		cfg.BasePath = "https://" + host + "/api/v4"
+	}
+	return &Futures{key: key, secret: secret, client: gateapi.NewAPIClient(cfg)}
+}
+
+func (f *Futures) Name() string {
+	return "gate"
+}
+
+func (f *Futures) auth(ctx context.Context) context.Context {
+	return context.WithValue(ctx, gateapi.ContextGateAPIV4, gateapi.GateAPIV4{Key: f.key, Secret: f.secret})
+}
+
+func (f *Futures) Contract(ctx context.Context, contract string) (exchange.FuturesContract, error) {
+	c, _, err := f.client.FuturesApi.GetFuturesContract(ctx, settle, contract)
+	if err != nil {
+		return exchange.FuturesContract{}, err
+	}
+	leverage, _ := strconv.Atoi(strings.Split(c.LeverageMax, ".")[0])
+	return exchange.FuturesContract{
+		Name:           c.Name,
+		Multiplier:     decimalOrZero(c.QuantoMultiplier),
+		PricePrecision: exchange.Precision(decimalOrZero(c.OrderPriceRound)),
+		MinSize:        c.OrderSizeMin,
+		MaxLeverage:    leverage,
+	}, nil
+}
+
+func (f *Futures) Candle(ctx context.Context, contract string, period time.Duration, size int) (hs.Candle, error) {
+	interval, ok := intervals[period]
+	if !ok {
+		return hs.Candle{}, fmt.Errorf("unsupported period %s", period)
+	}
+	raw, _, err := f.client.FuturesApi.ListFuturesCandlesticks(ctx, settle, contract, &gateapi.ListFuturesCandlesticksOpts{
+		Limit:    optional.NewInt32(int32(size)),
+		Interval: optional.NewString(interval),
+	})
+	if err != nil {
+		return hs.Candle{}, err
+	}
+	c := hs.NewCandle(size)
+	for _, r := range raw {
+		c.Append(hs.Ticker{
+			Timestamp: int64(r.T),
+			Open:      floatOrZero(r.O),
+			High:      floatOrZero(r.H),
+			Low:       floatOrZero(r.L),
+			Close:     floatOrZero(r.C),
+			Volume:    float64(r.V),
+		})
+	}
+	return c, nil
+}
+
+func (f *Futures) Available(ctx context.Context) (decimal.Decimal, error) {
+	a, _, err := f.client.FuturesApi.ListFuturesAccounts(f.auth(ctx), settle)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return decimalOrZero(a.Available), nil
+}
+
+func (f *Futures) Position(ctx context.Context, contract string) (exchange.FuturesPosition, error) {
+	p, _, err := f.client.FuturesApi.GetPosition(f.auth(ctx), settle, contract)
+	if err != nil {
+		return exchange.FuturesPosition{}, err
+	}
+	leverage, _ := strconv.Atoi(strings.Split(p.Leverage, ".")[0])
+	mode := exchange.MarginIsolated
+	if leverage == 0 {
+		mode = exchange.MarginCross
+	}
+	return exchange.FuturesPosition{
+		Contract:      contract,
+		Size:          p.Size,
+		Leverage:      leverage,
+		MarginMode:    mode,
+		EntryPrice:    decimalOrZero(p.EntryPrice),
+		MarkPrice:     decimalOrZero(p.MarkPrice),
+		LiqPrice:      decimalOrZero(p.LiqPrice),
+		Margin:        decimalOrZero(p.Margin),
+		UnrealisedPnl: decimalOrZero(p.UnrealisedPnl),
+	}, nil
+}
+
+// SetLeverage of the contract, gate use leverage 0 for cross margin
+func (f *Futures) SetLeverage(ctx context.Context, contract, mode string, leverage int) error {
+	if mode == exchange.MarginCross {
+		leverage = 0
+	}
+	_, _, err := f.client.FuturesApi.UpdatePositionLeverage(f.auth(ctx), settle, contract, strconv.Itoa(leverage))
+	return err
+}
+
+func (f *Futures) MarkPrice(ctx context.Context, contract string) (decimal.Decimal, error) {
+	c, _, err := f.client.FuturesApi.GetFuturesContract(ctx, settle, contract)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return decimalOrZero(c.MarkPrice), nil
+}
+
+func (f *Futures) FundingRate(ctx context.Context, contract string) (exchange.FundingRate, error) {
+	c, _, err := f.client.FuturesApi.GetFuturesContract(ctx, settle, contract)
+	if err != nil {
+		return exchange.FundingRate{}, err
+	}
+	return exchange.FundingRate{
+		Rate:     decimalOrZero(c.FundingRate),
+		NextTime: time.Unix(int64(c.FundingNextApply), 0),
+	}, nil
+}
+
+// PlaceOrder place the limit order (gtc), or the market order (ioc with price 0).
+// The client order id is put in text with prefix `t-`.
+func (f *Futures) PlaceOrder(ctx context.Context, r exchange.FuturesOrderRequest) (uint64, error) {
+	o := gateapi.FuturesOrder{
+		Contract:   r.Contract,
+		Size:       r.Size,
+		Price:      "0",
+		Tif:        "ioc",
+		ReduceOnly: r.ReduceOnly,
+	}
+	if r.Price.IsPositive() {
+		o.Price = r.Price.String()
+		o.Tif = "gtc"
+	}
+	if r.ClientOrderId != "" {
+		o.Text = "t-" + r.ClientOrderId
+	}
+	o, _, err := f.client.FuturesApi.CreateFuturesOrder(f.auth(ctx), settle, o)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(o.Id), nil
+}
+
+func (f *Futures) GetOrder(ctx context.Context, contract string, orderId uint64) (exchange.FuturesOrder, error) {
+	o, _, err := f.client.FuturesApi.GetFuturesOrder(f.auth(ctx), settle, strconv.FormatUint(orderId, 10))
+	if err != nil {
+		return exchange.FuturesOrder{}, err
+	}
+	return exchange.FuturesOrder{
+		Id:        uint64(o.Id),
+		Contract:  o.Contract,
+		Size:      o.Size,
+		Filled:    o.Size - o.Left,
+		Price:     decimalOrZero(o.Price),
+		FillPrice: decimalOrZero(o.FillPrice),
+		Finished:  o.Status == "finished",
+	}, nil
+}
+
+func (f *Futures) CancelOrder(ctx context.Context, contract string, orderId uint64) error {
+	_, _, err := f.client.FuturesApi.CancelFuturesOrder(f.auth(ctx), settle, strconv.FormatUint(orderId, 10))
+	return err
+}
+
+func decimalOrZero(s string) decimal.Decimal {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Zero
+	}
+	return d
+}
+
+func floatOrZero(s string) float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
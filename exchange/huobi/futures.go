@@ -0,0 +1,385 @@
+package huobi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/qtr/exchange"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultFuturesHost is the host of USDT-margined swap
+const DefaultFuturesHost = "api.hbdm.com"
+
+var futuresPeriods = map[time.Duration]string{
+	time.Minute:         "1min",
+	time.Minute * 5:     "5min",
+	time.Minute * 15:    "15min",
+	time.Minute * 30:    "30min",
+	time.Hour:           "60min",
+	time.Hour * 4:       "4hour",
+	time.Hour * 24:      "1day",
+	time.Hour * 24 * 7:  "1week",
+	time.Hour * 24 * 30: "1mon",
+}
+
+// Futures is the USDT-margined swap of huobi, the contract is like BTC-USDT.
+// The account is in hedge mode, the position size is long volume - short volume,
+// so the reduce-only order is placed with offset `close`.
+type Futures struct {
+	accessKey string
+	secretKey string
+	host      string
+	mode      string // cross or isolated, they use different API
+	client    *http.Client
+}
+
+func NewFutures(accessKey, secretKey, host, mode string) *Futures {
+	if host == "" {
+		host = DefaultFuturesHost
+	}
+	if mode == "" {
+		mode = exchange.MarginCross
+	}
+	return &Futures{
+		accessKey: accessKey,
+		secretKey: secretKey,
+		host:      host,
+		mode:      mode,
+		client:    &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (f *Futures) Name() string {
+	return "huobi"
+}
+
+type futuresResponse struct {
+	Status  string          `json:"status"`
+	ErrCode interface{}     `json:"err_code"`
+	ErrMsg  string          `json:"err_msg"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func (f *Futures) Contract(ctx context.Context, contract string) (exchange.FuturesContract, error) {
+	var data []struct {
+		ContractCode string  `json:"contract_code"`
+		ContractSize float64 `json:"contract_size"`
+		PriceTick    float64 `json:"price_tick"`
+	}
+	if err := f.get(ctx, "/linear-swap-api/v1/swap_contract_info", url.Values{"contract_code": {contract}}, &data); err != nil {
+		return exchange.FuturesContract{}, err
+	}
+	if len(data) == 0 {
+		return exchange.FuturesContract{}, fmt.Errorf("contract %s not found", contract)
+	}
+	return exchange.FuturesContract{
+		Name:           data[0].ContractCode,
+		Multiplier:     decimal.NewFromFloat(data[0].ContractSize),
+		PricePrecision: exchange.Precision(decimal.NewFromFloat(data[0].PriceTick)),
+		MinSize:        1,
+	}, nil
+}
+
+type futuresKline struct {
+	Id     int64   `json:"id"`
+	Open   float64 `json:"open"`
+	Close  float64 `json:"close"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Amount float64 `json:"amount"` // in base currency
+}
+
+func (f *Futures) Candle(ctx context.Context, contract string, period time.Duration, size int) (hs.Candle, error) {
+	p, ok := futuresPeriods[period]
+	if !ok {
+		return hs.Candle{}, fmt.Errorf("unsupported period %s", period)
+	}
+	var data []futuresKline
+	params := url.Values{"contract_code": {contract}, "period": {p}, "size": {strconv.Itoa(size)}}
+	if err := f.get(ctx, "/linear-swap-ex/market/history/kline", params, &data); err != nil {
+		return hs.Candle{}, err
+	}
+	c := hs.NewCandle(size)
+	for _, k := range data {
+		c.Append(hs.Ticker{Timestamp: k.Id, Open: k.Open, High: k.High, Low: k.Low, Close: k.Close, Volume: k.Amount})
+	}
+	return c, nil
+}
+
+func (f *Futures) Available(ctx context.Context) (decimal.Decimal, error) {
+	if f.mode == exchange.MarginCross {
+		var data []struct {
+			WithdrawAvailable float64 `json:"withdraw_available"`
+		}
+		if err := f.post(ctx, "/linear-swap-api/v1/swap_cross_account_info", map[string]interface{}{"margin_account": "USDT"}, &data); err != nil {
+			return decimal.Zero, err
+		}
+		if len(data) == 0 {
+			return decimal.Zero, errors.New("no cross margin account")
+		}
+		return decimal.NewFromFloat(data[0].WithdrawAvailable), nil
+	}
+	return decimal.Zero, errors.New("isolated margin is per contract, use position margin instead")
+}
+
+func (f *Futures) Position(ctx context.Context, contract string) (exchange.FuturesPosition, error) {
+	path := "/linear-swap-api/v1/swap_position_info"
+	accountPath := "/linear-swap-api/v1/swap_account_info"
+	accountParams := map[string]interface{}{"contract_code": contract}
+	if f.mode == exchange.MarginCross {
+		path = "/linear-swap-api/v1/swap_cross_position_info"
+		accountPath = "/linear-swap-api/v1/swap_cross_account_info"
+		accountParams = map[string]interface{}{"margin_account": "USDT"}
+	}
+	var data []struct {
+		ContractCode   string  `json:"contract_code"`
+		Volume         float64 `json:"volume"`
+		Direction      string  `json:"direction"`
+		CostOpen       float64 `json:"cost_open"`
+		LastPrice      float64 `json:"last_price"`
+		PositionMargin float64 `json:"position_margin"`
+		ProfitUnreal   float64 `json:"profit_unreal"`
+		LeverRate      int     `json:"lever_rate"`
+	}
+	if err := f.post(ctx, path, map[string]interface{}{"contract_code": contract}, &data); err != nil {
+		return exchange.FuturesPosition{}, err
+	}
+	p := exchange.FuturesPosition{Contract: contract, MarginMode: f.mode}
+	for _, d := range data {
+		if d.ContractCode != contract {
+			continue
+		}
+		volume := int64(d.Volume)
+		if d.Direction == "sell" {
+			volume = -volume
+		}
+		p.Size += volume
+		p.Leverage = d.LeverRate
+		p.EntryPrice = decimal.NewFromFloat(d.CostOpen)
+		p.MarkPrice = decimal.NewFromFloat(d.LastPrice)
+		p.Margin = p.Margin.Add(decimal.NewFromFloat(d.PositionMargin))
+		p.UnrealisedPnl = p.UnrealisedPnl.Add(decimal.NewFromFloat(d.ProfitUnreal))
+	}
+
+	var accounts []struct {
+		ContractCode     string  `json:"contract_code"`
+		LiquidationPrice float64 `json:"liquidation_price"`
+		ContractDetail   []struct {
+			ContractCode     string  `json:"contract_code"`
+			LiquidationPrice float64 `json:"liquidation_price"`
+		} `json:"contract_detail"`
+	}
+	if err := f.post(ctx, accountPath, accountParams, &accounts); err != nil {
+		return p, err
+	}
+	for _, a := range accounts {
+		if a.ContractCode == contract {
+			p.LiqPrice = decimal.NewFromFloat(a.LiquidationPrice)
+		}
+		for _, d := range a.ContractDetail {
+			if d.ContractCode == contract {
+				p.LiqPrice = decimal.NewFromFloat(d.LiquidationPrice)
+			}
+		}
+	}
+	return p, nil
+}
+
+// SetLeverage of the contract, the margin mode must be the same as the client
+func (f *Futures) SetLeverage(ctx context.Context, contract, mode string, leverage int) error {
+	if mode != f.mode {
+		return fmt.Errorf("the client is in %s mode, can not set %s leverage", f.mode, mode)
+	}
+	path := "/linear-swap-api/v1/swap_switch_lever_rate"
+	if f.mode == exchange.MarginCross {
+		path = "/linear-swap-api/v1/swap_cross_switch_lever_rate"
+	}
+	return f.post(ctx, path, map[string]interface{}{"contract_code": contract, "lever_rate": leverage}, nil)
+}
+
+func (f *Futures) MarkPrice(ctx context.Context, contract string) (decimal.Decimal, error) {
+	var data []futuresKline
+	params := url.Values{"contract_code": {contract}, "period": {"1min"}, "size": {"1"}}
+	if err := f.get(ctx, "/index/market/history/linear_swap_mark_price_kline", params, &data); err != nil {
+		return decimal.Zero, err
+	}
+	if len(data) == 0 {
+		return decimal.Zero, errors.New("no mark price")
+	}
+	return decimal.NewFromFloat(data[0].Close), nil
+}
+
+func (f *Futures) FundingRate(ctx context.Context, contract string) (exchange.FundingRate, error) {
+	var data struct {
+		FundingRate string `json:"funding_rate"`
+		FundingTime string `json:"funding_time"` // ms
+	}
+	if err := f.get(ctx, "/linear-swap-api/v1/swap_funding_rate", url.Values{"contract_code": {contract}}, &data); err != nil {
+		return exchange.FundingRate{}, err
+	}
+	rate, _ := decimal.NewFromString(data.FundingRate)
+	ms, _ := strconv.ParseInt(data.FundingTime, 10, 64)
+	return exchange.FundingRate{Rate: rate, NextTime: time.Unix(ms/1000, ms%1000*int64(time.Millisecond))}, nil
+}
+
+// PlaceOrder place limit or market (optimal_20) order. Huobi client order id must be a number,
+// so the client order id is ignored if not.
+func (f *Futures) PlaceOrder(ctx context.Context, r exchange.FuturesOrderRequest) (uint64, error) {
+	params := map[string]interface{}{
+		"contract_code":    r.Contract,
+		"volume":           abs(r.Size),
+		"direction":        "buy",
+		"offset":           "open",
+		"order_price_type": "optimal_20",
+	}
+	if r.Size < 0 {
+		params["direction"] = "sell"
+	}
+	if r.ReduceOnly {
+		params["offset"] = "close"
+	}
+	if r.Price.IsPositive() {
+		params["order_price_type"] = "limit"
+		params["price"] = r.Price.String()
+	}
+	if id, err := strconv.ParseInt(r.ClientOrderId, 10, 64); err == nil {
+		params["client_order_id"] = id
+	}
+	path := "/linear-swap-api/v1/swap_order"
+	if f.mode == exchange.MarginCross {
+		path = "/linear-swap-api/v1/swap_cross_order"
+	}
+	var data struct {
+		OrderId uint64 `json:"order_id"`
+	}
+	if err := f.post(ctx, path, params, &data); err != nil {
+		return 0, err
+	}
+	return data.OrderId, nil
+}
+
+func (f *Futures) GetOrder(ctx context.Context, contract string, orderId uint64) (exchange.FuturesOrder, error) {
+	path := "/linear-swap-api/v1/swap_order_info"
+	if f.mode == exchange.MarginCross {
+		path = "/linear-swap-api/v1/swap_cross_order_info"
+	}
+	var data []struct {
+		OrderId       uint64  `json:"order_id"`
+		ContractCode  string  `json:"contract_code"`
+		Volume        float64 `json:"volume"`
+		TradeVolume   float64 `json:"trade_volume"`
+		Price         float64 `json:"price"`
+		TradeAvgPrice float64 `json:"trade_avg_price"`
+		Direction     string  `json:"direction"`
+		Status        int     `json:"status"`
+	}
+	params := map[string]interface{}{"contract_code": contract, "order_id": strconv.FormatUint(orderId, 10)}
+	if err := f.post(ctx, path, params, &data); err != nil {
+		return exchange.FuturesOrder{}, err
+	}
+	if len(data) == 0 {
+		return exchange.FuturesOrder{}, fmt.Errorf("order %d not found", orderId)
+	}
+	d := data[0]
+	o := exchange.FuturesOrder{
+		Id:        d.OrderId,
+		Contract:  d.ContractCode,
+		Size:      int64(d.Volume),
+		Filled:    int64(d.TradeVolume),
+		Price:     decimal.NewFromFloat(d.Price),
+		FillPrice: decimal.NewFromFloat(d.TradeAvgPrice),
+		// 5: partially filled and cancelled, 6: filled, 7: cancelled
+		Finished: d.Status == 5 || d.Status == 6 || d.Status == 7,
+	}
+	if d.Direction == "sell" {
+		o.Size, o.Filled = -o.Size, -o.Filled
+	}
+	return o, nil
+}
+
+func (f *Futures) CancelOrder(ctx context.Context, contract string, orderId uint64) error {
+	path := "/linear-swap-api/v1/swap_cancel"
+	if f.mode == exchange.MarginCross {
+		path = "/linear-swap-api/v1/swap_cross_cancel"
+	}
+	params := map[string]interface{}{"contract_code": contract, "order_id": strconv.FormatUint(orderId, 10)}
+	return f.post(ctx, path, params, nil)
+}
+
+func (f *Futures) get(ctx context.Context, path string, params url.Values, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+f.host+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	return f.do(req, result)
+}
+
+func (f *Futures) post(ctx context.Context, path string, params map[string]interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.sign(http.MethodPost, path), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return f.do(req, result)
+}
+
+func (f *Futures) do(req *http.Request, result interface{}) error {
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var r futuresResponse
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("decode response error: %w, raw: %s", err, data)
+	}
+	if r.Status != "ok" {
+		return fmt.Errorf("huobi futures error %v: %s", r.ErrCode, r.ErrMsg)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Data, result)
+}
+
+// sign return the url with signature v2
+func (f *Futures) sign(method, path string) string {
+	params := url.Values{}
+	params.Set("AccessKeyId", f.accessKey)
+	params.Set("SignatureMethod", "HmacSHA256")
+	params.Set("SignatureVersion", "2")
+	params.Set("Timestamp", time.Now().UTC().Format("2006-01-02T15:04:05"))
+	query := params.Encode()
+	payload := fmt.Sprintf("%s\n%s\n%s\n%s", method, f.host, path, query)
+	mac := hmac.New(sha256.New, []byte(f.secretKey))
+	mac.Write([]byte(payload))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return fmt.Sprintf("https://%s%s?%s&Signature=%s", f.host, path, query, url.QueryEscape(signature))
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,374 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	AlgoMarket  = "market"
+	AlgoChase   = "chase"
+	AlgoTWAP    = "twap"
+	AlgoIceberg = "iceberg"
+
+	defaultAlgoInterval = time.Second * 20
+
+	// market order is checked every second, until it is finished
+	marketChecks        = 30
+	marketCheckInterval = time.Second
+)
+
+// AlgoConf select and configure the execution algorithm, eg. {"name": "twap", "duration": "1h", "slices": 12, "interval": "20s", "timeout": "2h"}
+type AlgoConf struct {
+	Name     string  // market(default), chase, twap, iceberg
+	Interval string  // how long a child limit order waits before re-price, default 20s
+	Timeout  string  // max time of the whole job, empty means no timeout
+	Duration string  // twap only, total duration
+	Slices   int     // twap only, number of slices
+	Visible  float64 // iceberg only, visible amount (base currency) of every child order
+}
+
+// Job is a parent order, executed by child orders.
+// Buy job spend Total (quote currency), sell job sell Amount (base currency).
+type Job struct {
+	Direction     string // exchange.TradeDirectionBuy / exchange.TradeDirectionSell
+	ClientOrderId string // child orders use ClientOrderId-0, ClientOrderId-1, ...
+	Total         decimal.Decimal
+	Amount        decimal.Decimal
+}
+
+func (j Job) buy() bool {
+	return j.Direction == exchange.TradeDirectionBuy
+}
+
+// Progress of a job, reported after every child order finished
+type Progress struct {
+	Job          Job
+	Order        exchange.Order // last child order
+	Orders       int            // child orders placed
+	FilledAmount decimal.Decimal
+	FilledTotal  decimal.Decimal
+	Done         bool
+}
+
+// Left is the remain quantity in job unit (total for buy, amount for sell)
+func (p Progress) Left() decimal.Decimal {
+	if p.Job.buy() {
+		return p.Job.Total.Sub(p.FilledTotal)
+	}
+	return p.Job.Amount.Sub(p.FilledAmount)
+}
+
+func (p Progress) executed() decimal.Decimal {
+	if p.Job.buy() {
+		return p.FilledTotal
+	}
+	return p.FilledAmount
+}
+
+func (p *Progress) add(o exchange.Order) {
+	p.Order = o
+	p.FilledAmount = p.FilledAmount.Add(o.FilledAmount)
+	p.FilledTotal = p.FilledTotal.Add(o.FilledPrice.Mul(o.FilledAmount))
+}
+
+type ProgressHandler func(p Progress)
+
+// Algorithm execute a job, it returns when the job is done, cancelled or timeout.
+// The returned progress is always valid, even if err is not nil.
+type Algorithm interface {
+	Execute(ctx context.Context, job Job) (Progress, error)
+}
+
+// NewAlgorithm create algorithm by config, handler can be nil
+func NewAlgorithm(conf AlgoConf, ex exchange.RestAPIExchange, symbol exchange.Symbol, sugar *zap.SugaredLogger, handler ProgressHandler) (Algorithm, error) {
+	base := algoBase{
+		ex:       ex,
+		symbol:   symbol,
+		Sugar:    sugar,
+		interval: defaultAlgoInterval,
+		handler:  handler,
+	}
+	var err error
+	if conf.Interval != "" {
+		if base.interval, err = time.ParseDuration(conf.Interval); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Timeout != "" {
+		if base.timeout, err = time.ParseDuration(conf.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	switch conf.Name {
+	case "", AlgoMarket:
+		return &Market{algoBase: base}, nil
+	case AlgoChase:
+		return &ChaseLimit{algoBase: base}, nil
+	case AlgoTWAP:
+		if conf.Slices <= 0 {
+			return nil, errors.New("twap slices must be positive")
+		}
+		duration, err := time.ParseDuration(conf.Duration)
+		if err != nil {
+			return nil, err
+		}
+		return &TWAP{algoBase: base, duration: duration, slices: conf.Slices}, nil
+	case AlgoIceberg:
+		if conf.Visible <= 0 {
+			return nil, errors.New("iceberg visible amount must be positive")
+		}
+		return &Iceberg{algoBase: base, visible: decimal.NewFromFloat(conf.Visible)}, nil
+	default:
+		return nil, fmt.Errorf("unknown algorithm: %s", conf.Name)
+	}
+}
+
+// newMarketAlgorithm create the algorithm of the executor's market orders,
+// it's the market order by default, and chase on gate, which has no market order.
+func newMarketAlgorithm(conf AlgoConf, exchangeName string, ex exchange.RestAPIExchange, symbol exchange.Symbol, sugar *zap.SugaredLogger) (Algorithm, error) {
+	// Gate不支持市价单，默认使用追单算法
+	if conf.Name == "" && exchangeName == "gate" {
+		conf.Name = AlgoChase
+	}
+	return NewAlgorithm(conf, ex, symbol, sugar, nil)
+}
+
+// tickerExchange is implemented by exchanges which can give the best bid/ask, eg. gate
+type tickerExchange interface {
+	Ticker(symbol string) (*exchange.Ticker, error)
+}
+
+type algoBase struct {
+	ex       exchange.RestAPIExchange
+	symbol   exchange.Symbol
+	Sugar    *zap.SugaredLogger
+	interval time.Duration
+	timeout  time.Duration
+	handler  ProgressHandler
+}
+
+func (a *algoBase) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.timeout > 0 {
+		return context.WithTimeout(ctx, a.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (a *algoBase) notify(p Progress) {
+	if a.handler != nil {
+		a.handler(p)
+	}
+}
+
+func (a *algoBase) finish(p *Progress, err error) (Progress, error) {
+	p.Done = true
+	a.notify(*p)
+	return *p, err
+}
+
+// bestPrice return the best bid (buy) or best ask (sell), so the child order is a maker order.
+// fallback to last price if the exchange has no ticker.
+func (a *algoBase) bestPrice(buy bool) (decimal.Decimal, error) {
+	if t, ok := a.ex.(tickerExchange); ok {
+		ticker, err := t.Ticker(a.symbol.Symbol)
+		if err == nil {
+			if buy && ticker.HighestBid.IsPositive() {
+				return ticker.HighestBid, nil
+			} else if !buy && ticker.LowestAsk.IsPositive() {
+				return ticker.LowestAsk, nil
+			}
+		} else {
+			a.Sugar.Errorf("get ticker error: %s", err)
+		}
+	}
+	return a.ex.LastPrice(a.symbol.Symbol)
+}
+
+// wait until the interval passed or ctx done, return false if ctx done
+func (a *algoBase) wait(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// place a limit order, wait for one interval, then cancel the unfilled part.
+func (a *algoBase) place(ctx context.Context, buy bool, clientId string, price, amount decimal.Decimal) (o exchange.Order, err error) {
+	var orderId uint64
+	if buy {
+		orderId, err = a.ex.BuyLimit(a.symbol.Symbol, clientId, price, amount)
+	} else {
+		orderId, err = a.ex.SellLimit(a.symbol.Symbol, clientId, price, amount)
+	}
+	if err != nil {
+		return
+	}
+	a.Sugar.Infof("place child order %d / %s, price: %s, amount: %s", orderId, clientId, price, amount)
+	a.wait(ctx, a.interval)
+	o, err = a.ex.GetOrderById(orderId, a.symbol.Symbol)
+	if err == nil && o.FilledAmount.Equal(o.Amount) {
+		return
+	}
+	if err1 := a.ex.CancelOrder(a.symbol.Symbol, orderId); err1 != nil {
+		a.Sugar.Errorf("cancel child order %d error: %s", orderId, err1)
+	}
+	// the order may be filled between query and cancel, so query again
+	if o2, err2 := a.ex.GetOrderById(orderId, a.symbol.Symbol); err2 == nil {
+		return o2, nil
+	}
+	return
+}
+
+// chase execute quota (job unit) of the job, re-price child order to best bid/ask every interval.
+// visible limit the amount of every child order, zero means no limit.
+func (a *algoBase) chase(ctx context.Context, p *Progress, quota, visible decimal.Decimal) error {
+	buy := p.Job.buy()
+	start := p.executed()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		left := quota.Sub(p.executed().Sub(start))
+		if jobLeft := p.Left(); jobLeft.LessThan(left) {
+			left = jobLeft
+		}
+		if !left.IsPositive() {
+			return nil
+		}
+		price, err := a.bestPrice(buy)
+		if err != nil {
+			a.Sugar.Errorf("get price error: %s", err)
+			a.wait(ctx, a.interval)
+			continue
+		}
+		price = price.Round(a.symbol.PricePrecision)
+		amount := left
+		if buy {
+			amount = left.Div(price)
+		}
+		amount = amount.Truncate(a.symbol.AmountPrecision)
+		if visible.IsPositive() && amount.GreaterThan(visible) {
+			amount = visible.Truncate(a.symbol.AmountPrecision)
+		}
+		if amount.LessThan(a.symbol.LimitOrderMinAmount) || price.Mul(amount).LessThan(a.symbol.MinTotal) {
+			a.Sugar.Debugf("left amount %s is too small, price: %s", amount, price)
+			return nil
+		}
+		clientId := ChildId(p.Job.ClientOrderId, p.Orders)
+		o, err := a.place(ctx, buy, clientId, price, amount)
+		p.Orders++
+		if err != nil {
+			return err
+		}
+		p.add(o)
+		a.notify(*p)
+	}
+}
+
+// Market place one market order
+type Market struct {
+	algoBase
+}
+
+func (a *Market) Execute(ctx context.Context, job Job) (Progress, error) {
+	p := Progress{Job: job}
+	var orderId uint64
+	var err error
+	if job.buy() {
+		orderId, err = a.ex.BuyMarket(a.symbol, job.ClientOrderId, job.Total)
+	} else {
+		orderId, err = a.ex.SellMarket(a.symbol, job.ClientOrderId, job.Amount)
+	}
+	p.Orders++
+	if err != nil {
+		return a.finish(&p, err)
+	}
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+	// the filled amount is final only after the order is finished
+	for i := 0; i < marketChecks; i++ {
+		o, err1 := a.ex.GetOrderById(orderId, a.symbol.Symbol)
+		if err1 == nil && OrderFinished(o.Status) {
+			p.add(o)
+			return a.finish(&p, nil)
+		}
+		if err1 != nil {
+			a.Sugar.Errorf("get order %d error: %s", orderId, err1)
+		}
+		if !a.wait(ctx, marketCheckInterval) {
+			return a.finish(&p, ctx.Err())
+		}
+	}
+	return a.finish(&p, fmt.Errorf("market order %d is not finished", orderId))
+}
+
+// ChaseLimit place maker order at best bid/ask, re-price every interval until done
+type ChaseLimit struct {
+	algoBase
+}
+
+func (a *ChaseLimit) Execute(ctx context.Context, job Job) (Progress, error) {
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+	p := Progress{Job: job}
+	err := a.chase(ctx, &p, p.Left(), decimal.Zero)
+	return a.finish(&p, err)
+}
+
+// TWAP split the job into slices, execute one slice (by chase-limit) in every duration/slices.
+// unfilled part of a slice is carried to the next slice.
+type TWAP struct {
+	algoBase
+	duration time.Duration
+	slices   int
+}
+
+func (a *TWAP) Execute(ctx context.Context, job Job) (Progress, error) {
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+	p := Progress{Job: job}
+	slot := a.duration / time.Duration(a.slices)
+	begin := time.Now()
+	for i := 0; i < a.slices; i++ {
+		end := begin.Add(slot * time.Duration(i+1))
+		quota := p.Left().Div(decimal.NewFromInt(int64(a.slices - i)))
+		sliceCtx, sliceCancel := context.WithDeadline(ctx, end)
+		err := a.chase(sliceCtx, &p, quota, decimal.Zero)
+		sliceCancel()
+		if ctx.Err() != nil {
+			return a.finish(&p, ctx.Err())
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return a.finish(&p, err)
+		}
+		if !p.Left().IsPositive() {
+			break
+		}
+		if i < a.slices-1 && !a.wait(ctx, time.Until(end)) {
+			return a.finish(&p, ctx.Err())
+		}
+	}
+	return a.finish(&p, nil)
+}
+
+// Iceberg only show visible amount in the order book
+type Iceberg struct {
+	algoBase
+	visible decimal.Decimal
+}
+
+func (a *Iceberg) Execute(ctx context.Context, job Job) (Progress, error) {
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+	p := Progress{Job: job}
+	err := a.chase(ctx, &p, p.Left(), a.visible)
+	return a.finish(&p, err)
+}
@@ -0,0 +1,201 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"go.uber.org/zap"
+	"testing"
+)
+
+// fakeExchange fill every limit order at its price, up to fill amount (zero means full)
+type fakeExchange struct {
+	exchange.RestAPIExchange
+	price  decimal.Decimal
+	fill   decimal.Decimal
+	orders map[uint64]exchange.Order
+	// market orders are reported unfinished and unfilled for the first pending queries
+	pending int
+	queries int
+}
+
+func newFakeExchange(price, fill string) *fakeExchange {
+	return &fakeExchange{
+		price:  decimal.RequireFromString(price),
+		fill:   decimal.RequireFromString(fill),
+		orders: make(map[uint64]exchange.Order),
+	}
+}
+
+func (f *fakeExchange) LastPrice(symbol string) (decimal.Decimal, error) {
+	return f.price, nil
+}
+
+func (f *fakeExchange) place(clientOrderId string, price, amount decimal.Decimal) (uint64, error) {
+	id := uint64(len(f.orders) + 1)
+	filled := amount
+	if f.fill.IsPositive() && f.fill.LessThan(amount) {
+		filled = f.fill
+	}
+	f.orders[id] = exchange.Order{Id: id, ClientOrderId: clientOrderId, Price: price, Amount: amount, FilledPrice: price, FilledAmount: filled}
+	return id, nil
+}
+
+func (f *fakeExchange) BuyLimit(symbol, clientOrderId string, price, amount decimal.Decimal) (uint64, error) {
+	return f.place(clientOrderId, price, amount)
+}
+
+func (f *fakeExchange) SellLimit(symbol, clientOrderId string, price, amount decimal.Decimal) (uint64, error) {
+	return f.place(clientOrderId, price, amount)
+}
+
+func (f *fakeExchange) BuyMarket(symbol exchange.Symbol, clientOrderId string, total decimal.Decimal) (uint64, error) {
+	return f.market(clientOrderId, total.Div(f.price))
+}
+
+func (f *fakeExchange) SellMarket(symbol exchange.Symbol, clientOrderId string, amount decimal.Decimal) (uint64, error) {
+	return f.market(clientOrderId, amount)
+}
+
+func (f *fakeExchange) market(clientOrderId string, amount decimal.Decimal) (uint64, error) {
+	id, err := f.place(clientOrderId, f.price, amount)
+	o := f.orders[id]
+	o.Status = "filled"
+	f.orders[id] = o
+	return id, err
+}
+
+func (f *fakeExchange) GetOrderById(orderId uint64, symbol string) (exchange.Order, error) {
+	o := f.orders[orderId]
+	if o.Status == "filled" && f.queries < f.pending {
+		f.queries++
+		return exchange.Order{Id: o.Id, ClientOrderId: o.ClientOrderId, Price: o.Price, Amount: o.Amount, Status: "submitted"}, nil
+	}
+	return o, nil
+}
+
+func (f *fakeExchange) CancelOrder(symbol string, orderId uint64) error {
+	return nil
+}
+
+func testSymbol() exchange.Symbol {
+	return exchange.Symbol{
+		Symbol:              "btc_usdt",
+		PricePrecision:      2,
+		AmountPrecision:     4,
+		LimitOrderMinAmount: decimal.RequireFromString("0.0001"),
+		MinTotal:            decimal.RequireFromString("1"),
+	}
+}
+
+func TestAlgorithm_Execute(t *testing.T) {
+	var tests = []struct {
+		conf   AlgoConf
+		fill   string
+		job    Job
+		orders int
+		amount string
+		total  string
+	}{
+		{AlgoConf{Name: AlgoChase, Interval: "1ms"}, "0", Job{Direction: exchange.TradeDirectionSell, ClientOrderId: "c", Amount: decimal.RequireFromString("1")}, 1, "1", "10000"},
+		{AlgoConf{Name: AlgoChase, Interval: "1ms"}, "0.3", Job{Direction: exchange.TradeDirectionSell, ClientOrderId: "c", Amount: decimal.RequireFromString("1")}, 4, "1", "10000"},
+		{AlgoConf{Name: AlgoIceberg, Interval: "1ms", Visible: 0.25}, "0", Job{Direction: exchange.TradeDirectionSell, ClientOrderId: "i", Amount: decimal.RequireFromString("1")}, 4, "1", "10000"},
+		{AlgoConf{Name: AlgoChase, Interval: "1ms"}, "0", Job{Direction: exchange.TradeDirectionBuy, ClientOrderId: "b", Total: decimal.RequireFromString("5000")}, 1, "0.5", "5000"},
+		{AlgoConf{Name: AlgoTWAP, Interval: "1ms", Duration: "20ms", Slices: 4}, "0", Job{Direction: exchange.TradeDirectionBuy, ClientOrderId: "t", Total: decimal.RequireFromString("4000")}, 4, "0.4", "4000"},
+	}
+	for i, tt := range tests {
+		ex := newFakeExchange("10000", tt.fill)
+		var done bool
+		algo, err := NewAlgorithm(tt.conf, ex, testSymbol(), zap.NewNop().Sugar(), func(p Progress) { done = p.Done })
+		if err != nil {
+			t.Fatalf("[%d] new algorithm error: %s", i, err)
+		}
+		p, err := algo.Execute(context.Background(), tt.job)
+		if err != nil {
+			t.Errorf("[%d] execute error: %s", i, err)
+		}
+		if !done {
+			t.Errorf("[%d] handler not notified when done", i)
+		}
+		if p.Orders != tt.orders {
+			t.Errorf("[%d] want %d orders, got %d", i, tt.orders, p.Orders)
+		}
+		if !p.FilledAmount.Equal(decimal.RequireFromString(tt.amount)) {
+			t.Errorf("[%d] want amount %s, got %s", i, tt.amount, p.FilledAmount)
+		}
+		if !p.FilledTotal.Equal(decimal.RequireFromString(tt.total)) {
+			t.Errorf("[%d] want total %s, got %s", i, tt.total, p.FilledTotal)
+		}
+	}
+}
+
+func TestAlgorithm_Cancel(t *testing.T) {
+	ex := newFakeExchange("10000", "0.1")
+	algo, err := NewAlgorithm(AlgoConf{Name: AlgoChase, Interval: "1ms"}, ex, testSymbol(), zap.NewNop().Sugar(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, err := algo.Execute(ctx, Job{Direction: exchange.TradeDirectionSell, ClientOrderId: "c", Amount: decimal.RequireFromString("1")})
+	if err != context.Canceled {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+	if p.Orders != 0 {
+		t.Errorf("want no order, got %d", p.Orders)
+	}
+}
+
+func TestMarket_Execute(t *testing.T) {
+	ex := newFakeExchange("10000", "0")
+	ex.pending = 1
+	algo, err := NewAlgorithm(AlgoConf{}, ex, testSymbol(), zap.NewNop().Sugar(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := algo.Execute(context.Background(), Job{Direction: exchange.TradeDirectionBuy, ClientOrderId: "m", Total: decimal.RequireFromString("5000")})
+	if err != nil {
+		t.Errorf("execute error: %s", err)
+	}
+	if ex.queries != 1 {
+		t.Errorf("want the unfinished order queried once, got %d", ex.queries)
+	}
+	if !p.FilledAmount.Equal(decimal.RequireFromString("0.5")) || !p.FilledTotal.Equal(decimal.RequireFromString("5000")) {
+		t.Errorf("want amount 0.5 and total 5000, got %s and %s", p.FilledAmount, p.FilledTotal)
+	}
+
+	ex = newFakeExchange("10000", "0")
+	ex.pending = marketChecks
+	algo, _ = NewAlgorithm(AlgoConf{Timeout: "10ms"}, ex, testSymbol(), zap.NewNop().Sugar(), nil)
+	p, err = algo.Execute(context.Background(), Job{Direction: exchange.TradeDirectionSell, ClientOrderId: "m", Amount: decimal.RequireFromString("1")})
+	if err == nil {
+		t.Error("want error when the order is not finished")
+	}
+	if !p.FilledAmount.IsZero() {
+		t.Errorf("want nothing filled, got %s", p.FilledAmount)
+	}
+}
+
+func TestNewMarketAlgorithm(t *testing.T) {
+	var tests = []struct {
+		conf     AlgoConf
+		exchange string
+		want     Algorithm
+	}{
+		{AlgoConf{}, "huobi", &Market{}},
+		{AlgoConf{}, "gate", &ChaseLimit{}},
+		{AlgoConf{Name: AlgoMarket}, "gate", &Market{}},
+		{AlgoConf{Name: AlgoIceberg, Visible: 0.1}, "huobi", &Iceberg{}},
+	}
+	for i, tt := range tests {
+		algo, err := newMarketAlgorithm(tt.conf, tt.exchange, newFakeExchange("10000", "0"), testSymbol(), zap.NewNop().Sugar())
+		if err != nil {
+			t.Errorf("[%d] new algorithm error: %s", i, err)
+			continue
+		}
+		if got, want := fmt.Sprintf("%T", algo), fmt.Sprintf("%T", tt.want); got != want {
+			t.Errorf("[%d] want %s, got %s", i, want, got)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/shopspring/decimal"
 	"github.com/xyths/hs/broadcast"
@@ -33,8 +34,15 @@ type BaseExecutor struct {
 	fee      exchange.Fee
 	id       ClientIdManager
 
-	quota Quota
+	quota   Quota
+	account Account
+	sizer   Sizer
+	algo    AlgoConf // execution algorithm of the market orders
 	OrderProxy
+
+	paperConf *PaperConf // set by UsePaper, the paper exchange is created in Init
+	paper     *PaperExchange
+	namespace string // collection name prefix, for multiple symbols in one database
 }
 
 func (e *BaseExecutor) Init(
@@ -43,16 +51,65 @@ func (e *BaseExecutor) Init(
 	robots []broadcast.Broadcaster) {
 	e.Name = exName
 	e.Label = exLabel
-	e.ex = ex
 	e.Sugar = sugar
 	e.db = db
+	if e.paperConf != nil {
+		e.paper = NewPaperExchange(ex, *e.paperConf)
+		e.paper.SetNamespace(e.namespace)
+		e.paper.Init(sugar, db)
+		ex = e.paper
+	}
+	e.ex = ex
 	e.symbol = symbol
 	e.fee = fee
 	e.maxTotal = maxTotal
 	e.robots = robots
-	e.id.Init("-", db.Collection(collNameState))
-	e.OrderProxy.Init(db.Collection(collNameOrder))
-	e.quota.Init(db.Collection(collNameState), e.maxTotal)
+	e.id.Init("-", e.coll(collNameState))
+	e.id.UseCodec(exName, exLabel, symbol.Symbol)
+	e.OrderProxy.Init(e.coll(collNameOrder))
+	e.quota.Init(e.coll(collNameState), e.maxTotal)
+}
+
+// SetStrategy set the strategy instance name encoded in client order id (default is the exchange label),
+// must be called after Init
+func (e *BaseExecutor) SetStrategy(name string) {
+	e.id.UseCodec(e.Name, name, e.Symbol())
+}
+
+// SetNamespace set the state namespace, the paper account is in it too, must be called before Init
+func (e *BaseExecutor) SetNamespace(namespace string) {
+	e.namespace = namespace
+}
+
+// UsePaper switch to paper trading, orders are simulated by live market data, must be called before Init
+func (e *BaseExecutor) UsePaper(conf PaperConf) {
+	e.paperConf = &conf
+}
+
+// Paper return the paper exchange, nil if it's not paper trading
+func (e *BaseExecutor) Paper() *PaperExchange {
+	return e.paper
+}
+
+// StartPaper fill the paper orders by the live prices until ctx is done, it returns at once if it's not paper trading
+func (e *BaseExecutor) StartPaper(ctx context.Context) {
+	if e.paper != nil {
+		e.paper.Start(ctx)
+	}
+}
+
+// SetAlgo set the execution algorithm of the market orders, default is market order, or chase on gate
+func (e *BaseExecutor) SetAlgo(conf AlgoConf) {
+	e.algo = conf
+}
+
+func (e *BaseExecutor) coll(name string) *mongo.Collection {
+	return e.db.Collection(CollName(e.namespace, name))
+}
+
+// SetLedger use the shared ledger to allocate capital, name is the allocation name of this executor
+func (e *BaseExecutor) SetLedger(ctx context.Context, ledger *Ledger, name string) error {
+	return e.account.Init(ctx, ledger, name, e.QuoteCurrency(), e.Symbol(), e.maxTotal)
 }
 
 func (e *BaseExecutor) Exchange() exchange.RestAPIExchange {
@@ -93,6 +150,11 @@ func (e *BaseExecutor) ActualTakerFee() decimal.Decimal {
 }
 
 func (e *BaseExecutor) Load(ctx context.Context) error {
+	if e.paper != nil {
+		if err := e.paper.Load(ctx); err != nil {
+			return err
+		}
+	}
 	if err := e.quota.Load(ctx); err != nil {
 		return err
 	}
@@ -117,7 +179,8 @@ func (e *BaseExecutor) buyAllLimit(price decimal.Decimal) (orderId uint64, err e
 	if total.GreaterThan(quota) {
 		total = quota
 	}
-	amount := total.DivRound(price, e.AmountPrecision())
+	total = e.account.Limit(total)
+	amount := total.Div(price).Truncate(e.AmountPrecision())
 	total = amount.Mul(price)
 	if amount.LessThan(e.MinAmount()) {
 		e.Sugar.Infof("amount (%s / %s) is too small", amount, e.MinAmount())
@@ -128,7 +191,11 @@ func (e *BaseExecutor) buyAllLimit(price decimal.Decimal) (orderId uint64, err e
 		//e.Sugar.Errorf("get client order id error: %s", err)
 		return
 	}
+	if err = e.account.Reserve(clientId, total); err != nil {
+		return
+	}
 	orderId, err = e.ex.BuyLimit(e.Symbol(), clientId, price, amount)
+	e.account.Placed(clientId, orderId, err)
 	if err != nil {
 		//e.Sugar.Errorf("buy error: %s", err)
 		return
@@ -174,7 +241,13 @@ func (e *BaseExecutor) sellAllLimit(price decimal.Decimal) (orderId uint64, err
 	return
 }
 
-func (e *BaseExecutor) buyAllMarket() (orderId uint64, err error) {
+func (e *BaseExecutor) buyAllMarket() (Progress, error) {
+	return e.buyMarket(decimal.Zero)
+}
+
+// buyMarket buy at most want (quote currency) by the execution algorithm, zero want means all.
+// It returns when the job is done, the progress is valid even if err is not nil.
+func (e *BaseExecutor) buyMarket(want decimal.Decimal) (p Progress, err error) {
 	// 1. check if have available balance
 	balance, err := e.ex.SpotAvailableBalance()
 	if err != nil {
@@ -184,6 +257,9 @@ func (e *BaseExecutor) buyAllMarket() (orderId uint64, err error) {
 	if total.GreaterThan(e.maxTotal) {
 		total = e.maxTotal
 	}
+	if want.IsPositive() && total.GreaterThan(want) {
+		total = want
+	}
 	quota := e.quota.Get()
 	if quota.IsZero() {
 		e.Sugar.Debug("no quota")
@@ -192,6 +268,7 @@ func (e *BaseExecutor) buyAllMarket() (orderId uint64, err error) {
 	if total.GreaterThan(quota) {
 		total = quota
 	}
+	total = e.account.Limit(total)
 	if total.LessThan(e.MinTotal()) {
 		e.Sugar.Infof("buy-market total (%s / %s) is too small", total, e.MinTotal())
 		return
@@ -201,29 +278,42 @@ func (e *BaseExecutor) buyAllMarket() (orderId uint64, err error) {
 		//e.Sugar.Errorf("get client order id error: %s", err)
 		return
 	}
-	e.Sugar.Infof("buy all (market), clientOrderId is %s", clientId)
-	orderId, err = e.ex.BuyMarket(e.symbol, clientId, total)
-	if err != nil {
-		//e.Sugar.Errorf("buy error: %s", err)
+	if err = e.account.Reserve(clientId, total); err != nil {
+		return
+	}
+	e.Sugar.Infof("buy all (market), clientOrderId is %s, total %s", clientId, total)
+	p, err = e.execute(Job{Direction: exchange.TradeDirectionBuy, ClientOrderId: clientId, Total: total})
+	// the reservation is bound to the job, the unfilled part is released
+	e.account.SettleJob(p)
+	e.quota.Sub(p.FilledTotal)
+	if p.FilledAmount.IsZero() {
 		return
 	}
-	e.Sugar.Infof("place buy-market order, id %d / %s, total %s", orderId, clientId, total)
 	if err1 := e.id.LongAdd(context.Background()); err1 != nil {
 		e.Sugar.Errorf("update buy times error: %s", err1)
 		// this error can ignore
 		//return err
 	}
-	e.quota.Sub(total)
+	e.broadcastDone("买入", p)
 	return
 }
 
-func (e *RestExecutor) sellAllMarket() (orderId uint64, err error) {
+func (e *BaseExecutor) sellAllMarket() (Progress, error) {
+	return e.sellMarket(decimal.Zero)
+}
+
+// sellMarket sell at most want (base currency) by the execution algorithm, zero want means all.
+// It returns when the job is done, the progress is valid even if err is not nil.
+func (e *BaseExecutor) sellMarket(want decimal.Decimal) (p Progress, err error) {
 	balance, err := e.ex.SpotAvailableBalance()
 	if err != nil {
 		return
 	}
 	amount := balance[e.BaseCurrency()]
-	amount = amount.Round(e.AmountPrecision())
+	if want.IsPositive() && amount.GreaterThan(want) {
+		amount = want
+	}
+	amount = amount.Truncate(e.AmountPrecision())
 	if amount.LessThan(e.MinAmount()) {
 		e.Sugar.Infof("amount ( %s / %s ) is too small", amount, e.MinAmount())
 		return
@@ -232,21 +322,104 @@ func (e *RestExecutor) sellAllMarket() (orderId uint64, err error) {
 	if err != nil {
 		return
 	}
-	e.Sugar.Infof("sell all (market), clientOrderId is %s", clientId)
-	orderId, err = e.ex.SellMarket(e.symbol, clientId, amount)
-	if err != nil {
+	e.Sugar.Infof("sell all (market), clientOrderId is %s, amount %s", clientId, amount)
+	p, err = e.execute(Job{Direction: exchange.TradeDirectionSell, ClientOrderId: clientId, Amount: amount})
+	e.quota.Add(p.FilledTotal)
+	e.account.Credit(p.FilledTotal)
+	if p.FilledAmount.IsZero() {
 		return
 	}
-	//e.SetSellOrderId(orderId)
 	if err := e.id.ShortAdd(context.Background()); err != nil {
 		e.Sugar.Errorf("update sell times error: %s", err)
 		// this error can ignore
 		//return err
 	}
+	e.broadcastDone("卖出", p)
+	return
+}
+
+// execute the market job by the execution algorithm, it blocks until the job is done
+func (e *BaseExecutor) execute(job Job) (Progress, error) {
+	algo, err := newMarketAlgorithm(e.algo, e.Name, e.ex, e.symbol, e.Sugar)
+	if err != nil {
+		return Progress{Job: job}, err
+	}
+	return algo.Execute(context.Background(), job)
+}
+
+// SetSizer set the position sizing policy
+func (e *BaseExecutor) SetSizer(conf SizingConf) (err error) {
+	e.sizer, err = NewSizer(conf)
 	return
 }
 
-func (e *RestExecutor) Broadcast(format string, a ...interface{}) {
+// SizeTarget return the target position (base currency) by the sizing policy,
+// the equity is the quote and base balance, capped by max total.
+func (e *BaseExecutor) SizeTarget(atr decimal.Decimal) (decimal.Decimal, error) {
+	if e.sizer == nil {
+		return decimal.Zero, errors.New("no sizing policy")
+	}
+	balance, err := e.ex.SpotAvailableBalance()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	price, err := e.ex.LastPrice(e.Symbol())
+	if err != nil {
+		return decimal.Zero, err
+	}
+	equity := balance[e.QuoteCurrency()].Add(balance[e.BaseCurrency()].Mul(price))
+	if equity.GreaterThan(e.maxTotal) {
+		equity = e.maxTotal
+	}
+	return e.sizer.Size(SizingInput{Equity: equity, Price: price, Atr: atr})
+}
+
+// targetPosition buy or sell at market price, make the position (base currency) to target.
+func (e *BaseExecutor) targetPosition(target decimal.Decimal) error {
+	direction, held, amount, price, err := e.planTarget(target)
+	if err != nil {
+		return err
+	}
+	e.Sugar.Infof("target position %s, held %s, direction %d, amount %s", target, held, direction, amount)
+	switch direction {
+	case 1:
+		_, err = e.buyMarket(amount.Mul(price).Round(e.PricePrecision()))
+	case -1:
+		_, err = e.sellMarket(amount)
+	}
+	return err
+}
+
+// buyTarget buy at market price to raise the position (base currency) to target, it never sells.
+func (e *BaseExecutor) buyTarget(target decimal.Decimal) error {
+	direction, held, amount, price, err := e.planTarget(target)
+	if err != nil {
+		return err
+	}
+	e.Sugar.Infof("buy to target position %s, held %s, direction %d, amount %s", target, held, direction, amount)
+	if direction != 1 {
+		return nil
+	}
+	_, err = e.buyMarket(amount.Mul(price).Round(e.PricePrecision()))
+	return err
+}
+
+// planTarget plan the market order to make the position equal to target, see PlanTarget.
+func (e *BaseExecutor) planTarget(target decimal.Decimal) (direction int, held, amount, price decimal.Decimal, err error) {
+	balance, err := e.ex.SpotAvailableBalance()
+	if err != nil {
+		return
+	}
+	price, err = e.ex.LastPrice(e.Symbol())
+	if err != nil {
+		return
+	}
+	held = balance[e.BaseCurrency()]
+	direction, amount = PlanTarget(held, target, price, e.AmountPrecision(), e.MinAmount(), e.MinTotal())
+	return
+}
+
+func (e *BaseExecutor) Broadcast(format string, a ...interface{}) {
 	message := fmt.Sprintf(format, a...)
 	labels := []string{e.Name, e.Label}
 	secondsEastOfUTC := int((8 * time.Hour).Seconds())
@@ -261,3 +434,8 @@ func (e *RestExecutor) Broadcast(format string, a ...interface{}) {
 		}
 	}
 }
+
+func (e *BaseExecutor) broadcastDone(action string, p Progress) {
+	e.Broadcast("%s完成，订单号: %s\n\t子订单数: %d\n\t成交数量: %s, 成交总金额: %s",
+		action, p.Job.ClientOrderId, p.Orders, p.FilledAmount, p.FilledTotal)
+}
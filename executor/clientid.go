@@ -0,0 +1,261 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xyths/hs"
+	"go.mongodb.org/mongo-driver/mongo"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// client order id, version 1: 1<strategy>-<symbol>-<side><intent>[level]-<sequence>[-child]
+// eg. 1st01-a3k-be-2s, 1grid-a3k-bg1c-2t-0
+// strategy and symbol are short codes, all numbers are base36, so it's short enough for all exchanges.
+const (
+	clientIdVersion    = '1'
+	clientIdSep        = "-"
+	strategyCodeLength = 4
+	symbolCodeLength   = 3
+)
+
+// Intent is the purpose of the order in the strategy
+type Intent byte
+
+const (
+	IntentEntry      Intent = 'e'
+	IntentExit       Intent = 'x'
+	IntentStop       Intent = 's'
+	IntentTakeProfit Intent = 't'
+	IntentTrailing   Intent = 'l' // trailing stop, it's told apart from the fixed stop
+	IntentReinforce  Intent = 'r'
+	IntentGrid       Intent = 'g'
+	IntentRebalance  Intent = 'b'
+	IntentMarket     Intent = 'm' // all in / all out at market price, the side tells entry or exit
+	IntentFutures    Intent = 'f' // futures target position, the side tells long or short
+)
+
+const (
+	SideBuy  = 'b'
+	SideSell = 's'
+)
+
+// MaxClientIdLength is the max length of client order id (exclude exchange's own prefix)
+var MaxClientIdLength = map[string]int{
+	"gate":  26, // text is "t-" + id, max 28 bytes
+	"huobi": 64,
+}
+
+// reserved for child order suffix, eg. "-12"
+const childSuffixLength = 4
+
+// ClientId is the parsed client order id
+type ClientId struct {
+	Strategy string // strategy instance code
+	Symbol   string // symbol code
+	Side     byte   // SideBuy / SideSell
+	Intent   Intent
+	Level    int   // grid level, -1 means no level
+	Sequence int64 // unique in the strategy instance
+	Child    int   // child order index of execution algorithm, -1 means not a child order
+}
+
+func (id ClientId) Buy() bool {
+	return id.Side == SideBuy
+}
+
+// Belongs check if the id belongs to the strategy instance and symbol
+func (id ClientId) Belongs(strategy, symbol string) bool {
+	return id.Strategy == StrategyCode(strategy) && id.Symbol == SymbolCode(symbol)
+}
+
+// StrategyCode return the strategy instance code, short name is used directly, long name is hashed.
+func StrategyCode(name string) string {
+	name = strings.ToLower(name)
+	if len(name) <= strategyCodeLength && isBase36(name) && name != "" {
+		return name
+	}
+	return hashCode(name, strategyCodeLength)
+}
+
+// SymbolCode return the hashed symbol code
+func SymbolCode(symbol string) string {
+	return hashCode(strings.ToLower(symbol), symbolCodeLength)
+}
+
+func hashCode(s string, length int) string {
+	max := uint32(1)
+	for i := 0; i < length; i++ {
+		max *= 36
+	}
+	code := strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(s))%max), 36)
+	return strings.Repeat("0", length-len(code)) + code
+}
+
+func isBase36(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// ClientIdCodec encode client order id for one strategy instance and symbol
+type ClientIdCodec struct {
+	strategy string
+	symbol   string
+	maxLen   int
+}
+
+// NewClientIdCodec create codec, the max length is decided by exchange name
+func NewClientIdCodec(exchangeName, strategy, symbol string) ClientIdCodec {
+	maxLen, ok := MaxClientIdLength[exchangeName]
+	if !ok {
+		maxLen = MaxClientIdLength["gate"]
+	}
+	return ClientIdCodec{
+		strategy: StrategyCode(strategy),
+		symbol:   SymbolCode(symbol),
+		maxLen:   maxLen,
+	}
+}
+
+// Encode client order id, level < 0 means no level
+func (c ClientIdCodec) Encode(side byte, intent Intent, level int, sequence int64) (string, error) {
+	var b strings.Builder
+	b.WriteByte(clientIdVersion)
+	b.WriteString(c.strategy)
+	b.WriteString(clientIdSep)
+	b.WriteString(c.symbol)
+	b.WriteString(clientIdSep)
+	b.WriteByte(side)
+	b.WriteByte(byte(intent))
+	if level >= 0 {
+		b.WriteString(strconv.FormatInt(int64(level), 36))
+	}
+	b.WriteString(clientIdSep)
+	b.WriteString(strconv.FormatInt(sequence, 36))
+	id := b.String()
+	if len(id)+childSuffixLength > c.maxLen {
+		return "", fmt.Errorf("client order id %s is too long, max length is %d", id, c.maxLen-childSuffixLength)
+	}
+	return id, nil
+}
+
+// ChildId is the client order id of the child order, the parsed Child is the index
+func ChildId(clientOrderId string, child int) string {
+	return clientOrderId + clientIdSep + strconv.Itoa(child)
+}
+
+// ParseClientId parse client order id, the exchange prefix (eg. gate's "t-") is ignored
+func ParseClientId(s string) (id ClientId, err error) {
+	s = strings.TrimPrefix(s, "t-")
+	if len(s) == 0 || s[0] != clientIdVersion {
+		return id, errors.New("unknown client order id version")
+	}
+	fields := strings.Split(s[1:], clientIdSep)
+	if len(fields) != 4 && len(fields) != 5 {
+		return id, fmt.Errorf("bad client order id: %s", s)
+	}
+	id.Strategy = fields[0]
+	id.Symbol = fields[1]
+	action := fields[2]
+	if len(action) < 2 || (action[0] != SideBuy && action[0] != SideSell) {
+		return id, fmt.Errorf("bad side and intent in client order id: %s", s)
+	}
+	id.Side = action[0]
+	id.Intent = Intent(action[1])
+	id.Level = -1
+	if len(action) > 2 {
+		level, err := strconv.ParseInt(action[2:], 36, 32)
+		if err != nil {
+			return id, err
+		}
+		id.Level = int(level)
+	}
+	if id.Sequence, err = strconv.ParseInt(fields[3], 36, 64); err != nil {
+		return id, err
+	}
+	id.Child = -1
+	if len(fields) == 5 {
+		child, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return id, err
+		}
+		id.Child = child
+	}
+	return id, nil
+}
+
+// legacy prefix to side and intent
+var prefixIntent = map[string]struct {
+	side   byte
+	intent Intent
+}{
+	prefixBuyMarketOrder:     {SideBuy, IntentMarket},
+	prefixBuyLimitOrder:      {SideBuy, IntentEntry},
+	prefixBuyStopOrder:       {SideBuy, IntentStop},
+	prefixBuyReinforceOrder:  {SideBuy, IntentReinforce},
+	prefixSellMarketOrder:    {SideSell, IntentMarket},
+	prefixSellLimitOrder:     {SideSell, IntentExit},
+	prefixSellStopOrder:      {SideSell, IntentStop},
+	prefixSellReinforceOrder: {SideSell, IntentReinforce},
+	prefixTakeProfitOrder:    {SideSell, IntentTakeProfit},
+	prefixTrailingStopOrder:  {SideSell, IntentTrailing},
+	prefixShortSellOrder:     {SideSell, IntentEntry},
+	prefixShortBuyOrder:      {SideBuy, IntentExit},
+	prefixFuturesLongOrder:   {SideBuy, IntentFutures},
+	prefixFuturesShortOrder:  {SideSell, IntentFutures},
+}
+
+// IsPrefix check if the client order id is generated with the legacy prefix, both legacy and codec format are supported
+func IsPrefix(clientOrderId, prefix string) bool {
+	if id, err := ParseClientId(clientOrderId); err == nil {
+		p, ok := prefixIntent[prefix]
+		return ok && id.Side == p.side && id.Intent == p.intent
+	}
+	return strings.HasPrefix(clientOrderId, prefix)
+}
+
+// EncodePrefix encode client order id with the legacy prefix, eg. "bl"
+func (c ClientIdCodec) EncodePrefix(prefix string, sequence int64) (string, error) {
+	p, ok := prefixIntent[prefix]
+	if !ok {
+		return "", fmt.Errorf("unknown client order id prefix: %s", prefix)
+	}
+	return c.Encode(p.side, p.intent, -1, sequence)
+}
+
+// Sequence is a persistent counter, never wrap
+type Sequence struct {
+	lock  sync.Mutex
+	key   string
+	value int64
+	coll  *mongo.Collection
+}
+
+func (s *Sequence) Init(coll *mongo.Collection, key string) {
+	s.coll = coll
+	s.key = key
+}
+
+func (s *Sequence) Load(ctx context.Context) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.value, err = hs.LoadInt64(ctx, s.coll, s.key)
+	return
+}
+
+func (s *Sequence) Next(ctx context.Context) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.value++
+	if err := hs.SaveInt64(ctx, s.coll, s.key, s.value); err != nil {
+		return 0, errors.New(fmt.Sprintf("save %s error: %s", s.key, err))
+	}
+	return s.value, nil
+}
@@ -0,0 +1,126 @@
+package executor
+
+import "testing"
+
+func TestClientIdCodec(t *testing.T) {
+	var tests = []struct {
+		exchange, strategy, symbol string
+		side                       byte
+		intent                     Intent
+		level                      int
+		sequence                   int64
+	}{
+		{"gate", "st01", "btc_usdt", SideBuy, IntentEntry, -1, 1},
+		{"gate", "grid", "eth_usdt", SideSell, IntentGrid, 99, 123456789},
+		{"huobi", "supertrend-btc-daily", "btcusdt", SideSell, IntentStop, -1, 0},
+		{"gate", "Rtm", "ht_usdt", SideBuy, IntentRebalance, 0, 36},
+	}
+	for i, tt := range tests {
+		codec := NewClientIdCodec(tt.exchange, tt.strategy, tt.symbol)
+		s, err := codec.Encode(tt.side, tt.intent, tt.level, tt.sequence)
+		if err != nil {
+			t.Fatalf("[%d] encode error: %s", i, err)
+		}
+		if len(s)+childSuffixLength > MaxClientIdLength[tt.exchange] {
+			t.Errorf("[%d] %s is too long", i, s)
+		}
+		for _, raw := range []string{s, "t-" + s, s + "-3"} {
+			id, err := ParseClientId(raw)
+			if err != nil {
+				t.Fatalf("[%d] parse %s error: %s", i, raw, err)
+			}
+			if !id.Belongs(tt.strategy, tt.symbol) {
+				t.Errorf("[%d] %s should belong to %s/%s", i, raw, tt.strategy, tt.symbol)
+			}
+			if id.Side != tt.side || id.Intent != tt.intent || id.Level != tt.level || id.Sequence != tt.sequence {
+				t.Errorf("[%d] parse %s, got %+v", i, raw, id)
+			}
+		}
+	}
+}
+
+func TestClientIdCodec_EncodePrefix(t *testing.T) {
+	codec := NewClientIdCodec("gate", "rtm", "btc_usdt")
+	actions := make(map[string]string)
+	for prefix, p := range prefixIntent {
+		s, err := codec.EncodePrefix(prefix, 1)
+		if err != nil {
+			t.Errorf("encode prefix %s error: %s", prefix, err)
+			continue
+		}
+		id, err := ParseClientId(s)
+		if err != nil {
+			t.Errorf("parse %s error: %s", s, err)
+			continue
+		}
+		if id.Side != p.side || id.Intent != p.intent {
+			t.Errorf("prefix %s want %c%c, got %c%c", prefix, p.side, p.intent, id.Side, id.Intent)
+		}
+		action := string([]byte{id.Side, byte(id.Intent)})
+		if other, ok := actions[action]; ok {
+			t.Errorf("prefix %s and %s have the same side and intent %s", other, prefix, action)
+		}
+		actions[action] = prefix
+		if !IsPrefix(s, prefix) {
+			t.Errorf("%s should be the prefix %s", s, prefix)
+		}
+	}
+}
+
+func TestParseClientId_Legacy(t *testing.T) {
+	for _, s := range []string{"bl-0-1-2", "pre-buy", "b-3", ""} {
+		if _, err := ParseClientId(s); err == nil {
+			t.Errorf("%s should not be parsed", s)
+		}
+	}
+}
+
+func TestClientIdManager_Is(t *testing.T) {
+	m := ClientIdManager{}
+	codec := NewClientIdCodec("gate", "rtm", "btc_usdt")
+	id, _ := codec.EncodePrefix(prefixBuyLimitOrder, 1)
+	market, _ := codec.EncodePrefix(prefixBuyMarketOrder, 2)
+	var tests = []struct {
+		clientOrderId, prefix string
+		result                bool
+	}{
+		{"bl-0-1-2", prefixBuyLimitOrder, true},
+		{"sl-0-1-2", prefixBuyLimitOrder, false},
+		{id, prefixBuyLimitOrder, true},
+		{id, prefixSellLimitOrder, false},
+		{id, prefixBuyMarketOrder, false},
+		{market, prefixBuyMarketOrder, true},
+		{market, prefixBuyLimitOrder, false},
+	}
+	for i, tt := range tests {
+		if got := m.Is(tt.clientOrderId, tt.prefix); got != tt.result {
+			t.Errorf("[%d] want %t, got %t", i, tt.result, got)
+		}
+	}
+}
+
+func TestClientIdManager_Belongs(t *testing.T) {
+	m := ClientIdManager{}
+	if !m.Belongs("1rtm-a3k-bm-1") {
+		t.Error("all orders belong to the executor without codec")
+	}
+	m.UseCodec("gate", "rtm", "btc_usdt")
+	mine, _ := NewClientIdCodec("gate", "rtm", "btc_usdt").EncodePrefix(prefixBuyLimitOrder, 1)
+	other, _ := NewClientIdCodec("gate", "grid", "btc_usdt").EncodePrefix(prefixBuyLimitOrder, 1)
+	otherSymbol, _ := NewClientIdCodec("gate", "rtm", "eth_usdt").EncodePrefix(prefixBuyLimitOrder, 1)
+	var tests = []struct {
+		clientOrderId string
+		result        bool
+	}{
+		{mine, true},
+		{"t-" + mine, true},
+		{other, false},
+		{otherSymbol, false},
+		{"bl-0-1-2", true}, // legacy
+	}
+	for i, tt := range tests {
+		if got := m.Belongs(tt.clientOrderId); got != tt.result {
+			t.Errorf("[%d] %s want %t, got %t", i, tt.clientOrderId, tt.result, got)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	ConditionalStopLoss     = "stop-loss"
+	ConditionalTakeProfit   = "take-profit"
+	ConditionalTrailingStop = "trailing-stop"
+)
+
+// ConditionalOrder is a client-side emulated stop-loss / take-profit / trailing-stop order.
+// Direction is the direction of the order fired, eg. a stop-loss for long position is a sell.
+type ConditionalOrder struct {
+	Id        string // client order id of the fired order
+	Group     string // OCO group, when one fired, the others in the same group are cancelled
+	Type      string
+	Direction string
+
+	Price      decimal.Decimal // trigger price, for trailing-stop it's the current stop price
+	LimitPrice decimal.Decimal // zero means fire by the execution algorithm (market by default)
+	Amount     decimal.Decimal // base amount, for sell and buy-limit
+	Total      decimal.Decimal // quote total, for buy without limit price
+
+	TrailPercent float64         // trailing-stop distance in percent, eg. 5 means 5%
+	TrailAtr     float64         // trailing-stop distance in ATR, used when TrailPercent is zero
+	Atr          decimal.Decimal // last ATR, updated by ConditionalEngine.UpdateAtr
+	Extreme      decimal.Decimal // highest (sell) or lowest (buy) price since created
+
+	Created time.Time
+}
+
+func (o ConditionalOrder) sell() bool {
+	return o.Direction == exchange.TradeDirectionSell
+}
+
+// trail move the stop price with the extreme price, returns true if the stop price changed
+func (o *ConditionalOrder) trail(price decimal.Decimal) bool {
+	if o.Type != ConditionalTrailingStop {
+		return false
+	}
+	if o.Extreme.IsZero() || (o.sell() && price.GreaterThan(o.Extreme)) || (!o.sell() && price.LessThan(o.Extreme)) {
+		o.Extreme = price
+	}
+	var distance decimal.Decimal
+	if o.TrailPercent > 0 {
+		distance = o.Extreme.Mul(decimal.NewFromFloat(o.TrailPercent / 100))
+	} else if o.TrailAtr > 0 && o.Atr.IsPositive() {
+		distance = o.Atr.Mul(decimal.NewFromFloat(o.TrailAtr))
+	} else {
+		return false
+	}
+	stop := o.Extreme.Sub(distance)
+	if !o.sell() {
+		stop = o.Extreme.Add(distance)
+	}
+	// the stop never moves backward
+	if o.Price.IsZero() || (o.sell() && stop.GreaterThan(o.Price)) || (!o.sell() && stop.LessThan(o.Price)) {
+		o.Price = stop
+		return true
+	}
+	return false
+}
+
+// Triggered check if the price hit the trigger
+func (o ConditionalOrder) Triggered(price decimal.Decimal) bool {
+	if o.Price.IsZero() {
+		return false
+	}
+	switch o.Type {
+	case ConditionalStopLoss, ConditionalTrailingStop:
+		if o.sell() {
+			return price.LessThanOrEqual(o.Price)
+		}
+		return price.GreaterThanOrEqual(o.Price)
+	case ConditionalTakeProfit:
+		if o.sell() {
+			return price.GreaterThanOrEqual(o.Price)
+		}
+		return price.LessThanOrEqual(o.Price)
+	}
+	return false
+}
+
+// for mongodb
+type conditionalRecord struct {
+	Id           string    `bson:"_id"`
+	Group        string    `bson:",omitempty"`
+	Type         string    `bson:"type"`
+	Direction    string    `bson:"direction"`
+	Price        string    `bson:"price"`
+	LimitPrice   string    `bson:"limitPrice"`
+	Amount       string    `bson:"amount"`
+	Total        string    `bson:"total"`
+	TrailPercent float64   `bson:"trailPercent"`
+	TrailAtr     float64   `bson:"trailAtr"`
+	Atr          string    `bson:"atr"`
+	Extreme      string    `bson:"extreme"`
+	Created      time.Time `bson:"created"`
+}
+
+func (r conditionalRecord) order() ConditionalOrder {
+	return ConditionalOrder{
+		Id:           r.Id,
+		Group:        r.Group,
+		Type:         r.Type,
+		Direction:    r.Direction,
+		Price:        decimalOrZero(r.Price),
+		LimitPrice:   decimalOrZero(r.LimitPrice),
+		Amount:       decimalOrZero(r.Amount),
+		Total:        decimalOrZero(r.Total),
+		TrailPercent: r.TrailPercent,
+		TrailAtr:     r.TrailAtr,
+		Atr:          decimalOrZero(r.Atr),
+		Extreme:      decimalOrZero(r.Extreme),
+		Created:      r.Created,
+	}
+}
+
+func decimalOrZero(s string) decimal.Decimal {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Zero
+	}
+	return d
+}
+
+// TriggerHandler is called after a conditional order fired
+type TriggerHandler func(o ConditionalOrder, p Progress, err error)
+
+// ConditionalEngine watch the price and fire the conditional orders.
+// The pending orders are saved in mongodb, so they survive restart.
+type ConditionalEngine struct {
+	Sugar   *zap.SugaredLogger
+	ex      exchange.RestAPIExchange
+	symbol  exchange.Symbol
+	algo    Algorithm
+	coll    *mongo.Collection
+	handler TriggerHandler
+
+	lock   sync.Mutex
+	orders map[string]*ConditionalOrder
+
+	prices chan decimal.Decimal // the latest pushed price, handled by Watch
+}
+
+func (e *ConditionalEngine) Init(ex exchange.RestAPIExchange, symbol exchange.Symbol, sugar *zap.SugaredLogger,
+	coll *mongo.Collection, algo Algorithm, handler TriggerHandler) {
+	e.ex = ex
+	e.symbol = symbol
+	e.Sugar = sugar
+	e.coll = coll
+	e.algo = algo
+	e.handler = handler
+	e.orders = make(map[string]*ConditionalOrder)
+	e.prices = make(chan decimal.Decimal, 1)
+}
+
+// Load all pending conditional orders from database
+func (e *ConditionalEngine) Load(ctx context.Context) error {
+	cursor, err := e.coll.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	var records []conditionalRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, r := range records {
+		o := r.order()
+		e.orders[o.Id] = &o
+		e.Sugar.Infof("loaded %s order %s, price: %s", o.Type, o.Id, o.Price)
+	}
+	return nil
+}
+
+// Add a conditional order, replace the old one if the Id exists
+func (e *ConditionalEngine) Add(ctx context.Context, o ConditionalOrder) error {
+	if o.Id == "" {
+		return errors.New("conditional order id is empty")
+	}
+	if o.Type == ConditionalTrailingStop && o.TrailPercent <= 0 && o.TrailAtr <= 0 {
+		return errors.New("trailing-stop need percent or atr distance")
+	}
+	if o.Created.IsZero() {
+		o.Created = time.Now()
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err := e.save(ctx, o); err != nil {
+		return err
+	}
+	e.orders[o.Id] = &o
+	e.Sugar.Infof("add %s order %s, direction: %s, price: %s", o.Type, o.Id, o.Direction, o.Price)
+	return nil
+}
+
+// AddOCO add orders in one OCO group
+func (e *ConditionalEngine) AddOCO(ctx context.Context, group string, orders ...ConditionalOrder) error {
+	for _, o := range orders {
+		o.Group = group
+		if err := e.Add(ctx, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdatePrice update the trigger price of stop-loss / take-profit order
+func (e *ConditionalEngine) UpdatePrice(ctx context.Context, id string, price decimal.Decimal) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	o, ok := e.orders[id]
+	if !ok {
+		return fmt.Errorf("conditional order %s not found", id)
+	}
+	if o.Price.Equal(price) {
+		return nil
+	}
+	o.Price = price
+	return e.save(ctx, *o)
+}
+
+// UpdateAtr update the ATR of all ATR trailing-stop orders
+func (e *ConditionalEngine) UpdateAtr(ctx context.Context, atr decimal.Decimal) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, o := range e.orders {
+		if o.Type != ConditionalTrailingStop || o.TrailAtr <= 0 {
+			continue
+		}
+		o.Atr = atr
+		if err := e.save(ctx, *o); err != nil {
+			e.Sugar.Errorf("save conditional order %s error: %s", o.Id, err)
+		}
+	}
+}
+
+// Cancel the conditional order
+func (e *ConditionalEngine) Cancel(ctx context.Context, id string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.remove(ctx, id)
+}
+
+// CancelAll cancel all pending conditional orders
+func (e *ConditionalEngine) CancelAll(ctx context.Context) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for id := range e.orders {
+		if err := e.remove(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending return a copy of all pending conditional orders
+func (e *ConditionalEngine) Pending() []ConditionalOrder {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	var orders []ConditionalOrder
+	for _, o := range e.orders {
+		orders = append(orders, *o)
+	}
+	return orders
+}
+
+// firing is the triggered order, with the orders removed with it (itself and its OCO group)
+type firing struct {
+	order   ConditionalOrder
+	removed []ConditionalOrder
+}
+
+// OnPrice check all pending orders with the new price, fire the triggered ones.
+// If the fire failed without any fill, the order and its OCO group are restored, fired again on the next price.
+// It's called by the price feed, eg. ticker subscription or Start.
+func (e *ConditionalEngine) OnPrice(ctx context.Context, price decimal.Decimal) {
+	var triggered []ConditionalOrder
+	var toFire []firing
+	e.lock.Lock()
+	for _, o := range e.orders {
+		if o.trail(price) {
+			e.Sugar.Debugf("trailing-stop %s moved to %s", o.Id, o.Price)
+			if err := e.save(ctx, *o); err != nil {
+				e.Sugar.Errorf("save conditional order %s error: %s", o.Id, err)
+			}
+		}
+		if o.Triggered(price) {
+			triggered = append(triggered, *o)
+		}
+	}
+	for _, o := range triggered {
+		if _, ok := e.orders[o.Id]; !ok {
+			// cancelled by other order in the same OCO group
+			continue
+		}
+		if err := e.remove(ctx, o.Id); err != nil {
+			// still pending, try again on the next price
+			e.Sugar.Errorf("remove conditional order %s error: %s", o.Id, err)
+			continue
+		}
+		f := firing{order: o, removed: []ConditionalOrder{o}}
+		if o.Group != "" {
+			for id, other := range e.orders {
+				if other.Group == o.Group {
+					removed := *other
+					if err := e.remove(ctx, id); err != nil {
+						e.Sugar.Errorf("remove conditional order %s error: %s", id, err)
+						continue
+					}
+					f.removed = append(f.removed, removed)
+				}
+			}
+		}
+		toFire = append(toFire, f)
+	}
+	e.lock.Unlock()
+
+	for _, f := range toFire {
+		p, err := e.fire(ctx, f.order, price)
+		if err != nil && p.FilledAmount.IsZero() {
+			e.restore(ctx, f.removed)
+		}
+		if e.handler != nil {
+			e.handler(f.order, p, err)
+		}
+	}
+}
+
+// restore the removed orders, when the fire failed
+func (e *ConditionalEngine) restore(ctx context.Context, orders []ConditionalOrder) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, o := range orders {
+		o := o
+		if err := e.save(ctx, o); err != nil {
+			e.Sugar.Errorf("restore conditional order %s error: %s", o.Id, err)
+			continue
+		}
+		e.orders[o.Id] = &o
+		e.Sugar.Infof("restore %s order %s, price: %s", o.Type, o.Id, o.Price)
+	}
+}
+
+// Start poll the last price every interval, for exchanges without websocket
+func (e *ConditionalEngine) Start(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			if len(e.Pending()) == 0 {
+				continue
+			}
+			price, err := e.ex.LastPrice(e.symbol.Symbol)
+			if err != nil {
+				e.Sugar.Errorf("get last price error: %s", err)
+				continue
+			}
+			e.OnPrice(ctx, price)
+		}
+	}
+}
+
+// Feed send the pushed price to Watch, the price not handled yet is replaced, so it never blocks the feed
+func (e *ConditionalEngine) Feed(price decimal.Decimal) {
+	for {
+		select {
+		case e.prices <- price:
+			return
+		default:
+			select {
+			case <-e.prices:
+			default:
+			}
+		}
+	}
+}
+
+// Watch fire the orders by the prices sent with Feed, one price at a time, for exchanges with websocket
+func (e *ConditionalEngine) Watch(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case price := <-e.prices:
+			e.OnPrice(ctx, price)
+		}
+	}
+}
+
+func (e *ConditionalEngine) fire(ctx context.Context, o ConditionalOrder, price decimal.Decimal) (Progress, error) {
+	e.Sugar.Infof("%s order %s triggered, trigger price: %s, last price: %s", o.Type, o.Id, o.Price, price)
+	p := Progress{Job: Job{Direction: o.Direction, ClientOrderId: o.Id, Total: o.Total, Amount: o.Amount}}
+	var err error
+	if o.LimitPrice.IsPositive() {
+		var orderId uint64
+		if o.sell() {
+			orderId, err = e.ex.SellLimit(e.symbol.Symbol, o.Id, o.LimitPrice, o.Amount)
+		} else {
+			orderId, err = e.ex.BuyLimit(e.symbol.Symbol, o.Id, o.LimitPrice, o.Amount)
+		}
+		p.Orders++
+		p.Order = exchange.Order{Id: orderId, ClientOrderId: o.Id, Price: o.LimitPrice, Amount: o.Amount}
+	} else {
+		if p.Job.Direction == exchange.TradeDirectionBuy && p.Job.Total.IsZero() {
+			p.Job.Total = o.Amount.Mul(price).Round(e.symbol.PricePrecision)
+		}
+		p, err = e.algo.Execute(ctx, p.Job)
+	}
+	if err != nil {
+		e.Sugar.Errorf("fire %s order %s error: %s", o.Type, o.Id, err)
+	}
+	return p, err
+}
+
+func (e *ConditionalEngine) save(ctx context.Context, o ConditionalOrder) error {
+	option := options.FindOneAndUpdate().SetUpsert(true)
+	r := e.coll.FindOneAndUpdate(
+		ctx,
+		bson.D{
+			{"_id", o.Id},
+		},
+		bson.D{
+			{"$set", bson.D{
+				{"group", o.Group},
+				{"type", o.Type},
+				{"direction", o.Direction},
+				{"price", o.Price.String()},
+				{"limitPrice", o.LimitPrice.String()},
+				{"amount", o.Amount.String()},
+				{"total", o.Total.String()},
+				{"trailPercent", o.TrailPercent},
+				{"trailAtr", o.TrailAtr},
+				{"atr", o.Atr.String()},
+				{"extreme", o.Extreme.String()},
+				{"created", o.Created},
+			}},
+		},
+		option,
+	)
+	if r.Err() != nil && r.Err() != mongo.ErrNoDocuments {
+		return errors.New(fmt.Sprintf("save conditional order error: %s", r.Err()))
+	}
+	return nil
+}
+
+func (e *ConditionalEngine) remove(ctx context.Context, id string) error {
+	if _, err := e.coll.DeleteOne(ctx, bson.D{{"_id", id}}); err != nil {
+		return err
+	}
+	delete(e.orders, id)
+	return nil
+}
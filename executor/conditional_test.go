@@ -0,0 +1,76 @@
+package executor
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestConditionalOrder_Triggered(t *testing.T) {
+	var tests = []struct {
+		typ, direction string
+		trigger, price string
+		result         bool
+	}{
+		{ConditionalStopLoss, exchange.TradeDirectionSell, "100", "101", false},
+		{ConditionalStopLoss, exchange.TradeDirectionSell, "100", "100", true},
+		{ConditionalStopLoss, exchange.TradeDirectionBuy, "100", "99", false},
+		{ConditionalStopLoss, exchange.TradeDirectionBuy, "100", "101", true},
+		{ConditionalTakeProfit, exchange.TradeDirectionSell, "100", "99", false},
+		{ConditionalTakeProfit, exchange.TradeDirectionSell, "100", "101", true},
+		{ConditionalTakeProfit, exchange.TradeDirectionBuy, "100", "99", true},
+		{ConditionalStopLoss, exchange.TradeDirectionSell, "0", "1", false},
+	}
+	for i, tt := range tests {
+		o := ConditionalOrder{Type: tt.typ, Direction: tt.direction, Price: decimal.RequireFromString(tt.trigger)}
+		if got := o.Triggered(decimal.RequireFromString(tt.price)); got != tt.result {
+			t.Errorf("[%d] want %t, got %t", i, tt.result, got)
+		}
+	}
+}
+
+func TestConditionalOrder_trail(t *testing.T) {
+	var tests = []struct {
+		order  ConditionalOrder
+		prices []string
+		stop   string
+	}{
+		// percent, sell
+		{ConditionalOrder{Type: ConditionalTrailingStop, Direction: exchange.TradeDirectionSell, TrailPercent: 10},
+			[]string{"100", "120", "110"}, "108"},
+		// percent, buy
+		{ConditionalOrder{Type: ConditionalTrailingStop, Direction: exchange.TradeDirectionBuy, TrailPercent: 10},
+			[]string{"100", "80", "90"}, "88"},
+		// atr, sell
+		{ConditionalOrder{Type: ConditionalTrailingStop, Direction: exchange.TradeDirectionSell, TrailAtr: 2, Atr: decimal.NewFromInt(5)},
+			[]string{"100", "95", "105"}, "95"},
+		// no distance, never trail
+		{ConditionalOrder{Type: ConditionalTrailingStop, Direction: exchange.TradeDirectionSell, TrailAtr: 2},
+			[]string{"100", "120"}, "0"},
+	}
+	for i, tt := range tests {
+		o := tt.order
+		for _, p := range tt.prices {
+			o.trail(decimal.RequireFromString(p))
+		}
+		if !o.Price.Equal(decimal.RequireFromString(tt.stop)) {
+			t.Errorf("[%d] want stop %s, got %s", i, tt.stop, o.Price)
+		}
+	}
+}
+
+func TestConditionalEngine_Feed(t *testing.T) {
+	var e ConditionalEngine
+	e.Init(nil, exchange.Symbol{}, zap.NewNop().Sugar(), nil, nil, nil)
+	e.Feed(decimal.NewFromInt(1))
+	e.Feed(decimal.NewFromInt(2))
+	if price := <-e.prices; !price.Equal(decimal.NewFromInt(2)) {
+		t.Errorf("want the latest price 2, got %s", price)
+	}
+	select {
+	case price := <-e.prices:
+		t.Errorf("want one price, got another %s", price)
+	default:
+	}
+}
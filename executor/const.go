@@ -4,6 +4,9 @@ const (
 	collNameState = "state"
 	collNameOrder = "order"
 
+	collNamePaper      = "paper"
+	collNamePaperOrder = "paper_order"
+
 	sep                      = "-"
 	prefixBuyMarketOrder     = "bm"
 	prefixBuyLimitOrder      = "bl"
@@ -13,4 +16,18 @@ const (
 	prefixSellLimitOrder     = "sl"
 	prefixSellStopOrder      = "ss"
 	prefixSellReinforceOrder = "sr"
-)
\ No newline at end of file
+	prefixTakeProfitOrder    = "tp"
+	prefixTrailingStopOrder  = "ts"
+	prefixShortSellOrder     = "ms"
+	prefixShortBuyOrder      = "mb"
+	prefixFuturesLongOrder   = "fl"
+	prefixFuturesShortOrder  = "fs"
+)
+
+// CollName return the collection name in namespace, empty namespace is the legacy single symbol database
+func CollName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "_" + name
+}
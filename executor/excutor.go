@@ -7,9 +7,10 @@ import (
 	"github.com/xyths/hs"
 	"github.com/xyths/hs/exchange"
 	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/hub"
+	"github.com/xyths/qtr/mtf"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
-	"strings"
 	"sync"
 	"time"
 )
@@ -31,7 +32,17 @@ type Executor struct {
 	fee    exchange.Fee
 	id     ClientIdManager
 
-	quota Quota
+	quota   Quota
+	account Account
+	algo    AlgoConf // execution algorithm of the market orders
+
+	hub      *hub.Hub
+	consumer *hub.Consumer
+	candles  *mtf.Manager
+
+	paper     *PaperExchange
+	stopPaper context.CancelFunc
+	namespace string // collection name prefix, for multiple symbols in one database
 
 	buyOrderLock  sync.RWMutex
 	buyOrderId    uint64
@@ -42,15 +53,49 @@ type Executor struct {
 }
 
 func NewExecutor(config hs.ExchangeConf) (*Executor, error) {
-	e := Executor{
-		config: config,
+	ex, err := huobi.New(config.Label, config.Key, config.Secret, config.Host)
+	if err != nil {
+		return nil, err
 	}
-	ex, err := huobi.New(e.config.Label, e.config.Key, e.config.Secret, e.config.Host)
+	h := hub.New(ex)
+	return newExecutor(config, ex, h, mtf.New(ex, h), config.Symbols[0], "")
+}
+
+// NewExecutors create executors for all symbols in config,
+// they share one exchange connection, market data hub and candle manager.
+// every symbol has its own state namespace, except there is only one symbol.
+func NewExecutors(config hs.ExchangeConf) ([]*Executor, error) {
+	ex, err := huobi.New(config.Label, config.Key, config.Secret, config.Host)
 	if err != nil {
 		return nil, err
 	}
-	e.ex = ex
-	e.symbol, err = e.ex.GetSymbol(context.Background(), e.config.Symbols[0])
+	h := hub.New(ex)
+	m := mtf.New(ex, h)
+	var executors []*Executor
+	for _, symbol := range config.Symbols {
+		namespace := ""
+		if len(config.Symbols) > 1 {
+			namespace = symbol
+		}
+		e, err := newExecutor(config, ex, h, m, symbol, namespace)
+		if err != nil {
+			return nil, err
+		}
+		executors = append(executors, e)
+	}
+	return executors, nil
+}
+
+func newExecutor(config hs.ExchangeConf, ex exchange.Exchange, h *hub.Hub, m *mtf.Manager, symbol, namespace string) (*Executor, error) {
+	e := Executor{
+		config:    config,
+		ex:        ex,
+		hub:       h,
+		candles:   m,
+		namespace: namespace,
+	}
+	var err error
+	e.symbol, err = e.ex.GetSymbol(context.Background(), symbol)
 	if err != nil {
 		return nil, err
 	}
@@ -62,16 +107,59 @@ func NewExecutor(config hs.ExchangeConf) (*Executor, error) {
 	return &e, nil
 }
 
+// UsePaper switch to paper trading, orders are simulated by live market data, must be called before Init
+func (e *Executor) UsePaper(conf PaperConf) {
+	e.paper = NewPaperExchange(e.ex, conf)
+	e.ex = PaperWsExchange{PaperExchange: e.paper, WsAPIExchange: e.ex}
+}
+
+// SetAlgo set the execution algorithm of the market orders, default is market order, or chase on gate
+func (e *Executor) SetAlgo(conf AlgoConf) {
+	e.algo = conf
+}
+
+// Paper return the paper exchange, nil if it's not paper trading
+func (e *Executor) Paper() *PaperExchange {
+	return e.paper
+}
+
 func (e *Executor) Init(sugar *zap.SugaredLogger, db *mongo.Database, maxTotal decimal.Decimal) {
 	e.Sugar = sugar
 	e.db = db
 	e.maxTotal = maxTotal
-	e.id.Init("-", db.Collection(collNameState))
-	e.OrderProxy.Init(db.Collection(collNameOrder))
-	e.quota.Init(db.Collection(collNameState), e.maxTotal)
+	e.hub.Init(sugar)
+	e.candles.Init(sugar)
+	if e.paper != nil {
+		e.paper.SetNamespace(e.namespace)
+		e.paper.Init(sugar, db)
+	}
+	e.id.Init("-", e.coll(collNameState))
+	e.id.UseCodec(e.config.Name, e.config.Label, e.Symbol())
+	e.OrderProxy.Init(e.coll(collNameOrder))
+	e.quota.Init(e.coll(collNameState), e.maxTotal)
+}
+
+// SetStrategy set the strategy instance name encoded in client order id (default is the exchange label),
+// must be called after Init
+func (e *Executor) SetStrategy(name string) {
+	e.id.UseCodec(e.config.Name, name, e.Symbol())
+}
+
+// SetNamespace set the state namespace, the paper account is in it too, must be called before Init
+func (e *Executor) SetNamespace(namespace string) {
+	e.namespace = namespace
+}
+
+func (e *Executor) coll(name string) *mongo.Collection {
+	return e.db.Collection(CollName(e.namespace, name))
 }
 
 func (e *Executor) Load(ctx context.Context) error {
+	if e.paper != nil {
+		if err := e.paper.Load(ctx); err != nil {
+			return err
+		}
+	}
 	if err := e.quota.Load(ctx); err != nil {
 		return err
 	}
@@ -84,9 +172,24 @@ func (e *Executor) Load(ctx context.Context) error {
 	return nil
 }
 
+// SetLedger use the shared ledger to allocate capital, name is the allocation name of this executor
+func (e *Executor) SetLedger(ctx context.Context, ledger *Ledger, name string) error {
+	return e.account.Init(ctx, ledger, name, e.QuoteCurrency(), e.Symbol(), e.maxTotal)
+}
+
 func (e *Executor) Exchange() exchange.Exchange {
 	return e.ex
 }
+
+// Hub return the market data hub, strategies should subscribe market data from it
+func (e *Executor) Hub() *hub.Hub {
+	return e.hub
+}
+
+// Candles return the multi-timeframe candle manager, it's shared by the executors of all symbols
+func (e *Executor) Candles() *mtf.Manager {
+	return e.candles
+}
 func (e *Executor) Symbol() string {
 	return e.symbol.Symbol
 }
@@ -126,11 +229,70 @@ func (e *Executor) SubscribeCandle(clientId string, period time.Duration, respon
 }
 
 func (e *Executor) Start() {
-	e.ex.SubscribeOrder(e.Symbol(), "rtm-order", e.OrderUpdateHandler)
+	if e.paper != nil {
+		var ctx context.Context
+		ctx, e.stopPaper = context.WithCancel(context.Background())
+		e.paper.SetHandler(e.PaperHandler)
+		go e.paper.Start(ctx)
+		return
+	}
+	e.consumer = e.hub.Register("executor-"+e.Symbol(), hub.DefaultQueueSize, func(event hub.Event) {
+		e.OrderUpdateHandler(event.Raw)
+	})
+	e.hub.SubscribeOrder(e.consumer, e.Symbol())
 }
 
 func (e *Executor) Stop() {
-	e.ex.UnsubscribeOrder(e.Symbol(), "rtm-order")
+	if e.stopPaper != nil {
+		e.stopPaper()
+		return
+	}
+	if e.consumer != nil {
+		e.hub.Unregister(e.consumer)
+		e.consumer = nil
+	}
+}
+
+// PaperHandler is the OrderUpdateHandler for paper trading
+func (e *Executor) PaperHandler(event PaperEvent) {
+	o := event.Order
+	if !e.id.Belongs(o.ClientOrderId) {
+		return
+	}
+	if e.isMarketJob(o.ClientOrderId) {
+		// accounted when the job is done
+		return
+	}
+	switch event.Type {
+	case PaperEventCancel:
+		e.Sugar.Debugf("paper order cancelled, orderId: %d, clientOrderId: %s", o.Id, o.ClientOrderId)
+		if event.Direction != exchange.TradeDirectionBuy {
+			return
+		}
+		e.account.Settle(o)
+		if o.Type != paperTypeLimit {
+			return
+		}
+		// refund the quota
+		remain := o.Amount.Sub(o.FilledAmount)
+		if remain.IsPositive() {
+			e.quota.Add(o.Price.Mul(remain))
+		}
+	case PaperEventTrade:
+		e.Sugar.Debugf("paper order filled, orderId: %d, clientOrderId: %s", o.Id, o.ClientOrderId)
+		if event.Direction == exchange.TradeDirectionBuy {
+			e.account.Settle(o)
+			return
+		}
+		total := o.FilledPrice.Mul(o.FilledAmount)
+		e.quota.Add(total)
+		e.account.Credit(total)
+	}
+}
+
+// isMarketJob check if the order is a child order of the market job, which is executed by the algorithm
+func (e *Executor) isMarketJob(clientOrderId string) bool {
+	return e.id.Is(clientOrderId, prefixBuyMarketOrder) || e.id.Is(clientOrderId, prefixSellMarketOrder)
 }
 
 func (e *Executor) OrderUpdateHandler(response interface{}) {
@@ -156,6 +318,10 @@ func (e *Executor) OrderUpdateHandler(response interface{}) {
 			e.Sugar.Debugf("no clientOrderId, not my order %d", o.OrderId)
 			return
 		}
+		if !e.id.Belongs(o.ClientOrderId) {
+			e.Sugar.Debugf("order %d / %s belongs to other strategy", o.OrderId, o.ClientOrderId)
+			return
+		}
 		o2 := Order{
 			Id:            uint64(o.OrderId),
 			ClientOrderId: o.ClientOrderId,
@@ -169,9 +335,12 @@ func (e *Executor) OrderUpdateHandler(response interface{}) {
 			_ = e.CreateOrder(context.Background(), o2)
 		case "cancellation":
 			e.Sugar.Debugf("order cancelled, orderId: %d, clientOrderId: %s", o.OrderId, o.ClientOrderId)
-			if !strings.HasPrefix(o.ClientOrderId, prefixBuyLimitOrder) {
+			if !e.id.Is(o.ClientOrderId, prefixBuyLimitOrder) {
 				return
 			}
+			if o3, err := e.ex.GetOrderById(uint64(o.OrderId), e.Symbol()); err == nil {
+				e.account.Settle(o3)
+			}
 			o2.Remain = o.RemainAmt
 			remain, err := decimal.NewFromString(o2.Remain)
 			if err != nil || !remain.IsPositive() {
@@ -190,7 +359,13 @@ func (e *Executor) OrderUpdateHandler(response interface{}) {
 		case "trade":
 			e.Sugar.Debugf("order filled, orderId: %d, clientOrderId: %s, fill type: %s",
 				o.OrderId, o.ClientOrderId, o.OrderStatus)
-			if !strings.HasPrefix(o.ClientOrderId, prefixSellLimitOrder) && !strings.HasPrefix(o.ClientOrderId, prefixSellMarketOrder) {
+			if o.OrderStatus == "filled" && e.id.Is(o.ClientOrderId, prefixBuyLimitOrder) {
+				if o3, err := e.ex.GetOrderById(uint64(o.OrderId), e.Symbol()); err == nil {
+					e.account.Settle(o3)
+				}
+			}
+			// the market jobs are accounted when they are done
+			if !e.id.Is(o.ClientOrderId, prefixSellLimitOrder) {
 				return
 			}
 			td := Trade{
@@ -208,6 +383,7 @@ func (e *Executor) OrderUpdateHandler(response interface{}) {
 				}
 			}
 			e.quota.Add(total)
+			e.account.Credit(total)
 			//if err := t.fillOrder(context.Background(), o2, td); err != nil {
 			//	t.Sugar.Error(err)
 			//}
@@ -244,7 +420,8 @@ func (e *Executor) BuyAllLimit(price float64) error {
 	if total.GreaterThan(quota) {
 		total = quota
 	}
-	amount := total.DivRound(realPrice, e.AmountPrecision())
+	total = e.account.Limit(total)
+	amount := total.Div(realPrice).Truncate(e.AmountPrecision())
 	total = amount.Mul(realPrice)
 	if amount.LessThan(e.MinAmount()) {
 		e.Sugar.Infof("amount (%s / %s) is too small", amount, e.MinAmount())
@@ -255,7 +432,11 @@ func (e *Executor) BuyAllLimit(price float64) error {
 		//e.Sugar.Errorf("get client order id error: %s", err)
 		return err
 	}
+	if err := e.account.Reserve(clientId, total); err != nil {
+		return err
+	}
 	orderId, err := e.ex.BuyLimit(e.Symbol(), clientId, realPrice, amount)
+	e.account.Placed(clientId, orderId, err)
 	if err != nil {
 		//e.Sugar.Errorf("buy error: %s", err)
 		return err
@@ -319,6 +500,7 @@ func (e *Executor) BuyAllMarket() error {
 	if total.GreaterThan(quota) {
 		total = quota
 	}
+	total = e.account.Limit(total)
 	if total.LessThan(e.MinTotal()) {
 		e.Sugar.Infof("buy-market total (%s / %s) is too small", total, e.MinTotal())
 		return nil
@@ -328,20 +510,23 @@ func (e *Executor) BuyAllMarket() error {
 		//e.Sugar.Errorf("get client order id error: %s", err)
 		return err
 	}
-	orderId, err := e.ex.BuyMarket(e.symbol, clientId, total)
-	if err != nil {
-		//e.Sugar.Errorf("buy error: %s", err)
+	if err := e.account.Reserve(clientId, total); err != nil {
 		return err
 	}
-	e.SetBuyOrderId(orderId)
-	e.Sugar.Infof("place buy-market order, id %s / %s, total %s", orderId, clientId, total)
-	if err := e.id.LongAdd(context.Background()); err != nil {
-		e.Sugar.Errorf("update buy times error: %s", err)
-		// this error can ignore
-		//return err
+	e.Sugar.Infof("buy all (market), clientOrderId is %s, total %s", clientId, total)
+	p, err := e.execute(Job{Direction: exchange.TradeDirectionBuy, ClientOrderId: clientId, Total: total})
+	// the market job is accounted here, not by the order pushes of its child orders
+	e.account.SettleJob(p)
+	e.quota.Sub(p.FilledTotal)
+	if p.FilledAmount.IsPositive() {
+		e.Sugar.Infof("buy-market job %s done, %d orders, filled amount %s, total %s", clientId, p.Orders, p.FilledAmount, p.FilledTotal)
+		if err := e.id.LongAdd(context.Background()); err != nil {
+			e.Sugar.Errorf("update buy times error: %s", err)
+			// this error can ignore
+			//return err
+		}
 	}
-	e.quota.Sub(total)
-	return nil
+	return err
 }
 
 func (e *Executor) SellAllMarket() error {
@@ -349,7 +534,7 @@ func (e *Executor) SellAllMarket() error {
 	if err != nil {
 		return err
 	}
-	amount := balance[e.BaseCurrency()]
+	amount := balance[e.BaseCurrency()].Truncate(e.AmountPrecision())
 	if amount.LessThan(e.MinAmount()) {
 		e.Sugar.Infof("amount ( %s / %s ) is too small", amount, e.MinAmount())
 		return nil
@@ -359,18 +544,28 @@ func (e *Executor) SellAllMarket() error {
 		//e.Sugar.Errorf("get client order id error: %s", err)
 		return err
 	}
-	orderId, err := e.ex.SellMarket(e.symbol, clientId, amount)
-	if err != nil {
-		//e.Sugar.Errorf("sell error: %s", err)
-		return err
+	e.Sugar.Infof("sell all (market), clientOrderId is %s, amount %s", clientId, amount)
+	p, err := e.execute(Job{Direction: exchange.TradeDirectionSell, ClientOrderId: clientId, Amount: amount})
+	e.quota.Add(p.FilledTotal)
+	e.account.Credit(p.FilledTotal)
+	if p.FilledAmount.IsPositive() {
+		e.Sugar.Infof("sell-market job %s done, %d orders, filled amount %s, total %s", clientId, p.Orders, p.FilledAmount, p.FilledTotal)
+		if err := e.id.ShortAdd(context.Background()); err != nil {
+			e.Sugar.Errorf("update sell times error: %s", err)
+			// this error can ignore
+			//return err
+		}
 	}
-	e.SetSellOrderId(orderId)
-	if err := e.id.ShortAdd(context.Background()); err != nil {
-		e.Sugar.Errorf("update sell times error: %s", err)
-		// this error can ignore
-		//return err
+	return err
+}
+
+// execute the market job by the execution algorithm, it blocks until the job is done
+func (e *Executor) execute(job Job) (Progress, error) {
+	algo, err := newMarketAlgorithm(e.algo, e.config.Name, e.ex, e.symbol, e.Sugar)
+	if err != nil {
+		return Progress{Job: job}, err
 	}
-	return nil
+	return algo.Execute(context.Background(), job)
 }
 
 func (e *Executor) CancelAll() error {
@@ -424,14 +619,14 @@ func (e *Executor) SetBuyOrderId(newId uint64) {
 	defer e.buyOrderLock.Unlock()
 
 	e.buyOrderId = newId
-	_ = hs.SaveKey(context.Background(), e.db.Collection(collNameState), "buyOrderId", e.buyOrderId)
+	_ = hs.SaveKey(context.Background(), e.coll(collNameState), "buyOrderId", e.buyOrderId)
 }
 
 func (e *Executor) LoadBuyOrderId(ctx context.Context) error {
 	e.buyOrderLock.Lock()
 	defer e.buyOrderLock.Unlock()
 
-	return hs.LoadKey(ctx, e.db.Collection(collNameState), "buyOrderId", &e.buyOrderId)
+	return hs.LoadKey(ctx, e.coll(collNameState), "buyOrderId", &e.buyOrderId)
 }
 
 func (e *Executor) GetSellOrderId() uint64 {
@@ -445,12 +640,12 @@ func (e *Executor) SetSellOrderId(newId uint64) {
 	defer e.sellOrderLock.Unlock()
 
 	e.sellOrderId = newId
-	_ = hs.SaveKey(context.Background(), e.db.Collection(collNameState), "sellOrderId", e.sellOrderId)
+	_ = hs.SaveKey(context.Background(), e.coll(collNameState), "sellOrderId", e.sellOrderId)
 }
 
 func (e *Executor) LoadSellOrderId(ctx context.Context) error {
 	e.sellOrderLock.Lock()
 	defer e.sellOrderLock.Unlock()
 
-	return hs.LoadKey(ctx, e.db.Collection(collNameState), "sellOrderId", &e.sellOrderId)
+	return hs.LoadKey(ctx, e.coll(collNameState), "sellOrderId", &e.sellOrderId)
 }
@@ -0,0 +1,226 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/qtr/exchange"
+	"github.com/xyths/qtr/exchange/gateio"
+	"github.com/xyths/qtr/exchange/huobi"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// poll the market order until it's finished
+	futuresPollInterval = time.Second
+	futuresPollTimes    = 30
+)
+
+var ErrFuturesSizeTooSmall = errors.New("futures order size is too small")
+
+// FuturesConf config the trader to trade the USDT-margined perpetual contract instead of spot
+type FuturesConf struct {
+	Exchange   string // gate or huobi, default is the exchange of the trader
+	Host       string // empty for default
+	Contract   string // BTC_USDT on gate, BTC-USDT on huobi
+	Leverage   int    // default is 1
+	MarginMode string `json:"marginMode"` // cross or isolated, default is isolated
+}
+
+// NewFuturesExchange create the futures client, the key is the same as spot
+func NewFuturesExchange(conf FuturesConf, key, secret string) (exchange.FuturesExchange, error) {
+	switch conf.Exchange {
+	case "gate":
+		return gateio.NewFutures(key, secret, conf.Host), nil
+	case "huobi":
+		return huobi.NewFutures(key, secret, conf.Host, conf.MarginMode), nil
+	default:
+		return nil, fmt.Errorf("futures is not supported on %s", conf.Exchange)
+	}
+}
+
+// FuturesTrader trade one contract, the position can be long or short
+type FuturesTrader struct {
+	Sugar    *zap.SugaredLogger
+	conf     FuturesConf
+	ex       exchange.FuturesExchange
+	contract exchange.FuturesContract
+}
+
+// NewFuturesTrader load the contract and set the leverage
+func NewFuturesTrader(ctx context.Context, conf FuturesConf, ex exchange.FuturesExchange, sugar *zap.SugaredLogger) (*FuturesTrader, error) {
+	if conf.Leverage <= 0 {
+		conf.Leverage = 1
+	}
+	if conf.MarginMode == "" {
+		conf.MarginMode = exchange.MarginIsolated
+	}
+	if conf.MarginMode != exchange.MarginCross && conf.MarginMode != exchange.MarginIsolated {
+		return nil, fmt.Errorf("unknown margin mode: %s", conf.MarginMode)
+	}
+	contract, err := ex.Contract(ctx, conf.Contract)
+	if err != nil {
+		return nil, err
+	}
+	if contract.MaxLeverage > 0 && conf.Leverage > contract.MaxLeverage {
+		return nil, fmt.Errorf("leverage %d exceeds max leverage %d of %s", conf.Leverage, contract.MaxLeverage, contract.Name)
+	}
+	if err := ex.SetLeverage(ctx, conf.Contract, conf.MarginMode, conf.Leverage); err != nil {
+		return nil, err
+	}
+	sugar.Infof("futures %s on %s, multiplier: %s, leverage: %d (%s)",
+		contract.Name, ex.Name(), contract.Multiplier, conf.Leverage, conf.MarginMode)
+	return &FuturesTrader{Sugar: sugar, conf: conf, ex: ex, contract: contract}, nil
+}
+
+func (f *FuturesTrader) Exchange() exchange.FuturesExchange {
+	return f.ex
+}
+
+func (f *FuturesTrader) Contract() exchange.FuturesContract {
+	return f.contract
+}
+
+func (f *FuturesTrader) Position(ctx context.Context) (exchange.FuturesPosition, error) {
+	return f.ex.Position(ctx, f.conf.Contract)
+}
+
+// Size is the contracts can be opened with margin total at price
+func (f *FuturesTrader) Size(total, price decimal.Decimal) int64 {
+	return futuresSize(total, price, f.contract.Multiplier, f.conf.Leverage)
+}
+
+// Contracts convert the base currency amount to contracts, rounded
+func (f *FuturesTrader) Contracts(amount decimal.Decimal) int64 {
+	if !f.contract.Multiplier.IsPositive() {
+		return 0
+	}
+	return amount.Div(f.contract.Multiplier).Round(0).IntPart()
+}
+
+// Target trade the position to target size at market price, the opposite position is closed with reduce-only order first.
+func (f *FuturesTrader) Target(ctx context.Context, target int64, clientOrderId string) (exchange.FuturesPosition, error) {
+	p, err := f.Position(ctx)
+	if err != nil {
+		return p, err
+	}
+	steps := targetSteps(p.Size, target)
+	for i, s := range steps {
+		if !s.ReduceOnly && abs64(s.Size) < f.contract.MinSize {
+			f.Sugar.Infof("size %d is less than min size %d, skip", s.Size, f.contract.MinSize)
+			continue
+		}
+		id := clientOrderId
+		if len(steps) > 1 {
+			id = ChildId(clientOrderId, i)
+		}
+		o, err := f.market(ctx, s.Size, s.ReduceOnly, id)
+		if err != nil {
+			return p, err
+		}
+		f.Sugar.Infof("futures order %d / %s, size: %d, filled: %d, price: %s, reduce only: %t",
+			o.Id, id, o.Size, o.Filled, o.FillPrice, s.ReduceOnly)
+	}
+	return f.Position(ctx)
+}
+
+func (f *FuturesTrader) market(ctx context.Context, size int64, reduceOnly bool, clientOrderId string) (exchange.FuturesOrder, error) {
+	orderId, err := f.ex.PlaceOrder(ctx, exchange.FuturesOrderRequest{
+		Contract:      f.conf.Contract,
+		ClientOrderId: clientOrderId,
+		Size:          size,
+		ReduceOnly:    reduceOnly,
+	})
+	if err != nil {
+		return exchange.FuturesOrder{}, err
+	}
+	for i := 0; i < futuresPollTimes; i++ {
+		o, err := f.ex.GetOrder(ctx, f.conf.Contract, orderId)
+		if err != nil {
+			f.Sugar.Errorf("get futures order %d error: %s", orderId, err)
+		} else if o.Finished {
+			return o, nil
+		}
+		select {
+		case <-ctx.Done():
+			return exchange.FuturesOrder{}, ctx.Err()
+		case <-time.After(futuresPollInterval):
+		}
+	}
+	return exchange.FuturesOrder{Id: orderId}, fmt.Errorf("futures order %d is not finished", orderId)
+}
+
+// Limit place the limit order, it's reduce-only if it reduces the current position.
+func (f *FuturesTrader) Limit(ctx context.Context, size int64, price decimal.Decimal, clientOrderId string) (uint64, error) {
+	if abs64(size) < f.contract.MinSize {
+		return 0, fmt.Errorf("%w: %d / %d", ErrFuturesSizeTooSmall, size, f.contract.MinSize)
+	}
+	p, err := f.Position(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return f.ex.PlaceOrder(ctx, exchange.FuturesOrderRequest{
+		Contract:      f.conf.Contract,
+		ClientOrderId: clientOrderId,
+		Size:          size,
+		Price:         price.Round(f.contract.PricePrecision),
+		ReduceOnly:    reduces(p.Size, size),
+	})
+}
+
+func (f *FuturesTrader) GetOrder(ctx context.Context, orderId uint64) (exchange.FuturesOrder, error) {
+	return f.ex.GetOrder(ctx, f.conf.Contract, orderId)
+}
+
+func (f *FuturesTrader) CancelOrder(ctx context.Context, orderId uint64) error {
+	return f.ex.CancelOrder(ctx, f.conf.Contract, orderId)
+}
+
+type futuresStep struct {
+	Size       int64
+	ReduceOnly bool
+}
+
+// targetSteps split the trade from position to target, close (reduce-only) first and then open
+func targetSteps(position, target int64) []futuresStep {
+	diff := target - position
+	if diff == 0 {
+		return nil
+	}
+	var steps []futuresStep
+	if reduces(position, diff) {
+		closing := diff
+		if abs64(diff) > abs64(position) {
+			closing = -position
+		}
+		steps = append(steps, futuresStep{Size: closing, ReduceOnly: true})
+		diff -= closing
+	}
+	if diff != 0 {
+		steps = append(steps, futuresStep{Size: diff})
+	}
+	return steps
+}
+
+// reduces return true if the order of size reduces the position
+func reduces(position, size int64) bool {
+	return position > 0 && size < 0 || position < 0 && size > 0
+}
+
+// futuresSize is total * leverage / (price * multiplier), truncated
+func futuresSize(total, price, multiplier decimal.Decimal, leverage int) int64 {
+	value := price.Mul(multiplier)
+	if !value.IsPositive() {
+		return 0
+	}
+	return total.Mul(decimal.NewFromInt(int64(leverage))).Div(value).IntPart()
+}
+
+func abs64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,109 @@
+package executor
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/qtr/exchange"
+	"go.uber.org/zap"
+	"reflect"
+	"testing"
+)
+
+func TestTargetSteps(t *testing.T) {
+	var tests = []struct {
+		position int64
+		target   int64
+		want     []futuresStep
+	}{
+		{0, 0, nil},
+		{0, 10, []futuresStep{{Size: 10}}},
+		{0, -10, []futuresStep{{Size: -10}}},
+		{5, 10, []futuresStep{{Size: 5}}},
+		{10, 4, []futuresStep{{Size: -6, ReduceOnly: true}}},
+		{10, 0, []futuresStep{{Size: -10, ReduceOnly: true}}},
+		{10, -10, []futuresStep{{Size: -10, ReduceOnly: true}, {Size: -10}}},
+		{-10, 10, []futuresStep{{Size: 10, ReduceOnly: true}, {Size: 10}}},
+		{-10, -3, []futuresStep{{Size: 7, ReduceOnly: true}}},
+	}
+	for i, tt := range tests {
+		got := targetSteps(tt.position, tt.target)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("[%d] want %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestFuturesSize(t *testing.T) {
+	var tests = []struct {
+		total      string
+		price      string
+		multiplier string
+		leverage   int
+		want       int64
+	}{
+		{"1000", "100", "0.1", 1, 100},
+		{"1000", "100", "0.1", 3, 300},
+		{"1000", "30000", "0.0001", 1, 333},
+		{"1000", "30000", "0.001", 2, 66},
+		{"1000", "0", "0.1", 1, 0},
+	}
+	for i, tt := range tests {
+		got := futuresSize(
+			decimal.RequireFromString(tt.total),
+			decimal.RequireFromString(tt.price),
+			decimal.RequireFromString(tt.multiplier),
+			tt.leverage,
+		)
+		if got != tt.want {
+			t.Errorf("[%d] want %d, got %d", i, tt.want, got)
+		}
+	}
+}
+
+// fakeFutures fill every order at once, and record the placed orders
+type fakeFutures struct {
+	exchange.FuturesExchange
+	size   int64
+	placed []exchange.FuturesOrderRequest
+}
+
+func (f *fakeFutures) Position(ctx context.Context, contract string) (exchange.FuturesPosition, error) {
+	return exchange.FuturesPosition{Contract: contract, Size: f.size}, nil
+}
+
+func (f *fakeFutures) PlaceOrder(ctx context.Context, r exchange.FuturesOrderRequest) (uint64, error) {
+	f.placed = append(f.placed, r)
+	f.size += r.Size
+	return uint64(len(f.placed)), nil
+}
+
+func (f *fakeFutures) GetOrder(ctx context.Context, contract string, orderId uint64) (exchange.FuturesOrder, error) {
+	r := f.placed[orderId-1]
+	return exchange.FuturesOrder{Id: orderId, Contract: contract, Size: r.Size, Filled: r.Size, Finished: true}, nil
+}
+
+func TestFuturesTrader_Target(t *testing.T) {
+	ex := &fakeFutures{size: 10}
+	f := FuturesTrader{Sugar: zap.NewNop().Sugar(), ex: ex, contract: exchange.FuturesContract{MinSize: 1}}
+	clientId, err := NewClientIdCodec("gate", "st", "btc_usdt").EncodePrefix(prefixFuturesShortOrder, 35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := f.Target(context.Background(), -10, clientId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Size != -10 || len(ex.placed) != 2 {
+		t.Fatalf("want position -10 by 2 orders, got %d by %d", p.Size, len(ex.placed))
+	}
+	for i, r := range ex.placed {
+		id, err := ParseClientId(r.ClientOrderId)
+		if err != nil {
+			t.Errorf("[%d] parse %s error: %s", i, r.ClientOrderId, err)
+			continue
+		}
+		if id.Sequence != 35 || id.Child != i {
+			t.Errorf("[%d] want sequence 35 and child %d, got %d and %d", i, i, id.Sequence, id.Child)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"math"
+	"time"
+)
+
+const (
+	SpacingGeometric  = "geometric"  // same ratio between levels
+	SpacingArithmetic = "arithmetic" // same price difference between levels
+	SpacingNatr       = "natr"       // geometric, the ratio is a multiple of NATR, centered on the current price
+
+	WeightEven   = "even"
+	WeightMiddle = "middle" // more size near the middle of the range
+	WeightEdge   = "edge"   // more size near the edges of the range
+)
+
+// SpacingConf config the grid levels and the order size of each level, eg. {"mode": "natr", "natrMultiple": 0.5, "refresh": "24h"}
+type SpacingConf struct {
+	Mode         string  // geometric (default), arithmetic or natr
+	NatrPeriod   int     `json:"natrPeriod"`   // natr only, default 14
+	NatrInterval string  `json:"natrInterval"` // natr only, candle interval, default 1h
+	NatrMultiple float64 `json:"natrMultiple"` // natr only, the spacing is NatrMultiple * NATR percent, default 1
+	Refresh      string  // natr only, rebuild the grid with the new NATR every Refresh, empty means never
+	Threshold    float64 // natr only, rebuild on Refresh only if the spread changed more than Threshold (relative), default 0.2
+
+	Weight      string  // even (default), middle or edge
+	WeightRatio float64 `json:"weightRatio"` // the heaviest level size / the lightest, default 2
+}
+
+// Validate check the config and fill the defaults
+func (c *SpacingConf) Validate() error {
+	switch c.Mode {
+	case "":
+		c.Mode = SpacingGeometric
+	case SpacingGeometric, SpacingArithmetic:
+	case SpacingNatr:
+		if c.NatrPeriod <= 0 {
+			c.NatrPeriod = 14
+		}
+		if c.NatrInterval == "" {
+			c.NatrInterval = "1h"
+		}
+		if c.NatrMultiple <= 0 {
+			c.NatrMultiple = 1
+		}
+		if _, err := time.ParseDuration(c.NatrInterval); err != nil {
+			return err
+		}
+		if c.Refresh != "" {
+			if _, err := time.ParseDuration(c.Refresh); err != nil {
+				return err
+			}
+		}
+		if c.Threshold <= 0 {
+			c.Threshold = 0.2
+		}
+	default:
+		return fmt.Errorf("unknown spacing mode: %s", c.Mode)
+	}
+	switch c.Weight {
+	case "":
+		c.Weight = WeightEven
+	case WeightEven, WeightMiddle, WeightEdge:
+	default:
+		return fmt.Errorf("unknown weight: %s", c.Weight)
+	}
+	if c.WeightRatio <= 0 {
+		c.WeightRatio = 2
+	}
+	if c.WeightRatio < 1 {
+		return errors.New("weightRatio must not be less than 1")
+	}
+	return nil
+}
+
+// NatrSpacing is the level ratio from the latest NATR (in percent), eg. 0.98 for 2%
+func (c SpacingConf) NatrSpacing(natr float64) (float64, error) {
+	s := c.NatrMultiple * natr / 100
+	if s <= 0 || s >= 1 {
+		return 0, fmt.Errorf("wrong spacing %f from natr %f", s, natr)
+	}
+	return 1 - s, nil
+}
+
+// SpreadChanged check if the new spread differs from the current one more than Threshold, so the grid need rebuild.
+// A zero current spread (unknown) is always changed.
+func (c SpacingConf) SpreadChanged(current, spread float64) bool {
+	if current <= 0 {
+		return true
+	}
+	return math.Abs(spread-current)/current > c.Threshold
+}
+
+// NatrRange return the high and low of number geometric levels with scale, centered on price
+func NatrRange(price, scale float64, number int) (high, low float64) {
+	high = price / math.Pow(scale, float64(number/2))
+	low = price * math.Pow(scale, float64(number-number/2))
+	return
+}
+
+// GridPrices return number+1 level prices, from high to low
+func GridPrices(mode string, high, low float64, number int, precision int32) ([]decimal.Decimal, error) {
+	if number <= 0 || low <= 0 || high <= low {
+		return nil, fmt.Errorf("wrong grid range [%f, %f] with %d levels", low, high, number)
+	}
+	current := decimal.NewFromFloat(high)
+	prices := []decimal.Decimal{current.Round(precision)}
+	switch mode {
+	case SpacingArithmetic:
+		step := decimal.NewFromFloat((high - low) / float64(number))
+		for i := 1; i <= number; i++ {
+			prices = append(prices, current.Sub(step.Mul(decimal.NewFromInt(int64(i)))).Round(precision))
+		}
+	default:
+		scale := decimal.NewFromFloat(math.Pow(low/high, 1.0/float64(number)))
+		for i := 1; i <= number; i++ {
+			current = current.Mul(scale).Round(precision)
+			prices = append(prices, current)
+		}
+	}
+	for i := 1; i < len(prices); i++ {
+		if !prices[i].LessThan(prices[i-1]) || !prices[i].IsPositive() {
+			return nil, fmt.Errorf("grid price %s at level %d is not less than %s, the spacing is too small", prices[i], i, prices[i-1])
+		}
+	}
+	return prices, nil
+}
+
+// GridWeights return n weights with mean 1, the heaviest / the lightest is ratio
+func GridWeights(weight string, ratio float64, n int) []decimal.Decimal {
+	raw := make([]float64, n)
+	sum := 0.0
+	for i := range raw {
+		x := 0.0 // distance to the middle, 0 ~ 1
+		if n > 1 {
+			x = math.Abs(2*float64(i)/float64(n-1) - 1)
+		}
+		switch weight {
+		case WeightMiddle:
+			raw[i] = 1 + (ratio-1)*(1-x)
+		case WeightEdge:
+			raw[i] = 1 + (ratio-1)*x
+		default:
+			raw[i] = 1
+		}
+		sum += raw[i]
+	}
+	weights := make([]decimal.Decimal, n)
+	for i, w := range raw {
+		weights[i] = decimal.NewFromFloat(w * float64(n) / sum)
+	}
+	return weights
+}
+
+// BuildGrids build the grid levels from prices (high to low), the buy total of level i is total / n * weights[i-1].
+// The amount and total are checked with the symbol.
+func BuildGrids(prices []decimal.Decimal, total decimal.Decimal, weights []decimal.Decimal, symbol exchange.Symbol) ([]hs.Grid, error) {
+	number := len(prices) - 1
+	if number <= 0 || len(weights) != number {
+		return nil, fmt.Errorf("%d prices and %d weights mismatch", len(prices), len(weights))
+	}
+	preTotal := total.Div(decimal.NewFromInt(int64(number)))
+	grids := []hs.Grid{{Id: 0, Price: prices[0]}}
+	for i := 1; i <= number; i++ {
+		amountBuy := preTotal.Mul(weights[i-1]).DivRound(prices[i], symbol.AmountPrecision)
+		if amountBuy.LessThan(symbol.LimitOrderMinAmount) {
+			return nil, fmt.Errorf("amount %s less than minAmount(%s)", amountBuy, symbol.LimitOrderMinAmount)
+		}
+		realTotal := prices[i].Mul(amountBuy)
+		if realTotal.LessThan(symbol.MinTotal) {
+			return nil, fmt.Errorf("total %s less than minTotal(%s)", realTotal, symbol.MinTotal)
+		}
+		grids = append(grids, hs.Grid{
+			Id:        i,
+			Price:     prices[i],
+			AmountBuy: amountBuy,
+			TotalBuy:  realTotal,
+		})
+		grids[i-1].AmountSell = amountBuy
+	}
+	return grids, nil
+}
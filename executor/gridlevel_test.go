@@ -0,0 +1,116 @@
+package executor
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestGridPrices(t *testing.T) {
+	var tests = []struct {
+		mode   string
+		high   float64
+		low    float64
+		number int
+		want   []string
+	}{
+		{SpacingArithmetic, 110, 100, 4, []string{"110", "107.5", "105", "102.5", "100"}},
+		{SpacingGeometric, 400, 100, 2, []string{"400", "200", "100"}},
+		{SpacingNatr, 400, 100, 2, []string{"400", "200", "100"}},
+	}
+	for i, tt := range tests {
+		got, err := GridPrices(tt.mode, tt.high, tt.low, tt.number, 2)
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %s", i, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("[%d] want %v, got %v", i, tt.want, got)
+			continue
+		}
+		for j := range got {
+			if !got[j].Equal(decimal.RequireFromString(tt.want[j])) {
+				t.Errorf("[%d] level %d want %s, got %s", i, j, tt.want[j], got[j])
+			}
+		}
+	}
+
+	// the spacing is less than the price precision
+	if _, err := GridPrices(SpacingArithmetic, 1.01, 1, 10, 2); err == nil {
+		t.Error("want error when the levels are not distinct")
+	}
+}
+
+func TestGridWeights(t *testing.T) {
+	var tests = []struct {
+		weight string
+		ratio  float64
+		n      int
+		want   []string
+	}{
+		{WeightEven, 2, 3, []string{"1", "1", "1"}},
+		{WeightMiddle, 2, 3, []string{"0.75", "1.5", "0.75"}},
+		{WeightEdge, 3, 3, []string{"1.2857142857142858", "0.42857142857142855", "1.2857142857142858"}},
+		{WeightMiddle, 2, 1, []string{"1"}},
+	}
+	for i, tt := range tests {
+		got := GridWeights(tt.weight, tt.ratio, tt.n)
+		for j := range got {
+			if !got[j].Equal(decimal.RequireFromString(tt.want[j])) {
+				t.Errorf("[%d] weight %d want %s, got %s", i, j, tt.want[j], got[j])
+			}
+		}
+	}
+}
+
+func TestBuildGrids(t *testing.T) {
+	prices := []decimal.Decimal{
+		decimal.RequireFromString("120"),
+		decimal.RequireFromString("110"),
+		decimal.RequireFromString("100"),
+	}
+	grids, err := BuildGrids(prices, decimal.NewFromInt(200), GridWeights(WeightEven, 2, 2), testSymbol())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !grids[1].AmountBuy.Equal(decimal.RequireFromString("0.9091")) || !grids[0].AmountSell.Equal(grids[1].AmountBuy) {
+		t.Errorf("level 1 want buy 0.9091, got %s, sell at level 0: %s", grids[1].AmountBuy, grids[0].AmountSell)
+	}
+	if !grids[2].TotalBuy.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("level 2 want total 100, got %s", grids[2].TotalBuy)
+	}
+
+	// 0.5 / 100 is less than MinTotal 1
+	if _, err := BuildGrids(prices, decimal.RequireFromString("1"), GridWeights(WeightEven, 2, 2), testSymbol()); err == nil {
+		t.Error("want error when total is less than MinTotal")
+	}
+}
+
+func TestNatrRange(t *testing.T) {
+	high, low := NatrRange(100, 0.5, 2)
+	if high != 200 || low != 50 {
+		t.Errorf("want [50, 200], got [%f, %f]", low, high)
+	}
+}
+
+func TestSpacingConf_SpreadChanged(t *testing.T) {
+	c := SpacingConf{Mode: SpacingNatr}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		current, spread float64
+		changed         bool
+	}{
+		{0, 0.01, true},
+		{0.01, 0.01, false},
+		{0.01, 0.0115, false},
+		{0.01, 0.0085, false},
+		{0.01, 0.013, true},
+		{0.01, 0.007, true},
+	}
+	for i, tt := range tests {
+		if got := c.SpreadChanged(tt.current, tt.spread); got != tt.changed {
+			t.Errorf("[%d] %f -> %f want %t, got %t", i, tt.current, tt.spread, tt.changed, got)
+		}
+	}
+}
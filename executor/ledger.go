@@ -0,0 +1,486 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	collNameLedger = "ledger"
+
+	defaultReconcileInterval = time.Minute
+	maxLedgerRetry           = 10
+	// reservation without order id is used by a running algorithm, or left by a crashed process
+	staleReservation = time.Hour * 24
+)
+
+var (
+	ErrInsufficientCapital = errors.New("insufficient capital")
+	ErrAllocationNotFound  = errors.New("allocation not found")
+)
+
+// LedgerConf config the shared ledger. All strategies on the same account should use the same mongo database.
+type LedgerConf struct {
+	Mongo    hs.MongoConf
+	Name     string // allocation name of this strategy, unique in the account
+	Interval string // reconcile interval, default 1m
+}
+
+// ReconcileInterval parse the interval, return the default if it's empty
+func (c LedgerConf) ReconcileInterval() (time.Duration, error) {
+	if c.Interval == "" {
+		return defaultReconcileInterval, nil
+	}
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("bad ledger interval %s: %w", c.Interval, err)
+	}
+	return interval, nil
+}
+
+// Reservation is the capital reserved for a placed buy order, in the allocation currency
+type Reservation struct {
+	ClientOrderId string    `bson:"clientOrderId"`
+	OrderId       uint64    `bson:"orderId"` // 0 means the order is not placed yet
+	Symbol        string    `bson:"symbol"`
+	Amount        string    `bson:"amount"`
+	Created       time.Time `bson:"created"`
+}
+
+// Allocation is the named capital of one strategy
+type Allocation struct {
+	Name         string        `bson:"_id"`
+	Currency     string        `bson:"currency"`
+	Limit        string        `bson:"limit"`
+	Used         string        `bson:"used"` // spent by filled buy orders, minus the sell income
+	Reservations []Reservation `bson:"reservations"`
+	Blocked      bool          `bson:"blocked"` // the currency is over committed, no new reservation
+	Version      int64         `bson:"version"`
+	Updated      time.Time     `bson:"updated"`
+}
+
+func (a Allocation) Reserved() decimal.Decimal {
+	reserved := decimal.Zero
+	for _, r := range a.Reservations {
+		reserved = reserved.Add(decimalOrZero(r.Amount))
+	}
+	return reserved
+}
+
+// Available capital, limit - used - reserved
+func (a Allocation) Available() decimal.Decimal {
+	return decimalOrZero(a.Limit).Sub(decimalOrZero(a.Used)).Sub(a.Reserved())
+}
+
+// Committed capital, limit - used, the reserved is a part of it
+func (a Allocation) Committed() decimal.Decimal {
+	return decimalOrZero(a.Limit).Sub(decimalOrZero(a.Used))
+}
+
+func (a *Allocation) reserve(clientOrderId, symbol string, amount decimal.Decimal, now time.Time) error {
+	if a.Blocked || amount.GreaterThan(a.Available()) {
+		return ErrInsufficientCapital
+	}
+	a.remove(clientOrderId)
+	a.Reservations = append(a.Reservations, Reservation{
+		ClientOrderId: clientOrderId,
+		Symbol:        symbol,
+		Amount:        amount.String(),
+		Created:       now,
+	})
+	return nil
+}
+
+func (a *Allocation) bind(clientOrderId string, orderId uint64) error {
+	for i := range a.Reservations {
+		if a.Reservations[i].ClientOrderId == clientOrderId {
+			a.Reservations[i].OrderId = orderId
+			return nil
+		}
+	}
+	return fmt.Errorf("reservation %s not found", clientOrderId)
+}
+
+// settle remove the reservation and add the spent to used, the unknown reservation is ignored
+func (a *Allocation) settle(clientOrderId string, spent decimal.Decimal) {
+	if _, ok := a.remove(clientOrderId); !ok {
+		return
+	}
+	a.Used = decimalOrZero(a.Used).Add(spent).String()
+}
+
+func (a *Allocation) credit(income decimal.Decimal) {
+	a.Used = decimalOrZero(a.Used).Sub(income).String()
+}
+
+// settlements return the spent capital of the reservations to settle, by client order id.
+// The finished orders are settled with the filled total, the stale reservations without order are released.
+func (a Allocation) settlements(ex exchange.RestAPIExchange, now time.Time, sugar *zap.SugaredLogger) map[string]decimal.Decimal {
+	settled := make(map[string]decimal.Decimal)
+	for _, r := range a.Reservations {
+		if r.OrderId == 0 {
+			if now.Sub(r.Created) > staleReservation {
+				sugar.Infof("release stale reservation %s of %s", r.ClientOrderId, a.Name)
+				settled[r.ClientOrderId] = decimal.Zero
+			}
+			continue
+		}
+		o, err := ex.GetOrderById(r.OrderId, r.Symbol)
+		if err != nil {
+			sugar.Errorf("get order %d error: %s", r.OrderId, err)
+			continue
+		}
+		if !OrderFinished(o.Status) {
+			continue
+		}
+		spent := o.FilledPrice.Mul(o.FilledAmount)
+		sugar.Infof("settle reservation %s of %s, reserved: %s, spent: %s", r.ClientOrderId, a.Name, r.Amount, spent)
+		settled[r.ClientOrderId] = spent
+	}
+	return settled
+}
+
+// overCommitted return the committed capital of the currencies, which is more than the balance
+func overCommitted(allocations []Allocation, balance map[string]decimal.Decimal) map[string]decimal.Decimal {
+	committed := make(map[string]decimal.Decimal)
+	for _, a := range allocations {
+		committed[a.Currency] = committed[a.Currency].Add(a.Committed())
+	}
+	over := make(map[string]decimal.Decimal)
+	for currency, c := range committed {
+		if c.GreaterThan(balance[currency]) {
+			over[currency] = c
+		}
+	}
+	return over
+}
+
+func (a *Allocation) remove(clientOrderId string) (Reservation, bool) {
+	for i, r := range a.Reservations {
+		if r.ClientOrderId == clientOrderId {
+			a.Reservations = append(a.Reservations[:i], a.Reservations[i+1:]...)
+			return r, true
+		}
+	}
+	return Reservation{}, false
+}
+
+// Ledger is the account level capital ledger, shared by all strategies on the same account.
+// Every allocation is a document with version, updated by optimistic lock, so it's safe between processes.
+type Ledger struct {
+	Sugar *zap.SugaredLogger
+	coll  *mongo.Collection
+}
+
+func NewLedger(ctx context.Context, conf LedgerConf, sugar *zap.SugaredLogger) (*Ledger, error) {
+	db, err := hs.ConnectMongo(ctx, conf.Mongo)
+	if err != nil {
+		return nil, err
+	}
+	l := &Ledger{}
+	l.Init(db.Collection(collNameLedger), sugar)
+	return l, nil
+}
+
+func (l *Ledger) Init(coll *mongo.Collection, sugar *zap.SugaredLogger) {
+	l.coll = coll
+	l.Sugar = sugar
+}
+
+func (l *Ledger) Close(ctx context.Context) {
+	_ = l.coll.Database().Client().Disconnect(ctx)
+}
+
+// Allocate create the allocation, or update the limit if it exists
+func (l *Ledger) Allocate(ctx context.Context, name, currency string, limit decimal.Decimal) error {
+	option := options.Update().SetUpsert(true)
+	_, err := l.coll.UpdateOne(
+		ctx,
+		bson.D{
+			{"_id", name},
+		},
+		bson.D{
+			{"$set", bson.D{
+				{"currency", currency},
+				{"limit", limit.String()},
+				{"updated", time.Now()},
+			}},
+			{"$setOnInsert", bson.D{
+				{"used", "0"},
+				{"reservations", bson.A{}},
+			}},
+			{"$inc", bson.D{
+				{"version", 1},
+			}},
+		},
+		option,
+	)
+	return err
+}
+
+func (l *Ledger) Get(ctx context.Context, name string) (a Allocation, err error) {
+	err = l.coll.FindOne(ctx, bson.D{{"_id", name}}).Decode(&a)
+	if err == mongo.ErrNoDocuments {
+		err = ErrAllocationNotFound
+	}
+	return
+}
+
+func (l *Ledger) All(ctx context.Context) ([]Allocation, error) {
+	cursor, err := l.coll.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var allocations []Allocation
+	err = cursor.All(ctx, &allocations)
+	return allocations, err
+}
+
+func (l *Ledger) Available(ctx context.Context, name string) (decimal.Decimal, error) {
+	a, err := l.Get(ctx, name)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return a.Available(), nil
+}
+
+// Reserve capital for a buy order, before the order placed
+func (l *Ledger) Reserve(ctx context.Context, name, clientOrderId, symbol string, amount decimal.Decimal) error {
+	return l.update(ctx, name, func(a *Allocation) error {
+		return a.reserve(clientOrderId, symbol, amount, time.Now())
+	})
+}
+
+// Bind the exchange order id to the reservation, after the order placed
+func (l *Ledger) Bind(ctx context.Context, name, clientOrderId string, orderId uint64) error {
+	return l.update(ctx, name, func(a *Allocation) error {
+		return a.bind(clientOrderId, orderId)
+	})
+}
+
+// Release the reservation, when the order is cancelled or failed
+func (l *Ledger) Release(ctx context.Context, name, clientOrderId string) error {
+	return l.Settle(ctx, name, clientOrderId, decimal.Zero)
+}
+
+// Settle the reservation with the spent capital, the rest is released
+func (l *Ledger) Settle(ctx context.Context, name, clientOrderId string, spent decimal.Decimal) error {
+	return l.update(ctx, name, func(a *Allocation) error {
+		a.settle(clientOrderId, spent)
+		return nil
+	})
+}
+
+// Credit the income of sell orders back to the allocation
+func (l *Ledger) Credit(ctx context.Context, name string, income decimal.Decimal) error {
+	return l.update(ctx, name, func(a *Allocation) error {
+		a.credit(income)
+		return nil
+	})
+}
+
+// Reconcile settle reservations of finished orders, and compare the allocations with the real balance.
+// When the committed capital is more than the balance, the allocations of the currency are blocked until it's enough.
+func (l *Ledger) Reconcile(ctx context.Context, ex exchange.RestAPIExchange) error {
+	allocations, err := l.All(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range allocations {
+		a := &allocations[i]
+		for clientOrderId, spent := range a.settlements(ex, now, l.Sugar) {
+			if err := l.Settle(ctx, a.Name, clientOrderId, spent); err != nil {
+				l.Sugar.Errorf("settle reservation %s error: %s", clientOrderId, err)
+				continue
+			}
+			a.settle(clientOrderId, spent)
+		}
+	}
+	balance, err := ex.SpotBalance()
+	if err != nil {
+		return err
+	}
+	over := overCommitted(allocations, balance)
+	for currency, c := range over {
+		l.Sugar.Warnf("%s over allocated, allocations: %s, balance: %s, new reservations are blocked", currency, c, balance[currency])
+	}
+	for _, a := range allocations {
+		_, blocked := over[a.Currency]
+		if a.Blocked == blocked {
+			continue
+		}
+		if err := l.update(ctx, a.Name, func(a *Allocation) error {
+			a.Blocked = blocked
+			return nil
+		}); err != nil {
+			l.Sugar.Errorf("block allocation %s error: %s", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// Start reconcile periodically
+func (l *Ledger) Start(ctx context.Context, ex exchange.RestAPIExchange, interval time.Duration) {
+	if interval == 0 {
+		interval = defaultReconcileInterval
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			if err := l.Reconcile(ctx, ex); err != nil {
+				l.Sugar.Errorf("reconcile ledger error: %s", err)
+			}
+		}
+	}
+}
+
+// update the allocation with optimistic lock, retry if it's modified by others
+func (l *Ledger) update(ctx context.Context, name string, fn func(a *Allocation) error) error {
+	for i := 0; i < maxLedgerRetry; i++ {
+		a, err := l.Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		version := a.Version
+		if err := fn(&a); err != nil {
+			return err
+		}
+		if a.Reservations == nil {
+			a.Reservations = []Reservation{}
+		}
+		r, err := l.coll.UpdateOne(
+			ctx,
+			bson.D{
+				{"_id", name},
+				{"version", version},
+			},
+			bson.D{
+				{"$set", bson.D{
+					{"used", a.Used},
+					{"reservations", a.Reservations},
+					{"blocked", a.Blocked},
+					{"version", version + 1},
+					{"updated", time.Now()},
+				}},
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if r.MatchedCount == 1 {
+			return nil
+		}
+	}
+	return fmt.Errorf("update allocation %s conflict", name)
+}
+
+// OrderFinished check if the order is filled or cancelled, the status is different between exchanges
+func OrderFinished(status string) bool {
+	switch status {
+	case "closed", "cancelled", // gate
+		"filled", "canceled", "partial-canceled": // huobi
+		return true
+	}
+	return false
+}
+
+// Account bind a trader or executor to its allocation in the shared ledger, zero value means no ledger
+type Account struct {
+	ledger *Ledger
+	name   string
+	symbol string
+}
+
+func (a *Account) Init(ctx context.Context, ledger *Ledger, name, currency, symbol string, limit decimal.Decimal) error {
+	a.ledger = ledger
+	a.name = name
+	a.symbol = symbol
+	return ledger.Allocate(ctx, name, currency, limit)
+}
+
+// Enabled return true if the shared ledger is used
+func (a *Account) Enabled() bool {
+	return a.ledger != nil
+}
+
+// Limit the total by the available capital
+func (a *Account) Limit(total decimal.Decimal) decimal.Decimal {
+	if a.ledger == nil {
+		return total
+	}
+	available, err := a.ledger.Available(context.Background(), a.name)
+	if err != nil {
+		a.ledger.Sugar.Errorf("get available capital error: %s", err)
+		return decimal.Zero
+	}
+	if total.GreaterThan(available) {
+		return available
+	}
+	return total
+}
+
+// Reserve the capital before placing the buy order
+func (a *Account) Reserve(clientOrderId string, total decimal.Decimal) error {
+	if a.ledger == nil {
+		return nil
+	}
+	return a.ledger.Reserve(context.Background(), a.name, clientOrderId, a.symbol, total)
+}
+
+// Placed bind the order id to the reservation, or release it if place order failed
+func (a *Account) Placed(clientOrderId string, orderId uint64, err error) {
+	if a.ledger == nil {
+		return
+	}
+	if err != nil {
+		if err1 := a.ledger.Release(context.Background(), a.name, clientOrderId); err1 != nil {
+			a.ledger.Sugar.Errorf("release reservation %s error: %s", clientOrderId, err1)
+		}
+		return
+	}
+	if err1 := a.ledger.Bind(context.Background(), a.name, clientOrderId, orderId); err1 != nil {
+		a.ledger.Sugar.Errorf("bind reservation %s error: %s", clientOrderId, err1)
+	}
+}
+
+// Settle the buy order, the unfilled part is released
+func (a *Account) Settle(o exchange.Order) {
+	if a.ledger == nil {
+		return
+	}
+	if err := a.ledger.Settle(context.Background(), a.name, o.ClientOrderId, o.FilledPrice.Mul(o.FilledAmount)); err != nil {
+		a.ledger.Sugar.Errorf("settle reservation %s error: %s", o.ClientOrderId, err)
+	}
+}
+
+// SettleJob settle the buy job of the execution algorithm, the reservation is made for the job, not its child orders
+func (a *Account) SettleJob(p Progress) {
+	if a.ledger == nil {
+		return
+	}
+	if err := a.ledger.Settle(context.Background(), a.name, p.Job.ClientOrderId, p.FilledTotal); err != nil {
+		a.ledger.Sugar.Errorf("settle reservation %s error: %s", p.Job.ClientOrderId, err)
+	}
+}
+
+// Credit the sell income
+func (a *Account) Credit(income decimal.Decimal) {
+	if a.ledger == nil {
+		return
+	}
+	if err := a.ledger.Credit(context.Background(), a.name, income); err != nil {
+		a.ledger.Sugar.Errorf("credit %s error: %s", a.name, err)
+	}
+}
@@ -0,0 +1,193 @@
+package executor
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestAllocation_Available(t *testing.T) {
+	a := Allocation{
+		Limit: "1000",
+		Used:  "300",
+		Reservations: []Reservation{
+			{ClientOrderId: "a", Amount: "100"},
+			{ClientOrderId: "b", Amount: "50.5"},
+		},
+	}
+	if want := decimal.RequireFromString("549.5"); !a.Available().Equal(want) {
+		t.Errorf("want %s, got %s", want, a.Available())
+	}
+	if _, ok := a.remove("a"); !ok {
+		t.Error("reservation a not found")
+	}
+	if _, ok := a.remove("c"); ok {
+		t.Error("reservation c should not exist")
+	}
+	if want := decimal.RequireFromString("649.5"); !a.Available().Equal(want) {
+		t.Errorf("want %s, got %s", want, a.Available())
+	}
+}
+
+func TestOrderFinished(t *testing.T) {
+	var tests = []struct {
+		status string
+		result bool
+	}{
+		{"open", false},
+		{"closed", true},
+		{"cancelled", true},
+		{"submitted", false},
+		{"partial-filled", false},
+		{"filled", true},
+		{"partial-canceled", true},
+	}
+	for i, tt := range tests {
+		if got := OrderFinished(tt.status); got != tt.result {
+			t.Errorf("[%d] %s want %t, got %t", i, tt.status, tt.result, got)
+		}
+	}
+}
+
+func TestAllocation_Lifecycle(t *testing.T) {
+	var tests = []struct {
+		name      string
+		blocked   bool
+		reserve   string
+		err       error
+		orderId   uint64 // 0 means place order failed
+		spent     string // empty means the order is not finished
+		used      string
+		available string
+		reserved  int
+	}{
+		{"filled", false, "100", nil, 1, "100", "100", "900", 0},
+		{"partial filled", false, "100", nil, 1, "40", "40", "960", 0},
+		{"cancelled", false, "100", nil, 1, "0", "0", "1000", 0},
+		{"place failed", false, "100", nil, 0, "0", "0", "1000", 0},
+		{"open", false, "100", nil, 1, "", "0", "900", 1},
+		{"insufficient", false, "1000.01", ErrInsufficientCapital, 0, "", "0", "1000", 0},
+		{"blocked", true, "10", ErrInsufficientCapital, 0, "", "0", "1000", 0},
+	}
+	for _, tt := range tests {
+		a := Allocation{Limit: "1000", Used: "0", Blocked: tt.blocked}
+		err := a.reserve("a", "btc_usdt", decimal.RequireFromString(tt.reserve), time.Now())
+		if err != tt.err {
+			t.Errorf("[%s] reserve want error %v, got %v", tt.name, tt.err, err)
+		}
+		if err == nil && tt.orderId != 0 {
+			if err := a.bind("a", tt.orderId); err != nil {
+				t.Errorf("[%s] bind error: %s", tt.name, err)
+			}
+		}
+		if tt.spent != "" {
+			a.settle("a", decimal.RequireFromString(tt.spent))
+			// settle again is ignored
+			a.settle("a", decimal.RequireFromString(tt.spent))
+		}
+		if !decimalOrZero(a.Used).Equal(decimal.RequireFromString(tt.used)) {
+			t.Errorf("[%s] want used %s, got %s", tt.name, tt.used, a.Used)
+		}
+		if want := decimal.RequireFromString(tt.available); !a.Available().Equal(want) {
+			t.Errorf("[%s] want available %s, got %s", tt.name, want, a.Available())
+		}
+		if len(a.Reservations) != tt.reserved {
+			t.Errorf("[%s] want %d reservations, got %d", tt.name, tt.reserved, len(a.Reservations))
+		}
+	}
+}
+
+func TestAllocation_Credit(t *testing.T) {
+	a := Allocation{Limit: "1000", Used: "300"}
+	a.credit(decimal.RequireFromString("320"))
+	if want := decimal.RequireFromString("-20"); !decimalOrZero(a.Used).Equal(want) {
+		t.Errorf("want used %s, got %s", want, a.Used)
+	}
+	if err := a.bind("a", 1); err == nil {
+		t.Error("bind unknown reservation should fail")
+	}
+}
+
+func TestAllocation_settlements(t *testing.T) {
+	now := time.Now()
+	ex := newFakeExchange("100", "0")
+	ex.orders[1] = exchange.Order{Id: 1, Status: "closed", FilledPrice: decimal.RequireFromString("100"), FilledAmount: decimal.RequireFromString("1")}
+	ex.orders[2] = exchange.Order{Id: 2, Status: "cancelled", FilledPrice: decimal.RequireFromString("100"), FilledAmount: decimal.RequireFromString("0.3")}
+	ex.orders[3] = exchange.Order{Id: 3, Status: "open"}
+	a := Allocation{
+		Name: "test", Limit: "1000", Used: "0",
+		Reservations: []Reservation{
+			{ClientOrderId: "filled", OrderId: 1, Amount: "100", Created: now},
+			{ClientOrderId: "partial", OrderId: 2, Amount: "100", Created: now},
+			{ClientOrderId: "open", OrderId: 3, Amount: "100", Created: now},
+			{ClientOrderId: "stale", Amount: "100", Created: now.Add(-staleReservation - time.Minute)},
+			{ClientOrderId: "running", Amount: "100", Created: now.Add(-time.Minute)},
+		},
+	}
+	settled := a.settlements(ex, now, zap.NewNop().Sugar())
+	var tests = []struct {
+		clientOrderId string
+		settled       bool
+		spent         string
+	}{
+		{"filled", true, "100"},
+		{"partial", true, "30"},
+		{"open", false, ""},
+		{"stale", true, "0"},
+		{"running", false, ""},
+	}
+	for _, tt := range tests {
+		spent, ok := settled[tt.clientOrderId]
+		if ok != tt.settled {
+			t.Errorf("[%s] want settled %t, got %t", tt.clientOrderId, tt.settled, ok)
+			continue
+		}
+		if ok && !spent.Equal(decimal.RequireFromString(tt.spent)) {
+			t.Errorf("[%s] want spent %s, got %s", tt.clientOrderId, tt.spent, spent)
+		}
+	}
+	for clientOrderId, spent := range settled {
+		a.settle(clientOrderId, spent)
+	}
+	if want := decimal.RequireFromString("130"); !decimalOrZero(a.Used).Equal(want) {
+		t.Errorf("want used %s, got %s", want, a.Used)
+	}
+	if want := decimal.RequireFromString("670"); !a.Available().Equal(want) {
+		t.Errorf("want available %s, got %s", want, a.Available())
+	}
+}
+
+func TestOverCommitted(t *testing.T) {
+	allocations := []Allocation{
+		{Name: "a", Currency: "usdt", Limit: "1000", Used: "300"},
+		{Name: "b", Currency: "usdt", Limit: "500", Used: "-100"},
+		{Name: "c", Currency: "btc", Limit: "1", Used: "0"},
+	}
+	var tests = []struct {
+		usdt, btc string
+		over      map[string]string
+	}{
+		{"1300", "1", map[string]string{}},
+		{"1299.99", "1", map[string]string{"usdt": "1300"}},
+		{"2000", "0.5", map[string]string{"btc": "1"}},
+		{"0", "0", map[string]string{"usdt": "1300", "btc": "1"}},
+	}
+	for i, tt := range tests {
+		balance := map[string]decimal.Decimal{
+			"usdt": decimal.RequireFromString(tt.usdt),
+			"btc":  decimal.RequireFromString(tt.btc),
+		}
+		over := overCommitted(allocations, balance)
+		if len(over) != len(tt.over) {
+			t.Errorf("[%d] want %v, got %v", i, tt.over, over)
+			continue
+		}
+		for currency, want := range tt.over {
+			if c, ok := over[currency]; !ok || !c.Equal(decimal.RequireFromString(want)) {
+				t.Errorf("[%d] %s want %s, got %s", i, currency, want, c)
+			}
+		}
+	}
+}
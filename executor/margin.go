@@ -0,0 +1,363 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	MarginCross    = "cross"
+	MarginIsolated = "isolated"
+
+	keyShortPosition = "shortPosition"
+	// the margin account is liquidated when the risk rate (assets / debt) falls to 110%
+	liquidationRiskRate = 1.1
+
+	// the margin market order is checked every second, until it is finished
+	marginOrderChecks        = 30
+	marginOrderCheckInterval = time.Second
+)
+
+var ErrShortTooSmall = errors.New("short amount is too small")
+
+// MarginConf config the short side on the margin account, the collateral (quote currency) must be in the margin account.
+type MarginConf struct {
+	Mode        string  // cross or isolated, default is isolated
+	Leverage    float64 // leverage when open short, default is 1
+	MaxLeverage float64 `json:"maxLeverage"` // close the short if the leverage exceeds it, default is 3
+	Slippage    float64 // extra percent of the buy back total, default is 0.5
+}
+
+// MarginBalance of the margin account of one symbol, loan and interest are positive
+type MarginBalance struct {
+	Trade            map[string]decimal.Decimal
+	Loan             map[string]decimal.Decimal
+	Interest         map[string]decimal.Decimal
+	LiquidationPrice decimal.Decimal // zero if the exchange does not provide
+}
+
+// MarginExchange is the exchange supports margin loan
+type MarginExchange interface {
+	MarginBalance(symbol string) (MarginBalance, error)
+	Borrow(symbol, currency string, amount decimal.Decimal) (loanId uint64, err error)
+	Repay(loanId uint64, amount decimal.Decimal) error
+	MarginSellMarket(symbol, clientOrderId string, amount decimal.Decimal) (orderId uint64, err error)
+	MarginBuyMarket(symbol, clientOrderId string, total decimal.Decimal) (orderId uint64, err error)
+	GetMarginOrder(orderId uint64) (MarginOrder, error)
+}
+
+// MarginOrder is the state of a margin order, FilledTotal is the filled quote (cash) amount
+type MarginOrder struct {
+	Id           uint64
+	Status       string
+	FilledAmount decimal.Decimal
+	FilledTotal  decimal.Decimal
+}
+
+// ShortPosition is the opening short, it's saved in state collection
+type ShortPosition struct {
+	LoanId      uint64    `bson:"loanId"`
+	Borrowed    string    `bson:"borrowed"`    // base currency borrowed
+	Price       string    `bson:"price"`       // average sell price
+	Income      string    `bson:"income"`      // quote currency got from the sell
+	Interest    string    `bson:"interest"`    // interest accrued, in base currency
+	Liquidation string    `bson:"liquidation"` // liquidation price
+	Leverage    string    `bson:"leverage"`
+	Opened      time.Time `bson:"opened"`
+}
+
+func (p ShortPosition) Active() bool {
+	return p.LoanId != 0
+}
+
+// MarginShort borrow the base currency and sell, buy back and repay when close.
+type MarginShort struct {
+	Sugar  *zap.SugaredLogger
+	conf   MarginConf
+	ex     MarginExchange
+	symbol exchange.Symbol
+	coll   *mongo.Collection
+
+	lock     sync.Mutex
+	position ShortPosition
+}
+
+func NewMarginShort(conf MarginConf, ex MarginExchange, symbol exchange.Symbol, coll *mongo.Collection, sugar *zap.SugaredLogger) (*MarginShort, error) {
+	if conf.Mode == "" {
+		conf.Mode = MarginIsolated
+	}
+	if conf.Mode != MarginCross && conf.Mode != MarginIsolated {
+		return nil, fmt.Errorf("unknown margin mode: %s", conf.Mode)
+	}
+	if conf.Leverage <= 0 {
+		conf.Leverage = 1
+	}
+	if conf.MaxLeverage <= 0 {
+		conf.MaxLeverage = 3
+	}
+	if conf.Leverage > conf.MaxLeverage {
+		return nil, fmt.Errorf("leverage %f exceeds max leverage %f", conf.Leverage, conf.MaxLeverage)
+	}
+	if conf.Slippage <= 0 {
+		conf.Slippage = 0.5
+	}
+	return &MarginShort{Sugar: sugar, conf: conf, ex: ex, symbol: symbol, coll: coll}, nil
+}
+
+func (m *MarginShort) Load(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return hs.LoadKey(ctx, m.coll, keyShortPosition, &m.position)
+}
+
+func (m *MarginShort) Position() ShortPosition {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.position
+}
+
+// MaxLeverage is the leverage limit in config
+func (m *MarginShort) MaxLeverage() float64 {
+	return m.conf.MaxLeverage
+}
+
+func (m *MarginShort) save(ctx context.Context) {
+	if err := hs.SaveKey(ctx, m.coll, keyShortPosition, m.position); err != nil {
+		m.Sugar.Errorf("save short position error: %s", err)
+	}
+}
+
+// Open the short at about price, the value is the margin equity * leverage, and not more than maxTotal (if positive)
+func (m *MarginShort) Open(ctx context.Context, price, maxTotal decimal.Decimal, clientOrderId string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.position.Active() {
+		m.Sugar.Infof("short position is already open, loan id: %d", m.position.LoanId)
+		return nil
+	}
+	base, quote := m.symbol.BaseCurrency, m.symbol.QuoteCurrency
+	before, err := m.ex.MarginBalance(m.symbol.Symbol)
+	if err != nil {
+		return err
+	}
+	amount := shortAmount(before.Trade[quote], decimal.NewFromFloat(m.conf.Leverage), maxTotal, price, m.symbol.AmountPrecision)
+	if amount.LessThan(m.symbol.LimitOrderMinAmount) || amount.Mul(price).LessThan(m.symbol.MinTotal) {
+		return fmt.Errorf("%w: %s, collateral: %s %s", ErrShortTooSmall, amount, before.Trade[quote], quote)
+	}
+	loanId, err := m.ex.Borrow(m.symbol.Symbol, base, amount)
+	if err != nil {
+		return err
+	}
+	// save the loan before sell, so it's repaid even the sell failed
+	m.position = ShortPosition{LoanId: loanId, Borrowed: amount.String(), Opened: time.Now()}
+	m.save(ctx)
+	m.Sugar.Infof("borrowed %s %s, loan id: %d", amount, base, loanId)
+
+	orderId, err := m.ex.MarginSellMarket(m.symbol.Symbol, clientOrderId, amount)
+	if err == nil && orderId == 0 {
+		err = errors.New("short sell order not placed, maybe insufficient balance")
+	}
+	if err != nil {
+		// repay the unsold coins at once, or the loan accrues interest until the next close
+		if err1 := m.repayUnsold(ctx); err1 != nil {
+			m.Sugar.Errorf("repay loan %d error: %s", loanId, err1)
+		}
+		return err
+	}
+	o, err := waitMarginOrder(ctx, m.ex, orderId, marginOrderCheckInterval)
+	if err != nil {
+		return err
+	}
+	income := o.FilledTotal
+	m.position.Income = income.String()
+	if o.FilledAmount.IsPositive() {
+		m.position.Price = income.DivRound(o.FilledAmount, m.symbol.PricePrecision).String()
+	}
+	m.Sugar.Infof("short sell order %d / %s, amount: %s, filled: %s, income: %s", orderId, clientOrderId, amount, o.FilledAmount, income)
+	after, err := m.ex.MarginBalance(m.symbol.Symbol)
+	if err != nil {
+		m.save(ctx)
+		return err
+	}
+	m.update(after, price)
+	m.save(ctx)
+	return nil
+}
+
+// Close buy back the borrowed and interest, then repay. The profit is in quote currency.
+func (m *MarginShort) Close(ctx context.Context, price decimal.Decimal, clientOrderId string) (profit decimal.Decimal, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.position.Active() {
+		return decimal.Zero, nil
+	}
+	base, quote := m.symbol.BaseCurrency, m.symbol.QuoteCurrency
+	before, err := m.ex.MarginBalance(m.symbol.Symbol)
+	if err != nil {
+		return
+	}
+	debt := before.Loan[base].Add(before.Interest[base])
+	spent := decimal.Zero
+	if need := debt.Sub(before.Trade[base]); need.IsPositive() {
+		total := buyBackTotal(need, price, decimal.NewFromFloat(m.conf.Slippage), m.symbol.PricePrecision)
+		if total.LessThan(m.symbol.MinTotal) {
+			total = m.symbol.MinTotal
+		}
+		orderId, err := m.ex.MarginBuyMarket(m.symbol.Symbol, clientOrderId, total)
+		if err != nil {
+			return profit, err
+		}
+		o, err := waitMarginOrder(ctx, m.ex, orderId, marginOrderCheckInterval)
+		if err != nil {
+			return profit, err
+		}
+		spent = o.FilledTotal
+		m.Sugar.Infof("short buy back order %d / %s, need: %s, total: %s, spent: %s", orderId, clientOrderId, need, total, spent)
+	}
+	after, err := m.ex.MarginBalance(m.symbol.Symbol)
+	if err != nil {
+		return
+	}
+	repay := decimal.Min(after.Trade[base], debt)
+	if err = m.ex.Repay(m.position.LoanId, repay); err != nil {
+		return
+	}
+	profit = decimalOrZero(m.position.Income).Sub(spent)
+	if repay.LessThan(debt) {
+		m.update(after, price)
+		m.save(ctx)
+		return profit, fmt.Errorf("loan %d is partly repaid, %s / %s %s", m.position.LoanId, repay, debt, base)
+	}
+	m.Sugar.Infof("loan %d repaid %s %s, profit: %s %s", m.position.LoanId, repay, base, profit, quote)
+	m.clear(ctx)
+	return profit, nil
+}
+
+// repayUnsold repay the loan with the borrowed coins, when the short sell failed
+func (m *MarginShort) repayUnsold(ctx context.Context) error {
+	base := m.symbol.BaseCurrency
+	b, err := m.ex.MarginBalance(m.symbol.Symbol)
+	if err != nil {
+		return err
+	}
+	debt := b.Loan[base].Add(b.Interest[base])
+	repay := decimal.Min(b.Trade[base], debt)
+	if err := m.ex.Repay(m.position.LoanId, repay); err != nil {
+		return err
+	}
+	if repay.LessThan(debt) {
+		return fmt.Errorf("loan %d is partly repaid, %s / %s %s", m.position.LoanId, repay, debt, base)
+	}
+	m.Sugar.Infof("loan %d repaid %s %s, the short sell failed", m.position.LoanId, repay, base)
+	m.clear(ctx)
+	return nil
+}
+
+func (m *MarginShort) clear(ctx context.Context) {
+	m.position = ShortPosition{}
+	if err := hs.DeleteKey(ctx, m.coll, keyShortPosition); err != nil {
+		m.Sugar.Errorf("delete short position error: %s", err)
+	}
+}
+
+// waitMarginOrder check the order every interval until it is finished, the filled amount is final only then
+func waitMarginOrder(ctx context.Context, ex MarginExchange, orderId uint64, interval time.Duration) (o MarginOrder, err error) {
+	for i := 0; i < marginOrderChecks; i++ {
+		o, err = ex.GetMarginOrder(orderId)
+		if err == nil && OrderFinished(o.Status) {
+			return o, nil
+		}
+		select {
+		case <-ctx.Done():
+			return o, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+	if err != nil {
+		return o, err
+	}
+	return o, fmt.Errorf("margin order %d is not finished, status: %s", orderId, o.Status)
+}
+
+// Update the interest, leverage and liquidation price at price.
+// It returns true if the leverage exceeds the max, the short should be closed then.
+func (m *MarginShort) Update(ctx context.Context, price decimal.Decimal) (over bool, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.position.Active() {
+		return false, nil
+	}
+	b, err := m.ex.MarginBalance(m.symbol.Symbol)
+	if err != nil {
+		return false, err
+	}
+	leverage := m.update(b, price)
+	m.save(ctx)
+	return leverage.GreaterThan(decimal.NewFromFloat(m.conf.MaxLeverage)), nil
+}
+
+func (m *MarginShort) update(b MarginBalance, price decimal.Decimal) decimal.Decimal {
+	base, quote := m.symbol.BaseCurrency, m.symbol.QuoteCurrency
+	debt := b.Loan[base].Add(b.Interest[base])
+	leverage := shortLeverage(b.Trade[quote], b.Trade[base], debt, price)
+	liquidation := b.LiquidationPrice
+	if !liquidation.IsPositive() {
+		liquidation = shortLiquidation(b.Trade[quote], b.Trade[base], debt).Round(m.symbol.PricePrecision)
+	}
+	m.position.Interest = b.Interest[base].String()
+	m.position.Leverage = leverage.StringFixed(2)
+	m.position.Liquidation = liquidation.String()
+	m.Sugar.Infof("short position, borrowed: %s, interest: %s %s, leverage: %s, liquidation price: %s",
+		m.position.Borrowed, m.position.Interest, base, m.position.Leverage, m.position.Liquidation)
+	return leverage
+}
+
+// shortAmount is the base amount to borrow and sell
+func shortAmount(collateral, leverage, maxTotal, price decimal.Decimal, precision int32) decimal.Decimal {
+	value := collateral.Mul(leverage)
+	if maxTotal.IsPositive() && value.GreaterThan(maxTotal) {
+		value = maxTotal
+	}
+	if !price.IsPositive() {
+		return decimal.Zero
+	}
+	return value.Div(price).Truncate(precision)
+}
+
+// buyBackTotal is the quote total to buy amount back at price, with slippage percent
+func buyBackTotal(amount, price, slippage decimal.Decimal, precision int32) decimal.Decimal {
+	p := decimal.NewFromInt(1).Add(slippage.Div(decimal.NewFromInt(100)))
+	total := amount.Mul(price).Mul(p)
+	t := total.Truncate(precision)
+	if t.LessThan(total) {
+		t = t.Add(decimal.New(1, -precision))
+	}
+	return t
+}
+
+// shortLeverage is debt value / equity, equity is quote + (base - debt) * price
+func shortLeverage(quote, base, debt, price decimal.Decimal) decimal.Decimal {
+	debtValue := debt.Mul(price)
+	equity := quote.Add(base.Mul(price)).Sub(debtValue)
+	if !equity.IsPositive() {
+		return decimal.NewFromInt(1000000)
+	}
+	return debtValue.Div(equity)
+}
+
+// shortLiquidation is the price when (quote + base * price) / (debt * price) == liquidationRiskRate
+func shortLiquidation(quote, base, debt decimal.Decimal) decimal.Decimal {
+	d := debt.Mul(decimal.NewFromFloat(liquidationRiskRate)).Sub(base)
+	if !d.IsPositive() {
+		return decimal.Zero
+	}
+	return quote.Div(d)
+}
@@ -0,0 +1,162 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/huobirdcenter/huobi_golang/pkg/client"
+	"github.com/huobirdcenter/huobi_golang/pkg/model/margin"
+	"github.com/huobirdcenter/huobi_golang/pkg/model/order"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange/huobi"
+)
+
+// HuobiMargin is the MarginExchange of huobi, mode is cross (super-margin) or isolated (margin)
+type HuobiMargin struct {
+	c    *huobi.Client
+	mode string
+
+	accountId string
+}
+
+func NewHuobiMargin(c *huobi.Client, mode, symbol string) (*HuobiMargin, error) {
+	m := &HuobiMargin{c: c, mode: mode}
+	accounts, err := c.GetAccountInfo()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		if mode == MarginCross && a.Type == "super-margin" ||
+			mode != MarginCross && a.Type == "margin" && a.Subtype == symbol {
+			m.accountId = fmt.Sprintf("%d", a.Id)
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s margin account for %s, transfer some collateral in first", mode, symbol)
+}
+
+func (m *HuobiMargin) MarginBalance(symbol string) (b MarginBalance, err error) {
+	b = MarginBalance{
+		Trade:    make(map[string]decimal.Decimal),
+		Loan:     make(map[string]decimal.Decimal),
+		Interest: make(map[string]decimal.Decimal),
+	}
+	add := func(currency, typ, balance string) {
+		value, err := decimal.NewFromString(balance)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case "trade":
+			b.Trade[currency] = b.Trade[currency].Add(value)
+		case "loan":
+			b.Loan[currency] = b.Loan[currency].Add(value.Abs())
+		case "interest":
+			b.Interest[currency] = b.Interest[currency].Add(value.Abs())
+		}
+	}
+	if m.mode == MarginCross {
+		hb := new(client.CrossMarginClient).Init(m.c.AccessKey, m.c.SecretKey, m.c.Host)
+		r, err := hb.MarginAccountsBalance("")
+		if err != nil {
+			return b, err
+		}
+		if r == nil {
+			return b, errors.New("no cross margin balance")
+		}
+		for _, i := range r.List {
+			add(i.Currency, i.Type, i.Balance)
+		}
+		return b, nil
+	}
+	hb := new(client.IsolatedMarginClient).Init(m.c.AccessKey, m.c.SecretKey, m.c.Host)
+	r, err := hb.MarginAccountsBalance(margin.MarginAccountsBalanceOptionalRequest{Symbol: symbol})
+	if err != nil {
+		return b, err
+	}
+	for _, a := range r {
+		if a.Symbol != symbol {
+			continue
+		}
+		for _, i := range a.List {
+			add(i.Currency, i.Type, i.Balance)
+		}
+		b.LiquidationPrice, _ = decimal.NewFromString(a.FlPrice)
+	}
+	return b, nil
+}
+
+func (m *HuobiMargin) Borrow(symbol, currency string, amount decimal.Decimal) (uint64, error) {
+	var id int
+	var err error
+	if m.mode == MarginCross {
+		hb := new(client.CrossMarginClient).Init(m.c.AccessKey, m.c.SecretKey, m.c.Host)
+		id, err = hb.ApplyLoan(margin.CrossMarginOrdersRequest{Currency: currency, Amount: amount.String()})
+	} else {
+		hb := new(client.IsolatedMarginClient).Init(m.c.AccessKey, m.c.SecretKey, m.c.Host)
+		id, err = hb.Apply(margin.IsolatedMarginOrdersRequest{Symbol: symbol, Currency: currency, Amount: amount.String()})
+	}
+	if err != nil {
+		return 0, err
+	}
+	return uint64(id), nil
+}
+
+func (m *HuobiMargin) Repay(loanId uint64, amount decimal.Decimal) error {
+	var err error
+	r := margin.MarginOrdersRepayRequest{Amount: amount.String()}
+	if m.mode == MarginCross {
+		hb := new(client.CrossMarginClient).Init(m.c.AccessKey, m.c.SecretKey, m.c.Host)
+		_, err = hb.Repay(fmt.Sprintf("%d", loanId), r)
+	} else {
+		hb := new(client.IsolatedMarginClient).Init(m.c.AccessKey, m.c.SecretKey, m.c.Host)
+		_, err = hb.Repay(fmt.Sprintf("%d", loanId), r)
+	}
+	return err
+}
+
+func (m *HuobiMargin) MarginSellMarket(symbol, clientOrderId string, amount decimal.Decimal) (uint64, error) {
+	return m.c.PlaceOrder(&order.PlaceOrderRequest{
+		AccountId:     m.accountId,
+		Type:          "sell-market",
+		Source:        m.source(),
+		Symbol:        symbol,
+		Amount:        amount.String(),
+		ClientOrderId: clientOrderId,
+	})
+}
+
+// MarginBuyMarket buy with total quote currency, the same as spot buy-market
+func (m *HuobiMargin) MarginBuyMarket(symbol, clientOrderId string, total decimal.Decimal) (uint64, error) {
+	return m.c.PlaceOrder(&order.PlaceOrderRequest{
+		AccountId:     m.accountId,
+		Type:          "buy-market",
+		Source:        m.source(),
+		Symbol:        symbol,
+		Amount:        total.String(),
+		ClientOrderId: clientOrderId,
+	})
+}
+
+func (m *HuobiMargin) GetMarginOrder(orderId uint64) (MarginOrder, error) {
+	hb := new(client.OrderClient).Init(m.c.AccessKey, m.c.SecretKey, m.c.Host)
+	r, err := hb.GetOrderById(fmt.Sprint(orderId))
+	if err != nil {
+		return MarginOrder{}, err
+	}
+	if r == nil || r.Data == nil {
+		return MarginOrder{}, fmt.Errorf("order %d not found", orderId)
+	}
+	return MarginOrder{
+		Id:           orderId,
+		Status:       r.Data.State,
+		FilledAmount: decimalOrZero(r.Data.FilledAmount),
+		FilledTotal:  decimalOrZero(r.Data.FilledCashAmount),
+	}, nil
+}
+
+func (m *HuobiMargin) source() string {
+	if m.mode == MarginCross {
+		return "super-margin-api"
+	}
+	return "margin-api"
+}
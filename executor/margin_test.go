@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func TestShortAmount(t *testing.T) {
+	var tests = []struct {
+		collateral string
+		leverage   string
+		maxTotal   string
+		price      string
+		want       string
+	}{
+		{"1000", "1", "0", "10", "100"},
+		{"1000", "2", "0", "10", "200"},
+		{"1000", "2", "500", "10", "50"},
+		{"1000", "1", "0", "3", "333.3333"},
+		{"1000", "1", "0", "0", "0"},
+	}
+	for i, tt := range tests {
+		got := shortAmount(
+			decimal.RequireFromString(tt.collateral),
+			decimal.RequireFromString(tt.leverage),
+			decimal.RequireFromString(tt.maxTotal),
+			decimal.RequireFromString(tt.price),
+			4,
+		)
+		if !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("[%d] want %s, got %s", i, tt.want, got)
+		}
+	}
+}
+
+func TestBuyBackTotal(t *testing.T) {
+	var tests = []struct {
+		amount   string
+		price    string
+		slippage string
+		want     string
+	}{
+		{"10", "100", "0.5", "1005"},
+		{"0.333", "3", "0", "1"}, // 0.999 rounded up
+	}
+	for i, tt := range tests {
+		got := buyBackTotal(
+			decimal.RequireFromString(tt.amount),
+			decimal.RequireFromString(tt.price),
+			decimal.RequireFromString(tt.slippage),
+			2,
+		)
+		if !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("[%d] want %s, got %s", i, tt.want, got)
+		}
+	}
+}
+
+func TestShortLeverage(t *testing.T) {
+	// 1000 collateral, sold 100 at 10, so 2000 quote and 100 debt
+	var tests = []struct {
+		price string
+		want  string
+	}{
+		{"10", "1"},
+		{"15", "3"},
+		{"20", "1000000"}, // no equity
+	}
+	quote, debt := decimal.NewFromInt(2000), decimal.NewFromInt(100)
+	for i, tt := range tests {
+		got := shortLeverage(quote, decimal.Zero, debt, decimal.RequireFromString(tt.price))
+		if !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("[%d] want %s, got %s", i, tt.want, got)
+		}
+	}
+}
+
+func TestShortLiquidation(t *testing.T) {
+	quote, debt := decimal.NewFromInt(2000), decimal.NewFromInt(100)
+	// 2000 / (100 * 1.1)
+	if got := shortLiquidation(quote, decimal.Zero, debt).Round(4); !got.Equal(decimal.RequireFromString("18.1818")) {
+		t.Errorf("want 18.1818, got %s", got)
+	}
+	if got := shortLiquidation(quote, decimal.NewFromInt(200), debt); !got.IsZero() {
+		t.Errorf("want no liquidation when base covers the debt, got %s", got)
+	}
+}
+
+func TestNewMarginShort(t *testing.T) {
+	m, err := NewMarginShort(MarginConf{}, nil, testSymbol(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.conf.Mode != MarginIsolated || m.conf.Leverage != 1 || m.conf.MaxLeverage != 3 || m.conf.Slippage != 0.5 {
+		t.Errorf("bad default conf: %+v", m.conf)
+	}
+	for _, conf := range []MarginConf{{Mode: "future"}, {Leverage: 4, MaxLeverage: 3}} {
+		if _, err := NewMarginShort(conf, nil, testSymbol(), nil, nil); err == nil {
+			t.Errorf("want error for %+v", conf)
+		}
+	}
+}
+
+// fakeMargin report the order unfinished for the first pending queries
+type fakeMargin struct {
+	MarginExchange
+	order   MarginOrder
+	pending int
+	queries int
+}
+
+func (f *fakeMargin) GetMarginOrder(orderId uint64) (MarginOrder, error) {
+	f.queries++
+	if f.queries <= f.pending {
+		return MarginOrder{Id: orderId, Status: "submitted"}, nil
+	}
+	return f.order, nil
+}
+
+func TestWaitMarginOrder(t *testing.T) {
+	filled := MarginOrder{Id: 1, Status: "filled", FilledAmount: decimal.NewFromInt(100), FilledTotal: decimal.NewFromInt(1000)}
+	ex := &fakeMargin{order: filled, pending: 2}
+	o, err := waitMarginOrder(context.Background(), ex, 1, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.queries != 3 || !o.FilledTotal.Equal(filled.FilledTotal) {
+		t.Errorf("want the filled order after 3 queries, got %+v after %d", o, ex.queries)
+	}
+	ex = &fakeMargin{order: filled, pending: marginOrderChecks}
+	if _, err := waitMarginOrder(context.Background(), ex, 1, time.Millisecond); err == nil {
+		t.Error("want error when the order is not finished")
+	}
+}
@@ -0,0 +1,692 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PaperEventTrade  = "trade"
+	PaperEventCancel = "cancellation"
+
+	paperAccountId       = "account"
+	paperStatusSubmitted = "submitted"
+	paperStatusFilled    = "filled"
+	paperStatusCanceled  = "canceled"
+	paperTypeLimit       = "limit"
+	paperTypeMarket      = "market"
+
+	defaultPaperFee      = 0.002
+	defaultPaperInterval = time.Second * 5
+)
+
+var ErrPaperInsufficientBalance = errors.New("insufficient paper balance")
+
+// PaperConf is the paper trading config, eg. {"balance": {"usdt": 1000}, "fee": 0.002, "slippage": 0.001}
+type PaperConf struct {
+	Balance  map[string]float64 // initial virtual balance, currency is the same as exchange, eg. USDT for gate, usdt for huobi
+	Fee      float64            // fee rate for maker and taker, default 0.002
+	Slippage float64            // market order slippage, eg. 0.001 means 0.1%
+	Interval string             // price polling interval, default 5s
+}
+
+// PaperEvent is the order event of paper trading, like the order push of exchange
+type PaperEvent struct {
+	Type      string // PaperEventTrade or PaperEventCancel
+	Direction string
+	Order     exchange.Order
+	Profit    decimal.Decimal // realized PnL of the sell trade, in quote currency
+}
+
+// PaperHandler is called after the paper order filled or cancelled
+type PaperHandler func(e PaperEvent)
+
+type paperOrder struct {
+	exchange.Order
+	Direction string
+	Base      string
+	Quote     string
+	Frozen    decimal.Decimal // locked balance, quote for buy and base for sell
+	Profit    decimal.Decimal
+}
+
+func (o *paperOrder) buy() bool {
+	return o.Direction == exchange.TradeDirectionBuy
+}
+
+// PaperExchange simulates the order fills locally by the live market data of the real exchange.
+// all the market data APIs are passed to the real exchange, and the trading APIs never touch it,
+// so it can be used wherever the real exchange is used.
+type PaperExchange struct {
+	exchange.RestAPIExchange
+
+	conf      PaperConf
+	fee       decimal.Decimal
+	slippage  decimal.Decimal
+	interval  time.Duration
+	Sugar     *zap.SugaredLogger
+	coll      *mongo.Collection
+	orderColl *mongo.Collection
+	broadcast func(format string, a ...interface{})
+	handler   PaperHandler
+	now       func() time.Time
+	namespace string // collection name prefix
+
+	lock     sync.Mutex
+	symbols  map[string]exchange.Symbol
+	balance  map[string]decimal.Decimal
+	locked   map[string]decimal.Decimal
+	cost     map[string]decimal.Decimal // cost of the base currency held, in quote currency
+	realized map[string]decimal.Decimal // realized PnL, by quote currency
+	orders   map[uint64]*paperOrder     // open orders
+	nextId   uint64
+}
+
+func NewPaperExchange(ex exchange.RestAPIExchange, conf PaperConf) *PaperExchange {
+	p := &PaperExchange{
+		RestAPIExchange: ex,
+		conf:            conf,
+		fee:             decimal.NewFromFloat(conf.Fee),
+		slippage:        decimal.NewFromFloat(conf.Slippage),
+		interval:        defaultPaperInterval,
+		symbols:         make(map[string]exchange.Symbol),
+		balance:         make(map[string]decimal.Decimal),
+		locked:          make(map[string]decimal.Decimal),
+		cost:            make(map[string]decimal.Decimal),
+		realized:        make(map[string]decimal.Decimal),
+		orders:          make(map[uint64]*paperOrder),
+		now:             time.Now,
+	}
+	if conf.Fee == 0 {
+		p.fee = decimal.NewFromFloat(defaultPaperFee)
+	}
+	if d, err := time.ParseDuration(conf.Interval); err == nil && d > 0 {
+		p.interval = d
+	}
+	return p
+}
+
+func (p *PaperExchange) Init(sugar *zap.SugaredLogger, db *mongo.Database) {
+	p.Sugar = sugar
+	p.coll = db.Collection(CollName(p.namespace, collNamePaper))
+	p.orderColl = db.Collection(CollName(p.namespace, collNamePaperOrder))
+}
+
+// SetNamespace set the collection name prefix, must be called before Init
+func (p *PaperExchange) SetNamespace(namespace string) {
+	p.namespace = namespace
+}
+
+// SetBroadcaster set the function to broadcast paper fills
+func (p *PaperExchange) SetBroadcaster(broadcast func(format string, a ...interface{})) {
+	p.broadcast = broadcast
+}
+
+// SetClock set the clock of order time, for backtest
+func (p *PaperExchange) SetClock(now func() time.Time) {
+	p.now = now
+}
+
+// SetHandler set the handler of paper order events
+func (p *PaperExchange) SetHandler(handler PaperHandler) {
+	p.handler = handler
+}
+
+// for mongodb
+type paperAccountRecord struct {
+	Balance  map[string]string `bson:"balance"`
+	Locked   map[string]string `bson:"locked"`
+	Cost     map[string]string `bson:"cost"`
+	Realized map[string]string `bson:"realized"`
+	NextId   uint64            `bson:"nextId"`
+}
+
+type paperOrderRecord struct {
+	Id            uint64    `bson:"_id"`
+	ClientOrderId string    `bson:"clientOrderId"`
+	Type          string    `bson:"type"`
+	Symbol        string    `bson:"symbol"`
+	Direction     string    `bson:"direction"`
+	Base          string    `bson:"base"`
+	Quote         string    `bson:"quote"`
+	Price         string    `bson:"price"`
+	Amount        string    `bson:"amount"`
+	Frozen        string    `bson:"frozen"`
+	Status        string    `bson:"status"`
+	FilledPrice   string    `bson:"filledPrice"`
+	FilledAmount  string    `bson:"filledAmount"`
+	Profit        string    `bson:"profit"`
+	Time          time.Time `bson:"time"`
+}
+
+func (r paperOrderRecord) order() *paperOrder {
+	return &paperOrder{
+		Order: exchange.Order{
+			Id:            r.Id,
+			ClientOrderId: r.ClientOrderId,
+			Type:          r.Type,
+			Symbol:        r.Symbol,
+			Price:         decimalOrZero(r.Price),
+			Amount:        decimalOrZero(r.Amount),
+			Time:          r.Time,
+			Status:        r.Status,
+			FilledPrice:   decimalOrZero(r.FilledPrice),
+			FilledAmount:  decimalOrZero(r.FilledAmount),
+		},
+		Direction: r.Direction,
+		Base:      r.Base,
+		Quote:     r.Quote,
+		Frozen:    decimalOrZero(r.Frozen),
+		Profit:    decimalOrZero(r.Profit),
+	}
+}
+
+func decimalMap(m map[string]string) map[string]decimal.Decimal {
+	d := make(map[string]decimal.Decimal)
+	for k, v := range m {
+		d[k] = decimalOrZero(v)
+	}
+	return d
+}
+
+func stringMap(m map[string]decimal.Decimal) map[string]string {
+	s := make(map[string]string)
+	for k, v := range m {
+		s[k] = v.String()
+	}
+	return s
+}
+
+// Load the paper account and open orders, create the account by config if not exists
+func (p *PaperExchange) Load(ctx context.Context) error {
+	var r paperAccountRecord
+	err := p.coll.FindOne(ctx, bson.D{{"_id", paperAccountId}}).Decode(&r)
+	if err == mongo.ErrNoDocuments {
+		p.lock.Lock()
+		for currency, amount := range p.conf.Balance {
+			p.balance[currency] = decimal.NewFromFloat(amount)
+		}
+		p.lock.Unlock()
+		p.Sugar.Infof("create paper account, balance: %v", p.conf.Balance)
+		return p.save(ctx, nil)
+	} else if err != nil {
+		return err
+	}
+	cursor, err := p.orderColl.Find(ctx, bson.D{{"status", paperStatusSubmitted}})
+	if err != nil {
+		return err
+	}
+	var records []paperOrderRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.balance = decimalMap(r.Balance)
+	p.locked = decimalMap(r.Locked)
+	p.cost = decimalMap(r.Cost)
+	p.realized = decimalMap(r.Realized)
+	p.nextId = r.NextId
+	for _, record := range records {
+		p.orders[record.Id] = record.order()
+	}
+	p.Sugar.Infof("paper account loaded, balance: %v, open orders: %d", p.balance, len(p.orders))
+	return nil
+}
+
+// save the account, and the order if not nil
+func (p *PaperExchange) save(ctx context.Context, o *paperOrder) error {
+	p.lock.Lock()
+	account := bson.D{
+		{"balance", stringMap(p.balance)},
+		{"locked", stringMap(p.locked)},
+		{"cost", stringMap(p.cost)},
+		{"realized", stringMap(p.realized)},
+		{"nextId", p.nextId},
+		{"updated", time.Now()},
+	}
+	var order bson.D
+	if o != nil {
+		order = bson.D{
+			{"clientOrderId", o.ClientOrderId},
+			{"type", o.Type},
+			{"symbol", o.Symbol},
+			{"direction", o.Direction},
+			{"base", o.Base},
+			{"quote", o.Quote},
+			{"price", o.Price.String()},
+			{"amount", o.Amount.String()},
+			{"frozen", o.Frozen.String()},
+			{"status", o.Status},
+			{"filledPrice", o.FilledPrice.String()},
+			{"filledAmount", o.FilledAmount.String()},
+			{"profit", o.Profit.String()},
+			{"time", o.Time},
+		}
+	}
+	p.lock.Unlock()
+
+	option := options.FindOneAndUpdate().SetUpsert(true)
+	r := p.coll.FindOneAndUpdate(ctx, bson.D{{"_id", paperAccountId}}, bson.D{{"$set", account}}, option)
+	if r.Err() != nil && r.Err() != mongo.ErrNoDocuments {
+		return errors.New(fmt.Sprintf("save paper account error: %s", r.Err()))
+	}
+	if o == nil {
+		return nil
+	}
+	r = p.orderColl.FindOneAndUpdate(ctx, bson.D{{"_id", o.Id}}, bson.D{{"$set", order}}, option)
+	if r.Err() != nil && r.Err() != mongo.ErrNoDocuments {
+		return errors.New(fmt.Sprintf("save paper order error: %s", r.Err()))
+	}
+	return nil
+}
+
+// Start poll the best bid/ask of symbols with open orders, and match the orders
+func (p *PaperExchange) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.interval):
+			for _, symbol := range p.openSymbols() {
+				bid, ask, err := p.quote(symbol)
+				if err != nil {
+					p.Sugar.Errorf("get paper quote error: %s", err)
+					continue
+				}
+				p.OnPrice(ctx, symbol, bid, ask)
+			}
+		}
+	}
+}
+
+func (p *PaperExchange) openSymbols() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, o := range p.orders {
+		if !seen[o.Symbol] {
+			seen[o.Symbol] = true
+			symbols = append(symbols, o.Symbol)
+		}
+	}
+	return symbols
+}
+
+// OnPrice match the open orders with the best bid and ask, limit order is filled at the better price
+func (p *PaperExchange) OnPrice(ctx context.Context, symbol string, bid, ask decimal.Decimal) {
+	p.match(ctx, symbol, func(o *paperOrder) (decimal.Decimal, bool) {
+		if o.buy() {
+			return decimal.Min(o.Price, ask), ask.IsPositive() && ask.LessThanOrEqual(o.Price)
+		}
+		return decimal.Max(o.Price, bid), bid.IsPositive() && bid.GreaterThanOrEqual(o.Price)
+	})
+}
+
+// OnCandle match the open orders with the candle bars after the order created,
+// limit order is filled at its own price when touched
+func (p *PaperExchange) OnCandle(ctx context.Context, symbol string, c hs.Candle) {
+	p.match(ctx, symbol, func(o *paperOrder) (decimal.Decimal, bool) {
+		for i := 0; i < c.Length(); i++ {
+			if c.Timestamp[i] < o.Time.Unix() {
+				continue
+			}
+			if o.buy() && decimal.NewFromFloat(c.Low[i]).LessThanOrEqual(o.Price) {
+				return o.Price, true
+			} else if !o.buy() && decimal.NewFromFloat(c.High[i]).GreaterThanOrEqual(o.Price) {
+				return o.Price, true
+			}
+		}
+		return decimal.Zero, false
+	})
+}
+
+func (p *PaperExchange) match(ctx context.Context, symbol string, fillPrice func(o *paperOrder) (decimal.Decimal, bool)) {
+	var filled []*paperOrder
+	p.lock.Lock()
+	for _, o := range p.orders {
+		if o.Symbol != symbol {
+			continue
+		}
+		if price, ok := fillPrice(o); ok {
+			p.fill(o, price)
+			delete(p.orders, o.Id)
+			filled = append(filled, o)
+		}
+	}
+	p.lock.Unlock()
+	for _, o := range filled {
+		p.filled(ctx, o)
+	}
+}
+
+// fill the order at price, the lock must be held
+func (p *PaperExchange) fill(o *paperOrder, price decimal.Decimal) {
+	total := price.Mul(o.Amount)
+	if o.buy() {
+		p.locked[o.Quote] = p.locked[o.Quote].Sub(o.Frozen)
+		p.balance[o.Quote] = p.balance[o.Quote].Sub(total)
+		p.balance[o.Base] = p.balance[o.Base].Add(o.Amount.Mul(decimal.NewFromInt(1).Sub(p.fee)))
+		p.cost[o.Base] = p.cost[o.Base].Add(total)
+	} else {
+		p.locked[o.Base] = p.locked[o.Base].Sub(o.Frozen)
+		held := p.balance[o.Base]
+		basis := decimal.Zero
+		if held.IsPositive() {
+			basis = p.cost[o.Base].Mul(o.Amount).Div(held)
+		}
+		income := total.Mul(decimal.NewFromInt(1).Sub(p.fee))
+		p.balance[o.Base] = held.Sub(o.Amount)
+		p.balance[o.Quote] = p.balance[o.Quote].Add(income)
+		p.cost[o.Base] = p.cost[o.Base].Sub(basis)
+		o.Profit = income.Sub(basis)
+		p.realized[o.Quote] = p.realized[o.Quote].Add(o.Profit)
+	}
+	o.Frozen = decimal.Zero
+	o.Status = paperStatusFilled
+	o.FilledPrice = price
+	o.FilledAmount = o.Amount
+}
+
+// filled persist and notify the filled order
+func (p *PaperExchange) filled(ctx context.Context, o *paperOrder) {
+	if err := p.save(ctx, o); err != nil {
+		p.Sugar.Error(err)
+	}
+	total := o.FilledPrice.Mul(o.FilledAmount)
+	p.Sugar.Infof("paper order %d / %s filled, direction: %s, price: %s, amount: %s, profit: %s",
+		o.Id, o.ClientOrderId, o.Direction, o.FilledPrice, o.FilledAmount, o.Profit)
+	if p.broadcast != nil {
+		if o.buy() {
+			p.broadcast("[模拟] 买入成交，订单号: %d / %s, 价格: %s, 数量: %s, 金额: %s",
+				o.Id, o.ClientOrderId, o.FilledPrice, o.FilledAmount, total)
+		} else {
+			p.broadcast("[模拟] 卖出成交，订单号: %d / %s, 价格: %s, 数量: %s, 金额: %s, 盈亏: %s, 累计盈亏: %s",
+				o.Id, o.ClientOrderId, o.FilledPrice, o.FilledAmount, total, o.Profit, p.Realized()[o.Quote])
+		}
+	}
+	p.notify(PaperEvent{Type: PaperEventTrade, Direction: o.Direction, Order: o.Order, Profit: o.Profit})
+}
+
+// notify the handler asynchronously, like the order push of exchange
+func (p *PaperExchange) notify(e PaperEvent) {
+	if p.handler != nil {
+		go p.handler(e)
+	}
+}
+
+func (p *PaperExchange) getSymbol(symbol string) (exchange.Symbol, error) {
+	p.lock.Lock()
+	s, ok := p.symbols[symbol]
+	p.lock.Unlock()
+	if ok {
+		return s, nil
+	}
+	s, err := p.RestAPIExchange.GetSymbol(context.Background(), symbol)
+	if err != nil {
+		return s, err
+	}
+	p.lock.Lock()
+	p.symbols[symbol] = s
+	p.lock.Unlock()
+	return s, nil
+}
+
+// quote return the best bid and ask, use the last price if the real exchange has no ticker
+func (p *PaperExchange) quote(symbol string) (bid, ask decimal.Decimal, err error) {
+	if t, ok := p.RestAPIExchange.(tickerExchange); ok {
+		ticker, err := t.Ticker(symbol)
+		if err == nil && ticker.HighestBid.IsPositive() && ticker.LowestAsk.IsPositive() {
+			return ticker.HighestBid, ticker.LowestAsk, nil
+		}
+	}
+	price, err := p.RestAPIExchange.LastPrice(symbol)
+	return price, price, err
+}
+
+// Ticker return the best bid and ask, so the execution algorithms work in paper trading
+func (p *PaperExchange) Ticker(symbol string) (*exchange.Ticker, error) {
+	bid, ask, err := p.quote(symbol)
+	if err != nil {
+		return nil, err
+	}
+	return &exchange.Ticker{HighestBid: bid, LowestAsk: ask}, nil
+}
+
+func (p *PaperExchange) SpotBalance() (map[string]decimal.Decimal, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	balance := make(map[string]decimal.Decimal)
+	for k, v := range p.balance {
+		balance[k] = v
+	}
+	return balance, nil
+}
+
+func (p *PaperExchange) SpotAvailableBalance() (map[string]decimal.Decimal, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	balance := make(map[string]decimal.Decimal)
+	for k, v := range p.balance {
+		balance[k] = v.Sub(p.locked[k])
+	}
+	return balance, nil
+}
+
+// Realized return the realized PnL by quote currency
+func (p *PaperExchange) Realized() map[string]decimal.Decimal {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	realized := make(map[string]decimal.Decimal)
+	for k, v := range p.realized {
+		realized[k] = v
+	}
+	return realized
+}
+
+// Summary return the balance and realized PnL of paper account
+func (p *PaperExchange) Summary() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var currencies []string
+	for k := range p.balance {
+		currencies = append(currencies, k)
+	}
+	sort.Strings(currencies)
+	var b strings.Builder
+	b.WriteString("Paper account\n")
+	for _, c := range currencies {
+		b.WriteString(fmt.Sprintf("\t%s: %s (locked %s)\n", c, p.balance[c], p.locked[c]))
+	}
+	for c, pnl := range p.realized {
+		b.WriteString(fmt.Sprintf("\tRealized PnL (%s): %s\n", c, pnl))
+	}
+	b.WriteString(fmt.Sprintf("\tOpen orders: %d", len(p.orders)))
+	return b.String()
+}
+
+func (p *PaperExchange) BuyLimit(symbol, clientOrderId string, price, amount decimal.Decimal) (uint64, error) {
+	return p.placeLimit(symbol, clientOrderId, exchange.TradeDirectionBuy, price, amount)
+}
+
+func (p *PaperExchange) SellLimit(symbol, clientOrderId string, price, amount decimal.Decimal) (uint64, error) {
+	return p.placeLimit(symbol, clientOrderId, exchange.TradeDirectionSell, price, amount)
+}
+
+func (p *PaperExchange) BuyMarket(symbol exchange.Symbol, clientOrderId string, total decimal.Decimal) (uint64, error) {
+	_, ask, err := p.quote(symbol.Symbol)
+	if err != nil {
+		return 0, err
+	}
+	price := ask.Mul(decimal.NewFromInt(1).Add(p.slippage))
+	amount := total.Div(price).Truncate(symbol.AmountPrecision)
+	return p.placeMarket(symbol, clientOrderId, exchange.TradeDirectionBuy, price, amount)
+}
+
+func (p *PaperExchange) SellMarket(symbol exchange.Symbol, clientOrderId string, amount decimal.Decimal) (uint64, error) {
+	bid, _, err := p.quote(symbol.Symbol)
+	if err != nil {
+		return 0, err
+	}
+	price := bid.Mul(decimal.NewFromInt(1).Sub(p.slippage))
+	return p.placeMarket(symbol, clientOrderId, exchange.TradeDirectionSell, price, amount)
+}
+
+func (p *PaperExchange) BuyStopLimit(symbol, clientOrderId string, price, amount, stopPrice decimal.Decimal) (uint64, error) {
+	return 0, errors.New("stop-limit order is not supported in paper trading, use the conditional order")
+}
+
+func (p *PaperExchange) SellStopLimit(symbol, clientOrderId string, price, amount, stopPrice decimal.Decimal) (uint64, error) {
+	return 0, errors.New("stop-limit order is not supported in paper trading, use the conditional order")
+}
+
+func (p *PaperExchange) newOrder(symbol exchange.Symbol, clientOrderId, direction, typ string, price, amount decimal.Decimal) *paperOrder {
+	p.nextId++
+	return &paperOrder{
+		Order: exchange.Order{
+			Id:            p.nextId,
+			ClientOrderId: clientOrderId,
+			Type:          typ,
+			Symbol:        symbol.Symbol,
+			Price:         price,
+			Amount:        amount,
+			Time:          p.now(),
+			Status:        paperStatusSubmitted,
+		},
+		Direction: direction,
+		Base:      symbol.BaseCurrency,
+		Quote:     symbol.QuoteCurrency,
+	}
+}
+
+// available return the available balance, the lock must be held
+func (p *PaperExchange) available(currency string) decimal.Decimal {
+	return p.balance[currency].Sub(p.locked[currency])
+}
+
+func (p *PaperExchange) placeLimit(symbol, clientOrderId, direction string, price, amount decimal.Decimal) (uint64, error) {
+	if !price.IsPositive() || !amount.IsPositive() {
+		return 0, fmt.Errorf("bad paper order, price: %s, amount: %s", price, amount)
+	}
+	s, err := p.getSymbol(symbol)
+	if err != nil {
+		return 0, err
+	}
+	p.lock.Lock()
+	o := p.newOrder(s, clientOrderId, direction, paperTypeLimit, price, amount)
+	currency := o.Base
+	o.Frozen = amount
+	if o.buy() {
+		currency = o.Quote
+		o.Frozen = price.Mul(amount)
+	}
+	if p.available(currency).LessThan(o.Frozen) {
+		p.nextId--
+		p.lock.Unlock()
+		return 0, ErrPaperInsufficientBalance
+	}
+	p.locked[currency] = p.locked[currency].Add(o.Frozen)
+	p.orders[o.Id] = o
+	p.lock.Unlock()
+
+	if err := p.save(context.Background(), o); err != nil {
+		p.Sugar.Error(err)
+	}
+	p.Sugar.Infof("place paper %s-limit order %d / %s, price: %s, amount: %s", direction, o.Id, clientOrderId, price, amount)
+	// marketable limit order is filled at once
+	if bid, ask, err := p.quote(symbol); err == nil {
+		p.OnPrice(context.Background(), symbol, bid, ask)
+	}
+	return o.Id, nil
+}
+
+func (p *PaperExchange) placeMarket(symbol exchange.Symbol, clientOrderId, direction string, price, amount decimal.Decimal) (uint64, error) {
+	if !price.IsPositive() || !amount.IsPositive() {
+		return 0, fmt.Errorf("bad paper order, price: %s, amount: %s", price, amount)
+	}
+	p.lock.Lock()
+	o := p.newOrder(symbol, clientOrderId, direction, paperTypeMarket, price, amount)
+	if (o.buy() && p.available(o.Quote).LessThan(price.Mul(amount))) || (!o.buy() && p.available(o.Base).LessThan(amount)) {
+		p.nextId--
+		p.lock.Unlock()
+		return 0, ErrPaperInsufficientBalance
+	}
+	p.fill(o, price)
+	p.lock.Unlock()
+
+	p.filled(context.Background(), o)
+	return o.Id, nil
+}
+
+func (p *PaperExchange) GetOrderById(orderId uint64, symbol string) (exchange.Order, error) {
+	p.lock.Lock()
+	o, ok := p.orders[orderId]
+	p.lock.Unlock()
+	if ok {
+		return o.Order, nil
+	}
+	var r paperOrderRecord
+	if err := p.orderColl.FindOne(context.Background(), bson.D{{"_id", orderId}}).Decode(&r); err != nil {
+		return exchange.Order{}, err
+	}
+	return r.order().Order, nil
+}
+
+func (p *PaperExchange) CancelOrder(symbol string, orderId uint64) error {
+	p.lock.Lock()
+	o, ok := p.orders[orderId]
+	if !ok {
+		p.lock.Unlock()
+		return fmt.Errorf("paper order %d is not open", orderId)
+	}
+	currency := o.Base
+	if o.buy() {
+		currency = o.Quote
+	}
+	p.locked[currency] = p.locked[currency].Sub(o.Frozen)
+	o.Frozen = decimal.Zero
+	o.Status = paperStatusCanceled
+	delete(p.orders, orderId)
+	p.lock.Unlock()
+
+	if err := p.save(context.Background(), o); err != nil {
+		p.Sugar.Error(err)
+	}
+	p.Sugar.Infof("paper order %d / %s cancelled", o.Id, o.ClientOrderId)
+	p.notify(PaperEvent{Type: PaperEventCancel, Direction: o.Direction, Order: o.Order})
+	return nil
+}
+
+func (p *PaperExchange) IsFullFilled(symbol string, orderId uint64) (exchange.Order, bool, error) {
+	o, err := p.GetOrderById(orderId, symbol)
+	if err != nil {
+		return o, false, err
+	}
+	return o, o.Status == paperStatusFilled, nil
+}
+
+// PaperWsExchange is the paper exchange with live websocket market data,
+// the order subscription is replaced by PaperHandler.
+type PaperWsExchange struct {
+	*PaperExchange
+	exchange.WsAPIExchange
+}
+
+func (p PaperWsExchange) SubscribeOrder(symbol, clientId string, responseHandler exchange.ResponseHandler) {
+}
+
+func (p PaperWsExchange) UnsubscribeOrder(symbol, clientId string) {
+}
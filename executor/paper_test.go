@@ -0,0 +1,51 @@
+package executor
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"testing"
+)
+
+func TestPaperExchange_fill(t *testing.T) {
+	p := NewPaperExchange(newFakeExchange("100", "0"), PaperConf{Fee: 0.001})
+	p.balance["USDT"] = decimal.NewFromInt(1000)
+
+	symbol := exchange.Symbol{Symbol: "btc_usdt", BaseCurrency: "BTC", QuoteCurrency: "USDT"}
+	buy := p.newOrder(symbol, "b", exchange.TradeDirectionBuy, paperTypeLimit, decimal.NewFromInt(100), decimal.NewFromInt(2))
+	buy.Frozen = decimal.NewFromInt(200)
+	p.locked["USDT"] = buy.Frozen
+	p.fill(buy, decimal.NewFromInt(90))
+
+	balance, _ := p.SpotAvailableBalance()
+	if !balance["USDT"].Equal(decimal.NewFromInt(820)) {
+		t.Errorf("want USDT 820, got %s", balance["USDT"])
+	}
+	if !balance["BTC"].Equal(decimal.RequireFromString("1.998")) {
+		t.Errorf("want BTC 1.998, got %s", balance["BTC"])
+	}
+
+	sell := p.newOrder(symbol, "s", exchange.TradeDirectionSell, paperTypeMarket, decimal.NewFromInt(110), decimal.RequireFromString("0.999"))
+	p.fill(sell, decimal.NewFromInt(110))
+	// income 109.89 * 0.999, cost basis is half of 180
+	wantProfit := decimal.RequireFromString("109.78011").Sub(decimal.NewFromInt(90))
+	if !sell.Profit.Equal(wantProfit) {
+		t.Errorf("want profit %s, got %s", wantProfit, sell.Profit)
+	}
+	if !p.Realized()["USDT"].Equal(wantProfit) {
+		t.Errorf("want realized %s, got %s", wantProfit, p.Realized()["USDT"])
+	}
+	if sell.Status != paperStatusFilled || !sell.FilledAmount.Equal(sell.Amount) {
+		t.Errorf("want filled, got %s %s", sell.Status, sell.FilledAmount)
+	}
+}
+
+func TestPaperExchange_quote(t *testing.T) {
+	p := NewPaperExchange(newFakeExchange("100", "0"), PaperConf{})
+	bid, ask, err := p.quote("btc_usdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bid.Equal(decimal.NewFromInt(100)) || !ask.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("want last price as bid and ask, got %s %s", bid, ask)
+	}
+}
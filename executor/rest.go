@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/shopspring/decimal"
 	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
 	"time"
 )
 
@@ -21,6 +22,9 @@ func (e *RestExecutor) Load(ctx context.Context) error {
 	if err := e.BaseExecutor.Load(ctx); err != nil {
 		return err
 	}
+	if err := e.LoadState(ctx); err != nil {
+		return err
+	}
 	if err := e.LoadBuyOrderId(ctx); err != nil {
 		return err
 	}
@@ -38,7 +42,8 @@ func (e *RestExecutor) Start(ctx context.Context) error {
 			return ctx.Err()
 		case signal := <-e.Receiver:
 			e.Sugar.Debugf("got signal: %v", signal)
-			go e.Process(signal)
+			// process in order, the state is changed by the previous signal
+			e.Process(signal)
 		case <-time.After(time.Second * 20):
 			e.check()
 		}
@@ -51,7 +56,7 @@ func (e *RestExecutor) Process(signal Signal) {
 	case -1: // sell
 		e.short()
 	case 1: // buy
-		e.long()
+		e.long(signal.Atr)
 	}
 }
 
@@ -61,7 +66,16 @@ func (e *RestExecutor) check() {
 
 // 如果订单存在，则检查是否成交，如成交，则改变状态
 func (e *RestExecutor) checkOrder() {
-
+	if e.GetBuyOrderId() != 0 {
+		if err := e.CheckAllBuy(); err == nil && e.GetBuyOrderId() == 0 && e.state == Buying {
+			e.SetState(Open)
+		}
+	}
+	if e.GetSellOrderId() != 0 {
+		if err := e.CheckAllSell(); err == nil && e.GetSellOrderId() == 0 && e.state == Selling {
+			e.SetState(Empty)
+		}
+	}
 }
 
 // short try to sell
@@ -69,40 +83,61 @@ func (e *RestExecutor) checkOrder() {
 // 2. place order
 // 3. change state if necessary
 func (e *RestExecutor) short() {
+	// the previous order may be filled
+	e.checkOrder()
 	switch e.state {
 	case Empty:
 		// do nothing
 	case Selling:
 		// do nothing
-	case Open:
-		// sell all coins
 	case Buying:
 		// cancel buy order
+		if err := e.CancelAllBuy(); err != nil {
+			return
+		}
+		fallthrough
+	case Open:
 		// sell all coins
-		e.sellAllMarket()
+		if err := e.SellAllMarket(); err != nil {
+			e.Sugar.Errorf("sell error: %s", err)
+		}
+		// the market job is done, the state follows the position left
+		if err := e.ResetState(); err != nil {
+			e.Sugar.Errorf("reset state error: %s", err)
+		}
 	}
+	e.checkOrder()
 }
 
 // long try to buy
 // 1. check state
 // 2. place order
 // 3. change state if necessary
-func (e *RestExecutor) long() {
+func (e *RestExecutor) long(atr decimal.Decimal) {
+	// the previous order may be filled
+	e.checkOrder()
 	switch e.state {
 	case Open:
 		// do nothing
 	case Buying:
 		// do nothing
-	case Empty:
-		// buy use all of money
-		e.buyAllMarket()
-		e.state = Buying
 	case Selling:
 		// cancel sell order
-		// buy all
-		e.buyAllMarket()
-		e.state = Buying
+		if err := e.CancelAllSell(); err != nil {
+			return
+		}
+		fallthrough
+	case Empty:
+		// buy use all of money, or up to the target of the sizing policy
+		if err := e.BuyTarget(atr); err != nil {
+			e.Sugar.Errorf("buy error: %s", err)
+		}
+		// the market job is done, it's open if the coins is held, even if nothing is bought
+		if err := e.ResetState(); err != nil {
+			e.Sugar.Errorf("reset state error: %s", err)
+		}
 	}
+	e.checkOrder()
 }
 
 func (e *RestExecutor) BuyAllLimit(price float64) error {
@@ -126,23 +161,35 @@ func (e *RestExecutor) SellAllLimit(price float64) error {
 	return nil
 }
 
+// BuyAllMarket buy all by the execution algorithm, it returns when the job is done
 func (e *RestExecutor) BuyAllMarket() error {
-	orderId, err := e.buyAllMarket()
-	if err != nil {
-		//e.Sugar.Errorf("buy error: %s", err)
-		return err
-	}
-	e.SetBuyOrderId(orderId)
-	return nil
+	_, err := e.buyAllMarket()
+	return err
 }
 
+// SellAllMarket sell all by the execution algorithm, it returns when the job is done
 func (e *RestExecutor) SellAllMarket() error {
-	orderId, err := e.sellAllMarket()
+	_, err := e.sellAllMarket()
+	return err
+}
+
+// TargetPosition buy or sell at market price, to make the position (base currency) equal to target.
+// strategies can scale in and out by target, instead of all in / all out.
+func (e *RestExecutor) TargetPosition(target decimal.Decimal) error {
+	return e.targetPosition(target)
+}
+
+// BuyTarget buy up to the target position of the sizing policy, or buy all at market price if no policy is set.
+// It never sells from a buy signal. atr is only needed by the atr policy.
+func (e *RestExecutor) BuyTarget(atr decimal.Decimal) error {
+	if e.sizer == nil {
+		return e.BuyAllMarket()
+	}
+	target, err := e.SizeTarget(atr)
 	if err != nil {
 		return err
 	}
-	e.SetSellOrderId(orderId)
-	return nil
+	return e.buyTarget(target)
 }
 
 func (e *RestExecutor) CancelAll() error {
@@ -164,6 +211,13 @@ func (e *RestExecutor) CancelAllBuy() error {
 		} else {
 			e.Sugar.Debugf("cancelled order %d", orderId)
 			e.SetBuyOrderId(0)
+			if o, err := e.ex.GetOrderById(orderId, e.Symbol()); err == nil {
+				e.account.Settle(o)
+				// refund the quota of limit order, market order is always filled
+				if remain := o.Amount.Sub(o.FilledAmount); e.id.Is(o.ClientOrderId, prefixBuyLimitOrder) && remain.IsPositive() {
+					e.quota.Add(o.Price.Mul(remain))
+				}
+			}
 			return nil
 		}
 	}
@@ -206,8 +260,8 @@ func (e *RestExecutor) CheckAllBuy() error {
 		if fullFilled {
 			e.Sugar.Debugf("buy order %d is full-filled", orderId)
 			e.SetBuyOrderId(0)
-			e.Broadcast("买入成交，订单号: %d / %s, 价格: %s, 数量: %s, 买入总金额: %s",
-				orderId, o.ClientOrderId, o.Price, o.Amount, o.Amount.Mul(o.Price))
+			e.account.Settle(o)
+			e.broadcastFilled("买入", o)
 			return nil
 		}
 	}
@@ -226,25 +280,64 @@ func (e *RestExecutor) CheckAllSell() error {
 		if fullFilled {
 			e.Sugar.Debugf("sell order %d is full-filled", orderId)
 			e.SetSellOrderId(0)
-			e.Broadcast("卖出成交，订单号: %d / %s, 价格: %s, 数量: %s, 买入总金额: %s",
-				orderId, o.ClientOrderId, o.Price, o.Amount, o.Amount.Mul(o.Price))
+			total := o.FilledPrice.Mul(o.FilledAmount)
+			e.quota.Add(total)
+			e.account.Credit(total)
+			e.broadcastFilled("卖出", o)
 			return nil
 		}
 	}
 	return nil
 }
 
+func (e *RestExecutor) broadcastFilled(action string, o exchange.Order) {
+	e.Broadcast("%s成交，订单号: %d / %s\n\t下单价格: %s, 下单数量: %s\n\t成交价格: %s, 成交数量: %s\n\t下单总金额: %s, 成交总金额: %s",
+		action, o.Id, o.ClientOrderId,
+		o.Price, o.Amount,
+		o.FilledPrice, o.FilledAmount,
+		o.Price.Mul(o.Amount), o.FilledPrice.Mul(o.FilledAmount),
+	)
+}
+
+func (e *RestExecutor) State() State {
+	return e.state
+}
+
+func (e *RestExecutor) SetState(state State) {
+	e.state = state
+	_ = hs.SaveKey(context.Background(), e.coll(collNameState), "state", e.state)
+}
+
+// ResetState set the state by the balance when there is no pending order,
+// it's Open if the base currency held is not less than the min amount, otherwise Empty.
+func (e *RestExecutor) ResetState() error {
+	balance, err := e.ex.SpotAvailableBalance()
+	if err != nil {
+		return err
+	}
+	if balance[e.BaseCurrency()].LessThan(e.MinAmount()) {
+		e.SetState(Empty)
+	} else {
+		e.SetState(Open)
+	}
+	return nil
+}
+
+func (e *RestExecutor) LoadState(ctx context.Context) error {
+	return hs.LoadKey(ctx, e.coll(collNameState), "state", &e.state)
+}
+
 func (e *RestExecutor) GetBuyOrderId() uint64 {
 	return e.buyOrderId
 }
 
 func (e *RestExecutor) SetBuyOrderId(newId uint64) {
 	e.buyOrderId = newId
-	_ = hs.SaveKey(context.Background(), e.db.Collection(collNameState), "buyOrderId", e.buyOrderId)
+	_ = hs.SaveKey(context.Background(), e.coll(collNameState), "buyOrderId", e.buyOrderId)
 }
 
 func (e *RestExecutor) LoadBuyOrderId(ctx context.Context) error {
-	return hs.LoadKey(ctx, e.db.Collection(collNameState), "buyOrderId", &e.buyOrderId)
+	return hs.LoadKey(ctx, e.coll(collNameState), "buyOrderId", &e.buyOrderId)
 }
 
 func (e *RestExecutor) GetSellOrderId() uint64 {
@@ -253,9 +346,9 @@ func (e *RestExecutor) GetSellOrderId() uint64 {
 
 func (e *RestExecutor) SetSellOrderId(newId uint64) {
 	e.sellOrderId = newId
-	_ = hs.SaveKey(context.Background(), e.db.Collection(collNameState), "sellOrderId", e.sellOrderId)
+	_ = hs.SaveKey(context.Background(), e.coll(collNameState), "sellOrderId", e.sellOrderId)
 }
 
 func (e *RestExecutor) LoadSellOrderId(ctx context.Context) error {
-	return hs.LoadKey(ctx, e.db.Collection(collNameState), "sellOrderId", &e.sellOrderId)
+	return hs.LoadKey(ctx, e.coll(collNameState), "sellOrderId", &e.sellOrderId)
 }
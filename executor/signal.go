@@ -6,4 +6,5 @@ type Signal struct {
 	Direction int // 1: buy, -1: sell
 	Price     decimal.Decimal
 	Amount    decimal.Decimal
-}
\ No newline at end of file
+	Atr       decimal.Decimal // latest ATR, only needed by the atr sizing policy
+}
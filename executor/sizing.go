@@ -0,0 +1,158 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	SizingAll      = "all"      // all in, capped by max total
+	SizingNotional = "notional" // fixed notional (quote currency)
+	SizingFraction = "fraction" // fixed fraction of equity
+	SizingAtr      = "atr"      // volatility-targeted, risk a fraction of equity per ATR move
+	SizingKelly    = "kelly"    // fractional Kelly
+)
+
+// SizingConf select and configure the position sizing policy, eg. {"policy": "atr", "risk": 0.01, "multiple": 2}
+type SizingConf struct {
+	Policy   string  // all(default), notional, fraction, atr, kelly
+	MaxTotal float64 `json:"maxTotal"` // cap of position value (quote currency), 0 means no cap
+
+	Notional float64 // notional only, position value
+	Fraction float64 // fraction only, fraction of equity, eg. 0.2
+	Risk     float64 // atr only, fraction of equity at risk, eg. 0.01
+	Multiple float64 // atr only, stop distance in ATR, default 1
+
+	WinRate     float64 `json:"winRate"`     // kelly only, probability of win
+	PayoffRatio float64 `json:"payoffRatio"` // kelly only, average win / average loss
+	Kelly       float64 // kelly only, fraction of full Kelly, eg. 0.5 means half Kelly
+}
+
+// SizingInput is the market and account status when sizing
+type SizingInput struct {
+	Equity decimal.Decimal // total equity in quote currency
+	Price  decimal.Decimal
+	Atr    decimal.Decimal // latest ATR, only needed by atr policy
+}
+
+// Sizer return the target position (base currency amount)
+type Sizer interface {
+	Size(in SizingInput) (decimal.Decimal, error)
+}
+
+func NewSizer(conf SizingConf) (Sizer, error) {
+	base := sizerBase{maxTotal: decimal.NewFromFloat(conf.MaxTotal)}
+	switch conf.Policy {
+	case "", SizingAll:
+		return &allSizer{base}, nil
+	case SizingNotional:
+		if conf.Notional <= 0 {
+			return nil, errors.New("notional must be positive")
+		}
+		return &fractionSizer{sizerBase: base, notional: decimal.NewFromFloat(conf.Notional)}, nil
+	case SizingFraction:
+		if conf.Fraction <= 0 || conf.Fraction > 1 {
+			return nil, errors.New("fraction must be in (0, 1]")
+		}
+		return &fractionSizer{sizerBase: base, fraction: decimal.NewFromFloat(conf.Fraction)}, nil
+	case SizingAtr:
+		if conf.Risk <= 0 {
+			return nil, errors.New("risk must be positive")
+		}
+		multiple := conf.Multiple
+		if multiple <= 0 {
+			multiple = 1
+		}
+		return &atrSizer{sizerBase: base, risk: decimal.NewFromFloat(conf.Risk), multiple: decimal.NewFromFloat(multiple)}, nil
+	case SizingKelly:
+		if conf.WinRate <= 0 || conf.WinRate >= 1 || conf.PayoffRatio <= 0 || conf.Kelly <= 0 {
+			return nil, errors.New("kelly need winRate in (0, 1), positive payoffRatio and kelly fraction")
+		}
+		f := KellyFraction(conf.WinRate, conf.PayoffRatio) * conf.Kelly
+		if f > 1 {
+			f = 1
+		}
+		// negative edge, never open position
+		if f < 0 {
+			f = 0
+		}
+		// drop the float64 error
+		return &fractionSizer{sizerBase: base, fraction: decimal.NewFromFloat(f).Round(8)}, nil
+	default:
+		return nil, fmt.Errorf("unknown sizing policy: %s", conf.Policy)
+	}
+}
+
+// KellyFraction is the full Kelly fraction, f = W - (1 - W) / R
+func KellyFraction(winRate, payoffRatio float64) float64 {
+	return winRate - (1-winRate)/payoffRatio
+}
+
+type sizerBase struct {
+	maxTotal decimal.Decimal
+}
+
+// amount convert position value to amount, and apply the max total cap
+func (s sizerBase) amount(total, price decimal.Decimal) (decimal.Decimal, error) {
+	if !price.IsPositive() {
+		return decimal.Zero, errors.New("price must be positive")
+	}
+	if s.maxTotal.IsPositive() && total.GreaterThan(s.maxTotal) {
+		total = s.maxTotal
+	}
+	if total.IsNegative() {
+		total = decimal.Zero
+	}
+	return total.Div(price), nil
+}
+
+type allSizer struct {
+	sizerBase
+}
+
+func (s *allSizer) Size(in SizingInput) (decimal.Decimal, error) {
+	return s.amount(in.Equity, in.Price)
+}
+
+// fractionSizer is used by notional, fraction and kelly
+type fractionSizer struct {
+	sizerBase
+	notional decimal.Decimal
+	fraction decimal.Decimal
+}
+
+func (s *fractionSizer) Size(in SizingInput) (decimal.Decimal, error) {
+	if s.notional.IsPositive() {
+		return s.amount(s.notional, in.Price)
+	}
+	return s.amount(in.Equity.Mul(s.fraction), in.Price)
+}
+
+type atrSizer struct {
+	sizerBase
+	risk     decimal.Decimal
+	multiple decimal.Decimal
+}
+
+func (s *atrSizer) Size(in SizingInput) (decimal.Decimal, error) {
+	if !in.Atr.IsPositive() {
+		return decimal.Zero, errors.New("atr must be positive")
+	}
+	amount := in.Equity.Mul(s.risk).Div(in.Atr.Mul(s.multiple))
+	return s.amount(amount.Mul(in.Price), in.Price)
+}
+
+// PlanTarget compare the held and target position, return the direction (1 buy, -1 sell, 0 nothing) and amount.
+// amount is truncated by the symbol precision, and too small order is ignored.
+func PlanTarget(held, target, price decimal.Decimal, precision int32, minAmount, minTotal decimal.Decimal) (direction int, amount decimal.Decimal) {
+	diff := target.Sub(held)
+	amount = diff.Abs().Truncate(precision)
+	if amount.LessThan(minAmount) || amount.Mul(price).LessThan(minTotal) || amount.IsZero() {
+		return 0, decimal.Zero
+	}
+	if diff.IsPositive() {
+		return 1, amount
+	}
+	return -1, amount
+}
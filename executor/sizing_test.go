@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestSizer_Size(t *testing.T) {
+	var tests = []struct {
+		conf   SizingConf
+		equity string
+		price  string
+		atr    string
+		amount string
+	}{
+		{SizingConf{}, "1000", "10", "0", "100"},
+		{SizingConf{MaxTotal: 500}, "1000", "10", "0", "50"},
+		{SizingConf{Policy: SizingNotional, Notional: 200}, "1000", "10", "0", "20"},
+		{SizingConf{Policy: SizingFraction, Fraction: 0.2}, "1000", "10", "0", "20"},
+		{SizingConf{Policy: SizingAtr, Risk: 0.01}, "10000", "10", "0.5", "200"},
+		{SizingConf{Policy: SizingAtr, Risk: 0.01, Multiple: 2}, "10000", "10", "0.5", "100"},
+		{SizingConf{Policy: SizingAtr, Risk: 0.01, MaxTotal: 1000}, "10000", "10", "0.5", "100"},
+		// full kelly = 0.6 - 0.4 / 2 = 0.4, half kelly = 0.2
+		{SizingConf{Policy: SizingKelly, WinRate: 0.6, PayoffRatio: 2, Kelly: 0.5}, "1000", "10", "0", "20"},
+		// negative edge
+		{SizingConf{Policy: SizingKelly, WinRate: 0.3, PayoffRatio: 1, Kelly: 1}, "1000", "10", "0", "0"},
+	}
+	for i, tt := range tests {
+		sizer, err := NewSizer(tt.conf)
+		if err != nil {
+			t.Errorf("[%d] new sizer error: %s", i, err)
+			continue
+		}
+		amount, err := sizer.Size(SizingInput{
+			Equity: decimal.RequireFromString(tt.equity),
+			Price:  decimal.RequireFromString(tt.price),
+			Atr:    decimal.RequireFromString(tt.atr),
+		})
+		if err != nil {
+			t.Errorf("[%d] size error: %s", i, err)
+			continue
+		}
+		if !amount.Equal(decimal.RequireFromString(tt.amount)) {
+			t.Errorf("[%d] want %s, got %s", i, tt.amount, amount)
+		}
+	}
+}
+
+func TestNewSizer_Error(t *testing.T) {
+	var confs = []SizingConf{
+		{Policy: "martingale"},
+		{Policy: SizingNotional},
+		{Policy: SizingFraction, Fraction: 1.5},
+		{Policy: SizingAtr},
+		{Policy: SizingKelly, WinRate: 1, PayoffRatio: 1, Kelly: 1},
+	}
+	for i, conf := range confs {
+		if _, err := NewSizer(conf); err == nil {
+			t.Errorf("[%d] want error, got nil", i)
+		}
+	}
+}
+
+func TestPlanTarget(t *testing.T) {
+	var tests = []struct {
+		held, target string
+		direction    int
+		amount       string
+	}{
+		{"0", "1.23456", 1, "1.2345"},
+		{"2", "0.5", -1, "1.5"},
+		{"1", "1.0001", 0, "0"}, // less than min amount
+		{"1", "1.05", 0, "0"},   // less than min total
+		{"1", "1", 0, "0"},
+	}
+	price := decimal.NewFromInt(10)
+	minAmount := decimal.RequireFromString("0.01")
+	minTotal := decimal.NewFromInt(1)
+	for i, tt := range tests {
+		direction, amount := PlanTarget(decimal.RequireFromString(tt.held), decimal.RequireFromString(tt.target),
+			price, 4, minAmount, minTotal)
+		if direction != tt.direction || !amount.Equal(decimal.RequireFromString(tt.amount)) {
+			t.Errorf("[%d] want %d %s, got %d %s", i, tt.direction, tt.amount, direction, amount)
+		}
+	}
+}
+
+func TestBaseExecutor_buyTarget(t *testing.T) {
+	var tests = []struct {
+		held   string
+		target string
+	}{
+		{"2", "1"}, // never sell from a buy signal
+		{"1", "1"}, // already at the target
+		{"0", "0"}, // nothing to hold
+	}
+	for i, tt := range tests {
+		ex := NewPaperExchange(newFakeExchange("100", "0"), PaperConf{})
+		symbol := testSymbol()
+		symbol.BaseCurrency, symbol.QuoteCurrency = "BTC", "USDT"
+		ex.balance["BTC"] = decimal.RequireFromString(tt.held)
+		e := BaseExecutor{ex: ex, symbol: symbol, Sugar: zap.NewNop().Sugar()}
+		if err := e.buyTarget(decimal.RequireFromString(tt.target)); err != nil {
+			t.Fatal(err)
+		}
+		if ex.nextId != 0 || !ex.balance["BTC"].Equal(decimal.RequireFromString(tt.held)) {
+			t.Errorf("[%d] want no order and held %s, got %d orders and held %s", i, tt.held, ex.nextId, ex.balance["BTC"])
+		}
+	}
+}
@@ -20,6 +20,10 @@ type ClientIdManager struct {
 	short  int64
 	unique int64
 	coll   *mongo.Collection
+	codec  *ClientIdCodec
+
+	strategy string // strategy instance name of the codec
+	symbol   string
 }
 
 func (m *ClientIdManager) Init(sep string, coll *mongo.Collection) {
@@ -27,6 +31,32 @@ func (m *ClientIdManager) Init(sep string, coll *mongo.Collection) {
 	m.coll = coll
 }
 
+// UseCodec generate client order id by codec of the strategy instance, instead of the legacy prefix-short-long-unique format
+func (m *ClientIdManager) UseCodec(exchangeName, strategy, symbol string) {
+	codec := NewClientIdCodec(exchangeName, strategy, symbol)
+	m.codec = &codec
+	m.strategy = strategy
+	m.symbol = symbol
+}
+
+// Belongs check if the order is placed by the strategy instance.
+// Legacy ids (placed before the codec is used) always belong to the executor.
+func (m *ClientIdManager) Belongs(clientOrderId string) bool {
+	if m.codec == nil {
+		return true
+	}
+	id, err := ParseClientId(clientOrderId)
+	if err != nil {
+		return true
+	}
+	return id.Belongs(m.strategy, m.symbol)
+}
+
+// Is check if the client order id is generated with the prefix, both legacy and codec format are supported
+func (m *ClientIdManager) Is(clientOrderId, prefix string) bool {
+	return IsPrefix(clientOrderId, prefix)
+}
+
 func (m *ClientIdManager) Load(ctx context.Context) (err error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -37,7 +67,7 @@ func (m *ClientIdManager) Load(ctx context.Context) (err error) {
 	if m.short, err = hs.LoadInt64(ctx, m.coll, "short"); err != nil {
 		return
 	}
-	if m.unique, err = hs.LoadInt64(ctx, m.coll, "unique"); err != nil {
+	if m.unique, err = hs.LoadInt64(ctx, m.coll, "uniqueId"); err != nil {
 		return
 	}
 	return
@@ -75,6 +105,9 @@ func (m *ClientIdManager) GetClientOrderId(ctx context.Context, prefix string) (
 	if err != nil {
 		return "", err
 	}
+	if m.codec != nil {
+		return m.codec.EncodePrefix(prefix, unique)
+	}
 	m.lock.RLock()
 	short := m.short
 	long := m.long
@@ -85,7 +118,7 @@ func (m *ClientIdManager) getUniqueId(ctx context.Context, ) (int64, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	m.unique = (m.unique + 1) % 10000
+	m.unique++
 	if err := hs.SaveInt64(ctx, m.coll, "uniqueId", m.unique); err != nil {
 		return 0, errors.New(fmt.Sprintf("save uniqueId error: %s", err))
 	}
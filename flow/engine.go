@@ -0,0 +1,166 @@
+package flow
+
+import (
+	"github.com/xyths/hs/exchange"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWindow = time.Minute
+	DefaultLarge  = 5
+)
+
+// Features is the order flow of the trades in the rolling window, up to the latest trade
+type Features struct {
+	Symbol string
+	Time   time.Time // time of the latest trade
+	Last   float64   // price of the latest trade
+
+	VWAP       float64
+	Volume     float64 // base amount
+	BuyVolume  float64 // amount of the buyer initiated (aggressor) trades
+	SellVolume float64 // amount of the seller initiated trades
+	Imbalance  float64 // (buy - sell) / (buy + sell), in [-1, 1]
+
+	Trades    int     // number of trades (after merging the same timestamp and price)
+	Intensity float64 // trades per second
+
+	LargeBuy  int  // large buy trades in the window
+	LargeSell int  // large sell trades in the window
+	Large     bool // the latest update has large trade
+
+	Volatility float64 // realized volatility, square root of the sum of squared log returns between trades
+}
+
+type tick struct {
+	timestamp int64 // ms
+	price     float64
+	amount    float64
+	buy       bool
+	large     bool
+}
+
+// Engine compute the features of one symbol, it's safe for concurrent use
+type Engine struct {
+	Symbol string
+	Window time.Duration
+	Large  float64 // a trade is large if its amount >= Large * mean amount in the window
+
+	lock  sync.Mutex
+	ticks []tick
+}
+
+func NewEngine(symbol string, window time.Duration, large float64) *Engine {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	if large <= 0 {
+		large = DefaultLarge
+	}
+	return &Engine{Symbol: symbol, Window: window, Large: large}
+}
+
+// Add the trades and return the features of the window
+func (e *Engine) Add(trades []exchange.TradeDetail) Features {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	large := false
+	for _, t := range trades {
+		price, _ := t.Price.Float64()
+		amount, _ := t.Amount.Float64()
+		if price <= 0 || amount <= 0 {
+			continue
+		}
+		buy := t.Direction == exchange.TradeDirectionBuy
+		l := len(e.ticks)
+		// huobi split one taker order to many trades with the same timestamp and price
+		if l > 0 && e.ticks[l-1].timestamp == t.Timestamp && e.ticks[l-1].price == price && e.ticks[l-1].buy == buy {
+			e.ticks[l-1].amount += amount
+			e.ticks[l-1].large = e.isLarge(e.ticks[l-1].amount, l-1)
+			large = large || e.ticks[l-1].large
+			continue
+		}
+		tk := tick{timestamp: t.Timestamp, price: price, amount: amount, buy: buy}
+		tk.large = e.isLarge(amount, l)
+		large = large || tk.large
+		e.ticks = append(e.ticks, tk)
+	}
+	e.evict()
+	f := e.features()
+	f.Large = large
+	return f
+}
+
+// isLarge compare the amount with the mean amount of the first n ticks
+func (e *Engine) isLarge(amount float64, n int) bool {
+	if n == 0 {
+		return false
+	}
+	sum := 0.0
+	for _, t := range e.ticks[:n] {
+		sum += t.amount
+	}
+	return amount >= e.Large*sum/float64(n)
+}
+
+// evict the ticks out of the window of the latest one
+func (e *Engine) evict() {
+	l := len(e.ticks)
+	if l == 0 {
+		return
+	}
+	from := e.ticks[l-1].timestamp - e.Window.Milliseconds()
+	i := 0
+	for i < l && e.ticks[i].timestamp <= from {
+		i++
+	}
+	if i > 0 {
+		e.ticks = append(e.ticks[:0], e.ticks[i:]...)
+	}
+}
+
+// Features return the current features without adding trades
+func (e *Engine) Features() Features {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.features()
+}
+
+func (e *Engine) features() Features {
+	f := Features{Symbol: e.Symbol}
+	l := len(e.ticks)
+	if l == 0 {
+		return f
+	}
+	last := e.ticks[l-1]
+	f.Time = time.Unix(0, last.timestamp*int64(time.Millisecond))
+	f.Last = last.price
+	f.Trades = l
+	var total, sumSq float64
+	for i, t := range e.ticks {
+		total += t.price * t.amount
+		f.Volume += t.amount
+		if t.buy {
+			f.BuyVolume += t.amount
+			if t.large {
+				f.LargeBuy++
+			}
+		} else {
+			f.SellVolume += t.amount
+			if t.large {
+				f.LargeSell++
+			}
+		}
+		if i > 0 {
+			r := math.Log(t.price / e.ticks[i-1].price)
+			sumSq += r * r
+		}
+	}
+	f.VWAP = total / f.Volume
+	f.Imbalance = (f.BuyVolume - f.SellVolume) / f.Volume
+	f.Intensity = float64(l) / e.Window.Seconds()
+	f.Volatility = math.Sqrt(sumSq)
+	return f
+}
@@ -0,0 +1,116 @@
+package flow
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func trade(ts int64, price, amount float64, direction string) exchange.TradeDetail {
+	return exchange.TradeDetail{
+		Timestamp: ts,
+		Price:     decimal.NewFromFloat(price),
+		Amount:    decimal.NewFromFloat(amount),
+		Direction: direction,
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestEngine(t *testing.T) {
+	e := NewEngine("btcusdt", 10*time.Second, 3)
+	f := e.Add([]exchange.TradeDetail{
+		trade(1000, 100, 1, exchange.TradeDirectionBuy),
+		trade(2000, 101, 1, exchange.TradeDirectionSell),
+		trade(3000, 102, 2, exchange.TradeDirectionBuy),
+	})
+	if f.Trades != 3 || !near(f.Volume, 4) || !near(f.BuyVolume, 3) || !near(f.SellVolume, 1) {
+		t.Errorf("wrong volume: %+v", f)
+	}
+	// (100 + 101 + 204) / 4
+	if !near(f.VWAP, 101.25) || !near(f.Imbalance, 0.5) || !near(f.Intensity, 0.3) {
+		t.Errorf("wrong vwap / imbalance / intensity: %+v", f)
+	}
+	vol := math.Sqrt(math.Pow(math.Log(101.0/100), 2) + math.Pow(math.Log(102.0/101), 2))
+	if !near(f.Volatility, vol) || f.Last != 102 {
+		t.Errorf("expect volatility %f, got %f", vol, f.Volatility)
+	}
+	if f.Large || f.LargeBuy != 0 {
+		t.Errorf("expect no large trade: %+v", f)
+	}
+
+	// the same timestamp and price is merged, 5 >= 3 * 4 / 3
+	f = e.Add([]exchange.TradeDetail{
+		trade(4000, 103, 2, exchange.TradeDirectionSell),
+		trade(4000, 103, 3, exchange.TradeDirectionSell),
+	})
+	if f.Trades != 4 || !f.Large || f.LargeSell != 1 {
+		t.Errorf("expect merged large sell trade: %+v", f)
+	}
+	f = e.Add([]exchange.TradeDetail{trade(5000, 103, 0.1, exchange.TradeDirectionBuy)})
+	if f.Large || f.LargeSell != 1 {
+		t.Errorf("large flag is only for the latest update: %+v", f)
+	}
+
+	// ticks before 12000 - 10000 are evicted
+	f = e.Add([]exchange.TradeDetail{trade(12000, 104, 1, exchange.TradeDirectionBuy)})
+	if f.Trades != 4 || f.Time != time.Unix(12, 0) {
+		t.Errorf("expect 4 trades in window, got %d, time %s", f.Trades, f.Time)
+	}
+	if e.Features().Trades != 4 {
+		t.Error("Features should not change the window")
+	}
+}
+
+func TestFeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "flow.csv")
+	feed, err := NewFeed(Conf{Window: "1m", Record: filename}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan Features, 2)
+	s := feed.Subscribe("test", 0, func(f Features) {
+		got <- f
+	})
+	feed.Update("btcusdt", []exchange.TradeDetail{trade(1000, 100, 1, exchange.TradeDirectionBuy)})
+	feed.Update("ethusdt", []exchange.TradeDetail{trade(1000, 10, 1, exchange.TradeDirectionSell)})
+	for _, symbol := range []string{"btcusdt", "ethusdt"} {
+		select {
+		case f := <-got:
+			if f.Symbol != symbol {
+				t.Errorf("expect %s, got %s", symbol, f.Symbol)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no features received")
+		}
+	}
+	if feed.Features("ethusdt").Imbalance != -1 {
+		t.Errorf("wrong imbalance of ethusdt")
+	}
+	feed.Unsubscribe(s)
+	<-s.Done()
+	if err := feed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "time,symbol") {
+		t.Errorf("expect header and 2 rows, got %q", data)
+	}
+}
@@ -0,0 +1,215 @@
+package flow
+
+import (
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/hub"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultQueueSize = 100
+
+// Conf config the order flow features
+type Conf struct {
+	Window string  // rolling window, default 1m
+	Large  float64 // a trade is large if its amount >= Large * mean amount in the window, default 5
+	Record string  // record the features to this csv file for offline study, empty means no record
+}
+
+// Feed compute the features of many symbols and fan out to the subscribers
+type Feed struct {
+	Sugar *zap.SugaredLogger
+
+	window time.Duration
+	large  float64
+
+	lock        sync.Mutex
+	engines     map[string]*Engine
+	subscribers map[*Subscriber]struct{}
+	recorder    Recorder
+	consumer    *hub.Consumer
+}
+
+func NewFeed(conf Conf, sugar *zap.SugaredLogger) (*Feed, error) {
+	window := DefaultWindow
+	if conf.Window != "" {
+		w, err := time.ParseDuration(conf.Window)
+		if err != nil {
+			return nil, err
+		}
+		window = w
+	}
+	if sugar == nil {
+		sugar = zap.NewNop().Sugar()
+	}
+	f := &Feed{
+		Sugar:       sugar,
+		window:      window,
+		large:       conf.Large,
+		engines:     make(map[string]*Engine),
+		subscribers: make(map[*Subscriber]struct{}),
+	}
+	if conf.Record != "" {
+		r, err := NewCSVRecorder(conf.Record)
+		if err != nil {
+			return nil, err
+		}
+		f.recorder = r
+	}
+	return f, nil
+}
+
+// Update add the trades of symbol, publish the features to all subscribers and the recorder
+func (f *Feed) Update(symbol string, trades []exchange.TradeDetail) Features {
+	features := f.engine(symbol).Add(trades)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.recorder != nil {
+		if err := f.recorder.Record(features); err != nil {
+			f.Sugar.Errorf("record features error: %s", err)
+		}
+	}
+	for s := range f.subscribers {
+		if !s.push(features) {
+			f.Sugar.Warnf("subscriber %s queue is full, drop features of %s", s.Name, symbol)
+		}
+	}
+	return features
+}
+
+// Features return the latest features of symbol
+func (f *Feed) Features(symbol string) Features {
+	return f.engine(symbol).Features()
+}
+
+func (f *Feed) engine(symbol string) *Engine {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	e, ok := f.engines[symbol]
+	if !ok {
+		e = NewEngine(symbol, f.window, f.large)
+		f.engines[symbol] = e
+	}
+	return e
+}
+
+// Attach subscribe the trades of symbols from the hub, so the feed shares the exchange subscription with other consumers
+func (f *Feed) Attach(h *hub.Hub, symbols []string) error {
+	c := h.Register("flow", hub.DefaultQueueSize, func(e hub.Event) {
+		if e.Type == hub.EventTrade {
+			f.Update(e.Symbol, e.Trades)
+		}
+	})
+	for _, symbol := range symbols {
+		if err := h.SubscribeTrade(c, symbol); err != nil {
+			h.Unregister(c)
+			return err
+		}
+	}
+	f.lock.Lock()
+	f.consumer = c
+	f.lock.Unlock()
+	return nil
+}
+
+// Detach unsubscribe from the hub
+func (f *Feed) Detach(h *hub.Hub) {
+	f.lock.Lock()
+	c := f.consumer
+	f.consumer = nil
+	f.lock.Unlock()
+	if c != nil {
+		h.Unregister(c)
+		<-c.Done()
+	}
+}
+
+// Subscribe the features of all symbols, handler is called in the subscriber's own goroutine.
+// Features are dropped when the queue (size) is full, so a slow subscriber never blocks the trade stream.
+func (f *Feed) Subscribe(name string, size int, handler func(Features)) *Subscriber {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	s := &Subscriber{
+		Name:    name,
+		queue:   make(chan Features, size),
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	f.lock.Lock()
+	f.subscribers[s] = struct{}{}
+	f.lock.Unlock()
+	return s
+}
+
+// Unsubscribe stop the subscriber after the queued features are handled
+func (f *Feed) Unsubscribe(s *Subscriber) {
+	f.lock.Lock()
+	delete(f.subscribers, s)
+	f.lock.Unlock()
+	s.close()
+}
+
+// Close stop all subscribers and the recorder
+func (f *Feed) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for s := range f.subscribers {
+		s.close()
+		delete(f.subscribers, s)
+	}
+	if f.recorder != nil {
+		err := f.recorder.Close()
+		f.recorder = nil
+		return err
+	}
+	return nil
+}
+
+// Subscriber receive the features by a bounded queue
+type Subscriber struct {
+	Name string
+
+	queue   chan Features
+	handler func(Features)
+	dropped uint64
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Dropped return the number of features dropped because the queue is full
+func (s *Subscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Done is closed when the subscriber is stopped
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscriber) run() {
+	defer close(s.done)
+	for f := range s.queue {
+		s.handler(f)
+	}
+}
+
+func (s *Subscriber) push(f Features) bool {
+	select {
+	case s.queue <- f:
+		return true
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		return false
+	}
+}
+
+func (s *Subscriber) close() {
+	s.closeOnce.Do(func() {
+		close(s.queue)
+	})
+}
@@ -0,0 +1,70 @@
+package flow
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Recorder save the features for offline study
+type Recorder interface {
+	Record(f Features) error
+	Close() error
+}
+
+var csvHeader = []string{
+	"time", "symbol", "last", "vwap", "volume", "buyVolume", "sellVolume", "imbalance",
+	"trades", "intensity", "largeBuy", "largeSell", "large", "volatility",
+}
+
+// CSVRecorder append the features to a csv file, the header is written if the file is empty
+type CSVRecorder struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func NewCSVRecorder(filename string) (*CSVRecorder, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r := &CSVRecorder{file: file, writer: csv.NewWriter(file)}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		if err := r.writer.Write(csvHeader); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *CSVRecorder) Record(f Features) error {
+	if err := r.writer.Write(featuresRow(f)); err != nil {
+		return err
+	}
+	r.writer.Flush()
+	return r.writer.Error()
+}
+
+func (r *CSVRecorder) Close() error {
+	r.writer.Flush()
+	return r.file.Close()
+}
+
+func featuresRow(f Features) []string {
+	ff := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return []string{
+		f.Time.Format(time.RFC3339Nano), f.Symbol, ff(f.Last), ff(f.VWAP),
+		ff(f.Volume), ff(f.BuyVolume), ff(f.SellVolume), ff(f.Imbalance),
+		strconv.Itoa(f.Trades), ff(f.Intensity), strconv.Itoa(f.LargeBuy), strconv.Itoa(f.LargeSell),
+		strconv.FormatBool(f.Large), ff(f.Volatility),
+	}
+}
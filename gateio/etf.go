@@ -0,0 +1,75 @@
+package gateio
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EtfTicker is the ticker of the leveraged token (ETF), only the API v4 returns the net value
+type EtfTicker struct {
+	Last         decimal.Decimal
+	LowestAsk    decimal.Decimal
+	HighestBid   decimal.Decimal
+	NetValue     decimal.Decimal // current NAV
+	PreNetValue  decimal.Decimal // NAV after the last rebalance
+	PreTimestamp time.Time       // time of the last rebalance
+	Leverage     decimal.Decimal // current real leverage
+}
+
+type responseEtfTicker struct {
+	CurrencyPair    string `json:"currency_pair"`
+	Last            string `json:"last"`
+	LowestAsk       string `json:"lowest_ask"`
+	HighestBid      string `json:"highest_bid"`
+	EtfNetValue     string `json:"etf_net_value"`
+	EtfPreNetValue  string `json:"etf_pre_net_value"`
+	EtfPreTimestamp int64  `json:"etf_pre_timestamp"`
+	EtfLeverage     string `json:"etf_leverage"`
+}
+
+// EtfTicker get the ticker with NAV and leverage of the leveraged token, eg. btc3l_usdt
+func (g *GateIO) EtfTicker(symbol string) (EtfTicker, error) {
+	url := fmt.Sprintf("%s/spot/tickers?currency_pair=%s", g.v4BaseUrl, strings.ToUpper(symbol))
+	resp, err := http.Get(url)
+	if err != nil {
+		return EtfTicker{}, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return EtfTicker{}, err
+	}
+	var result []responseEtfTicker
+	if err := json.Unmarshal(data, &result); err != nil {
+		return EtfTicker{}, fmt.Errorf("decode etf ticker error: %w, raw: %s", err, data)
+	}
+	if len(result) == 0 {
+		return EtfTicker{}, fmt.Errorf("no ticker of %s", symbol)
+	}
+	r := result[0]
+	if r.EtfNetValue == "" {
+		return EtfTicker{}, fmt.Errorf("%s is not a leveraged token", symbol)
+	}
+	return EtfTicker{
+		Last:         decimalOrZero(r.Last),
+		LowestAsk:    decimalOrZero(r.LowestAsk),
+		HighestBid:   decimalOrZero(r.HighestBid),
+		NetValue:     decimalOrZero(r.EtfNetValue),
+		PreNetValue:  decimalOrZero(r.EtfPreNetValue),
+		PreTimestamp: time.Unix(r.EtfPreTimestamp, 0),
+		Leverage:     decimalOrZero(r.EtfLeverage),
+	}, nil
+}
+
+func decimalOrZero(s string) decimal.Decimal {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Zero
+	}
+	return d
+}
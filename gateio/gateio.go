@@ -26,6 +26,7 @@ type GateIO struct {
 
 	publicBaseUrl  string
 	privateBaseUrl string
+	v4BaseUrl      string
 }
 
 func New(key, secret, host string) *GateIO {
@@ -35,6 +36,7 @@ func New(key, secret, host string) *GateIO {
 	}
 	g.publicBaseUrl = "https://data." + host + "/api2/1"
 	g.privateBaseUrl = "https://api." + host + "/api2/1"
+	g.v4BaseUrl = "https://api." + host + "/api/v4"
 	return g
 }
 
@@ -217,6 +219,31 @@ func (g *GateIO) AvailableBalance() (map[string]decimal.Decimal, error) {
 	return balance, nil
 }
 
+// TotalBalance is the available and locked (in open orders) balance
+func (g *GateIO) TotalBalance() (map[string]decimal.Decimal, error) {
+	url := "/private/balances"
+	param := ""
+	data, err := g.httpDo(POST, url, param)
+	if err != nil {
+		return nil, err
+	}
+	var result ResponseBalances
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	balance := make(map[string]decimal.Decimal)
+	for _, m := range []map[string]string{result.Available, result.Locked} {
+		for k, v := range m {
+			b, err := decimal.NewFromString(v)
+			if err != nil || b.IsZero() {
+				continue
+			}
+			balance[k] = balance[k].Add(b)
+		}
+	}
+	return balance, nil
+}
+
 //// get deposit address
 //func (g *GateIO) depositAddress(currency string) string {
 //	var method string = "POST"
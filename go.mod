@@ -3,7 +3,9 @@ module github.com/xyths/qtr
 go 1.13
 
 require (
+	github.com/antihax/optional v1.0.0
 	github.com/aws/aws-sdk-go v1.39.1 // indirect
+	github.com/gateio/gateapi-go/v5 v5.18.0
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/google/martian v2.1.0+incompatible
 	github.com/huobirdcenter/huobi_golang v0.0.0-20210226095227-8a30a95b6d0d
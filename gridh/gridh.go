@@ -3,12 +3,16 @@ package gridh
 import (
 	"context"
 	"fmt"
+	"github.com/markcheno/go-talib"
 	"github.com/shopspring/decimal"
 	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
 	. "github.com/xyths/hs/logger"
+	"github.com/xyths/qtr/executor"
 	"github.com/xyths/qtr/gateio"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"math"
 	"sync"
 	"time"
 )
@@ -18,7 +22,9 @@ type StrategyConf struct {
 	Total     float64 // total fund
 	Spread    float64 // 0.01 = 1%
 	Rebalance bool
-	Interval  string // sleep interval
+	Interval  string               // sleep interval
+	Spacing   executor.SpacingConf // spread mode and order size weight, natr replaces Spread when the grids are set up (and every Refresh)
+	Hedge     *HedgeConf           // keep the long/short value ratio, nil means no hedge
 }
 
 type Config struct {
@@ -42,13 +48,16 @@ type Trader struct {
 	minAmount       decimal.Decimal
 	minTotal        decimal.Decimal
 
-	scale      decimal.Decimal
-	longGrids  []hs.Grid
-	longBase   int
-	shortGrids []hs.Grid
-	shortBase  int
-	needSetup  bool
-	asset      Asset
+	scale       decimal.Decimal
+	longGrids   []hs.Grid
+	longBase    int
+	longSpread  float64 // spread of the grids, natr mode rebuilds the grids only when it changes enough
+	shortGrids  []hs.Grid
+	shortBase   int
+	shortSpread float64
+	needSetup   bool
+	asset       Asset
+	hedgeOrder  hedgeOrder // the buy leg of the last hedge, it's a limit order
 }
 
 func New(configFilename string) *Trader {
@@ -68,6 +77,14 @@ func (t *Trader) Init(ctx context.Context) {
 	}
 	t.db = db
 	t.scale = decimal.NewFromFloat(1 - t.config.Strategy.Spread)
+	if err := t.config.Strategy.Spacing.Validate(); err != nil {
+		Sugar.Fatal(err)
+	}
+	if t.config.Strategy.Hedge != nil {
+		if err := t.config.Strategy.Hedge.Validate(); err != nil {
+			Sugar.Fatal(err)
+		}
+	}
 	t.initEx(ctx)
 	t.initGrids(ctx)
 }
@@ -80,8 +97,20 @@ func (t *Trader) Close(ctx context.Context) {
 
 func (t *Trader) Trade(ctx context.Context) error {
 	if t.needSetup {
-		t.setupGrids(ctx)
+		if t.config.Strategy.Rebalance {
+			Sugar.Debug("sleep 10 seconds, wait for fund ready")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(10 * time.Second):
+			}
+		}
+		err := t.setupGrids()
+		// save the placed orders even if some failed
 		t.saveGrids(ctx)
+		if err != nil {
+			return err
+		}
 		t.needSetup = false
 	}
 	_ = t.Print(ctx)
@@ -89,14 +118,37 @@ func (t *Trader) Trade(ctx context.Context) error {
 	if err != nil {
 		Sugar.Fatalf("error interval format: %s", t.config.Strategy.Interval)
 	}
+	var hedgeC <-chan time.Time
+	if t.config.Strategy.Hedge != nil {
+		hedgeInterval, _ := time.ParseDuration(t.config.Strategy.Hedge.Interval)
+		hedgeTicker := time.NewTicker(hedgeInterval)
+		defer hedgeTicker.Stop()
+		hedgeC = hedgeTicker.C
+	}
+	// rebuild the natr grids periodically, nil channel never fires
+	var refresh <-chan time.Time
+	if t.config.Strategy.Spacing.Mode == executor.SpacingNatr && t.config.Strategy.Spacing.Refresh != "" {
+		d, _ := time.ParseDuration(t.config.Strategy.Spacing.Refresh)
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+	orderTicker := time.NewTicker(interval)
+	defer orderTicker.Stop()
 	t.checkOrders(ctx)
 	for {
 		select {
 		case <-ctx.Done():
 			Sugar.Info("context cancelled")
 			return nil
-		case <-time.After(interval):
+		case <-orderTicker.C:
 			t.checkOrders(ctx)
+		case <-hedgeC:
+			t.hedge(ctx)
+		case <-refresh:
+			if err := t.respace(ctx); err != nil {
+				Sugar.Errorf("respace grids error: %s", err)
+			}
 		}
 	}
 }
@@ -115,6 +167,9 @@ func (t *Trader) Print(ctx context.Context) error {
 	for _, g := range t.shortGrids {
 		Sugar.Infof("%2d\t%s\t%s\t%s\t%s\t%d", g.Id, g.TotalBuy, g.Price, g.AmountBuy, g.AmountSell, g.Order)
 	}
+	if t.config.Strategy.Hedge != nil {
+		t.printExposure()
+	}
 
 	return nil
 }
@@ -138,13 +193,13 @@ func (t *Trader) initGrids(ctx context.Context) {
 	}
 	if ticker, err := t.ex.Ticker(t.longSymbol); err == nil {
 		Sugar.Infof("%s last price: %f", t.longSymbol, ticker.Last)
-		t.longGrids, t.longBase = t.initOneGrids(ticker.Last)
+		t.longGrids, t.longBase, t.longSpread = t.initOneGrids(t.longSymbol, ticker.Last)
 	} else {
 		Sugar.Fatalf("error when get ticker: %s", err)
 	}
 	if ticker, err := t.ex.Ticker(t.shortSymbol); err == nil {
 		Sugar.Infof("%s last price: %f", t.shortSymbol, ticker.Last)
-		t.shortGrids, t.shortBase = t.initOneGrids(ticker.Last)
+		t.shortGrids, t.shortBase, t.shortSpread = t.initOneGrids(t.shortSymbol, ticker.Last)
 	} else {
 		Sugar.Fatalf("error when get ticker: %s", err)
 	}
@@ -154,33 +209,149 @@ func (t *Trader) initGrids(ctx context.Context) {
 	}
 }
 
-func (t *Trader) initOneGrids(price decimal.Decimal) (grids []hs.Grid, base int) {
-	total := decimal.NewFromFloat(t.config.Strategy.Total / 2).Div(decimal.NewFromInt(int64(t.config.Strategy.Number)))
-	for i := 0; i <= t.config.Strategy.Number; i++ {
-		p1 := t.scale.Pow(decimal.NewFromInt(int64(i - (t.config.Strategy.Number+1)/2))).Mul(price).Round(t.pricePrecision)
-		if p1.GreaterThan(price) {
+// initOneGrids build Number levels centered on price, spaced by Spread (or NATR)
+func (t *Trader) initOneGrids(symbol string, price decimal.Decimal) (grids []hs.Grid, base int, spread float64) {
+	spread = t.config.Strategy.Spread
+	if t.config.Strategy.Spacing.Mode == executor.SpacingNatr {
+		var err error
+		if spread, err = t.natrSpread(symbol); err != nil {
+			Sugar.Fatal(err)
+		}
+	}
+	grids, base, err := t.buildOneGrids(symbol, price, spread)
+	if err != nil {
+		Sugar.Fatal(err)
+	}
+	return
+}
+
+// buildOneGrids build Number levels centered on price, spaced by spread
+func (t *Trader) buildOneGrids(symbol string, price decimal.Decimal, spread float64) (grids []hs.Grid, base int, err error) {
+	spacing := t.config.Strategy.Spacing
+	p, _ := price.Float64()
+	high, low := centeredRange(spacing.Mode, p, spread, t.config.Strategy.Number)
+	prices, err := executor.GridPrices(spacing.Mode, high, low, t.config.Strategy.Number, t.pricePrecision)
+	if err != nil {
+		return
+	}
+	weights := executor.GridWeights(spacing.Weight, spacing.WeightRatio, t.config.Strategy.Number)
+	grids, err = executor.BuildGrids(prices, decimal.NewFromFloat(t.config.Strategy.Total/2), weights, exchange.Symbol{
+		Symbol:              symbol,
+		PricePrecision:      t.pricePrecision,
+		AmountPrecision:     t.amountPrecision,
+		LimitOrderMinAmount: t.minAmount,
+		MinTotal:            t.minTotal,
+	})
+	if err != nil {
+		return
+	}
+	for _, g := range grids {
+		if g.Price.GreaterThan(price) {
 			base++
 		}
-		amountBuy := total.DivRound(p1, t.amountPrecision)
-		if amountBuy.LessThan(t.minAmount) {
-			Sugar.Fatalf("amount %s is less than minAmount %s", amountBuy, t.minAmount)
+	}
+	return
+}
+
+// centeredRange is the high and low of number levels around price, level (number+1)/2 is the price
+func centeredRange(mode string, price, spread float64, number int) (high, low float64) {
+	k := float64((number + 1) / 2)
+	if mode == executor.SpacingArithmetic {
+		return price * (1 + spread*k), price * (1 - spread*(float64(number)-k))
+	}
+	scale := 1 - spread
+	return price * math.Pow(scale, -k), price * math.Pow(scale, float64(number)-k)
+}
+
+// natrSpread is the spread from the NATR of the last closed candle
+func (t *Trader) natrSpread(symbol string) (float64, error) {
+	spacing := t.config.Strategy.Spacing
+	interval, _ := time.ParseDuration(spacing.NatrInterval)
+	hours := int(interval*time.Duration(spacing.NatrPeriod*10)/time.Hour) + 1
+	candle, err := t.ex.GetCandle(symbol, int(interval/time.Second), hours)
+	if err != nil {
+		return 0, fmt.Errorf("get candle error: %w", err)
+	}
+	if candle.Length() <= spacing.NatrPeriod+1 {
+		return 0, fmt.Errorf("not enough candles for natr: %d", candle.Length())
+	}
+	natr := talib.Natr(candle.High, candle.Low, candle.Close, spacing.NatrPeriod)
+	scale, err := spacing.NatrSpacing(natr[len(natr)-2])
+	if err != nil {
+		return 0, err
+	}
+	Sugar.Infof("%s natr: %f, spread: %f", symbol, natr[len(natr)-2], 1-scale)
+	return 1 - scale, nil
+}
+
+// respace rebuild the grids with the latest NATR spread, centered on the last price.
+// One side is rebuilt only if its spread changed more than the threshold, its orders are cancelled and placed again,
+// the coins held are rebalanced to the new sell levels if Rebalance is set.
+func (t *Trader) respace(ctx context.Context) error {
+	for _, side := range []struct {
+		symbol string
+		prefix string
+		grids  *[]hs.Grid
+		base   *int
+		spread *float64
+	}{
+		{t.longSymbol, "l", &t.longGrids, &t.longBase, &t.longSpread},
+		{t.shortSymbol, "s", &t.shortGrids, &t.shortBase, &t.shortSpread},
+	} {
+		spread, err := t.natrSpread(side.symbol)
+		if err != nil {
+			return fmt.Errorf("get %s natr spread error: %w", side.symbol, err)
+		}
+		if !t.config.Strategy.Spacing.SpreadChanged(*side.spread, spread) {
+			Sugar.Infof("%s spread %f -> %f, keep the grids", side.symbol, *side.spread, spread)
+			continue
 		}
-		realTotal := p1.Mul(amountBuy)
-		if realTotal.LessThan(t.minTotal) {
-			Sugar.Fatalf("total %s is less than minTotal %s", realTotal, t.minTotal)
+		ticker, err := t.ex.Ticker(side.symbol)
+		if err != nil {
+			return fmt.Errorf("get %s ticker error: %w", side.symbol, err)
 		}
-		grid := hs.Grid{
-			Id:    i,
-			Price: p1,
+		grids, base, err := t.buildOneGrids(side.symbol, ticker.Last, spread)
+		if err != nil {
+			return fmt.Errorf("rebuild %s grids error: %w", side.symbol, err)
 		}
-		if i > 0 {
-			grids[i-1].AmountSell = amountBuy
-			grid.AmountBuy = amountBuy
-			grid.TotalBuy = realTotal
+		t.cancelGrids(side.symbol, *side.grids)
+		*side.grids, *side.base, *side.spread = grids, base, spread
+		if t.config.Strategy.Rebalance {
+			if err := t.rebalanceShortfall(ctx, side.symbol, grids, base); err != nil {
+				Sugar.Errorf("rebalance %s error: %s", side.symbol, err)
+			}
+		}
+		err = t.setupOneSide(side.symbol, side.prefix, grids, base)
+		// save the placed orders even if some failed
+		t.replaceGrids(ctx)
+		if err != nil {
+			return err
+		}
+		Sugar.Infof("%s grids respaced, spread: %f, base: %d", side.symbol, spread, base)
+	}
+	return nil
+}
+
+func (t *Trader) cancelGrids(symbol string, grids []hs.Grid) {
+	for _, g := range grids {
+		if g.Order == 0 {
+			continue
+		}
+		if _, err := t.ex.CancelOrder(symbol, g.Order); err != nil {
+			Sugar.Errorf("cancel %s order %d error: %s", symbol, g.Order, err)
 		}
-		grids = append(grids, grid)
 	}
-	return
+}
+
+// replaceGrids delete the old grids and bases, and save the new ones
+func (t *Trader) replaceGrids(ctx context.Context) {
+	for _, name := range []string{collNameLongGrid, collNameShortGrid, collNameBase} {
+		if _, err := t.db.Collection(name).DeleteMany(ctx, bson.D{}); err != nil {
+			Sugar.Errorf("delete %s error: %s", name, err)
+			return
+		}
+	}
+	t.saveGrids(ctx)
 }
 
 const (
@@ -196,12 +367,14 @@ func (t *Trader) saveGrids(ctx context.Context) {
 	if _, err := collBase.InsertOne(ctx, bson.D{
 		{"symbol", t.longSymbol},
 		{"base", t.longBase},
+		{"spread", t.longSpread},
 	}); err != nil {
 		Sugar.Fatalf("error when save long base: %s", err)
 	}
 	if _, err := collBase.InsertOne(ctx, bson.D{
 		{"symbol", t.shortSymbol},
 		{"base", t.shortBase},
+		{"spread", t.shortSpread},
 	}); err != nil {
 		Sugar.Fatalf("error when save short base: %s", err)
 	}
@@ -227,16 +400,19 @@ func (t *Trader) loadGrids(ctx context.Context) bool {
 	type Base struct {
 		Symbol string
 		Base   int
+		Spread float64
 	}
 	var longBase Base
 	if err := collBase.FindOne(ctx, bson.D{{"symbol", t.longSymbol}}).Decode(&longBase); err == mongo.ErrNoDocuments {
 		return false
 	} else {
 		t.longBase = longBase.Base
+		t.longSpread = longBase.Spread
 	}
 	var shortBase Base
 	_ = collBase.FindOne(ctx, bson.D{{"symbol", t.shortSymbol}}).Decode(&shortBase)
 	t.shortBase = shortBase.Base
+	t.shortSpread = shortBase.Spread
 
 	t.longGrids, _ = t.loadGridsOneSide(ctx, t.db.Collection(collNameLongGrid))
 	t.shortGrids, _ = t.loadGridsOneSide(ctx, t.db.Collection(collNameShortGrid))
@@ -284,6 +460,31 @@ func (t *Trader) rebalanceOneSide(ctx context.Context, symbol string, base int,
 	}
 	price := grids[base].Price
 	Sugar.Infow("rebalance, try to buy currency", "symbol", symbol, "price", price, "amount", amount, "total", price.Mul(amount))
+	t.buyAmount(ctx, symbol, amount)
+}
+
+// rebalanceShortfall buy the coins the sell levels above base need but not held, after the grids are rebuilt
+func (t *Trader) rebalanceShortfall(ctx context.Context, symbol string, grids []hs.Grid, base int) error {
+	available, err := t.ex.AvailableBalance()
+	if err != nil {
+		return err
+	}
+	need := decimal.Zero
+	for i := 0; i < base; i++ {
+		need = need.Add(grids[i].AmountSell)
+	}
+	held := available[baseCurrency(symbol)]
+	shortfall := need.Sub(held).Shift(t.amountPrecision).Ceil().Shift(-t.amountPrecision)
+	if shortfall.LessThan(t.minAmount) {
+		return nil
+	}
+	Sugar.Infow("rebalance, try to buy the shortfall", "symbol", symbol, "need", need, "held", held, "amount", shortfall)
+	t.buyAmount(ctx, symbol, shortfall)
+	return nil
+}
+
+// buyAmount buy amount at the last price, the unfilled orders are cancelled and placed again until all filled
+func (t *Trader) buyAmount(ctx context.Context, symbol string, amount decimal.Decimal) {
 	filledAmount := decimal.Zero
 	times := 0
 	for filledAmount.LessThan(amount) {
@@ -351,49 +552,45 @@ func (t *Trader) rebalanceOneSide(ctx context.Context, symbol string, base int,
 		"filledAmount", filledAmount)
 }
 
-func (t *Trader) setupGrids(ctx context.Context) {
-	Sugar.Debug("sleep 10 seconds, wait for fund ready")
-	time.Sleep(10 * time.Second)
-	for i := 0; i < t.longBase; i++ {
-		// sell long
-		clientOrderId := fmt.Sprintf("l-s-%d", i)
-		order, err := t.ex.Sell(t.longSymbol, t.longGrids[i].Price, t.longGrids[i].AmountSell, gateio.OrderTypeNormal, clientOrderId)
-		if err == nil {
-			t.longGrids[i].Order = order
-		} else {
-			Sugar.Fatalf("place long init sell order error: %s", err)
-		}
+// setupGrids place the orders of both grids
+func (t *Trader) setupGrids() error {
+	if err := t.setupOneSide(t.longSymbol, "l", t.longGrids, t.longBase); err != nil {
+		return err
 	}
-	for i := t.longBase + 1; i < len(t.longGrids); i++ {
-		// buy long
-		clientOrderId := fmt.Sprintf("l-b-%d", i)
-		order, err := t.ex.Buy(t.longSymbol, t.longGrids[i].Price, t.longGrids[i].AmountBuy, gateio.OrderTypeNormal, clientOrderId)
-		if err == nil {
-			t.longGrids[i].Order = order
-		} else {
-			Sugar.Fatalf("place long init buy order error: %s", err)
+	return t.setupOneSide(t.shortSymbol, "s", t.shortGrids, t.shortBase)
+}
+
+// setupOneSide place the sell orders above base and the buy orders below it.
+// The sells are placed from base up while the coins held are enough, the others are left without order.
+func (t *Trader) setupOneSide(symbol, prefix string, grids []hs.Grid, base int) error {
+	available, err := t.ex.AvailableBalance()
+	if err != nil {
+		return err
+	}
+	held := available[baseCurrency(symbol)]
+	for i := base - 1; i >= 0; i-- {
+		if held.LessThan(grids[i].AmountSell) {
+			Sugar.Warnf("%s held %s is not enough for the sell order at level %d (%s), the levels above have no order",
+				symbol, held, i, grids[i].AmountSell)
+			break
 		}
-	}
-	for i := 0; i < t.shortBase; i++ {
-		// sell short
-		clientOrderId := fmt.Sprintf("s-s-%d", i)
-		order, err := t.ex.Sell(t.shortSymbol, t.shortGrids[i].Price, t.shortGrids[i].AmountSell, gateio.OrderTypeNormal, clientOrderId)
-		if err == nil {
-			t.shortGrids[i].Order = order
-		} else {
-			Sugar.Fatalf("place short init sell order error: %s", err)
+		clientOrderId := fmt.Sprintf("%s-s-%d", prefix, i)
+		order, err := t.ex.Sell(symbol, grids[i].Price, grids[i].AmountSell, gateio.OrderTypeNormal, clientOrderId)
+		if err != nil {
+			return fmt.Errorf("place %s init sell order error: %w", symbol, err)
 		}
-	}
-	for i := t.shortBase + 1; i < len(t.shortGrids); i++ {
-		// buy short
-		clientOrderId := fmt.Sprintf("s-b-%d", i)
-		order, err := t.ex.Buy(t.shortSymbol, t.shortGrids[i].Price, t.shortGrids[i].AmountBuy, gateio.OrderTypeNormal, clientOrderId)
-		if err == nil {
-			t.shortGrids[i].Order = order
-		} else {
-			Sugar.Fatalf("place short init buy order error: %s", err)
+		grids[i].Order = order
+		held = held.Sub(grids[i].AmountSell)
+	}
+	for i := base + 1; i < len(grids); i++ {
+		clientOrderId := fmt.Sprintf("%s-b-%d", prefix, i)
+		order, err := t.ex.Buy(symbol, grids[i].Price, grids[i].AmountBuy, gateio.OrderTypeNormal, clientOrderId)
+		if err != nil {
+			return fmt.Errorf("place %s init buy order error: %w", symbol, err)
 		}
+		grids[i].Order = order
 	}
+	return nil
 }
 
 func (t *Trader) checkOrders(ctx context.Context) {
@@ -413,7 +610,8 @@ func (t *Trader) checkOrdersOneSide(ctx context.Context, symbol string, base int
 			"order", grids[top].Order)
 		if grids[top].Order != 0 {
 			if _, closed := t.ex.IsOrderClose(symbol, grids[top].Order); closed {
-				go t.up(ctx, symbol)
+				// move the grid in the trade loop, so it never runs with respace
+				t.up(ctx, symbol)
 				return
 			}
 		}
@@ -428,7 +626,7 @@ func (t *Trader) checkOrdersOneSide(ctx context.Context, symbol string, base int
 			"order", grids[bottom].Order)
 		if grids[bottom].Order != 0 {
 			if _, closed := t.ex.IsOrderClose(symbol, grids[bottom].Order); closed {
-				go t.down(ctx, symbol)
+				t.down(ctx, symbol)
 			}
 		}
 	}
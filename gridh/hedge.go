@@ -0,0 +1,273 @@
+package gridh
+
+import (
+	"context"
+	"fmt"
+	"github.com/shopspring/decimal"
+	. "github.com/xyths/hs/logger"
+	"github.com/xyths/qtr/gateio"
+	"strings"
+	"time"
+)
+
+// the leveraged tokens of gate rebalance daily in Beijing time
+var beijing = time.FixedZone("CST", 8*3600)
+
+// HedgeConf keep the value of the long token / the short token at Ratio, eg. {"ratio": 1, "threshold": 0.1}
+type HedgeConf struct {
+	Ratio         float64 // long value / short value, default 1
+	Threshold     float64 // rebalance when the ratio drifts more than Threshold, default 0.1 (10%)
+	Interval      string  // check interval, default 10m
+	Blackout      string  // no rebalance around the token's rebalance, default 10m
+	RebalanceTime string  `json:"rebalanceTime"` // the daily rebalance time of the token, Beijing time, default 00:00
+	MaxTrade      float64 `json:"maxTrade"`      // max value of one rebalance, 0 means no limit
+}
+
+func (c *HedgeConf) Validate() error {
+	if c.Ratio <= 0 {
+		c.Ratio = 1
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = 0.1
+	}
+	if c.Interval == "" {
+		c.Interval = "10m"
+	}
+	if c.Blackout == "" {
+		c.Blackout = "10m"
+	}
+	if c.RebalanceTime == "" {
+		c.RebalanceTime = "00:00"
+	}
+	if _, err := time.ParseDuration(c.Interval); err != nil {
+		return err
+	}
+	if _, err := time.ParseDuration(c.Blackout); err != nil {
+		return err
+	}
+	if _, err := time.Parse("15:04", c.RebalanceTime); err != nil {
+		return fmt.Errorf("bad rebalanceTime %s: %w", c.RebalanceTime, err)
+	}
+	return nil
+}
+
+// hedgeOrder is the pending buy order of the hedge, Id is 0 if none
+type hedgeOrder struct {
+	Symbol string
+	Id     uint64
+}
+
+// hedge check the long/short value ratio, and move value from the heavier token to the lighter one
+func (t *Trader) hedge(ctx context.Context) {
+	conf := t.config.Strategy.Hedge
+	if !t.checkHedgeOrder() {
+		return
+	}
+	longTicker, err := t.ex.EtfTicker(t.longSymbol)
+	if err != nil {
+		Sugar.Errorf("get %s etf ticker error: %s", t.longSymbol, err)
+		return
+	}
+	shortTicker, err := t.ex.EtfTicker(t.shortSymbol)
+	if err != nil {
+		Sugar.Errorf("get %s etf ticker error: %s", t.shortSymbol, err)
+		return
+	}
+	balance, err := t.ex.TotalBalance()
+	if err != nil {
+		Sugar.Errorf("get balance error: %s", err)
+		return
+	}
+	t.asset.Quote = balance[t.quoteCurrency]
+	t.asset.Long = balance[baseCurrency(t.longSymbol)]
+	t.asset.Short = balance[baseCurrency(t.shortSymbol)]
+	longValue := t.asset.Long.Mul(longTicker.Last)
+	shortValue := t.asset.Short.Mul(shortTicker.Last)
+	delta := exposure(longValue, longTicker.Leverage, shortValue, shortTicker.Leverage)
+	Sugar.Infow("hedge",
+		"longValue", longValue.Round(2),
+		"longNav", longTicker.NetValue,
+		"longLeverage", longTicker.Leverage,
+		"shortValue", shortValue.Round(2),
+		"shortNav", shortTicker.NetValue,
+		"shortLeverage", shortTicker.Leverage,
+		"delta", delta.Round(2))
+
+	blackout, _ := time.ParseDuration(conf.Blackout)
+	now := time.Now()
+	if inBlackout(now, conf.RebalanceTime, blackout, longTicker.PreTimestamp, shortTicker.PreTimestamp) {
+		Sugar.Debug("hedge paused, near the token rebalance")
+		return
+	}
+	diff := hedgeDiff(longValue, shortValue, decimal.NewFromFloat(conf.Ratio), decimal.NewFromFloat(conf.Threshold))
+	if diff.IsZero() {
+		return
+	}
+	if conf.MaxTrade > 0 {
+		max := decimal.NewFromFloat(conf.MaxTrade)
+		if diff.Abs().GreaterThan(max) {
+			diff = max.Mul(decimal.NewFromInt(int64(diff.Sign())))
+		}
+	}
+	available, err := t.ex.AvailableBalance()
+	if err != nil {
+		Sugar.Errorf("get balance error: %s", err)
+		return
+	}
+	if diff.IsPositive() {
+		t.shift(ctx, t.longSymbol, longTicker, t.shortSymbol, shortTicker, diff, available[baseCurrency(t.longSymbol)])
+	} else {
+		t.shift(ctx, t.shortSymbol, shortTicker, t.longSymbol, longTicker, diff.Neg(), available[baseCurrency(t.shortSymbol)])
+	}
+}
+
+// shift sell value of from, and buy to with the proceeds
+func (t *Trader) shift(ctx context.Context, from string, fromTicker gateio.EtfTicker, to string, toTicker gateio.EtfTicker, value, available decimal.Decimal) {
+	if !fromTicker.HighestBid.IsPositive() || !toTicker.LowestAsk.IsPositive() {
+		Sugar.Errorf("no bid of %s or ask of %s", from, to)
+		return
+	}
+	sellAmount := value.Div(fromTicker.HighestBid).Truncate(t.amountPrecision)
+	if sellAmount.GreaterThan(available) {
+		sellAmount = available.Truncate(t.amountPrecision)
+	}
+	if !t.tradable(sellAmount, fromTicker.HighestBid) {
+		Sugar.Infof("hedge amount %s of %s is too small", sellAmount, from)
+		return
+	}
+	now := time.Now().Unix()
+	orderId, err := t.ex.Sell(from, fromTicker.HighestBid, sellAmount, gateio.OrderTypeIOC, fmt.Sprintf("h-s-%d", now))
+	if err != nil {
+		Sugar.Errorf("hedge sell %s error: %s", from, err)
+		return
+	}
+	order, err := t.ex.GetOrder(orderId, from)
+	if err != nil {
+		Sugar.Errorf("get hedge order %d error: %s", orderId, err)
+		return
+	}
+	proceeds := order.FilledAmount.Mul(fromTicker.HighestBid)
+	buyAmount := proceeds.Div(toTicker.LowestAsk).Truncate(t.amountPrecision)
+	if !t.tradable(buyAmount, toTicker.LowestAsk) {
+		Sugar.Infof("hedge sold %s %s, nothing to buy", order.FilledAmount, from)
+		return
+	}
+	buyId, err := t.ex.Buy(to, toTicker.LowestAsk, buyAmount, gateio.OrderTypeNormal, fmt.Sprintf("h-b-%d", now))
+	if err != nil {
+		Sugar.Errorf("hedge buy %s error: %s", to, err)
+		return
+	}
+	t.hedgeOrder = hedgeOrder{Symbol: to, Id: buyId}
+	Sugar.Infof("对冲再平衡，卖出 %s %s (价格 %s)，买入 %s %s (价格 %s)",
+		from, order.FilledAmount, fromTicker.HighestBid, to, buyAmount, toTicker.LowestAsk)
+}
+
+// checkHedgeOrder check the buy leg of the last hedge, cancel it if still open.
+// It returns true if there is no pending order, the balance is settled for the next hedge then.
+func (t *Trader) checkHedgeOrder() bool {
+	o := t.hedgeOrder
+	if o.Id == 0 {
+		return true
+	}
+	order, err := t.ex.GetOrder(o.Id, o.Symbol)
+	if err != nil {
+		Sugar.Errorf("get hedge buy order %d error: %s", o.Id, err)
+		return false
+	}
+	switch order.Status {
+	case gateio.OrderStatusClosed, gateio.OrderStatusCancelled:
+		Sugar.Infof("hedge buy order %d of %s finished, filled %s / %s", o.Id, o.Symbol, order.FilledAmount, order.Amount)
+		t.hedgeOrder = hedgeOrder{}
+		return true
+	default:
+		// the price moved away, cancel it and hedge by the new balance next time
+		if _, err := t.ex.CancelOrder(o.Symbol, o.Id); err != nil {
+			Sugar.Errorf("cancel hedge buy order %d error: %s", o.Id, err)
+			return false
+		}
+		Sugar.Infof("hedge buy order %d of %s is cancelled, filled %s / %s", o.Id, o.Symbol, order.FilledAmount, order.Amount)
+		t.hedgeOrder = hedgeOrder{}
+		return false
+	}
+}
+
+func (t *Trader) tradable(amount, price decimal.Decimal) bool {
+	return amount.GreaterThanOrEqual(t.minAmount) && amount.Mul(price).GreaterThanOrEqual(t.minTotal)
+}
+
+// hedgeDiff is the value to move from the long token to the short one (negative for the reverse),
+// zero if the ratio drifts not more than threshold.
+func hedgeDiff(longValue, shortValue, ratio, threshold decimal.Decimal) decimal.Decimal {
+	if !shortValue.IsPositive() && !longValue.IsPositive() {
+		return decimal.Zero
+	}
+	if shortValue.IsPositive() {
+		drift := longValue.Div(shortValue).Div(ratio).Sub(decimal.NewFromInt(1))
+		if drift.Abs().LessThanOrEqual(threshold) {
+			return decimal.Zero
+		}
+	}
+	total := longValue.Add(shortValue)
+	target := total.Mul(ratio).Div(ratio.Add(decimal.NewFromInt(1)))
+	return longValue.Sub(target)
+}
+
+// exposure is the combined delta in the quote currency, the long token is positive
+func exposure(longValue, longLeverage, shortValue, shortLeverage decimal.Decimal) decimal.Decimal {
+	return longValue.Mul(longLeverage.Abs()).Sub(shortValue.Mul(shortLeverage.Abs()))
+}
+
+// inBlackout is true if now is within blackout of the daily rebalance time (HH:MM, Beijing), or after the last rebalance of the tokens
+func inBlackout(now time.Time, rebalanceTime string, blackout time.Duration, last ...time.Time) bool {
+	for _, l := range last {
+		if !l.IsZero() && now.Sub(l) >= 0 && now.Sub(l) < blackout {
+			return true
+		}
+	}
+	clock, err := time.Parse("15:04", rebalanceTime)
+	if err != nil {
+		return false
+	}
+	local := now.In(beijing)
+	scheduled := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, beijing)
+	for _, s := range []time.Time{scheduled.AddDate(0, 0, -1), scheduled, scheduled.AddDate(0, 0, 1)} {
+		d := now.Sub(s)
+		if d < 0 {
+			d = -d
+		}
+		if d < blackout {
+			return true
+		}
+	}
+	return false
+}
+
+func baseCurrency(symbol string) string {
+	return strings.ToUpper(strings.Split(symbol, "_")[0])
+}
+
+// printExposure log the combined delta exposure in the quote currency
+func (t *Trader) printExposure() {
+	longTicker, err := t.ex.EtfTicker(t.longSymbol)
+	if err != nil {
+		Sugar.Errorf("get %s etf ticker error: %s", t.longSymbol, err)
+		return
+	}
+	shortTicker, err := t.ex.EtfTicker(t.shortSymbol)
+	if err != nil {
+		Sugar.Errorf("get %s etf ticker error: %s", t.shortSymbol, err)
+		return
+	}
+	balance, err := t.ex.TotalBalance()
+	if err != nil {
+		Sugar.Errorf("get balance error: %s", err)
+		return
+	}
+	longValue := balance[baseCurrency(t.longSymbol)].Mul(longTicker.Last)
+	shortValue := balance[baseCurrency(t.shortSymbol)].Mul(shortTicker.Last)
+	Sugar.Infow("Hedge",
+		"longValue", longValue.Round(2),
+		"shortValue", shortValue.Round(2),
+		"ratio", t.config.Strategy.Hedge.Ratio,
+		"delta", exposure(longValue, longTicker.Leverage, shortValue, shortTicker.Leverage).Round(2))
+}
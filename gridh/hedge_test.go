@@ -0,0 +1,48 @@
+package gridh
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func TestHedgeDiff(t *testing.T) {
+	var tests = []struct {
+		long, short, ratio, threshold string
+		want                          string
+	}{
+		{"100", "100", "1", "0.1", "0"},
+		{"105", "100", "1", "0.1", "0"},
+		{"150", "100", "1", "0.1", "25"},
+		{"50", "100", "1", "0.1", "-25"},
+		{"300", "100", "2", "0.1", "33.3333333333333333"},
+		{"100", "0", "1", "0.1", "50"},
+		{"0", "0", "1", "0.1", "0"},
+	}
+	for i, tt := range tests {
+		got := hedgeDiff(decimal.RequireFromString(tt.long), decimal.RequireFromString(tt.short),
+			decimal.RequireFromString(tt.ratio), decimal.RequireFromString(tt.threshold))
+		if !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("[%d] want %s, got %s", i, tt.want, got)
+		}
+	}
+}
+
+func TestInBlackout(t *testing.T) {
+	blackout := 10 * time.Minute
+	var tests = []struct {
+		now  time.Time
+		last time.Time
+		want bool
+	}{
+		{time.Date(2021, 1, 1, 16, 5, 0, 0, time.UTC), time.Time{}, true},  // 00:05 Beijing
+		{time.Date(2021, 1, 1, 15, 55, 0, 0, time.UTC), time.Time{}, true}, // 23:55 Beijing
+		{time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC), time.Time{}, false},
+		{time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC), time.Date(2021, 1, 1, 11, 55, 0, 0, time.UTC), true},
+	}
+	for i, tt := range tests {
+		if got := inBlackout(tt.now, "00:00", blackout, tt.last); got != tt.want {
+			t.Errorf("[%d] want %v, got %v", i, tt.want, got)
+		}
+	}
+}
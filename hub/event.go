@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"fmt"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"time"
+)
+
+type EventType int
+
+const (
+	EventCandleUpdate EventType = iota // the latest bar is updated
+	EventCandleClosed                  // a new bar is opened, the previous one is closed
+	EventTrade
+	EventOrder
+	EventBalance
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCandleUpdate:
+		return "candle update"
+	case EventCandleClosed:
+		return "candle closed"
+	case EventTrade:
+		return "trade"
+	case EventOrder:
+		return "order update"
+	case EventBalance:
+		return "balance"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// Channel is the exchange subscription channel
+type Channel string
+
+const (
+	ChannelCandle  Channel = "candle"
+	ChannelTrade   Channel = "trade"
+	ChannelOrder   Channel = "order"
+	ChannelBalance Channel = "balance"
+)
+
+// Key identify one exchange subscription, Interval is only for candle
+type Key struct {
+	Symbol   string
+	Channel  Channel
+	Interval time.Duration
+}
+
+func (k Key) String() string {
+	if k.Channel == ChannelCandle {
+		return fmt.Sprintf("%s/%s/%s", k.Symbol, k.Channel, k.Interval)
+	}
+	return fmt.Sprintf("%s/%s", k.Symbol, k.Channel)
+}
+
+// Event is fan out to all consumers of the subscription.
+//
+// EventCandleUpdate and EventCandleClosed are exclusive for one tick:
+// when a tick opens a new bar, only EventCandleClosed is sent, Ticker is the new bar and Closed is the previous one.
+// Candle is the history data returned by the candle request, Ticker is empty then.
+// Raw is the original response from exchange, for order and balance it's the only payload.
+type Event struct {
+	Type     EventType
+	Symbol   string
+	Interval time.Duration
+
+	Ticker hs.Ticker
+	Closed hs.Ticker
+	Candle *hs.Candle
+	Trades []exchange.TradeDetail
+	Raw    interface{}
+}
@@ -0,0 +1,312 @@
+package hub
+
+import (
+	"errors"
+	"github.com/huobirdcenter/huobi_golang/pkg/client/websocketclientbase"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/exchange/huobi"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// client ids used for exchange subscriptions, one subscription is shared by all consumers
+const (
+	clientIdCandle  = "hub-candle"
+	clientIdTrade   = "hub-trade"
+	clientIdOrder   = "hub-order"
+	clientIdBalance = "hub-balance"
+)
+
+const DefaultQueueSize = 100
+
+// tradeExchange is the exchange supports trade detail subscription, eg. huobi
+type tradeExchange interface {
+	SubscribeTrade(symbol, clientId string, responseHandler exchange.TradeHandler)
+	UnsubscribeTrade(symbol, clientId string)
+}
+
+// balanceExchange is the exchange supports account update subscription, eg. huobi
+type balanceExchange interface {
+	SubscribeAccountUpdate(clientId string, responseHandler websocketclientbase.ResponseHandler)
+	UnsubscribeAccountUpdate(clientId string)
+}
+
+// CandleParser parse the candle response to the latest bar or the history data
+type CandleParser func(resp interface{}) (*hs.Ticker, *hs.Candle, error)
+
+type subscription struct {
+	key       Key
+	consumers map[*Consumer]struct{}
+	last      hs.Ticker // the latest bar, for candle closed detection
+}
+
+// Hub owns the websocket subscriptions of one exchange connection.
+// Subscriptions are de-duplicated by (symbol, channel, interval),
+// the events are fan out to registered consumers.
+type Hub struct {
+	Sugar *zap.SugaredLogger
+
+	ex          exchange.WsAPIExchange
+	parseCandle CandleParser
+
+	lock sync.Mutex
+	subs map[Key]*subscription
+}
+
+func New(ex exchange.WsAPIExchange) *Hub {
+	return &Hub{
+		Sugar:       zap.NewNop().Sugar(),
+		ex:          ex,
+		parseCandle: huobi.CandlestickHandler,
+		subs:        make(map[Key]*subscription),
+	}
+}
+
+func (h *Hub) Init(sugar *zap.SugaredLogger) {
+	h.Sugar = sugar
+}
+
+// SetCandleParser replace the default (huobi) candle parser
+func (h *Hub) SetCandleParser(parser CandleParser) {
+	h.parseCandle = parser
+}
+
+// Register a consumer, handler is called in the consumer's own goroutine.
+// Events are dropped when the queue (size) is full, so a slow consumer never blocks the others.
+func (h *Hub) Register(name string, size int, handler func(Event)) *Consumer {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	c := &Consumer{
+		Name:    name,
+		queue:   make(chan Event, size),
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Unregister remove the consumer from all subscriptions, and stop it after the queued events are handled.
+func (h *Hub) Unregister(c *Consumer) {
+	var keys []Key
+	h.lock.Lock()
+	for key := range h.subs {
+		if h.remove(key, c) {
+			keys = append(keys, key)
+		}
+	}
+	h.lock.Unlock()
+	c.close()
+	for _, key := range keys {
+		h.unsubscribeExchange(key)
+	}
+}
+
+func (h *Hub) SubscribeCandle(c *Consumer, symbol string, interval time.Duration) {
+	h.subscribe(c, Key{Symbol: symbol, Channel: ChannelCandle, Interval: interval})
+}
+
+func (h *Hub) UnsubscribeCandle(c *Consumer, symbol string, interval time.Duration) {
+	h.unsubscribe(c, Key{Symbol: symbol, Channel: ChannelCandle, Interval: interval})
+}
+
+func (h *Hub) SubscribeOrder(c *Consumer, symbol string) {
+	h.subscribe(c, Key{Symbol: symbol, Channel: ChannelOrder})
+}
+
+func (h *Hub) UnsubscribeOrder(c *Consumer, symbol string) {
+	h.unsubscribe(c, Key{Symbol: symbol, Channel: ChannelOrder})
+}
+
+func (h *Hub) SubscribeTrade(c *Consumer, symbol string) error {
+	if _, ok := h.ex.(tradeExchange); !ok {
+		return errors.New("exchange not support trade subscription")
+	}
+	h.subscribe(c, Key{Symbol: symbol, Channel: ChannelTrade})
+	return nil
+}
+
+func (h *Hub) UnsubscribeTrade(c *Consumer, symbol string) {
+	h.unsubscribe(c, Key{Symbol: symbol, Channel: ChannelTrade})
+}
+
+// SubscribeBalance subscribe the account balance update, it's not bound to any symbol
+func (h *Hub) SubscribeBalance(c *Consumer) error {
+	if _, ok := h.ex.(balanceExchange); !ok {
+		return errors.New("exchange not support balance subscription")
+	}
+	h.subscribe(c, Key{Channel: ChannelBalance})
+	return nil
+}
+
+func (h *Hub) UnsubscribeBalance(c *Consumer) {
+	h.unsubscribe(c, Key{Channel: ChannelBalance})
+}
+
+// Subscriptions return the number of exchange subscriptions
+func (h *Hub) Subscriptions() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.subs)
+}
+
+func (h *Hub) subscribe(c *Consumer, key Key) {
+	h.lock.Lock()
+	if sub, ok := h.subs[key]; ok {
+		sub.consumers[c] = struct{}{}
+		h.lock.Unlock()
+		h.Sugar.Debugf("consumer %s join subscription %s", c.Name, key)
+		return
+	}
+	h.subs[key] = &subscription{key: key, consumers: map[*Consumer]struct{}{c: {}}}
+	h.lock.Unlock()
+
+	h.Sugar.Infof("subscribe %s for consumer %s", key, c.Name)
+	switch key.Channel {
+	case ChannelCandle:
+		h.ex.SubscribeCandlestick(key.Symbol, clientIdCandle, key.Interval, func(resp interface{}) {
+			h.onCandle(key, resp)
+		})
+	case ChannelOrder:
+		h.ex.SubscribeOrder(key.Symbol, clientIdOrder, func(resp interface{}) {
+			h.dispatch(key, Event{Type: EventOrder, Symbol: key.Symbol, Raw: resp})
+		})
+	case ChannelTrade:
+		h.ex.(tradeExchange).SubscribeTrade(key.Symbol, clientIdTrade, func(trades []exchange.TradeDetail) {
+			h.dispatch(key, Event{Type: EventTrade, Symbol: key.Symbol, Trades: trades})
+		})
+	case ChannelBalance:
+		h.ex.(balanceExchange).SubscribeAccountUpdate(clientIdBalance, func(resp interface{}) {
+			h.dispatch(key, Event{Type: EventBalance, Raw: resp})
+		})
+	}
+}
+
+func (h *Hub) unsubscribe(c *Consumer, key Key) {
+	h.lock.Lock()
+	last := h.remove(key, c)
+	h.lock.Unlock()
+	if last {
+		h.unsubscribeExchange(key)
+	}
+}
+
+// remove the consumer from subscription, return true if it's the last one. must hold the lock.
+func (h *Hub) remove(key Key, c *Consumer) bool {
+	sub, ok := h.subs[key]
+	if !ok {
+		return false
+	}
+	if _, ok := sub.consumers[c]; !ok {
+		return false
+	}
+	delete(sub.consumers, c)
+	if len(sub.consumers) > 0 {
+		return false
+	}
+	delete(h.subs, key)
+	return true
+}
+
+func (h *Hub) unsubscribeExchange(key Key) {
+	h.Sugar.Infof("unsubscribe %s, no consumer", key)
+	switch key.Channel {
+	case ChannelCandle:
+		h.ex.UnsubscribeCandlestick(key.Symbol, clientIdCandle, key.Interval)
+	case ChannelOrder:
+		h.ex.UnsubscribeOrder(key.Symbol, clientIdOrder)
+	case ChannelTrade:
+		h.ex.(tradeExchange).UnsubscribeTrade(key.Symbol, clientIdTrade)
+	case ChannelBalance:
+		h.ex.(balanceExchange).UnsubscribeAccountUpdate(clientIdBalance)
+	}
+}
+
+func (h *Hub) onCandle(key Key, resp interface{}) {
+	ticker, candle, err := h.parseCandle(resp)
+	if err != nil {
+		h.Sugar.Info(err)
+		return
+	}
+	if candle != nil {
+		h.dispatch(key, Event{Type: EventCandleUpdate, Symbol: key.Symbol, Interval: key.Interval, Candle: candle, Raw: resp})
+	}
+	if ticker == nil {
+		return
+	}
+	e := Event{Type: EventCandleUpdate, Symbol: key.Symbol, Interval: key.Interval, Ticker: *ticker, Raw: resp}
+	h.lock.Lock()
+	if sub, ok := h.subs[key]; ok {
+		if sub.last.Timestamp != 0 && sub.last.Timestamp != ticker.Timestamp {
+			e.Type = EventCandleClosed
+			e.Closed = sub.last
+		}
+		sub.last = *ticker
+	}
+	h.lock.Unlock()
+	h.dispatch(key, e)
+}
+
+func (h *Hub) dispatch(key Key, e Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	sub, ok := h.subs[key]
+	if !ok {
+		return
+	}
+	for c := range sub.consumers {
+		if !c.push(e) {
+			h.Sugar.Warnf("consumer %s queue is full, drop %s event of %s", c.Name, e.Type, key)
+		}
+	}
+}
+
+// Consumer receive the events of its subscriptions by a bounded queue
+type Consumer struct {
+	Name string
+
+	queue   chan Event
+	handler func(Event)
+	dropped uint64
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Dropped return the number of events dropped because the queue is full
+func (c *Consumer) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Done is closed when the consumer is stopped
+func (c *Consumer) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Consumer) run() {
+	defer close(c.done)
+	for e := range c.queue {
+		c.handler(e)
+	}
+}
+
+// push never blocks, it's called with the hub lock.
+func (c *Consumer) push(e Event) bool {
+	select {
+	case c.queue <- e:
+		return true
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+		return false
+	}
+}
+
+func (c *Consumer) close() {
+	c.closeOnce.Do(func() {
+		close(c.queue)
+	})
+}
@@ -0,0 +1,125 @@
+package hub
+
+import (
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWsExchange record the subscriptions, and let test push responses by hand
+type fakeWsExchange struct {
+	exchange.WsAPIExchange
+
+	lock         sync.Mutex
+	subscribed   int
+	unsubscribed int
+	handlers     map[string]exchange.ResponseHandler
+}
+
+func newFakeWsExchange() *fakeWsExchange {
+	return &fakeWsExchange{handlers: make(map[string]exchange.ResponseHandler)}
+}
+
+func (f *fakeWsExchange) SubscribeCandlestick(symbol, clientId string, period time.Duration, responseHandler exchange.ResponseHandler) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.subscribed++
+	f.handlers[symbol] = responseHandler
+}
+
+func (f *fakeWsExchange) UnsubscribeCandlestick(symbol, clientId string, period time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.unsubscribed++
+	delete(f.handlers, symbol)
+}
+
+func (f *fakeWsExchange) push(symbol string, ticker hs.Ticker) {
+	f.lock.Lock()
+	h := f.handlers[symbol]
+	f.lock.Unlock()
+	h(ticker)
+}
+
+func tickerParser(resp interface{}) (*hs.Ticker, *hs.Candle, error) {
+	ticker := resp.(hs.Ticker)
+	return &ticker, nil, nil
+}
+
+func TestHub_Dedup(t *testing.T) {
+	ex := newFakeWsExchange()
+	h := New(ex)
+	h.SetCandleParser(tickerParser)
+
+	var lock sync.Mutex
+	var got [2][]Event
+	var wg sync.WaitGroup
+	newConsumer := func(i int) *Consumer {
+		return h.Register("c", 10, func(e Event) {
+			lock.Lock()
+			got[i] = append(got[i], e)
+			lock.Unlock()
+			wg.Done()
+		})
+	}
+	c1 := newConsumer(0)
+	c2 := newConsumer(1)
+	h.SubscribeCandle(c1, "btcusdt", time.Minute)
+	h.SubscribeCandle(c2, "btcusdt", time.Minute)
+	if ex.subscribed != 1 || h.Subscriptions() != 1 {
+		t.Fatalf("want 1 subscription, got %d %d", ex.subscribed, h.Subscriptions())
+	}
+
+	wg.Add(6)
+	ex.push("btcusdt", hs.Ticker{Timestamp: 60, Close: 1})
+	ex.push("btcusdt", hs.Ticker{Timestamp: 60, Close: 2})
+	ex.push("btcusdt", hs.Ticker{Timestamp: 120, Close: 3})
+	wg.Wait()
+	for i := range got {
+		if len(got[i]) != 3 {
+			t.Fatalf("[%d] want 3 events, got %d", i, len(got[i]))
+		}
+		if got[i][1].Type != EventCandleUpdate || got[i][1].Ticker.Close != 2 {
+			t.Errorf("[%d] want candle update, got %s %f", i, got[i][1].Type, got[i][1].Ticker.Close)
+		}
+		if e := got[i][2]; e.Type != EventCandleClosed || e.Closed.Close != 2 || e.Ticker.Close != 3 {
+			t.Errorf("[%d] want candle closed, got %s %f %f", i, e.Type, e.Closed.Close, e.Ticker.Close)
+		}
+	}
+
+	h.Unregister(c1)
+	if ex.unsubscribed != 0 {
+		t.Errorf("want keep subscription for c2")
+	}
+	h.UnsubscribeCandle(c2, "btcusdt", time.Minute)
+	if ex.unsubscribed != 1 || h.Subscriptions() != 0 {
+		t.Errorf("want unsubscribed, got %d %d", ex.unsubscribed, h.Subscriptions())
+	}
+	h.Unregister(c2)
+	<-c1.Done()
+	<-c2.Done()
+}
+
+func TestHub_Drop(t *testing.T) {
+	ex := newFakeWsExchange()
+	h := New(ex)
+	h.SetCandleParser(tickerParser)
+
+	block := make(chan struct{})
+	c := h.Register("slow", 1, func(e Event) {
+		<-block
+	})
+	h.SubscribeCandle(c, "btcusdt", time.Minute)
+	for i := 0; i < 5; i++ {
+		ex.push("btcusdt", hs.Ticker{Timestamp: 60})
+	}
+	// the first is handling or queued, at least 3 are dropped
+	if c.Dropped() < 3 {
+		t.Errorf("want at least 3 dropped, got %d", c.Dropped())
+	}
+	close(block)
+	h.Unregister(c)
+	<-c.Done()
+}
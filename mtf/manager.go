@@ -0,0 +1,320 @@
+// Package mtf maintain the multi-timeframe candles of symbols from one base candle stream.
+// All the timeframes of one symbol are resampled from the base stream (1m by default) of the hub,
+// so the strategies of the same symbol share the buffers and the websocket subscription.
+package mtf
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/hub"
+	"go.uber.org/zap"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxHistory is the max bars loaded from the exchange, it's the limit of huobi
+const MaxHistory = 2000
+
+// Request is the candle wanted by a subscriber
+type Request struct {
+	Symbol   string
+	Interval time.Duration // must be multiple of the base interval
+	Lookback int           // bars needed by the indicators, the buffer is sized by the largest one of all subscribers
+	Update   bool          // receive the update of the forming bar too, not only the closed
+}
+
+// Event is sent to the subscribers of (Symbol, Interval).
+// Candle always ends with the forming bar, when Closed is true, the bar just closed is at Length()-2.
+type Event struct {
+	Symbol   string
+	Interval time.Duration
+	Closed   bool
+	Candle   hs.Candle
+}
+
+type key struct {
+	symbol   string
+	interval time.Duration
+}
+
+type series struct {
+	candle hs.Candle
+	base   hs.Ticker // the latest version of the base bar folded into the forming bar
+	subs   map[*Subscriber]struct{}
+}
+
+type Manager struct {
+	Sugar *zap.SugaredLogger
+
+	ex   exchange.RestAPIExchange
+	hub  *hub.Hub
+	base time.Duration
+
+	lock     sync.Mutex
+	series   map[key]*series
+	symbols  map[string]int // number of series of symbol
+	consumer *hub.Consumer
+}
+
+// New create the manager, the history is from ex, and the base stream is from h
+func New(ex exchange.RestAPIExchange, h *hub.Hub) *Manager {
+	return &Manager{
+		Sugar:   zap.NewNop().Sugar(),
+		ex:      ex,
+		hub:     h,
+		base:    exchange.MIN1,
+		series:  make(map[key]*series),
+		symbols: make(map[string]int),
+	}
+}
+
+func (m *Manager) Init(sugar *zap.SugaredLogger) {
+	m.Sugar = sugar
+}
+
+// SetBase change the base interval, it should be called before any subscription
+func (m *Manager) SetBase(base time.Duration) {
+	m.base = base
+}
+
+// Subscribe the candle, the history (Lookback bars, MaxHistory at most) is loaded before return.
+// handler is called in the subscriber's own goroutine, the events are dropped when the queue is full.
+// handler may be nil if the subscriber only read the buffer by Candle.
+func (m *Manager) Subscribe(name string, r Request, handler func(Event)) (*Subscriber, error) {
+	if r.Interval < m.base || r.Interval%m.base != 0 {
+		return nil, fmt.Errorf("interval %s is not multiple of base interval %s", r.Interval, m.base)
+	}
+	if r.Lookback <= 0 {
+		return nil, errors.New("lookback must be positive")
+	}
+	k := key{symbol: r.Symbol, interval: r.Interval}
+	size := r.Lookback + 2 // the forming bar, and the closed one for crossover
+	m.lock.Lock()
+	s, ok := m.series[k]
+	backfill := !ok || s.candle.Capacity < size
+	m.lock.Unlock()
+
+	var history hs.Candle
+	if backfill {
+		var err error
+		history, err = m.ex.CandleBySize(r.Symbol, r.Interval, historySize(size))
+		if err != nil {
+			return nil, err
+		}
+		m.Sugar.Infof("%s/%s candle loaded, %d bars", r.Symbol, r.Interval, history.Length())
+	}
+
+	sub := &Subscriber{
+		Name:    name,
+		request: r,
+		queue:   make(chan Event, hub.DefaultQueueSize),
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+	go sub.run()
+
+	first := false
+	m.lock.Lock()
+	if s, ok = m.series[k]; !ok {
+		s = &series{subs: make(map[*Subscriber]struct{})}
+		m.series[k] = s
+		m.symbols[r.Symbol]++
+		first = m.symbols[r.Symbol] == 1
+	}
+	if backfill && s.candle.Capacity < size {
+		s.candle = hs.NewCandle(size)
+		s.candle.Add(history)
+		s.base = hs.Ticker{}
+	}
+	s.subs[sub] = struct{}{}
+	if m.consumer == nil {
+		m.consumer = m.hub.Register("mtf", hub.DefaultQueueSize, m.onBase)
+	}
+	consumer := m.consumer
+	m.lock.Unlock()
+
+	if first {
+		m.hub.SubscribeCandle(consumer, r.Symbol, m.base)
+	}
+	return sub, nil
+}
+
+func historySize(size int) int {
+	if size > MaxHistory {
+		return MaxHistory
+	}
+	return size
+}
+
+// Unsubscribe stop the subscriber, the base stream is unsubscribed when the symbol has no subscriber
+func (m *Manager) Unsubscribe(sub *Subscriber) {
+	k := key{symbol: sub.request.Symbol, interval: sub.request.Interval}
+	last := false
+	m.lock.Lock()
+	if s, ok := m.series[k]; ok {
+		if _, ok := s.subs[sub]; ok {
+			delete(s.subs, sub)
+			if len(s.subs) == 0 {
+				delete(m.series, k)
+				m.symbols[k.symbol]--
+				if m.symbols[k.symbol] == 0 {
+					delete(m.symbols, k.symbol)
+					last = true
+				}
+			}
+		}
+	}
+	sub.close()
+	consumer := m.consumer
+	m.lock.Unlock()
+	if last && consumer != nil {
+		m.hub.UnsubscribeCandle(consumer, k.symbol, m.base)
+	}
+}
+
+// Candle return a copy of the candle, it ends with the forming bar.
+// It implement rule.Source for the subscribed timeframes.
+func (m *Manager) Candle(symbol string, interval time.Duration) (hs.Candle, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, ok := m.series[key{symbol: symbol, interval: interval}]
+	if !ok {
+		return hs.Candle{}, fmt.Errorf("%s/%s is not subscribed", symbol, interval)
+	}
+	return clone(s.candle), nil
+}
+
+func (m *Manager) onBase(e hub.Event) {
+	if e.Candle != nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for k, s := range m.series {
+		if k.symbol != e.Symbol {
+			continue
+		}
+		if e.Type == hub.EventCandleClosed {
+			// the last version of the closed base bar, then the new one
+			s.fold(e.Closed, k.interval)
+		}
+		closed := s.fold(e.Ticker, k.interval)
+		var c hs.Candle
+		for sub := range s.subs {
+			if sub.handler == nil || !closed && !sub.request.Update {
+				continue
+			}
+			if c.Capacity == 0 {
+				c = clone(s.candle)
+			}
+			if !sub.push(Event{Symbol: k.symbol, Interval: k.interval, Closed: closed, Candle: c}) {
+				m.Sugar.Warnf("subscriber %s queue is full, drop %s/%s event", sub.Name, k.symbol, k.interval)
+			}
+		}
+	}
+}
+
+// fold the base bar t into the forming bar, return true if a new bar is opened
+func (s *series) fold(t hs.Ticker, interval time.Duration) bool {
+	if t.Timestamp == 0 {
+		return false
+	}
+	sec := int64(interval.Seconds())
+	l := s.candle.Length()
+	if l == 0 {
+		s.candle.Append(hs.Ticker{Timestamp: t.Timestamp - t.Timestamp%sec,
+			Open: t.Open, High: t.High, Low: t.Low, Close: t.Close, Volume: t.Volume})
+		s.base = t
+		return false
+	}
+	start := s.candle.Timestamp[l-1]
+	if t.Timestamp < start {
+		return false
+	}
+	if t.Timestamp >= start+sec {
+		// skip the empty bars if no trade for a while
+		start += (t.Timestamp - start) / sec * sec
+		s.candle.Append(hs.Ticker{Timestamp: start,
+			Open: t.Open, High: t.High, Low: t.Low, Close: t.Close, Volume: t.Volume})
+		s.base = t
+		return true
+	}
+	p := l - 1
+	volume := s.candle.Volume[p]
+	if s.base.Timestamp == t.Timestamp {
+		volume += t.Volume - s.base.Volume
+	} else if s.base.Timestamp != 0 {
+		volume += t.Volume
+	} // else the first base bar after loading history, it's already in the history
+	s.candle.Append(hs.Ticker{Timestamp: start,
+		Open:   s.candle.Open[p],
+		High:   math.Max(s.candle.High[p], t.High),
+		Low:    math.Min(s.candle.Low[p], t.Low),
+		Close:  t.Close,
+		Volume: volume,
+	})
+	s.base = t
+	return false
+}
+
+func clone(c hs.Candle) hs.Candle {
+	r := hs.NewCandle(c.Capacity)
+	r.Timestamp = append(r.Timestamp, c.Timestamp...)
+	r.Open = append(r.Open, c.Open...)
+	r.High = append(r.High, c.High...)
+	r.Low = append(r.Low, c.Low...)
+	r.Close = append(r.Close, c.Close...)
+	r.Volume = append(r.Volume, c.Volume...)
+	return r
+}
+
+// Subscriber receive the events by a bounded queue
+type Subscriber struct {
+	Name string
+
+	request Request
+	queue   chan Event
+	handler func(Event)
+	dropped uint64
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Dropped return the number of events dropped because the queue is full
+func (s *Subscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Done is closed when the subscriber is stopped
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscriber) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		s.handler(e)
+	}
+}
+
+// push never blocks, it's called with the manager lock.
+func (s *Subscriber) push(e Event) bool {
+	select {
+	case s.queue <- e:
+		return true
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		return false
+	}
+}
+
+func (s *Subscriber) close() {
+	s.closeOnce.Do(func() {
+		close(s.queue)
+	})
+}
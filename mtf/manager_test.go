@@ -0,0 +1,193 @@
+package mtf
+
+import (
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/hub"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExchange return the history by CandleBySize, and let test push the base bars by hand
+type fakeExchange struct {
+	exchange.Exchange
+
+	lock    sync.Mutex
+	history map[time.Duration]hs.Candle
+	size    int // the last size requested
+	handler exchange.ResponseHandler
+}
+
+func (f *fakeExchange) CandleBySize(symbol string, period time.Duration, size int) (hs.Candle, error) {
+	f.size = size
+	return f.history[period], nil
+}
+
+func (f *fakeExchange) SubscribeCandlestick(symbol, clientId string, period time.Duration, responseHandler exchange.ResponseHandler) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.handler = responseHandler
+}
+
+func (f *fakeExchange) UnsubscribeCandlestick(symbol, clientId string, period time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.handler = nil
+}
+
+func (f *fakeExchange) push(ticker hs.Ticker) {
+	f.lock.Lock()
+	h := f.handler
+	f.lock.Unlock()
+	h(ticker)
+}
+
+func tickerParser(resp interface{}) (*hs.Ticker, *hs.Candle, error) {
+	ticker := resp.(hs.Ticker)
+	return &ticker, nil, nil
+}
+
+func TestSeries_fold(t *testing.T) {
+	s := &series{candle: hs.NewCandle(10)}
+	// history with the forming 5m bar at 300, already has the first 1m bar
+	s.candle.Append(hs.Ticker{Timestamp: 0, Open: 1, High: 2, Low: 1, Close: 2, Volume: 10})
+	s.candle.Append(hs.Ticker{Timestamp: 300, Open: 2, High: 3, Low: 2, Close: 3, Volume: 5})
+
+	if s.fold(hs.Ticker{Timestamp: 300, Open: 2, High: 3, Low: 2, Close: 3, Volume: 6}, time.Minute*5) {
+		t.Errorf("want no bar closed")
+	}
+	if s.candle.Volume[1] != 5 {
+		t.Errorf("first base bar is in history, want volume 5, got %f", s.candle.Volume[1])
+	}
+	s.fold(hs.Ticker{Timestamp: 300, Open: 2, High: 3, Low: 2, Close: 3, Volume: 8}, time.Minute*5)
+	s.fold(hs.Ticker{Timestamp: 360, Open: 3, High: 5, Low: 1.5, Close: 4, Volume: 1}, time.Minute*5)
+	if s.candle.High[1] != 5 || s.candle.Low[1] != 1.5 || s.candle.Close[1] != 4 || s.candle.Volume[1] != 8 {
+		t.Errorf("bad forming bar: %v", s.candle)
+	}
+	if !s.fold(hs.Ticker{Timestamp: 1200, Open: 4, High: 4, Low: 4, Close: 4, Volume: 1}, time.Minute*5) {
+		t.Errorf("want bar closed")
+	}
+	if s.candle.Length() != 3 || s.candle.Timestamp[2] != 1200 || s.candle.Open[2] != 4 {
+		t.Errorf("want new bar at 1200, got %v", s.candle)
+	}
+	if s.fold(hs.Ticker{Timestamp: 900, Close: 1}, time.Minute*5) || s.candle.Close[2] != 4 {
+		t.Errorf("want the stale bar ignored")
+	}
+}
+
+func TestManager_Subscribe(t *testing.T) {
+	ex := &fakeExchange{history: map[time.Duration]hs.Candle{}}
+	for _, interval := range []time.Duration{time.Minute, time.Minute * 5} {
+		c := hs.NewCandle(10)
+		c.Append(hs.Ticker{Timestamp: 600, Open: 1, High: 1, Low: 1, Close: 1, Volume: 1})
+		ex.history[interval] = c
+	}
+	h := hub.New(ex)
+	h.SetCandleParser(tickerParser)
+	m := New(ex, h)
+
+	var lock sync.Mutex
+	var got []Event
+	var wg sync.WaitGroup
+	handler := func(e Event) {
+		lock.Lock()
+		defer lock.Unlock()
+		got = append(got, e)
+		wg.Done()
+	}
+	if _, err := m.Subscribe("bad", Request{Symbol: "btcusdt", Interval: time.Second * 90, Lookback: 1}, handler); err == nil {
+		t.Errorf("want error for bad interval")
+	}
+	s1, err := m.Subscribe("1m", Request{Symbol: "btcusdt", Interval: time.Minute, Lookback: 5}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s5, err := m.Subscribe("5m", Request{Symbol: "btcusdt", Interval: time.Minute * 5, Lookback: 5, Update: true}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Subscriptions() != 1 {
+		t.Errorf("want 1 base subscription, got %d", h.Subscriptions())
+	}
+
+	// 5m updated twice, 1m closed at 660
+	wg.Add(3)
+	ex.push(hs.Ticker{Timestamp: 600, Open: 1, High: 2, Low: 1, Close: 2, Volume: 2})
+	ex.push(hs.Ticker{Timestamp: 660, Open: 2, High: 3, Low: 2, Close: 3, Volume: 1})
+	wg.Wait()
+	lock.Lock()
+	closed1m, update5m := 0, 0
+	for _, e := range got {
+		if e.Interval == time.Minute && e.Closed {
+			closed1m++
+			if e.Candle.Length() != 2 || e.Candle.Close[0] != 2 {
+				t.Errorf("want the closed 1m bar with close 2, got %v", e.Candle)
+			}
+		}
+		if e.Interval == time.Minute*5 && !e.Closed {
+			update5m++
+		}
+	}
+	lock.Unlock()
+	if closed1m != 1 || update5m != 2 {
+		t.Errorf("want 1 closed 1m and 2 updates of 5m, got %d, %d", closed1m, update5m)
+	}
+	c, err := m.Candle("btcusdt", time.Minute*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Length() != 1 || c.High[0] != 3 || c.Close[0] != 3 {
+		t.Errorf("bad 5m candle: %v", c)
+	}
+
+	m.Unsubscribe(s1)
+	m.Unsubscribe(s5)
+	<-s1.Done()
+	<-s5.Done()
+	if h.Subscriptions() != 0 {
+		t.Errorf("want base unsubscribed, got %d", h.Subscriptions())
+	}
+	if _, err := m.Candle("btcusdt", time.Minute); err == nil {
+		t.Errorf("want error after unsubscribe")
+	}
+}
+
+func TestManager_Subscribe_history(t *testing.T) {
+	ex := &fakeExchange{history: map[time.Duration]hs.Candle{}}
+	c := hs.NewCandle(10)
+	c.Append(hs.Ticker{Timestamp: 86400, Open: 1, High: 1, Low: 1, Close: 1, Volume: 1})
+	ex.history[time.Hour*24] = c
+	h := hub.New(ex)
+	h.SetCandleParser(tickerParser)
+	m := New(ex, h)
+
+	sub, err := m.Subscribe("1d", Request{Symbol: "btcusdt", Interval: time.Hour * 24, Lookback: MaxHistory}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.size != MaxHistory {
+		t.Errorf("want %d bars requested, got %d", MaxHistory, ex.size)
+	}
+	// no handler, the buffer is still updated, the 1m subscriber tells the base bar is folded
+	var wg sync.WaitGroup
+	s1, err := m.Subscribe("1m", Request{Symbol: "btcusdt", Interval: time.Minute, Lookback: 5, Update: true},
+		func(Event) { wg.Done() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(1)
+	ex.push(hs.Ticker{Timestamp: 86400 * 2, Open: 2, High: 2, Low: 2, Close: 2, Volume: 1})
+	wg.Wait()
+	got, err := m.Candle("btcusdt", time.Hour*24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Length() != 2 || got.Close[1] != 2 {
+		t.Errorf("bad 1d candle: %v", got)
+	}
+	m.Unsubscribe(sub)
+	m.Unsubscribe(s1)
+	<-sub.Done()
+	<-s1.Done()
+}
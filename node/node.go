@@ -24,7 +24,7 @@ type Node struct {
 	config Config
 	mg     *mongo.Database
 	gormDB *gorm.DB
-	grids  []grid.RestGridTrader
+	grids  []*grid.RestGridTrader
 }
 
 func New(configFilename string) *Node {
@@ -243,7 +243,7 @@ func (n *Node) initGrid(ctx context.Context) {
 	for _, u := range n.config.Users {
 		//exApi := n.initExAPI(u)
 		_ = u
-		g := grid.RestGridTrader{
+		g := &grid.RestGridTrader{
 			//Exchange: u.Exchange,
 			//Label:    u.Label,
 			//Symbol:     u.Symbol,
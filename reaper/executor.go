@@ -0,0 +1,425 @@
+package reaper
+
+import (
+	"context"
+	"fmt"
+	"github.com/huobirdcenter/huobi_golang/pkg/client"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/executor"
+	"time"
+)
+
+const (
+	collNameState = "state"
+
+	// check the orders and exits every interval, besides the signals
+	checkInterval = time.Second
+	// times to check the order status after cancel, one second each
+	finalChecks = 5
+)
+
+// state of the position of one symbol
+const (
+	stateIdle    = "idle"    // no position, no order
+	stateEntry   = "entry"   // maker buy order is placed
+	stateHolding = "holding" // holding the coin with the maker take profit order
+)
+
+// exit reasons
+const (
+	exitNone       = ""
+	exitTakeProfit = "take profit"
+	exitStopLoss   = "stop loss"
+	exitTimeout    = "max hold"
+	exitSignal     = "sell signal"
+)
+
+type position struct {
+	Symbol     string    `bson:"symbol"`
+	State      string    `bson:"state"`
+	EntryOrder uint64    `bson:"entryOrder"`
+	ExitOrder  uint64    `bson:"exitOrder"` // take profit order
+	Price      string    `bson:"price"`     // filled price of the entry
+	Amount     string    `bson:"amount"`    // base currency held, fee deducted
+	Placed     time.Time `bson:"placed"`    // entry order placed
+	Opened     time.Time `bson:"opened"`    // entry order filled
+	Closed     time.Time `bson:"closed"`    // last exit, for cooldown
+	Profit     string    `bson:"profit"`    // accumulated profit in quote currency
+	Trades     int       `bson:"trades"`    // accumulated round trips
+}
+
+// daily count the entries of the day
+type daily struct {
+	Date   string `bson:"date"`
+	Trades int    `bson:"trades"`
+}
+
+func positionKey(symbol string) string {
+	return "position_" + symbol
+}
+
+func beijingDate(t time.Time) string {
+	beijing := time.FixedZone("Beijing Time", int((8 * time.Hour).Seconds()))
+	return t.In(beijing).Format("2006-01-02")
+}
+
+// startExecutor start a exchange executor service to place orders.
+func (r *Reaper) startExecutor(ctx context.Context) {
+	r.Sugar.Info("executor service started")
+	// last direction of every symbol
+	directions := make(map[string]exchange.Direction)
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.cfg.Strategy.Trade {
+				for _, symbol := range r.symbols {
+					r.check(ctx, symbol, nil)
+				}
+			}
+		case signal, ok := <-r.ch:
+			if !ok {
+				r.Sugar.Info("signal channel closed")
+				return
+			}
+			if signal.Direction != directions[signal.Symbol] {
+				directions[signal.Symbol] = signal.Direction
+				r.Sugar.Debugf("executor got signal: %s, %s, %f, %f%%", signal.Symbol, signal.Direction, signal.Price, signal.Score*100)
+			}
+			if r.cfg.Strategy.Trade {
+				r.check(ctx, signal.Symbol, &signal)
+			}
+		}
+	}
+}
+
+func (r *Reaper) position(symbol string) *position {
+	p, ok := r.positions[symbol]
+	if !ok {
+		p = &position{Symbol: symbol, State: stateIdle}
+		r.positions[symbol] = p
+	}
+	return p
+}
+
+// check drive the state machine of the symbol, signal is nil on the timer
+func (r *Reaper) check(ctx context.Context, symbol string, signal *Signal) {
+	p := r.position(symbol)
+	switch p.State {
+	case stateIdle:
+		if signal != nil && r.canEnter(symbol, *signal, time.Now()) {
+			r.enter(ctx, p)
+		}
+	case stateEntry:
+		r.checkEntry(ctx, p)
+	case stateHolding:
+		r.checkHolding(ctx, p, signal)
+	}
+}
+
+// canEnter check the signal score, the cooldown, the max positions and the daily limit
+func (r *Reaper) canEnter(symbol string, signal Signal, now time.Time) bool {
+	s := r.cfg.Strategy
+	if signal.Direction != exchange.TradeDirectionBuy || signal.Score < s.Beacon.BuyScore {
+		return false
+	}
+	if now.Sub(r.position(symbol).Closed) < r.cooldown {
+		return false
+	}
+	if r.feed != nil && s.MinImbalance > 0 && r.feed.Features(symbol).Imbalance < s.MinImbalance {
+		return false
+	}
+	if r.openPositions() >= s.MaxPositions {
+		return false
+	}
+	if r.daily.Date == beijingDate(now) && s.MaxDaily > 0 && r.daily.Trades >= s.MaxDaily {
+		return false
+	}
+	return true
+}
+
+func (r *Reaper) openPositions() int {
+	n := 0
+	for _, p := range r.positions {
+		if p.State != stateIdle {
+			n++
+		}
+	}
+	return n
+}
+
+// tick is the min price change of the symbol
+func (r *Reaper) tick(symbol string) decimal.Decimal {
+	return decimal.New(1, -r.info[symbol].PricePrecision)
+}
+
+// bestBid return the best bid, so the buy order is a maker order
+func (r *Reaper) bestBid(symbol string) (decimal.Decimal, error) {
+	hb := new(client.MarketClient).Init(r.ex.Host)
+	t, err := hb.GetLast24hCandlestickAskBid(symbol)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if len(t.Bid) == 0 || !t.Bid[0].IsPositive() {
+		return decimal.Zero, fmt.Errorf("no bid of %s", symbol)
+	}
+	return t.Bid[0], nil
+}
+
+func (r *Reaper) enter(ctx context.Context, p *position) {
+	symbol := r.info[p.Symbol]
+	price, err := r.bestBid(p.Symbol)
+	if err != nil {
+		r.Sugar.Errorf("get best bid error: %s", err)
+		return
+	}
+	amount := decimal.NewFromFloat(r.cfg.Strategy.Total).Div(price).Truncate(symbol.AmountPrecision)
+	if amount.LessThan(symbol.LimitOrderMinAmount) || amount.Mul(price).LessThan(symbol.MinTotal) {
+		r.Sugar.Errorf("total %f is too small to buy %s at %s", r.cfg.Strategy.Total, p.Symbol, price)
+		return
+	}
+	clientId, err := r.clientOrderId(ctx, p.Symbol, executor.SideBuy, executor.IntentEntry)
+	if err != nil {
+		r.Sugar.Error(err)
+		return
+	}
+	orderId, err := r.ex.BuyLimit(p.Symbol, clientId, price, amount)
+	if err != nil || orderId == 0 {
+		r.Sugar.Errorf("place entry order error: %v", err)
+		return
+	}
+	r.Sugar.Infof("entry order %d / %s placed, %s price: %s, amount: %s", orderId, clientId, p.Symbol, price, amount)
+	p.State = stateEntry
+	p.EntryOrder = orderId
+	p.Placed = time.Now()
+	r.countDaily(ctx)
+	r.saveState(ctx, p)
+}
+
+// checkEntry wait the entry order filled, cancel it after timeout. The filled part is held with take profit order.
+func (r *Reaper) checkEntry(ctx context.Context, p *position) {
+	o, err := r.ex.GetOrderById(p.EntryOrder, p.Symbol)
+	if err != nil {
+		r.Sugar.Errorf("get entry order %d error: %s", p.EntryOrder, err)
+		return
+	}
+	finished := o.Status == "filled" || o.Status == "canceled" || o.Status == "partial-canceled"
+	if !finished {
+		if time.Since(p.Placed) < r.timeout {
+			return
+		}
+		if err := r.ex.CancelOrder(p.Symbol, p.EntryOrder); err != nil {
+			r.Sugar.Errorf("cancel entry order %d error: %s", p.EntryOrder, err)
+		}
+		// check again after cancelled
+		return
+	}
+	symbol := r.info[p.Symbol]
+	amount := o.FilledAmount.Mul(decimal.NewFromInt(1).Sub(r.fee[p.Symbol].ActualMaker)).Truncate(symbol.AmountPrecision)
+	if amount.LessThan(symbol.LimitOrderMinAmount) {
+		r.Sugar.Infof("entry order %d is not filled (%s), back to idle", p.EntryOrder, o.FilledAmount)
+		p.State = stateIdle
+		p.EntryOrder = 0
+		r.saveState(ctx, p)
+		return
+	}
+	price := o.FilledPrice
+	if !price.IsPositive() {
+		price = o.Price
+	}
+	p.State = stateHolding
+	p.Price = price.String()
+	p.Amount = amount.String()
+	p.Opened = time.Now()
+	target := price.Add(r.tick(p.Symbol).Mul(decimal.NewFromInt(int64(r.cfg.Strategy.TakeProfit))))
+	if clientId, err := r.clientOrderId(ctx, p.Symbol, executor.SideSell, executor.IntentTakeProfit); err != nil {
+		r.Sugar.Errorf("place take profit order error: %s", err)
+	} else if orderId, err := r.ex.SellLimit(p.Symbol, clientId, target, amount); err != nil {
+		r.Sugar.Errorf("place take profit order error: %s", err)
+	} else {
+		p.ExitOrder = orderId
+		r.Sugar.Infof("take profit order %d / %s placed, price: %s, amount: %s", orderId, clientId, target, amount)
+	}
+	r.saveState(ctx, p)
+	r.Broadcast(p.Symbol, "买入 %s @ %s，止盈价 %s", amount, price, target)
+}
+
+// checkHolding check the take profit order, or exit at market on stop loss, max hold time and strong sell signal
+func (r *Reaper) checkHolding(ctx context.Context, p *position, signal *Signal) {
+	entry, _ := decimal.NewFromString(p.Price)
+	amount, _ := decimal.NewFromString(p.Amount)
+	var exit exchange.Order
+	if p.ExitOrder != 0 {
+		o, err := r.ex.GetOrderById(p.ExitOrder, p.Symbol)
+		if err != nil {
+			r.Sugar.Errorf("get take profit order %d error: %s", p.ExitOrder, err)
+			return
+		}
+		if o.Status == "filled" {
+			r.closed(ctx, p, exitTakeProfit, entry, o.Price, amount)
+			return
+		}
+		exit = o
+	}
+	last := decimal.NewFromFloat(r.beacons[p.Symbol].Last())
+	reason := exitReason(entry, last, r.tick(p.Symbol), r.cfg.Strategy.StopLoss, time.Since(p.Opened), r.maxHold,
+		signal, r.cfg.Strategy.Beacon.SellScore)
+	if reason == exitNone {
+		return
+	}
+	if p.ExitOrder != 0 {
+		// it may be cancelled in the last check, but not finished then
+		if !executor.OrderFinished(exit.Status) {
+			if err := r.ex.CancelOrder(p.Symbol, p.ExitOrder); err != nil {
+				r.Sugar.Errorf("cancel take profit order %d error: %s", p.ExitOrder, err)
+				return
+			}
+		}
+		// the filled amount is final only after the cancel is done
+		o, err := r.finalOrder(ctx, p.Symbol, p.ExitOrder)
+		if err != nil {
+			r.Sugar.Errorf("get take profit order %d error: %s", p.ExitOrder, err)
+			return
+		}
+		if o.Status == "filled" {
+			r.closed(ctx, p, exitTakeProfit, entry, o.Price, amount)
+			return
+		}
+		// the partly filled amount is sold already
+		if o.FilledAmount.IsPositive() {
+			p.Profit = addDecimal(p.Profit, o.Price.Sub(entry).Mul(o.FilledAmount))
+			amount = amount.Sub(o.FilledAmount)
+		}
+		p.ExitOrder = 0
+	}
+	symbol := r.info[p.Symbol]
+	amount = amount.Truncate(symbol.AmountPrecision)
+	if amount.GreaterThanOrEqual(symbol.LimitOrderMinAmount) {
+		clientId, err := r.clientOrderId(ctx, p.Symbol, executor.SideSell, executor.IntentStop)
+		if err == nil {
+			_, err = r.ex.SellMarket(symbol, clientId, amount)
+		}
+		if err != nil {
+			r.Sugar.Errorf("sell at market error: %s", err)
+			p.Amount = amount.String()
+			r.saveState(ctx, p)
+			return
+		}
+	}
+	r.closed(ctx, p, reason, entry, last, amount)
+}
+
+// finalOrder wait the cancelled order to be finished, check finalChecks times, one second each
+func (r *Reaper) finalOrder(ctx context.Context, symbol string, orderId uint64) (o exchange.Order, err error) {
+	for i := 0; i < finalChecks; i++ {
+		o, err = r.ex.GetOrderById(orderId, symbol)
+		if err == nil && executor.OrderFinished(o.Status) {
+			return o, nil
+		}
+		select {
+		case <-ctx.Done():
+			return o, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	if err != nil {
+		return o, err
+	}
+	return o, fmt.Errorf("order %d is not finished after cancel, status: %s", orderId, o.Status)
+}
+
+// exitReason decide to exit at market or not, the take profit is done by the limit order
+func exitReason(entry, last, tick decimal.Decimal, stopLoss int, held, maxHold time.Duration, signal *Signal, sellScore float64) string {
+	if last.IsPositive() && last.LessThanOrEqual(entry.Sub(tick.Mul(decimal.NewFromInt(int64(stopLoss))))) {
+		return exitStopLoss
+	}
+	if held >= maxHold {
+		return exitTimeout
+	}
+	if signal != nil && sellScore > 0 && signal.Direction == exchange.TradeDirectionSell && signal.Score >= sellScore {
+		return exitSignal
+	}
+	return exitNone
+}
+
+func (r *Reaper) closed(ctx context.Context, p *position, reason string, entry, price, amount decimal.Decimal) {
+	profit := price.Sub(entry).Mul(amount)
+	p.Profit = addDecimal(p.Profit, profit)
+	p.Trades++
+	p.State = stateIdle
+	p.EntryOrder = 0
+	p.ExitOrder = 0
+	p.Price = ""
+	p.Amount = ""
+	p.Closed = time.Now()
+	r.saveState(ctx, p)
+	r.Broadcast(p.Symbol, "平仓(%s) %s @ %s，盈亏 %s，累计 %s", reason, amount, price, profit.Round(4), p.Profit)
+}
+
+func addDecimal(s string, d decimal.Decimal) string {
+	v, _ := decimal.NewFromString(s)
+	return v.Add(d).String()
+}
+
+func (r *Reaper) countDaily(ctx context.Context) {
+	today := beijingDate(time.Now())
+	if r.daily.Date != today {
+		r.daily = daily{Date: today}
+	}
+	r.daily.Trades++
+	if err := hs.SaveKey(ctx, r.db.Collection(collNameState), "daily", r.daily); err != nil {
+		r.Sugar.Errorf("save daily error: %s", err)
+	}
+}
+
+func (r *Reaper) clientOrderId(ctx context.Context, symbol string, side byte, intent executor.Intent) (string, error) {
+	sequence, err := r.sequence.Next(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get sequence error: %w", err)
+	}
+	id, err := r.codec[symbol].Encode(side, intent, -1, sequence)
+	if err != nil {
+		return "", fmt.Errorf("encode client order id error: %w", err)
+	}
+	return id, nil
+}
+
+func (r *Reaper) loadState(ctx context.Context) {
+	coll := r.db.Collection(collNameState)
+	for _, symbol := range r.symbols {
+		p := &position{}
+		if err := hs.LoadKey(ctx, coll, positionKey(symbol), p); err != nil || p.State == "" {
+			continue
+		}
+		r.positions[symbol] = p
+		r.Sugar.Infof("%s position loaded, state: %s", symbol, p.State)
+	}
+	if err := hs.LoadKey(ctx, coll, "daily", &r.daily); err != nil {
+		r.Sugar.Infof("no daily loaded: %s", err)
+	}
+}
+
+func (r *Reaper) saveState(ctx context.Context, p *position) {
+	if err := hs.SaveKey(ctx, r.db.Collection(collNameState), positionKey(p.Symbol), p); err != nil {
+		r.Sugar.Errorf("save %s position error: %s", p.Symbol, err)
+	}
+}
+
+func (r *Reaper) Broadcast(symbol, format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	r.Sugar.Info(message)
+	labels := []string{r.cfg.Exchange.Name, r.cfg.Exchange.Label}
+	beijing := time.FixedZone("Beijing Time", int((8 * time.Hour).Seconds()))
+	timeStr := time.Now().In(beijing).Format("2006-01-02 15:04:05")
+	msg := fmt.Sprintf("%s [%s] [%s] [%s] %s", timeStr, labels[0], labels[1], symbol, message)
+	for _, robot := range r.robots {
+		if err := robot.SendText(msg); err != nil {
+			r.Sugar.Infof("broadcast error: %s", err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package reaper
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/flow"
+	"testing"
+	"time"
+)
+
+func TestBeaconSignal(t *testing.T) {
+	b := NewBeacon(BeaconConf{MinLength: 1, Window: 3})
+	add := func(prices ...float64) {
+		for i, p := range prices {
+			b.Timestamps = append(b.Timestamps, int64(len(b.Timestamps)+i))
+			b.Prices = append(b.Prices, p)
+			b.Amount = append(b.Amount, 1)
+		}
+	}
+	add(10, 10, 10)
+	if s := b.Signal(); s != nil {
+		t.Errorf("expect no signal with %d ticks, got %v", len(b.Prices), s)
+	}
+	// avg of 10, 10, 10 is 10
+	add(11)
+	s := b.Signal()
+	if s == nil || s.Direction != exchange.TradeDirectionBuy || s.Score != 0.1 {
+		t.Errorf("expect buy signal with score 0.1, got %v", s)
+	}
+	// avg of 10, 10, 11
+	add(9.5)
+	s = b.Signal()
+	if s == nil || s.Direction != exchange.TradeDirectionSell {
+		t.Errorf("expect sell signal, got %v", s)
+	}
+	if b.Last() != 9.5 {
+		t.Errorf("expect last 9.5, got %f", b.Last())
+	}
+}
+
+func TestNewBeacon(t *testing.T) {
+	b := NewBeacon(BeaconConf{})
+	if b.MaxLength != defaultMaxLength || b.MinLength != defaultMinLength || b.Window != defaultWindow {
+		t.Errorf("wrong defaults: %d %d %d", b.MaxLength, b.MinLength, b.Window)
+	}
+}
+
+func TestExitReason(t *testing.T) {
+	entry := decimal.NewFromInt(100)
+	tick := decimal.RequireFromString("0.01")
+	sell := &Signal{Direction: exchange.TradeDirectionSell, Score: 0.002}
+	tests := []struct {
+		last      string
+		held      time.Duration
+		signal    *Signal
+		sellScore float64
+		expect    string
+	}{
+		{"100.01", time.Minute, nil, 0, exitNone},
+		{"99.95", time.Minute, nil, 0, exitStopLoss},
+		{"99.96", time.Minute, nil, 0, exitNone},
+		{"0", time.Minute, nil, 0, exitNone},
+		{"100", 5 * time.Minute, nil, 0, exitTimeout},
+		{"100", time.Minute, sell, 0, exitNone},
+		{"100", time.Minute, sell, 0.001, exitSignal},
+		{"100", time.Minute, sell, 0.003, exitNone},
+	}
+	for i, tt := range tests {
+		got := exitReason(entry, decimal.RequireFromString(tt.last), tick, 5, tt.held, 5*time.Minute, tt.signal, tt.sellScore)
+		if got != tt.expect {
+			t.Errorf("case %d: expect %q, got %q", i, tt.expect, got)
+		}
+	}
+}
+
+func TestCanEnter(t *testing.T) {
+	now := time.Now()
+	r := New(Config{Strategy: StrategyConf{MaxPositions: 1, MaxDaily: 2, Beacon: BeaconConf{BuyScore: 0.001}}})
+	r.cooldown = time.Minute
+	buy := Signal{Direction: exchange.TradeDirectionBuy, Score: 0.002}
+	if !r.canEnter("a", buy, now) {
+		t.Error("expect enter")
+	}
+	if r.canEnter("a", Signal{Direction: exchange.TradeDirectionBuy, Score: 0.0005}, now) {
+		t.Error("expect no entry for low score")
+	}
+	if r.canEnter("a", Signal{Direction: exchange.TradeDirectionSell, Score: 0.01}, now) {
+		t.Error("expect no entry for sell signal")
+	}
+	r.position("a").Closed = now.Add(-30 * time.Second)
+	if r.canEnter("a", buy, now) {
+		t.Error("expect no entry in cooldown")
+	}
+	r.position("b").State = stateHolding
+	if r.canEnter("c", buy, now) {
+		t.Error("expect no entry over max positions")
+	}
+	r.position("b").State = stateIdle
+	r.daily = daily{Date: beijingDate(now), Trades: 2}
+	if r.canEnter("c", buy, now) {
+		t.Error("expect no entry over daily limit")
+	}
+	r.daily.Date = "2000-01-01"
+	if !r.canEnter("c", buy, now) {
+		t.Error("expect enter on a new day")
+	}
+
+	r.cfg.Strategy.MinImbalance = 0.2
+	r.feed, _ = flow.NewFeed(flow.Conf{}, nil)
+	r.feed.Update("c", []exchange.TradeDetail{
+		{Timestamp: 1, Price: decimal.NewFromInt(10), Amount: decimal.NewFromInt(1), Direction: exchange.TradeDirectionBuy},
+		{Timestamp: 2, Price: decimal.NewFromInt(10), Amount: decimal.NewFromInt(1), Direction: exchange.TradeDirectionSell},
+	})
+	if r.canEnter("c", buy, now) {
+		t.Error("expect no entry when the imbalance is low")
+	}
+	r.feed.Update("c", []exchange.TradeDetail{
+		{Timestamp: 3, Price: decimal.NewFromInt(10), Amount: decimal.NewFromInt(2), Direction: exchange.TradeDirectionBuy},
+	})
+	if !r.canEnter("c", buy, now) {
+		t.Error("expect enter when the buy volume dominates")
+	}
+}
@@ -3,49 +3,105 @@ package reaper
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/xyths/hs"
+	"github.com/xyths/hs/broadcast"
+	"github.com/xyths/hs/exchange"
 	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/executor"
+	"github.com/xyths/qtr/flow"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
+	"log"
+	"time"
 )
 
 type Config struct {
 	Exchange hs.ExchangeConf
 	Mongo    hs.MongoConf
+	Strategy StrategyConf
 	Log      hs.LogConf
 	Robots   []hs.BroadcastConf
 }
 
+type StrategyConf struct {
+	Name   string     // strategy instance name, encoded in client order id, default reaper
+	Beacon BeaconConf // signal of the tick stream
+	Trade  bool       // place orders on signals, only log the signals if false
+
+	Total        float64 // quote currency of every position
+	MaxPositions int     `json:"maxPositions"` // max symbols holding position at the same time, default 1
+	MaxDaily     int     `json:"maxDaily"`     // max entries per day (Beijing time), 0 means no limit
+
+	TakeProfit int `json:"takeProfit"` // ticks above the entry price, the maker take profit order is placed at it
+	StopLoss   int `json:"stopLoss"`   // ticks below the entry price to sell at market
+
+	Flow         *flow.Conf // order flow features of the trade stream, nil means disabled
+	MinImbalance float64    `json:"minImbalance"` // min buy/sell volume imbalance to open, only if Flow is set
+
+	Cooldown string // min interval after the exit to open again, default 30s
+	MaxHold  string `json:"maxHold"` // sell at market if held longer than it, default 5m
+	Timeout  string `json:"timeout"` // cancel the unfilled entry order after it, default 10s
+}
+
 type Reaper struct {
 	cfg Config
 
-	Sugar  *zap.SugaredLogger
-	db     *mongo.Database
-	ex     *huobi.Client
-	symbol string
+	Sugar   *zap.SugaredLogger
+	db      *mongo.Database
+	ex      *huobi.Client
+	symbols []string
+
+	ch      chan Signal
+	beacons map[string]*Beacon
+	feed    *flow.Feed
 
-	ch     chan Signal
-	beacon Beacon
+	robots    []broadcast.Broadcaster
+	cooldown  time.Duration
+	maxHold   time.Duration
+	timeout   time.Duration
+	info      map[string]exchange.Symbol
+	fee       map[string]exchange.Fee
+	codec     map[string]executor.ClientIdCodec
+	sequence  executor.Sequence
+	positions map[string]*position
+	daily     daily
 }
 
 func New(cfg Config) *Reaper {
 	return &Reaper{
-		cfg: cfg,
-		beacon: Beacon{
-			MaxLength: 2000,
-			MinLength: 200,
-		},
+		cfg:       cfg,
+		beacons:   make(map[string]*Beacon),
+		info:      make(map[string]exchange.Symbol),
+		fee:       make(map[string]exchange.Fee),
+		codec:     make(map[string]executor.ClientIdCodec),
+		positions: make(map[string]*position),
 	}
 }
 
 func (r *Reaper) Init(ctx context.Context) error {
-	r.symbol = r.cfg.Exchange.Symbols[0]
+	if len(r.cfg.Exchange.Symbols) == 0 {
+		return errors.New("no symbol in config")
+	}
+	// every symbol has its own beacon, share one exchange connection
+	r.symbols = r.cfg.Exchange.Symbols
+	for _, symbol := range r.symbols {
+		r.beacons[symbol] = NewBeacon(r.cfg.Strategy.Beacon)
+	}
+	if err := r.initStrategy(); err != nil {
+		return err
+	}
 	l, err := hs.NewZapLogger(r.cfg.Log)
 	if err != nil {
 		return err
 	}
 	r.Sugar = l.Sugar()
 	r.Sugar.Info("Logger initialized")
+	if r.cfg.Strategy.Flow != nil {
+		if r.feed, err = flow.NewFeed(*r.cfg.Strategy.Flow, r.Sugar); err != nil {
+			return err
+		}
+	}
 	db, err := hs.ConnectMongo(ctx, r.cfg.Mongo)
 	if err != nil {
 		return err
@@ -58,6 +114,7 @@ func (r *Reaper) Init(ctx context.Context) error {
 		//	return err
 		//}
 		r.Sugar.Info("should try gate v4 API here")
+		return errors.New("gate is not supported yet")
 	case "huobi":
 		r.ex, err = huobi.New(r.cfg.Exchange.Label, r.cfg.Exchange.Key, r.cfg.Exchange.Secret, r.cfg.Exchange.Host)
 		if err != nil {
@@ -69,22 +126,39 @@ func (r *Reaper) Init(ctx context.Context) error {
 		return errors.New("unsupported exchange")
 	}
 	r.Sugar.Info("Exchange initialized")
-	//r.Sugar.Infof(
-	//	"Symbol: %s, PricePrecision: %d, AmountPrecision: %d, MinAmount: %s, MinTotal: %s",
-	//	r.Symbol(),
-	//	r.PricePrecision(), r.AmountPrecision(),
-	//	r.MinAmount(), r.MinTotal(),
-	//)
-	//r.Sugar.Infof(
-	//	"BaseMakerFee: %s, BaseTakerFee: %s, ActualMakerFee: %s, ActualTakerFee: %s",
-	//	r.BaseMakerFee(), r.BaseTakerFee(),
-	//	r.ActualMakerFee(), r.ActualTakerFee(),
-	//)
+	for _, symbol := range r.symbols {
+		if r.info[symbol], err = r.ex.GetSymbol(ctx, symbol); err != nil {
+			return err
+		}
+		if r.fee[symbol], err = r.ex.GetFee(symbol); err != nil {
+			return err
+		}
+		r.codec[symbol] = executor.NewClientIdCodec(r.cfg.Exchange.Name, r.cfg.Strategy.Name, symbol)
+		r.Sugar.Infof(
+			"Symbol: %s, PricePrecision: %d, AmountPrecision: %d, MinAmount: %s, MinTotal: %s, ActualMakerFee: %s, ActualTakerFee: %s",
+			symbol, r.info[symbol].PricePrecision, r.info[symbol].AmountPrecision,
+			r.info[symbol].LimitOrderMinAmount, r.info[symbol].MinTotal,
+			r.fee[symbol].ActualMaker, r.fee[symbol].ActualTaker,
+		)
+	}
+	for _, conf := range r.cfg.Robots {
+		r.robots = append(r.robots, broadcast.New(conf))
+	}
+	r.sequence.Init(r.db.Collection(collNameState), "sequence")
+	if err := r.sequence.Load(ctx); err != nil {
+		return err
+	}
+	r.loadState(ctx)
 	r.Sugar.Info("reaper initialized")
 	return nil
 }
 
 func (r *Reaper) Close(ctx context.Context) {
+	if r.feed != nil {
+		if err := r.feed.Close(); err != nil {
+			r.Sugar.Errorf("close flow feed error: %s", err)
+		}
+	}
 	r.Sugar.Info("reaper closed")
 }
 
@@ -103,12 +177,67 @@ func (r *Reaper) Stop(ctx context.Context) {
 	r.Sugar.Info("reaper stopped")
 }
 
+// initStrategy check the strategy config and set the defaults
+func (r *Reaper) initStrategy() (err error) {
+	s := &r.cfg.Strategy
+	if s.Name == "" {
+		s.Name = "reaper"
+	}
+	if s.MaxPositions <= 0 {
+		s.MaxPositions = 1
+	}
+	if s.Trade && (s.Total <= 0 || s.TakeProfit <= 0 || s.StopLoss <= 0) {
+		return errors.New("total, takeProfit and stopLoss must be positive when trade")
+	}
+	if r.cooldown, err = parseDuration(s.Cooldown, 30*time.Second); err != nil {
+		return
+	}
+	if r.maxHold, err = parseDuration(s.MaxHold, 5*time.Minute); err != nil {
+		return
+	}
+	r.timeout, err = parseDuration(s.Timeout, 10*time.Second)
+	return
+}
+
+func parseDuration(s string, d time.Duration) (time.Duration, error) {
+	if s == "" {
+		return d, nil
+	}
+	return time.ParseDuration(s)
+}
+
 func (r *Reaper) Print(ctx context.Context) error {
-	r.Sugar.Info("reaper print")
+	log.Printf("daily entries: %d (%s)", r.daily.Trades, r.daily.Date)
+	for _, symbol := range r.symbols {
+		p := r.position(symbol)
+		log.Printf(`%s: %s
+	entry order: %d, exit order: %d
+	price: %s, amount: %s, opened: %s
+	profit: %s, trades: %d`,
+			symbol, p.State, p.EntryOrder, p.ExitOrder, p.Price, p.Amount, p.Opened, p.Profit, p.Trades)
+	}
 	return nil
 }
 
+// Clear cancel the pending orders and delete the states, the coin held is kept
 func (r *Reaper) Clear(ctx context.Context) error {
-	r.Sugar.Info("reaper clear")
+	for _, symbol := range r.symbols {
+		p := r.position(symbol)
+		for _, orderId := range []uint64{p.EntryOrder, p.ExitOrder} {
+			if orderId == 0 {
+				continue
+			}
+			if err := r.ex.CancelOrder(symbol, orderId); err != nil {
+				r.Sugar.Errorf("cancel order %d error: %s", orderId, err)
+			}
+		}
+		if err := hs.DeleteKey(ctx, r.db.Collection(collNameState), positionKey(symbol)); err != nil {
+			return fmt.Errorf("delete %s state error: %w", symbol, err)
+		}
+	}
+	if err := hs.DeleteKey(ctx, r.db.Collection(collNameState), "daily"); err != nil {
+		return err
+	}
+	r.Sugar.Info("reaper state cleared")
 	return nil
 }
@@ -1,64 +1,75 @@
 package reaper
 
 import (
-	"context"
 	"github.com/xyths/hs/exchange"
 	"log"
 	"sync"
 )
 
-// startExecutor start a exchange executor service to place orders.
-func (r *Reaper) startExecutor(ctx context.Context) {
-	r.Sugar.Info("executor service started")
-	var direction exchange.Direction
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case signal, ok := <-r.ch:
-			if !ok {
-				r.Sugar.Info("signal channel closed")
-				return
-			}
-			if signal.Direction != direction {
-				direction = signal.Direction
-				r.Sugar.Debugf("executor got signal: %s, %f, %f%%", direction, signal.Price, signal.Score*100)
-			}
-		}
+func (r *Reaper) subscribeTrade() {
+	for _, symbol := range r.symbols {
+		r.ex.SubscribeTrade(symbol, "reaper", r.tradeHandler(symbol))
+		r.Sugar.Infof("subscribe to %s's trade history", symbol)
 	}
 }
 
-func (r *Reaper) subscribeTrade() {
-	r.ex.SubscribeTrade(r.symbol, "reaper", r.handleTradeUpdate)
-	r.Sugar.Infof("subscribe to %s's trade history", r.symbol)
+func (r *Reaper) unsubscribeTrade() {
+	for _, symbol := range r.symbols {
+		r.ex.UnsubscribeTrade(symbol, "reaper")
+		r.Sugar.Infof("unsubscribe to %s's trade history", symbol)
+	}
 }
 
-func (r *Reaper) unsubscribeTrade() {
-	r.ex.UnsubscribeTrade(r.symbol, "reaper")
-	r.Sugar.Infof("unsubscribe to %s's trade history", r.symbol)
+// tradeHandler return the trade handler of symbol, the trade detail has no symbol in it
+func (r *Reaper) tradeHandler(symbol string) func([]exchange.TradeDetail) {
+	return func(trades []exchange.TradeDetail) {
+		r.handleTradeUpdate(symbol, trades)
+	}
 }
 
-func (r *Reaper) handleTradeUpdate(trades []exchange.TradeDetail) {
+func (r *Reaper) handleTradeUpdate(symbol string, trades []exchange.TradeDetail) {
 	//for _, t := range trades {
 	//	r.Sugar.Debugf("frame:%d,%s,%s", t.Timestamp, t.Price.String(), t.Amount)
 	//}
+	beacon := r.beacons[symbol]
 	// add trades
-	r.beacon.Add(trades)
+	beacon.Add(trades)
+	if r.feed != nil {
+		r.feed.Update(symbol, trades)
+	}
 	// test signal
-	if signal := r.beacon.Signal(); signal != nil {
-		r.Sugar.Debugf("got signal: %s, %f, %f%%", signal.Direction, signal.Price, signal.Score*100)
-		r.ch <- *signal
+	if signal := beacon.Signal(); signal != nil {
+		signal.Symbol = symbol
+		r.Sugar.Debugf("got signal: %s, %s, %f, %f%%", symbol, signal.Direction, signal.Price, signal.Score*100)
+		// don't block the trade stream when the executor is busy
+		select {
+		case r.ch <- *signal:
+		default:
+			r.Sugar.Debugf("executor is busy, signal dropped")
+		}
 	}
 }
 
 const (
-	defaultMinLength = 6
-	window           = 5
+	defaultMaxLength = 2000
+	defaultMinLength = 200
+	defaultWindow    = 5
 )
 
+// BeaconConf config the signal of the tick stream
+type BeaconConf struct {
+	MaxLength int // max ticks kept, default 2000
+	MinLength int // min ticks before any signal, default 200
+	Window    int // ticks before the latest one to average, default 5
+
+	BuyScore  float64 `json:"buyScore"`  // min score of the buy signal to open the position, eg. 0.001 is 0.1%
+	SellScore float64 `json:"sellScore"` // min score of the sell signal to close the position early, 0 means never
+}
+
 type Beacon struct {
 	MaxLength int
 	MinLength int
+	Window    int
 
 	lock       sync.RWMutex
 	Timestamps []int64
@@ -66,28 +77,50 @@ type Beacon struct {
 	Amount     []float64
 }
 
+func NewBeacon(conf BeaconConf) *Beacon {
+	b := &Beacon{
+		MaxLength: conf.MaxLength,
+		MinLength: conf.MinLength,
+		Window:    conf.Window,
+	}
+	if b.MaxLength <= 0 {
+		b.MaxLength = defaultMaxLength
+	}
+	if b.MinLength <= 0 {
+		b.MinLength = defaultMinLength
+	}
+	// the average without the second latest needs 1 tick at least
+	if b.Window < 2 {
+		b.Window = defaultWindow
+	}
+	return b
+}
+
 type Signal struct {
+	Symbol    string
 	Direction exchange.Direction
 	Price     float64
 	Score     float64
 }
 
+// Signal compare the latest tick with the average of the Window ticks before it (avg1),
+// and the average without the second latest tick (avg2).
 func (b *Beacon) Signal() *Signal {
 	b.lock.RLock()
 	defer b.lock.RUnlock()
 
 	l := len(b.Timestamps)
-	if l < b.MinLength || l < defaultMinLength {
+	if l < b.MinLength || l < b.Window+1 {
 		return nil
 	}
 
 	var avg1, avg2 float64
-	for i := l - defaultMinLength; i < l-2; i++ {
+	for i := l - b.Window - 1; i < l-2; i++ {
 		avg2 += b.Prices[i]
 	}
 	avg1 = avg2 + b.Prices[l-2]
-	avg1 /= defaultMinLength - 1
-	avg2 /= defaultMinLength - 2
+	avg1 /= float64(b.Window)
+	avg2 /= float64(b.Window - 1)
 	latest := b.Prices[l-1]
 	second := b.Prices[l-2]
 	if latest > avg1 || (latest > second && latest > avg2) {
@@ -107,6 +140,16 @@ func (b *Beacon) Signal() *Signal {
 	}
 }
 
+// Last return the latest tick price, 0 if no tick
+func (b *Beacon) Last() float64 {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if len(b.Prices) == 0 {
+		return 0
+	}
+	return b.Prices[len(b.Prices)-1]
+}
+
 func (b *Beacon) Add(trades []exchange.TradeDetail) {
 	b.lock.Lock()
 	defer b.lock.Unlock()
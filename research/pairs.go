@@ -0,0 +1,34 @@
+package research
+
+import (
+	"fmt"
+	"github.com/xyths/qtr/trader/rest/pairs"
+	"os"
+)
+
+// Pairs backtest the pairs trading on two csv (timestamp,open,high,low,close with header), y is traded against x
+func (r *Research) Pairs(inputY, inputX string, conf pairs.BacktestConf, output string) error {
+	tsY, _, _, _, closeY := readData(inputY, true)
+	tsX, _, _, _, closeX := readData(inputX, true)
+	// skip the header
+	ts, y, x := pairs.Align(tsY[1:], closeY[1:], tsX[1:], closeX[1:])
+	r.Sugar.Infof("%d candles aligned", len(ts))
+	result := pairs.Backtest(ts, y, x, conf)
+	r.Sugar.Infof("trades: %d, final: %f, return: %f, max drawdown: %f",
+		len(result.Trades), result.Final, result.Return, result.MaxDrawdown)
+	return writePairsResult(result.Trades, output)
+}
+
+func writePairsResult(trades []pairs.Trade, output string) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, "Time,Position,Z,Beta,PriceY,PriceX,Equity")
+	for _, t := range trades {
+		fmt.Fprintf(f, "%d,%d,%f,%f,%f,%f,%f\n", t.Timestamp, t.Position, t.Z, t.Beta, t.PriceY, t.PriceX, t.Equity)
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"github.com/markcheno/go-talib"
+	indicator "github.com/xyths/go-indicators"
+	"github.com/xyths/hs"
+	"time"
+)
+
+type kind int
+
+const (
+	kindNumber kind = iota
+	kindBool
+)
+
+func (k kind) String() string {
+	if k == kindBool {
+		return "condition"
+	}
+	return "number"
+}
+
+// node is evaluated at the closed bar shift bars before the last closed one, the condition is 1 or 0
+type node interface {
+	kind() kind
+	eval(e *env, shift int) (float64, error)
+}
+
+type numberNode float64
+
+func (n numberNode) kind() kind {
+	return kindNumber
+}
+
+func (n numberNode) eval(_ *env, _ int) (float64, error) {
+	return float64(n), nil
+}
+
+type boolNode bool
+
+func (n boolNode) kind() kind {
+	return kindBool
+}
+
+func (n boolNode) eval(_ *env, _ int) (float64, error) {
+	return boolValue(bool(n)), nil
+}
+
+type notNode struct {
+	x node
+}
+
+func (n *notNode) kind() kind {
+	return kindBool
+}
+
+func (n *notNode) eval(e *env, shift int) (float64, error) {
+	v, err := n.x.eval(e, shift)
+	if err != nil {
+		return 0, err
+	}
+	return boolValue(v == 0), nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func newBinary(op string, left, right node) (node, error) {
+	want := kindNumber
+	if op == "&&" || op == "||" {
+		want = kindBool
+	}
+	if left.kind() != want || right.kind() != want {
+		return nil, fmt.Errorf("'%s' need two %ss, got %s and %s", op, want, left.kind(), right.kind())
+	}
+	return &binaryNode{op: op, left: left, right: right}, nil
+}
+
+func (n *binaryNode) kind() kind {
+	switch n.op {
+	case "+", "-", "*", "/":
+		return kindNumber
+	}
+	return kindBool
+}
+
+func (n *binaryNode) eval(e *env, shift int) (float64, error) {
+	l, err := n.left.eval(e, shift)
+	if err != nil {
+		return 0, err
+	}
+	// short circuit, so the right side need no data
+	if n.op == "&&" && l == 0 {
+		return 0, nil
+	}
+	if n.op == "||" && l != 0 {
+		return 1, nil
+	}
+	r, err := n.right.eval(e, shift)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case "&&", "||":
+		return boolValue(r != 0), nil
+	case "==":
+		return boolValue(l == r), nil
+	case "!=":
+		return boolValue(l != r), nil
+	case ">":
+		return boolValue(l > r), nil
+	case ">=":
+		return boolValue(l >= r), nil
+	case "<":
+		return boolValue(l < r), nil
+	case "<=":
+		return boolValue(l <= r), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return 0, errors.New("divided by zero")
+		}
+		return l / r, nil
+	}
+	return 0, fmt.Errorf("unknown operator '%s'", n.op)
+}
+
+// crossNode is true when a cross b at the last closed bar,
+// crossover means a > b now and a <= b at the bar before, crossunder is the opposite.
+type crossNode struct {
+	over bool
+	a, b node
+}
+
+func (n *crossNode) kind() kind {
+	return kindBool
+}
+
+func (n *crossNode) eval(e *env, shift int) (float64, error) {
+	var v [2][2]float64 // [shift][a, b]
+	for i := 0; i < 2; i++ {
+		var err error
+		if v[i][0], err = n.a.eval(e, shift+i); err != nil {
+			return 0, err
+		}
+		if v[i][1], err = n.b.eval(e, shift+i); err != nil {
+			return 0, err
+		}
+	}
+	if n.over {
+		return boolValue(v[0][0] > v[0][1] && v[1][0] <= v[1][1]), nil
+	}
+	return boolValue(v[0][0] < v[0][1] && v[1][0] >= v[1][1]), nil
+}
+
+// series is the value of an indicator at every bar, the values before warmup are invalid
+type series struct {
+	length int
+	warmup int
+	at     func(i int) float64
+}
+
+type callNode struct {
+	fn     *function
+	period time.Duration
+	args   []float64
+	field  string
+}
+
+func (n *callNode) kind() kind {
+	return n.fn.fields[n.field]
+}
+
+func (n *callNode) eval(e *env, shift int) (float64, error) {
+	s, err := e.get(n)
+	if err != nil {
+		return 0, err
+	}
+	// the last bar is forming
+	i := s.length - 2 - shift
+	if i < s.warmup || i < 0 {
+		return 0, ErrNotEnoughData
+	}
+	return s.at(i), nil
+}
+
+type function struct {
+	defaults []float64       // default value of the arguments after period, the count is the max arguments
+	required int             // count of the required arguments after period
+	fields   map[string]kind // the first field is "" for the default one
+	compute  func(c hs.Candle, args []float64, field string) series
+}
+
+var functions map[string]*function
+
+func init() {
+	price := func(get func(c hs.Candle) []float64) *function {
+		return &function{
+			fields: map[string]kind{"": kindNumber},
+			compute: func(c hs.Candle, args []float64, field string) series {
+				values := get(c)
+				return series{length: len(values), at: func(i int) float64 { return values[i] }}
+			},
+		}
+	}
+	closeIndicator := func(f func(in []float64, n int) []float64) *function {
+		return &function{
+			defaults: []float64{14},
+			required: 1,
+			fields:   map[string]kind{"": kindNumber},
+			compute: func(c hs.Candle, args []float64, field string) series {
+				n := int(args[0])
+				if c.Length() <= n {
+					return series{length: c.Length(), warmup: c.Length()}
+				}
+				values := f(c.Close, n)
+				return series{length: len(values), warmup: n, at: func(i int) float64 { return values[i] }}
+			},
+		}
+	}
+	rangeIndicator := func(f func(h, l, c []float64, n int) []float64) *function {
+		return &function{
+			defaults: []float64{14},
+			required: 1,
+			fields:   map[string]kind{"": kindNumber},
+			compute: func(c hs.Candle, args []float64, field string) series {
+				n := int(args[0])
+				if c.Length() <= n {
+					return series{length: c.Length(), warmup: c.Length()}
+				}
+				values := f(c.High, c.Low, c.Close, n)
+				return series{length: len(values), warmup: n, at: func(i int) float64 { return values[i] }}
+			},
+		}
+	}
+	functions = map[string]*function{
+		"open":   price(func(c hs.Candle) []float64 { return c.Open }),
+		"high":   price(func(c hs.Candle) []float64 { return c.High }),
+		"low":    price(func(c hs.Candle) []float64 { return c.Low }),
+		"close":  price(func(c hs.Candle) []float64 { return c.Close }),
+		"volume": price(func(c hs.Candle) []float64 { return c.Volume }),
+		"ema":    closeIndicator(talib.Ema),
+		"sma":    closeIndicator(talib.Sma),
+		"rsi":    closeIndicator(talib.Rsi),
+		"atr":    rangeIndicator(talib.Atr),
+		"natr":   rangeIndicator(talib.Natr),
+		// supertrend(period, factor, period)
+		"supertrend": {
+			defaults: []float64{3, 7},
+			required: 2,
+			fields:   map[string]kind{"": kindBool, "up": kindBool, "down": kindBool, "value": kindNumber},
+			compute:  superTrend,
+		},
+		// squeeze(period[, bbl, bbf, kcl, kcf]), the trend is 1 squeeze, 2 up, -2 down, 0 stop
+		"squeeze": {
+			defaults: []float64{20, 2, 20, 1.5},
+			fields:   map[string]kind{"": kindNumber, "trend": kindNumber, "value": kindNumber, "on": kindBool},
+			compute:  squeeze,
+		},
+	}
+}
+
+func newCall(name string, period time.Duration, args []node, field string) (node, error) {
+	if name == "crossover" || name == "crossunder" {
+		if period != 0 || len(args) != 2 || field != "" {
+			return nil, fmt.Errorf("usage: %s(a, b)", name)
+		}
+		if args[0].kind() != kindNumber || args[1].kind() != kindNumber {
+			return nil, fmt.Errorf("%s need two numbers", name)
+		}
+		return &crossNode{over: name == "crossover", a: args[0], b: args[1]}, nil
+	}
+	fn, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s'", name)
+	}
+	if period == 0 {
+		return nil, fmt.Errorf("%s need period as the first argument", name)
+	}
+	if len(args) < fn.required || len(args) > len(fn.defaults) {
+		return nil, fmt.Errorf("%s need %d-%d arguments after period, got %d", name, fn.required, len(fn.defaults), len(args))
+	}
+	if _, ok := fn.fields[field]; !ok {
+		return nil, fmt.Errorf("%s has no field '%s'", name, field)
+	}
+	values := append([]float64{}, fn.defaults...)
+	for i, arg := range args {
+		v, ok := arg.(numberNode)
+		if !ok {
+			return nil, fmt.Errorf("the arguments of %s must be numbers", name)
+		}
+		values[i] = float64(v)
+	}
+	return &callNode{fn: fn, period: period, args: values, field: field}, nil
+}
+
+// takePeriod report whether the first argument of function name is period
+func takePeriod(name string) bool {
+	_, ok := functions[name]
+	return ok
+}
+
+func superTrend(c hs.Candle, args []float64, field string) series {
+	factor, period := args[0], int(args[1])
+	if c.Length() <= period+1 {
+		return series{length: c.Length(), warmup: c.Length()}
+	}
+	tsl, trend := indicator.SuperTrend(factor, period, c.High, c.Low, c.Close)
+	s := series{length: len(trend), warmup: period}
+	switch field {
+	case "value":
+		s.at = func(i int) float64 { return tsl[i] }
+	case "down":
+		s.at = func(i int) float64 { return boolValue(!trend[i]) }
+	default:
+		s.at = func(i int) float64 { return boolValue(trend[i]) }
+	}
+	return s
+}
+
+func squeeze(c hs.Candle, args []float64, field string) series {
+	bbl, bbf, kcl, kcf := int(args[0]), args[1], int(args[2]), args[3]
+	warmup := bbl
+	if kcl > warmup {
+		warmup = kcl
+	}
+	if c.Length() <= warmup+2 {
+		return series{length: c.Length(), warmup: c.Length()}
+	}
+	_, d := indicator.Squeeze(bbl, kcl, bbf, kcf, c.High, c.Low, c.Close)
+	s := series{length: c.Length(), warmup: warmup + 1}
+	switch field {
+	case "value":
+		s.at = func(i int) float64 { return d.Value[i] }
+	case "on":
+		s.at = func(i int) float64 { return boolValue(d.SqueezeOn[i]) }
+	default:
+		// Summary take the second last as current
+		s.at = func(i int) float64 { return float64(indicator.Summary(headDetail(d, i+2)).Trend) }
+	}
+	return s
+}
+
+func headDetail(d indicator.Detail, n int) indicator.Detail {
+	return indicator.Detail{
+		Value:      d.Value[:n],
+		SqueezeOn:  d.SqueezeOn[:n],
+		SqueezeOff: d.SqueezeOff[:n],
+		NoSqueeze:  d.NoSqueeze[:n],
+		KCUpper:    d.KCUpper[:n],
+		KCMiddle:   d.KCMiddle[:n],
+		KCLower:    d.KCLower[:n],
+		BBUpper:    d.BBUpper[:n],
+		BBMiddle:   d.BBMiddle[:n],
+		BBLower:    d.BBLower[:n],
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,357 @@
+package rule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenOp // operators and punctuations
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex split the rule text into tokens.
+// Ident may start with digit, for the periods like 4H, 15m.
+func lex(text string) ([]token, error) {
+	var tokens []token
+	rs := []rune(text)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1]):
+			start := i
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])) {
+				i++
+			}
+			kind := tokenNumber
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_') {
+				kind = tokenIdent
+				i++
+			}
+			tokens = append(tokens, token{kind: kind, text: string(rs[start:i]), pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(rs[start:i]), pos: start})
+		default:
+			if i+1 < len(rs) {
+				two := string(rs[i : i+2])
+				switch two {
+				case "&&", "||", "==", "!=", ">=", "<=":
+					tokens = append(tokens, token{kind: tokenOp, text: two, pos: i})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("()+-*/<>!,.", r) {
+				return nil, fmt.Errorf("unexpected character '%c' at %d", r, i)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: string(r), pos: i})
+			i++
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(rs)})
+	return tokens, nil
+}
+
+// parser is a recursive descent parser, from the lowest precedence:
+//
+//	or:         and { "||" and }
+//	and:        unary { "&&" unary }
+//	unary:      "!" unary | comparison
+//	comparison: sum [ ("=="|"!="|">"|">="|"<"|"<=") sum ]
+//	sum:        product { ("+"|"-") product }
+//	product:    factor { ("*"|"/") factor }
+//	factor:     "-" factor | number | call [ "." field ] | "(" or ")"
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		return p.errorf("expect '%s'", op)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, a ...interface{}) error {
+	t := p.peek()
+	got := t.text
+	if t.kind == tokenEOF {
+		got = "end of rule"
+	}
+	return fmt.Errorf("%s at %d, got '%s'", fmt.Sprintf(format, a...), t.pos, got)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newBinary("||", left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newBinary("&&", left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if _, ok := p.accept("!"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if x.kind() != kindBool {
+			return nil, fmt.Errorf("'!' need a condition, got number")
+		}
+		return &notNode{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.accept("==", "!=", ">", ">=", "<", "<="); ok {
+		right, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return newBinary(op, left, right)
+	}
+	return left, nil
+}
+
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newBinary(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newBinary(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseFactor() (node, error) {
+	if _, ok := p.accept("-"); ok {
+		x, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return newBinary("-", numberNode(0), x)
+	}
+	if _, ok := p.accept("("); ok {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number '%s' at %d", t.text, t.pos)
+		}
+		return numberNode(v), nil
+	case tokenIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			p.next()
+			return boolNode(true), nil
+		case "false":
+			p.next()
+			return boolNode(false), nil
+		}
+		return p.parseCall()
+	}
+	return nil, p.errorf("expect number, function or '('")
+}
+
+// parseCall parse `name(args...)[.field]`, the first argument of indicators is the period
+func (p *parser) parseCall() (node, error) {
+	name := p.next()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []node
+	var period time.Duration
+	if _, ok := p.accept(")"); !ok {
+		for {
+			if len(args) == 0 && period == 0 && takePeriod(strings.ToLower(name.text)) && p.peek().kind == tokenIdent {
+				t := p.next()
+				d, err := ParsePeriod(t.text)
+				if err != nil {
+					return nil, fmt.Errorf("%s at %d", err, t.pos)
+				}
+				period = d
+			} else {
+				arg, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+			}
+			if _, ok := p.accept(")"); ok {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	field := ""
+	if _, ok := p.accept("."); ok {
+		t := p.next()
+		if t.kind != tokenIdent {
+			return nil, fmt.Errorf("expect field name at %d", t.pos)
+		}
+		field = strings.ToLower(t.text)
+	}
+	n, err := newCall(strings.ToLower(name.text), period, args, field)
+	if err != nil {
+		return nil, fmt.Errorf("%s at %d", err, name.pos)
+	}
+	return n, nil
+}
+
+// ParsePeriod parse the timeframe in rule, the unit is m(minute), H(hour), D(day), W(week) or M(month),
+// with optional count before the unit, eg. 15m, 4H, D, W.
+// The unit "m" and "M" are case-sensitive, the others are not.
+func ParsePeriod(text string) (time.Duration, error) {
+	if text == "" {
+		return 0, fmt.Errorf("empty period")
+	}
+	unit := text[len(text)-1:]
+	n := int64(1)
+	if len(text) > 1 {
+		v, err := strconv.ParseInt(text[:len(text)-1], 10, 64)
+		if err != nil || v <= 0 {
+			return 0, fmt.Errorf("bad period '%s'", text)
+		}
+		n = v
+	}
+	var d time.Duration
+	switch unit {
+	case "m":
+		d = time.Minute
+	case "h", "H":
+		d = time.Hour
+	case "d", "D":
+		d = time.Hour * 24
+	case "w", "W":
+		d = time.Hour * 24 * 7
+	case "M":
+		d = time.Hour * 24 * 30
+	default:
+		return 0, fmt.Errorf("bad period '%s'", text)
+	}
+	return time.Duration(n) * d, nil
+}
@@ -0,0 +1,109 @@
+// Package rule is a small language for the entry and exit conditions in config, eg.
+//
+//	squeeze(W).trend == 2 && supertrend(D, 3, 7).up && natr(D, 14) > 3
+//
+// The first argument of the indicators is the period, see ParsePeriod.
+// All the values are taken at the last closed bar.
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"time"
+)
+
+var ErrNotEnoughData = errors.New("not enough candle data")
+
+// Source provide candles for the rule, the last bar is the forming one, the same as RestAPIExchange.CandleBySize
+type Source interface {
+	Candle(symbol string, period time.Duration) (hs.Candle, error)
+}
+
+// ExchangeSource get candles from the exchange
+type ExchangeSource struct {
+	Ex   exchange.RestAPIExchange
+	Size int
+}
+
+func (s ExchangeSource) Candle(symbol string, period time.Duration) (hs.Candle, error) {
+	return s.Ex.CandleBySize(symbol, period, s.Size)
+}
+
+type Rule struct {
+	text string
+	root node
+}
+
+// Parse the rule text, the rule must be a condition, not a number
+func Parse(text string) (*Rule, error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.errorf("unexpected token")
+	}
+	if root.kind() != kindBool {
+		return nil, errors.New("rule must be a condition")
+	}
+	return &Rule{text: text, root: root}, nil
+}
+
+func (r *Rule) String() string {
+	return r.text
+}
+
+// Eval the rule on symbol, every period is fetched from src only once.
+// ErrNotEnoughData is returned if some indicator has no value at the last closed bar.
+func (r *Rule) Eval(src Source, symbol string) (bool, error) {
+	e := &env{
+		src:     src,
+		symbol:  symbol,
+		candles: make(map[time.Duration]hs.Candle),
+		series:  make(map[*callNode]series),
+	}
+	v, err := r.root.eval(e, 0)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", symbol, err)
+	}
+	return v != 0, nil
+}
+
+type env struct {
+	src     Source
+	symbol  string
+	candles map[time.Duration]hs.Candle
+	series  map[*callNode]series
+}
+
+func (e *env) candle(period time.Duration) (hs.Candle, error) {
+	if c, ok := e.candles[period]; ok {
+		return c, nil
+	}
+	c, err := e.src.Candle(e.symbol, period)
+	if err != nil {
+		return c, err
+	}
+	e.candles[period] = c
+	return c, nil
+}
+
+func (e *env) get(n *callNode) (series, error) {
+	if s, ok := e.series[n]; ok {
+		return s, nil
+	}
+	c, err := e.candle(n.period)
+	if err != nil {
+		return series{}, err
+	}
+	s := n.fn.compute(c, n.args, n.field)
+	e.series[n] = s
+	return s, nil
+}
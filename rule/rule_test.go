@@ -0,0 +1,169 @@
+package rule
+
+import (
+	"errors"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"testing"
+	"time"
+)
+
+// fakeSource return the candles by period, the close price is from closes, the last one is forming
+type fakeSource map[time.Duration][]float64
+
+func (s fakeSource) Candle(symbol string, period time.Duration) (hs.Candle, error) {
+	closes, ok := s[period]
+	if !ok {
+		return hs.Candle{}, errors.New("no candle")
+	}
+	c := hs.NewCandle(len(closes))
+	for i, price := range closes {
+		c.Append(hs.Ticker{
+			Timestamp: int64(i) * int64(period.Seconds()),
+			Open:      price, High: price + 1, Low: price - 1, Close: price, Volume: 100,
+		})
+	}
+	return c, nil
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		text string
+		want time.Duration
+	}{
+		{"1m", time.Minute},
+		{"15m", time.Minute * 15},
+		{"4H", time.Hour * 4},
+		{"D", time.Hour * 24},
+		{"W", time.Hour * 24 * 7},
+		{"M", time.Hour * 24 * 30},
+	}
+	for _, tt := range tests {
+		got, err := ParsePeriod(tt.text)
+		if err != nil || got != tt.want {
+			t.Errorf("ParsePeriod(%s) = %s, %v, want %s", tt.text, got, err, tt.want)
+		}
+	}
+	if _, err := ParsePeriod("3x"); err == nil {
+		t.Errorf("want error for bad unit")
+	}
+}
+
+func TestParse_error(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"close(D)",                 // not a condition
+		"close(D) > 1 &&",          // incomplete
+		"foo(D) > 1",               // unknown function
+		"ema(D) > 1",               // missing argument
+		"close() > 1",              // missing period
+		"supertrend(D, 3, 7).left", // unknown field
+		"close(D) && true",         // number in logic
+		"close(D) > 1 $",           // bad character
+	} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("want error for %q", text)
+		}
+	}
+}
+
+func TestRule_Eval(t *testing.T) {
+	src := fakeSource{
+		exchange.DAY1:  {1, 2, 3, 4, 5, 6, 100},
+		exchange.HOUR1: {5, 4, 3, 2, 6, 0},
+	}
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"close(D) == 6", true}, // the forming bar is ignored
+		{"close(D) > 5 && close(1H) < 3", false},
+		{"close(D) > 5 || close(1H) < 3", true},
+		{"!(close(D) > 5)", false},
+		{"close(D) - close(1H) * 2 == -6", true},
+		{"(close(D) + 2) / 4 == 2", true},
+		{"high(D) - low(D) == 2 && volume(D) == 100", true},
+		{"sma(D, 3) == 5", true},
+		{"crossover(close(1H), 4)", true},
+		{"crossunder(close(1H), 4)", false},
+		{"crossover(close(D), sma(D, 2))", false},
+		{"false || TRUE", true},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.text)
+		if err != nil {
+			t.Errorf("parse %q error: %s", tt.text, err)
+			continue
+		}
+		got, err := r.Eval(src, "btcusdt")
+		if err != nil {
+			t.Errorf("eval %q error: %s", tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("eval %q = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRule_Eval_notEnough(t *testing.T) {
+	src := fakeSource{exchange.DAY1: {1, 2, 3}}
+	r, err := Parse("ema(D, 14) > 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Eval(src, "btcusdt"); !errors.Is(err, ErrNotEnoughData) {
+		t.Errorf("want ErrNotEnoughData, got %v", err)
+	}
+	// short circuit need no data
+	r, _ = Parse("close(D) > 10 && ema(D, 14) > 1")
+	if on, err := r.Eval(src, "btcusdt"); err != nil || on {
+		t.Errorf("want false without error, got %v, %v", on, err)
+	}
+}
+
+func TestRule_Eval_indicators(t *testing.T) {
+	var up []float64
+	for i := 0; i < 100; i++ {
+		up = append(up, float64(100+i))
+	}
+	src := fakeSource{exchange.DAY1: up}
+	for _, text := range []string{
+		"supertrend(D, 3, 7).up && !supertrend(D, 3, 7).down",
+		"supertrend(D, 3, 7).value < close(D)",
+		"rsi(D, 14) > 70 && natr(D, 14) > 0 && atr(D, 14) > 0",
+		"ema(D, 10) > ema(D, 20)",
+		"squeeze(D).value > 0 && squeeze(D, 20, 2, 20, 1.5).trend != -2",
+	} {
+		r, err := Parse(text)
+		if err != nil {
+			t.Errorf("parse %q error: %s", text, err)
+			continue
+		}
+		if on, err := r.Eval(src, "btcusdt"); err != nil || !on {
+			t.Errorf("eval %q = %v, %v, want true", text, on, err)
+		}
+	}
+}
+
+func TestEvaluator_Signal(t *testing.T) {
+	src := fakeSource{exchange.DAY1: {1, 2, 3, 4}}
+	e, err := NewEvaluator(Conf{Entry: "close(D) > 2", Exit: "close(D) < 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := e.Signal(src, "btcusdt"); err != nil || s != SignalBuy {
+		t.Errorf("want BUY, got %s, %v", s, err)
+	}
+	e, _ = NewEvaluator(Conf{Entry: "close(D) > 2", Exit: "close(D) > 1"})
+	if s, _ := e.Signal(src, "btcusdt"); s != SignalSell {
+		t.Errorf("want SELL, got %s", s)
+	}
+	e, _ = NewEvaluator(Conf{})
+	if s, _ := e.Signal(src, "btcusdt"); s != SignalNone {
+		t.Errorf("want NONE, got %s", s)
+	}
+	if _, err := NewEvaluator(Conf{Entry: "close(D) >"}); err == nil {
+		t.Errorf("want error for bad entry rule")
+	}
+}
@@ -0,0 +1,72 @@
+package rule
+
+import "fmt"
+
+// Conf is the entry and exit rules in config, empty rule is never true
+type Conf struct {
+	Entry string `json:"entry"`
+	Exit  string `json:"exit"`
+}
+
+type Signal int
+
+const (
+	SignalNone Signal = iota
+	SignalBuy
+	SignalSell
+)
+
+func (s Signal) String() string {
+	switch s {
+	case SignalBuy:
+		return "BUY"
+	case SignalSell:
+		return "SELL"
+	}
+	return "NONE"
+}
+
+// Evaluator produce the trading signal by the entry and exit rules
+type Evaluator struct {
+	entry *Rule
+	exit  *Rule
+}
+
+func NewEvaluator(conf Conf) (*Evaluator, error) {
+	e := &Evaluator{}
+	var err error
+	if conf.Entry != "" {
+		if e.entry, err = Parse(conf.Entry); err != nil {
+			return nil, fmt.Errorf("bad entry rule: %w", err)
+		}
+	}
+	if conf.Exit != "" {
+		if e.exit, err = Parse(conf.Exit); err != nil {
+			return nil, fmt.Errorf("bad exit rule: %w", err)
+		}
+	}
+	return e, nil
+}
+
+// Signal check the exit rule first, so it's SignalSell if both rules are true
+func (e *Evaluator) Signal(src Source, symbol string) (Signal, error) {
+	if e.exit != nil {
+		on, err := e.exit.Eval(src, symbol)
+		if err != nil {
+			return SignalNone, err
+		}
+		if on {
+			return SignalSell, nil
+		}
+	}
+	if e.entry != nil {
+		on, err := e.entry.Eval(src, symbol)
+		if err != nil {
+			return SignalNone, err
+		}
+		if on {
+			return SignalBuy, nil
+		}
+	}
+	return SignalNone, nil
+}
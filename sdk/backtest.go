@@ -0,0 +1,150 @@
+package sdk
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"sync"
+	"time"
+)
+
+// backtestExchange use the real exchange for symbol, fee and history,
+// the last price and clock are the close price and close time of current bar
+type backtestExchange struct {
+	exchange.RestAPIExchange
+
+	lock  sync.RWMutex
+	price decimal.Decimal
+	now   time.Time
+}
+
+func newBacktestExchange(ex exchange.RestAPIExchange) *backtestExchange {
+	return &backtestExchange{RestAPIExchange: ex}
+}
+
+func (b *backtestExchange) set(price float64, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.price = decimal.NewFromFloat(price)
+	b.now = now
+}
+
+func (b *backtestExchange) LastPrice(symbol string) (decimal.Decimal, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.price, nil
+}
+
+func (b *backtestExchange) Now() time.Time {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.now
+}
+
+// replay is the history of one interval, closed is the number of bars closed at now
+type replay struct {
+	interval time.Duration
+	candle   hs.Candle
+	closed   int
+}
+
+// advance count the bars closed at now, return true if new bar closed
+func (p *replay) advance(now time.Time) bool {
+	old := p.closed
+	for p.closed < p.candle.Length() && p.candle.Timestamp[p.closed]+int64(p.interval.Seconds()) <= now.Unix() {
+		p.closed++
+	}
+	return p.closed > old
+}
+
+// runBacktest replay the bars of the first interval from config.Runner.From.
+// At the close of every bar, the paper orders are matched with the bar first,
+// then the strategy is called with the closed bars of all intervals.
+func (r *Runner) runBacktest(ctx context.Context) error {
+	from, err := time.ParseInLocation(timeLayout, r.config.Runner.From, time.Local)
+	if err != nil {
+		return err
+	}
+	to := time.Now()
+	if r.config.Runner.To != "" {
+		if to, err = time.ParseInLocation(timeLayout, r.config.Runner.To, time.Local); err != nil {
+			return err
+		}
+	}
+	symbol := r.context.Symbol.Symbol
+	var replays []*replay
+	for _, interval := range r.sub.Intervals {
+		start := from.Add(-time.Duration(r.sub.History) * interval)
+		c, err := r.ex.CandleFrom(symbol, "sdk-backtest", interval, start, to)
+		if err != nil {
+			return err
+		}
+		p := &replay{interval: interval, candle: c}
+		p.advance(from)
+		replays = append(replays, p)
+		r.Sugar.Infof("backtest %s candle loaded, %d bars, %d before start", interval, c.Length(), p.closed)
+	}
+	main := replays[0]
+	if main.closed >= main.candle.Length() {
+		r.Sugar.Info("no bar to backtest")
+		return nil
+	}
+	// place orders at the close of the last bar before start
+	first := main.closed
+	if first > 0 {
+		r.backtest.set(main.candle.Close[first-1], time.Unix(main.candle.Timestamp[first], 0))
+	} else {
+		r.backtest.set(main.candle.Open[0], time.Unix(main.candle.Timestamp[0], 0))
+	}
+	if err := r.strategy.OnStart(r.context); err != nil {
+		return err
+	}
+
+	var lastTimer time.Time
+	for i := first; i < main.candle.Length(); i++ {
+		select {
+		case <-ctx.Done():
+			r.strategy.OnStop(r.context)
+			return ctx.Err()
+		default:
+		}
+		now := time.Unix(main.candle.Timestamp[i]+int64(main.interval.Seconds()), 0)
+		if now.After(to) {
+			break
+		}
+		r.backtest.set(main.candle.Close[i], now)
+		r.paper.OnCandle(ctx, symbol, headCandle(main.candle, i+1))
+		r.pollOrders()
+		for _, p := range replays {
+			if p.advance(now) {
+				r.strategy.OnCandleClosed(r.context, p.interval, headCandle(p.candle, p.closed))
+			}
+		}
+		if r.sub.Timer > 0 && now.Sub(lastTimer) >= r.sub.Timer {
+			lastTimer = now
+			r.strategy.OnTimer(r.context, now)
+		}
+	}
+	r.pollOrders()
+	r.strategy.OnStop(r.context)
+	r.report()
+	return nil
+}
+
+// report the paper account and equity at the last price
+func (r *Runner) report() {
+	price, _ := r.backtest.LastPrice(r.context.Symbol.Symbol)
+	balance, err := r.paper.SpotBalance()
+	if err != nil {
+		r.Sugar.Error(err)
+		return
+	}
+	base := balance[r.context.Symbol.BaseCurrency]
+	quote := balance[r.context.Symbol.QuoteCurrency]
+	equity := quote.Add(base.Mul(price))
+	r.Sugar.Info(r.paper.Summary())
+	r.Sugar.Infof("backtest finished, %s: %s, %s: %s, equity: %s %s",
+		r.context.Symbol.BaseCurrency, base, r.context.Symbol.QuoteCurrency, quote,
+		equity, r.context.Symbol.QuoteCurrency)
+}
@@ -0,0 +1,59 @@
+package sdk
+
+import (
+	"context"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/executor"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"time"
+)
+
+// Context is passed to all strategy callbacks
+type Context struct {
+	Ctx      context.Context
+	Mode     Mode
+	Symbol   exchange.Symbol
+	Sugar    *zap.SugaredLogger
+	Executor *executor.RestExecutor
+	Store    Store
+
+	now func() time.Time
+}
+
+// Now return the time of the market data, it's the bar time in backtest
+func (c *Context) Now() time.Time {
+	return c.now()
+}
+
+// Broadcast send message to all robots, it's silent in backtest
+func (c *Context) Broadcast(format string, a ...interface{}) {
+	if c.Mode == ModeBacktest {
+		return
+	}
+	c.Executor.Broadcast(format, a...)
+}
+
+// Store keep the strategy state in its own collection, the value is stored by mongo driver (bson)
+type Store struct {
+	coll *mongo.Collection
+}
+
+func (s Store) Save(ctx context.Context, key string, value interface{}) error {
+	return hs.SaveKey(ctx, s.coll, key, value)
+}
+
+// Load the value of key, value is unchanged if the key is not found
+func (s Store) Load(ctx context.Context, key string, value interface{}) error {
+	return hs.LoadKey(ctx, s.coll, key, value)
+}
+
+func (s Store) Delete(ctx context.Context, key string) error {
+	return hs.DeleteKey(ctx, s.coll, key)
+}
+
+// Clear delete all state
+func (s Store) Clear(ctx context.Context) error {
+	return s.coll.Drop(ctx)
+}
@@ -0,0 +1,406 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/broadcast"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/hs/exchange/gateio"
+	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/executor"
+	"github.com/xyths/qtr/hub"
+	"github.com/xyths/qtr/trader"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"log"
+	"regexp"
+	"time"
+)
+
+type Mode string
+
+const (
+	ModeLive     Mode = "live"
+	ModePaper    Mode = "paper"
+	ModeBacktest Mode = "backtest"
+)
+
+const (
+	collNameStrategy = "strategy"
+
+	defaultHistory = 2000
+	defaultPoll    = time.Second * 10
+	timeLayout     = "2006-01-02"
+)
+
+type Config struct {
+	Exchange hs.ExchangeConf
+	Mongo    hs.MongoConf
+	Log      hs.LogConf
+	Robots   []hs.BroadcastConf
+	Paper    executor.PaperConf // virtual balance and fee, for paper and backtest
+	Runner   RunnerConf
+}
+
+type RunnerConf struct {
+	Total float64 // max total (quote currency) of the executor
+	Poll  string  // candle and order polling interval, live and paper only
+	From  string  // backtest start date, 2006-01-02
+	To    string  // backtest end date, default is now
+
+	Sizing executor.SizingConf // position sizing of the entry, empty policy means all in
+	Algo   executor.AlgoConf   // execution algorithm of the market orders, default is market order, or chase on gate
+}
+
+// Runner host one strategy on the first symbol of config, in live, paper or backtest mode.
+// Every mode has its own state namespace, the backtest state is cleared when start.
+type Runner struct {
+	config   Config
+	strategy Strategy
+	mode     Mode
+	sub      Subscription
+	poll     time.Duration
+
+	Sugar    *zap.SugaredLogger
+	db       *mongo.Database
+	ex       exchange.RestAPIExchange // the real exchange, for market data
+	robots   []broadcast.Broadcaster
+	paper    *executor.PaperExchange
+	backtest *backtestExchange
+	executor *executor.RestExecutor
+	context  *Context
+
+	lastBar map[time.Duration]int64   // the latest bar timestamp of every interval
+	orders  map[uint64]exchange.Order // the latest status of tracked orders
+	trades  chan []exchange.TradeDetail
+}
+
+var _ trader.Trader = (*Runner)(nil)
+
+func NewRunner(cfg Config, strategy Strategy, mode Mode) (*Runner, error) {
+	switch mode {
+	case ModeLive, ModePaper, ModeBacktest:
+	default:
+		return nil, fmt.Errorf("unknown mode %s", mode)
+	}
+	if len(cfg.Exchange.Symbols) == 0 {
+		return nil, errors.New("no symbol in config")
+	}
+	r := &Runner{
+		config:   cfg,
+		strategy: strategy,
+		mode:     mode,
+		sub:      strategy.Subscription(),
+		poll:     defaultPoll,
+		lastBar:  make(map[time.Duration]int64),
+		orders:   make(map[uint64]exchange.Order),
+		trades:   make(chan []exchange.TradeDetail, 10),
+	}
+	if len(r.sub.Intervals) == 0 {
+		return nil, errors.New("strategy must subscribe at least one candle interval")
+	}
+	if r.sub.History <= 0 {
+		r.sub.History = defaultHistory
+	}
+	if cfg.Runner.Poll != "" {
+		poll, err := time.ParseDuration(cfg.Runner.Poll)
+		if err != nil {
+			return nil, err
+		}
+		r.poll = poll
+	}
+	return r, nil
+}
+
+func (r *Runner) Init(ctx context.Context) error {
+	l, err := hs.NewZapLogger(r.config.Log)
+	if err != nil {
+		return err
+	}
+	r.Sugar = l.Sugar().Named(string(r.mode))
+	r.Sugar.Info("Logger initialized")
+	db, err := hs.ConnectMongo(ctx, r.config.Mongo)
+	if err != nil {
+		return err
+	}
+	r.db = db
+	if err := r.initEx(); err != nil {
+		return err
+	}
+	if r.mode != ModeBacktest {
+		for _, conf := range r.config.Robots {
+			r.robots = append(r.robots, broadcast.New(conf))
+		}
+	}
+	return r.initExecutor(ctx)
+}
+
+func (r *Runner) initEx() (err error) {
+	switch r.config.Exchange.Name {
+	case "gate":
+		r.ex = gateio.New(r.config.Exchange.Key, r.config.Exchange.Secret, r.config.Exchange.Host, r.Sugar)
+	case "huobi":
+		r.ex, err = huobi.New(r.config.Exchange.Label, r.config.Exchange.Key, r.config.Exchange.Secret, r.config.Exchange.Host)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported exchange")
+	}
+	r.Sugar.Info("Exchange initialized")
+	return nil
+}
+
+func (r *Runner) initExecutor(ctx context.Context) error {
+	namespace := r.namespace()
+	if r.mode == ModeBacktest {
+		if err := r.Clear(ctx); err != nil {
+			return err
+		}
+	}
+	symbol, err := r.ex.GetSymbol(ctx, r.config.Exchange.Symbols[0])
+	if err != nil {
+		return err
+	}
+	fee, err := r.ex.GetFee(symbol.Symbol)
+	if err != nil {
+		return err
+	}
+
+	var ex exchange.RestAPIExchange = r.ex
+	switch r.mode {
+	case ModePaper:
+		r.paper = executor.NewPaperExchange(r.ex, r.config.Paper)
+	case ModeBacktest:
+		r.backtest = newBacktestExchange(r.ex)
+		r.paper = executor.NewPaperExchange(r.backtest, r.config.Paper)
+		r.paper.SetClock(r.backtest.Now)
+	}
+	if r.paper != nil {
+		r.paper.SetNamespace(namespace)
+		r.paper.Init(r.Sugar, r.db)
+		if err := r.paper.Load(ctx); err != nil {
+			return err
+		}
+		ex = r.paper
+	}
+
+	r.executor = &executor.RestExecutor{}
+	r.executor.SetNamespace(namespace)
+	r.executor.Init(ex, r.Sugar, r.db, r.config.Exchange.Name, r.config.Exchange.Label,
+		symbol, fee, decimal.NewFromFloat(r.config.Runner.Total), r.robots)
+	r.executor.SetStrategy(r.strategy.Name())
+	if r.mode == ModeBacktest {
+		// the backtest clock is driven by the candles, only the market order is filled at once
+		r.executor.SetAlgo(executor.AlgoConf{Name: executor.AlgoMarket})
+	} else {
+		r.executor.SetAlgo(r.config.Runner.Algo)
+	}
+	if r.config.Runner.Sizing.Policy != "" {
+		if err := r.executor.SetSizer(r.config.Runner.Sizing); err != nil {
+			return err
+		}
+	}
+	if r.paper != nil {
+		r.paper.SetBroadcaster(r.executor.Broadcast)
+	}
+	if err := r.executor.Load(ctx); err != nil {
+		return err
+	}
+	r.context = &Context{
+		Mode:     r.mode,
+		Symbol:   symbol,
+		Sugar:    r.Sugar.Named(r.strategy.Name()),
+		Executor: r.executor,
+		Store:    Store{coll: r.db.Collection(executor.CollName(namespace, collNameStrategy))},
+		now:      time.Now,
+	}
+	if r.backtest != nil {
+		r.context.now = r.backtest.Now
+	}
+	r.Sugar.Infof("Strategy %s initialized, symbol: %s, namespace: %s", r.strategy.Name(), symbol.Symbol, namespace)
+	return nil
+}
+
+// namespace is the strategy name with mode suffix, so clearing one mode never touches the others
+func (r *Runner) namespace() string {
+	return namespace(r.strategy.Name(), r.mode)
+}
+
+func namespace(name string, mode Mode) string {
+	return name + "_" + string(mode)
+}
+
+// collPattern match all collections in the namespace
+func collPattern(namespace string) string {
+	return "^" + regexp.QuoteMeta(executor.CollName(namespace, ""))
+}
+
+func (r *Runner) Close(ctx context.Context) {
+	if r.db != nil {
+		_ = r.db.Client().Disconnect(ctx)
+	}
+	if r.Sugar != nil {
+		r.Sugar.Info("Runner stopped")
+		_ = r.Sugar.Sync()
+	}
+}
+
+func (r *Runner) Print(ctx context.Context) error {
+	log.Printf("Strategy: %s, Mode: %s, Namespace: %s", r.strategy.Name(), r.mode, r.namespace())
+	log.Printf("Buy order: %d, Sell order: %d", r.executor.GetBuyOrderId(), r.executor.GetSellOrderId())
+	if r.paper != nil {
+		log.Print(r.paper.Summary())
+	}
+	return nil
+}
+
+// Clear drop all collections of the namespace
+func (r *Runner) Clear(ctx context.Context) error {
+	names, err := r.db.ListCollectionNames(ctx, bson.D{{"name", bson.D{{"$regex", collPattern(r.namespace())}}}})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := r.db.Collection(name).Drop(ctx); err != nil {
+			return err
+		}
+		r.Sugar.Infof("collection %s dropped", name)
+	}
+	return nil
+}
+
+// Start run the strategy until ctx is done, or the backtest data is used up
+func (r *Runner) Start(ctx context.Context) error {
+	r.context.Ctx = ctx
+	if r.mode == ModeBacktest {
+		return r.runBacktest(ctx)
+	}
+	return r.runLive(ctx)
+}
+
+func (r *Runner) runLive(ctx context.Context) error {
+	if r.paper != nil {
+		go r.paper.Start(ctx)
+	}
+	if r.sub.Trade {
+		r.subscribeTrade()
+	}
+	if err := r.strategy.OnStart(r.context); err != nil {
+		return err
+	}
+	r.pollCandles()
+	r.Sugar.Info("Runner started")
+
+	var timer <-chan time.Time
+	if r.sub.Timer > 0 {
+		t := time.NewTicker(r.sub.Timer)
+		defer t.Stop()
+		timer = t.C
+	}
+	poll := time.NewTicker(r.poll)
+	defer poll.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.strategy.OnStop(r.context)
+			return nil
+		case <-poll.C:
+			r.pollOrders()
+			r.pollCandles()
+		case now := <-timer:
+			r.strategy.OnTimer(r.context, now)
+		case trades := <-r.trades:
+			r.strategy.OnTrade(r.context, trades)
+		}
+	}
+}
+
+// subscribeTrade subscribe trade detail by websocket, the trades are handled in the runner's goroutine
+func (r *Runner) subscribeTrade() {
+	ws, ok := r.ex.(exchange.Exchange)
+	if !ok {
+		r.Sugar.Warn("exchange not support websocket, no trade detail")
+		return
+	}
+	h := hub.New(ws)
+	h.Init(r.Sugar)
+	c := h.Register("sdk-"+r.strategy.Name(), hub.DefaultQueueSize, func(e hub.Event) {
+		r.trades <- e.Trades
+	})
+	if err := h.SubscribeTrade(c, r.context.Symbol.Symbol); err != nil {
+		r.Sugar.Warnf("subscribe trade error: %s", err)
+	}
+}
+
+// pollCandles call OnCandleClosed when a new bar is opened, otherwise OnCandleUpdate
+func (r *Runner) pollCandles() {
+	for _, interval := range r.sub.Intervals {
+		c, err := r.ex.CandleBySize(r.context.Symbol.Symbol, interval, r.sub.History)
+		if err != nil {
+			r.Sugar.Errorf("get candle error: %s", err)
+			continue
+		}
+		l := c.Length()
+		if l < 2 {
+			continue
+		}
+		last := r.lastBar[interval]
+		r.lastBar[interval] = c.Timestamp[l-1]
+		if last != 0 && last != c.Timestamp[l-1] {
+			r.strategy.OnCandleClosed(r.context, interval, headCandle(c, l-1))
+		} else {
+			r.strategy.OnCandleUpdate(r.context, interval, c)
+		}
+	}
+}
+
+// pollOrders call OnOrderUpdate when the status of executor's order is changed,
+// then let the executor settle the finished orders
+func (r *Runner) pollOrders() {
+	for _, id := range []uint64{r.executor.GetBuyOrderId(), r.executor.GetSellOrderId()} {
+		if _, ok := r.orders[id]; id != 0 && !ok {
+			r.orders[id] = exchange.Order{Id: id}
+		}
+	}
+	for id, old := range r.orders {
+		if executor.OrderFinished(old.Status) {
+			if id != r.executor.GetBuyOrderId() && id != r.executor.GetSellOrderId() {
+				delete(r.orders, id)
+			}
+			continue
+		}
+		o, err := r.executor.Exchange().GetOrderById(id, r.context.Symbol.Symbol)
+		if err != nil {
+			r.Sugar.Errorf("get order %d error: %s", id, err)
+			continue
+		}
+		if o.Status != old.Status || !o.FilledAmount.Equal(old.FilledAmount) {
+			r.orders[id] = o
+			r.strategy.OnOrderUpdate(r.context, o)
+		}
+	}
+	if err := r.executor.CheckAll(); err != nil {
+		r.Sugar.Errorf("check order error: %s", err)
+	}
+}
+
+// headCandle return the first n bars of c, the data is not copied
+func headCandle(c hs.Candle, n int) hs.Candle {
+	if n > c.Length() {
+		n = c.Length()
+	}
+	return hs.Candle{
+		Capacity:  c.Capacity,
+		Timestamp: c.Timestamp[:n],
+		Open:      c.Open[:n],
+		High:      c.High[:n],
+		Low:       c.Low[:n],
+		Close:     c.Close[:n],
+		Volume:    c.Volume[:n],
+	}
+}
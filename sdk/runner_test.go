@@ -0,0 +1,91 @@
+package sdk
+
+import (
+	"github.com/xyths/hs"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func testCandle() hs.Candle {
+	c := hs.NewCandle(10)
+	for i := int64(0); i < 5; i++ {
+		c.Append(hs.Ticker{Timestamp: i * 60, Open: 1, High: 2, Low: 0.5, Close: float64(i + 1)})
+	}
+	return c
+}
+
+func TestHeadCandle(t *testing.T) {
+	c := headCandle(testCandle(), 3)
+	if c.Length() != 3 || c.Close[2] != 3 {
+		t.Errorf("want 3 bars end with 3, got %d bars", c.Length())
+	}
+	c = headCandle(testCandle(), 10)
+	if c.Length() != 5 {
+		t.Errorf("want all 5 bars, got %d", c.Length())
+	}
+}
+
+func TestReplay_advance(t *testing.T) {
+	p := &replay{interval: time.Minute, candle: testCandle()}
+	if p.advance(time.Unix(59, 0)) {
+		t.Errorf("want no bar closed")
+	}
+	if !p.advance(time.Unix(60, 0)) || p.closed != 1 {
+		t.Errorf("want 1 bar closed, got %d", p.closed)
+	}
+	if !p.advance(time.Unix(200, 0)) || p.closed != 3 {
+		t.Errorf("want 3 bars closed, got %d", p.closed)
+	}
+	if p.advance(time.Unix(230, 0)) {
+		t.Errorf("want no new bar closed")
+	}
+	p.advance(time.Unix(1000, 0))
+	if p.closed != 5 {
+		t.Errorf("want all bars closed, got %d", p.closed)
+	}
+}
+
+func TestBacktestExchange(t *testing.T) {
+	b := newBacktestExchange(nil)
+	now := time.Unix(120, 0)
+	b.set(1.5, now)
+	price, err := b.LastPrice("btcusdt")
+	if err != nil || price.String() != "1.5" {
+		t.Errorf("want last price 1.5, got %s %v", price, err)
+	}
+	if !b.Now().Equal(now) {
+		t.Errorf("want now %s, got %s", now, b.Now())
+	}
+}
+
+func TestCollPattern(t *testing.T) {
+	colls := []string{"st_live_state", "st_live_strategy", "st_paper_state", "st_paper_paper_order", "st_backtest_order", "state", "st2_live_state"}
+	var tests = []struct {
+		mode Mode
+		want []string
+	}{
+		{ModeLive, []string{"st_live_state", "st_live_strategy"}},
+		{ModePaper, []string{"st_paper_state", "st_paper_paper_order"}},
+		{ModeBacktest, []string{"st_backtest_order"}},
+	}
+	for _, tt := range tests {
+		re := regexp.MustCompile(collPattern(namespace("st", tt.mode)))
+		var got []string
+		for _, c := range colls {
+			if re.MatchString(c) {
+				got = append(got, c)
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.mode, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: want %v, got %v", tt.mode, tt.want, got)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package sdk
+
+import (
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"time"
+)
+
+// Strategy is hosted by the Runner, all the callbacks are called in the runner's goroutine one by one,
+// so the strategy need not lock its own state.
+//
+// The candle passed to OnCandleClosed ends with the bar just closed,
+// the candle passed to OnCandleUpdate ends with the bar still forming (live and paper only).
+type Strategy interface {
+	Name() string
+	// Subscription tell the runner what market data the strategy want
+	Subscription() Subscription
+
+	OnStart(c *Context) error
+	OnCandleClosed(c *Context, interval time.Duration, candle hs.Candle)
+	OnCandleUpdate(c *Context, interval time.Duration, candle hs.Candle)
+	OnOrderUpdate(c *Context, order exchange.Order)
+	OnTrade(c *Context, trades []exchange.TradeDetail)
+	OnTimer(c *Context, now time.Time)
+	OnStop(c *Context)
+}
+
+type Subscription struct {
+	Intervals []time.Duration // candle intervals, the backtest steps by the first one
+	History   int             // bars of every interval, default is 2000
+	Trade     bool            // trade detail, live and paper only, the exchange must support websocket
+	Timer     time.Duration   // OnTimer period, 0 for no timer
+}
+
+// BaseStrategy implement all callbacks with nothing, embed it and override what you need
+type BaseStrategy struct{}
+
+func (BaseStrategy) OnStart(c *Context) error {
+	return nil
+}
+func (BaseStrategy) OnCandleClosed(c *Context, interval time.Duration, candle hs.Candle) {}
+func (BaseStrategy) OnCandleUpdate(c *Context, interval time.Duration, candle hs.Candle) {}
+func (BaseStrategy) OnOrderUpdate(c *Context, order exchange.Order)                      {}
+func (BaseStrategy) OnTrade(c *Context, trades []exchange.TradeDetail)                   {}
+func (BaseStrategy) OnTimer(c *Context, now time.Time)                                   {}
+func (BaseStrategy) OnStop(c *Context)                                                   {}
@@ -1,11 +1,13 @@
 package strategy
 
 import (
+	"context"
 	"github.com/markcheno/go-talib"
+	"github.com/shopspring/decimal"
 	indicator "github.com/xyths/go-indicators"
 	"github.com/xyths/hs"
 	"github.com/xyths/hs/exchange"
-	"github.com/xyths/qtr/exchange/huobi"
+	"github.com/xyths/qtr/mtf"
 	"go.uber.org/zap"
 	"math"
 	"sync"
@@ -14,6 +16,7 @@ import (
 
 type RtmExecutor interface {
 	Exchange() exchange.Exchange
+	Candles() *mtf.Manager
 	Symbol() string
 	//SubscribeCandle(clientId string, period time.Duration, responseHandler func(interface{}))
 
@@ -34,7 +37,9 @@ type RtmStrategyConf struct {
 	Interval string
 	Period   int
 	Factor   float64
-	Squeeze  SqueezeStrategyConf
+	Squeeze  SqueezeStrategyConf // RtmRest trades all the time if Squeeze.Interval is empty
+
+	Poll string // RtmRest only, the period of checking orders and latest price, default 20s
 }
 
 type RTMStrategy struct {
@@ -46,12 +51,11 @@ type RTMStrategy struct {
 	Sugar    *zap.SugaredLogger
 	executor RtmExecutor
 	squeeze  *SqueezeWs
+	sub      *mtf.Subscriber
 
 	enabledLock sync.RWMutex
 	enabled     bool
 
-	candle hs.Candle
-
 	mean      float64
 	upper     float64
 	lower     float64
@@ -64,7 +68,6 @@ func NewRTMStrategy(config RtmStrategyConf, dry bool) *RTMStrategy {
 	return &RTMStrategy{
 		config:  config,
 		squeeze: NewSqueezeWs(config.Squeeze, dry),
-		candle:  hs.NewCandle(2000),
 	}
 }
 
@@ -75,7 +78,7 @@ func (s *RTMStrategy) Init(logger *zap.SugaredLogger, ex RtmExecutor) {
 	}
 	s.interval = interval
 
-	s.squeeze.Init(logger, ex.Exchange(), ex.Symbol(), s.SqueezeOn, s.TrendOn, s.TrendOff)
+	s.squeeze.Init(logger, ex.Exchange(), ex.Candles(), ex.Symbol(), s.SqueezeOn, s.TrendOn, s.TrendOff)
 	s.Sugar = logger
 	s.executor = ex
 	s.symbol = ex.Symbol()
@@ -84,24 +87,30 @@ func (s *RTMStrategy) Init(logger *zap.SugaredLogger, ex RtmExecutor) {
 func (s *RTMStrategy) Start() {
 	s.executor.Start()
 	// start squeeze first
-	s.squeeze.Start()
-
-	{
-		to := time.Now()
-		from := to.Add(-2000 * s.interval)
-		candle, err := s.executor.Exchange().CandleFrom(s.symbol, "rtm-candle", s.interval, from, to)
-		if err != nil {
-			s.Sugar.Fatalf("get rtm candle error: %s", err)
-		}
-		s.candle.Add(candle)
-		// force to check trend on startup
-		s.onTick(s.dry, candle, true)
+	if err := s.squeeze.Start(); err != nil {
+		s.Sugar.Fatalf("start squeeze error: %s", err)
 	}
-	s.executor.Exchange().SubscribeCandlestick(s.symbol, "rtm-tick", s.interval, s.tickerHandler)
+
+	sub, err := s.executor.Candles().Subscribe("rtm-"+s.symbol, mtf.Request{
+		Symbol: s.symbol, Interval: s.interval, Lookback: candleLookback, Update: true,
+	}, s.candleHandler)
+	if err != nil {
+		s.Sugar.Fatalf("subscribe rtm candle error: %s", err)
+	}
+	s.sub = sub
+	candle, err := s.executor.Candles().Candle(s.symbol, s.interval)
+	if err != nil {
+		s.Sugar.Fatalf("get rtm candle error: %s", err)
+	}
+	// force to check trend on startup
+	s.onTick(s.dry, candle, true)
 }
 
 func (s *RTMStrategy) Stop() {
-	s.executor.Exchange().UnsubscribeCandlestick(s.symbol, "rtm-tick", s.interval)
+	if s.sub != nil {
+		s.executor.Candles().Unsubscribe(s.sub)
+		s.sub = nil
+	}
 	s.squeeze.Stop()
 	s.executor.Stop()
 }
@@ -148,28 +157,8 @@ func (s *RTMStrategy) IsEnabled() bool {
 	return s.enabled
 }
 
-func (s *RTMStrategy) tickerHandler(resp interface{}) {
-	//s.Sugar.Debug("rtm got candle update")
-	ticker, _, err := huobi.CandlestickHandler(resp)
-	if err != nil {
-		s.Sugar.Info(err)
-		return
-	}
-	if ticker != nil {
-		finished := false
-		if s.candle.Length() > 0 {
-			oldTime := s.candle.Timestamp[s.candle.Length()-1]
-			newTime := ticker.Timestamp
-			s.candle.Append(*ticker)
-			if oldTime != newTime {
-				finished = true
-			}
-		} else {
-			s.Sugar.Info("candle is not ready for append")
-		}
-		c2 := s.candle
-		s.onTick(s.dry, c2, finished)
-	}
+func (s *RTMStrategy) candleHandler(e mtf.Event) {
+	s.onTick(s.dry, e.Candle, e.Closed)
 }
 
 func (s *RTMStrategy) onTick(dry bool, candle hs.Candle, finished bool) {
@@ -184,49 +173,327 @@ func (s *RTMStrategy) onTick(dry bool, candle hs.Candle, finished bool) {
 
 	s.Sugar.Debug("RTM strategy processing")
 	l := candle.Length()
-	current := l - 2
-	previous := current - 1
 
 	if finished {
-		mean, upper, lower, atr := indicator.Rtm(
-			s.config.Period, s.config.Period, s.config.Factor,
-			candle.High, candle.Low, candle.Close,
-		)
-		mean2 := talib.LinearReg(mean, s.config.Period)
-		s.mean = mean[current]
-		s.upper = upper[current]
-		s.lower = lower[current]
-		s.atr = atr[current]
-		oldSellPrice := s.sellPrice
-		s.sellPrice = s.mean + s.atr
-		if mean2[current] > mean2[previous] {
-			s.trend = true
-		} else {
-			// must reset, in case trend turns down from up
-			s.trend = false
+		levels, ok := NewRtmLevels(candle, s.config.Period, s.config.Factor)
+		if !ok {
+			s.Sugar.Infof("not enough candles: %d", l)
+			return
 		}
+		s.mean = levels.Mean
+		s.upper = levels.Upper
+		s.lower = levels.Lower
+		s.atr = levels.ATR
+		// must reset, in case trend turns down from up
+		s.trend = levels.Trend
+		oldSellPrice := s.sellPrice
+		s.sellPrice = levels.SellPrice()
 
 		// always update sell order
-		if s.sellPrice != oldSellPrice {
+		if s.sellPrice != oldSellPrice && !dry {
 			s.executor.CancelAllSell()
 			s.executor.SellAllLimit(s.sellPrice)
 		}
 	}
 
 	// 1. upper trend, 2. lower than average
-	if s.trend {
-		// up trend
-		if candle.Close[l-1] < s.mean {
-			// latest close is less than mean
-			target := math.Min(candle.Close[l-1], s.mean-0.5*s.atr)
-			if !dry {
-				s.executor.CancelAllBuy()
-				s.executor.BuyAllLimit(target)
-			}
-			s.Sugar.Infof("long at price %f", target)
+	levels := RtmLevels{Mean: s.mean, ATR: s.atr, Trend: s.trend}
+	if target, ok := levels.BuyPrice(candle.Close[l-1]); ok {
+		if !dry {
+			s.executor.CancelAllBuy()
+			s.executor.BuyAllLimit(target)
 		}
-	} else {
+		s.Sugar.Infof("long at price %f", target)
+	} else if !s.trend {
 		// down trend
 		s.Sugar.Debug("it's down trend, no trade")
 	}
 }
+
+// RtmLevels is the RTM bands and trend of the last closed bar
+type RtmLevels struct {
+	Mean  float64
+	Upper float64
+	Lower float64
+	ATR   float64
+	Trend bool // the linear regression of mean is going up
+}
+
+// NewRtmLevels calculate the levels on the closed bars of candle (the last bar is not closed),
+// ok is false if there is not enough bars.
+func NewRtmLevels(candle hs.Candle, period int, factor float64) (levels RtmLevels, ok bool) {
+	return rtmLevelsAt(candle, candle.Length()-2, period, factor)
+}
+
+// ClosedRtmLevels calculate the levels on candle with closed bars only, eg. the candle of sdk.Strategy.OnCandleClosed
+func ClosedRtmLevels(candle hs.Candle, period int, factor float64) (levels RtmLevels, ok bool) {
+	return rtmLevelsAt(candle, candle.Length()-1, period, factor)
+}
+
+// rtmLevelsAt calculate the levels of bar current
+func rtmLevelsAt(candle hs.Candle, current, period int, factor float64) (levels RtmLevels, ok bool) {
+	previous := current - 1
+	if period <= 0 || current < period {
+		return
+	}
+	mean, upper, lower, atr := indicator.Rtm(period, period, factor, candle.High, candle.Low, candle.Close)
+	mean2 := talib.LinearReg(mean, period)
+	levels = RtmLevels{
+		Mean:  mean[current],
+		Upper: upper[current],
+		Lower: lower[current],
+		ATR:   atr[current],
+		Trend: mean2[current] > mean2[previous],
+	}
+	return levels, true
+}
+
+// SellPrice is the take profit price, one ATR above the mean
+func (l RtmLevels) SellPrice() float64 {
+	return l.Mean + l.ATR
+}
+
+// BuyPrice return the long price when it's up trend and the latest close is less than mean
+func (l RtmLevels) BuyPrice(close float64) (float64, bool) {
+	if !l.Trend || close >= l.Mean {
+		return 0, false
+	}
+	return math.Min(close, l.Mean-0.5*l.ATR), true
+}
+
+// RtmRestExecutor is the executor which polls the order status by RESTful API, eg. executor.RestExecutor
+type RtmRestExecutor interface {
+	Exchange() exchange.RestAPIExchange
+	Symbol() string
+	PricePrecision() int32
+
+	BuyAllLimit(price float64) error
+	SellAllLimit(price float64) error
+	SellAllMarket() error
+	CancelAll() error
+	CancelAllBuy() error
+	CancelAllSell() error
+	CheckAllBuy() error
+	CheckAllSell() error
+	GetBuyOrderId() uint64
+	GetSellOrderId() uint64
+}
+
+const defaultRtmPoll = time.Second * 20
+
+// RtmRest run the RTM strategy by polling candles and orders, for the exchanges without websocket, eg. gate.
+// The levels and the order prices are updated when a new bar is closed, the orders are only replaced when the price changes.
+// It always trades through the executor, use a paper executor to try it without real money.
+type RtmRest struct {
+	config   RtmStrategyConf
+	interval time.Duration
+	poll     time.Duration
+
+	Sugar    *zap.SugaredLogger
+	executor RtmRestExecutor
+	squeeze  *SqueezeRest // nil if not configured
+
+	enabled bool
+	levels  RtmLevels
+	last    int64 // timestamp of the last closed bar
+
+	// price of the pending orders, zero if no order
+	buyPrice  decimal.Decimal
+	sellPrice decimal.Decimal
+}
+
+func NewRtmRest(config RtmStrategyConf) *RtmRest {
+	s := &RtmRest{
+		config: config,
+	}
+	if config.Squeeze.Interval != "" {
+		s.squeeze = NewSqueezeRest(config.Squeeze, false)
+	} else {
+		s.enabled = true
+	}
+	return s
+}
+
+func (s *RtmRest) Init(logger *zap.SugaredLogger, ex RtmRestExecutor) {
+	interval, err := time.ParseDuration(s.config.Interval)
+	if err != nil {
+		panic(err)
+	}
+	s.interval = interval
+	s.poll = defaultRtmPoll
+	if s.config.Poll != "" {
+		if s.poll, err = time.ParseDuration(s.config.Poll); err != nil {
+			panic(err)
+		}
+	}
+	if s.squeeze != nil {
+		s.squeeze.Init(logger, ex.Exchange(), ex.Symbol(), s.SqueezeOn, s.TrendOn, s.TrendOff)
+	}
+	s.Sugar = logger
+	s.executor = ex
+}
+
+// Run polls until ctx is done
+func (s *RtmRest) Run(ctx context.Context) {
+	s.doWork(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			s.Sugar.Info(ctx.Err())
+			return
+		case <-time.After(s.poll):
+			s.doWork(ctx)
+		}
+	}
+}
+
+func (s *RtmRest) doWork(ctx context.Context) {
+	s.checkOrders()
+	candle, err := s.executor.Exchange().CandleBySize(s.executor.Symbol(), s.interval, candleLookback)
+	if err != nil {
+		s.Sugar.Errorf("get candle error: %s", err)
+		return
+	}
+	l := candle.Length()
+	if l < 2 {
+		return
+	}
+	if closed := candle.Timestamp[l-2]; closed != s.last {
+		s.last = closed
+		if s.squeeze != nil {
+			s.squeeze.Run(ctx)
+		}
+		s.onBar(candle)
+	}
+}
+
+// checkOrders clear the full-filled orders, and sell the coins just bought
+func (s *RtmRest) checkOrders() {
+	if s.executor.GetBuyOrderId() != 0 {
+		if err := s.executor.CheckAllBuy(); err != nil {
+			return
+		}
+		if s.executor.GetBuyOrderId() == 0 {
+			s.Sugar.Infof("buy order (price %s) is filled", s.buyPrice)
+			s.buyPrice = decimal.Zero
+			s.placeSell(true)
+		}
+	}
+	if s.executor.GetSellOrderId() != 0 {
+		if err := s.executor.CheckAllSell(); err != nil {
+			return
+		}
+		if s.executor.GetSellOrderId() == 0 {
+			s.Sugar.Infof("sell order (price %s) is filled", s.sellPrice)
+			s.sellPrice = decimal.Zero
+		}
+	}
+}
+
+// onBar update the levels on the new closed bar, move the sell order to the new price,
+// and place the buy order by the close of the bar, so the buy order is replaced once a bar at most.
+func (s *RtmRest) onBar(candle hs.Candle) {
+	levels, ok := NewRtmLevels(candle, s.config.Period, s.config.Factor)
+	if !ok {
+		s.Sugar.Infof("not enough candles: %d", candle.Length())
+		return
+	}
+	s.onLevels(levels, candle.Close[candle.Length()-2])
+}
+
+// onLevels trade on the levels and close of the last closed bar
+func (s *RtmRest) onLevels(levels RtmLevels, close float64) {
+	s.levels = levels
+	s.Sugar.Infow("rtm levels", "mean", levels.Mean, "upper", levels.Upper, "lower", levels.Lower,
+		"atr", levels.ATR, "trend", levels.Trend)
+	s.placeSell(false)
+	if !levels.Trend && s.executor.GetBuyOrderId() != 0 {
+		s.Sugar.Info("trend turns down, cancel the buy order")
+		if s.executor.CancelAllBuy() == nil {
+			s.buyPrice = decimal.Zero
+		}
+	}
+	if target, ok := levels.BuyPrice(close); ok {
+		s.placeBuy(target)
+	}
+}
+
+// placeBuy buy below the mean in up trend, the pending buy order is replaced if the price changes
+func (s *RtmRest) placeBuy(target float64) {
+	if !s.enabled {
+		s.Sugar.Debug("RTM strategy disabled")
+		return
+	}
+	realPrice := decimal.NewFromFloat(target).Round(s.executor.PricePrecision())
+	if !NeedReplace(s.executor.GetBuyOrderId(), s.buyPrice, realPrice) {
+		return
+	}
+	s.Sugar.Infof("long at price %s", realPrice)
+	if err := s.executor.CancelAllBuy(); err != nil {
+		return
+	}
+	s.buyPrice = decimal.Zero
+	if err := s.executor.BuyAllLimit(target); err != nil {
+		s.Sugar.Errorf("buy error: %s", err)
+		return
+	}
+	if s.executor.GetBuyOrderId() != 0 {
+		s.buyPrice = realPrice
+	}
+}
+
+// placeSell place the sell order at the sell price if it changes, or force to sell all coins
+func (s *RtmRest) placeSell(force bool) {
+	if s.levels.Mean == 0 {
+		return
+	}
+	target := s.levels.SellPrice()
+	realPrice := decimal.NewFromFloat(target).Round(s.executor.PricePrecision())
+	if !force && !NeedReplace(s.executor.GetSellOrderId(), s.sellPrice, realPrice) {
+		return
+	}
+	s.Sugar.Infof("sell at price %s", realPrice)
+	if err := s.executor.CancelAllSell(); err != nil {
+		return
+	}
+	s.sellPrice = decimal.Zero
+	if err := s.executor.SellAllLimit(target); err != nil {
+		s.Sugar.Errorf("sell error: %s", err)
+		return
+	}
+	if s.executor.GetSellOrderId() != 0 {
+		s.sellPrice = realPrice
+	}
+}
+
+// NeedReplace return true if there is no pending order, or the pending order is not at the price
+func NeedReplace(orderId uint64, placed, price decimal.Decimal) bool {
+	return orderId == 0 || !placed.Equal(price)
+}
+
+// when squeeze is fire off, stop new orders, waiting for sell out
+func (s *RtmRest) SqueezeOn(last int, dry bool) {
+	s.enabled = false
+}
+
+// trend is on, cancel opening orders and sell all coins
+func (s *RtmRest) TrendOn(up bool, last int, dry bool) {
+	pending := s.executor.GetBuyOrderId() != 0 || s.executor.GetSellOrderId() != 0
+	if !s.enabled && !pending {
+		return
+	}
+	s.enabled = false
+	if err := s.executor.CancelAll(); err != nil {
+		return
+	}
+	s.buyPrice = decimal.Zero
+	s.sellPrice = decimal.Zero
+	if err := s.executor.SellAllMarket(); err != nil {
+		s.Sugar.Errorf("sell market error: %s", err)
+	}
+}
+
+// start to RTM trading
+func (s *RtmRest) TrendOff(up bool, last int, dry bool) {
+	s.enabled = true
+}
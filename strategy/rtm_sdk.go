@@ -0,0 +1,86 @@
+package strategy
+
+import (
+	"errors"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/sdk"
+	"time"
+)
+
+// RtmSdk is the RTM strategy on the strategy SDK, it can run in live, paper and backtest mode.
+// It trades like RtmRest, the Squeeze filter is checked when a bar of its own interval closed.
+// The weekly, daily and rule checks of the Squeeze filter read the exchange directly, so they are not supported.
+type RtmSdk struct {
+	sdk.BaseStrategy
+
+	rest            *RtmRest
+	interval        time.Duration
+	squeeze         *SqueezeBase // nil if not configured
+	squeezeInterval time.Duration
+}
+
+func NewRtmSdk(config RtmStrategyConf) (*RtmSdk, error) {
+	interval, err := time.ParseDuration(config.Interval)
+	if err != nil {
+		return nil, err
+	}
+	if config.Factor <= 0 || config.Period <= 0 {
+		return nil, errors.New("factor and period must be positive")
+	}
+	s := &RtmSdk{rest: NewRtmRest(config), interval: interval}
+	if sc := config.Squeeze; sc.Interval != "" {
+		if sc.CheckWeekly || sc.CheckDaily || sc.Rule != "" {
+			return nil, errors.New("squeeze checkWeekly, checkDaily and rule are not supported by the runner")
+		}
+		if s.squeezeInterval, err = time.ParseDuration(sc.Interval); err != nil {
+			return nil, err
+		}
+		s.squeeze = NewSqueezeBase(sc, false)
+	}
+	return s, nil
+}
+
+func (s *RtmSdk) Name() string {
+	return "rtm"
+}
+
+func (s *RtmSdk) Subscription() sdk.Subscription {
+	intervals := []time.Duration{s.interval}
+	if s.squeeze != nil && s.squeezeInterval != s.interval {
+		intervals = append(intervals, s.squeezeInterval)
+	}
+	return sdk.Subscription{Intervals: intervals}
+}
+
+func (s *RtmSdk) OnStart(c *sdk.Context) error {
+	s.rest.Init(c.Sugar, c.Executor)
+	if s.squeeze != nil {
+		s.squeeze.Init(c.Sugar, c.Executor.Exchange(), c.Symbol.Symbol, s.rest.SqueezeOn, s.rest.TrendOn, s.rest.TrendOff)
+	}
+	c.Sugar.Infof("RTM started, buy order: %d, sell order: %d", c.Executor.GetBuyOrderId(), c.Executor.GetSellOrderId())
+	return nil
+}
+
+// OnCandleClosed check the squeeze first, so the RTM trades on the latest filter when the intervals are the same
+func (s *RtmSdk) OnCandleClosed(c *sdk.Context, interval time.Duration, candle hs.Candle) {
+	if s.squeeze != nil && interval == s.squeezeInterval {
+		s.squeeze.onTick(candle, true)
+	}
+	if interval != s.interval {
+		return
+	}
+	levels, ok := ClosedRtmLevels(candle, s.rest.config.Period, s.rest.config.Factor)
+	if !ok {
+		c.Sugar.Infof("not enough candles: %d", candle.Length())
+		return
+	}
+	s.rest.onLevels(levels, candle.Close[candle.Length()-1])
+}
+
+// OnOrderUpdate clear the full-filled orders, and sell the coins just bought
+func (s *RtmSdk) OnOrderUpdate(c *sdk.Context, o exchange.Order) {
+	c.Sugar.Infof("order %d / %s %s, price: %s, filled: %s / %s",
+		o.Id, o.ClientOrderId, o.Status, o.FilledPrice, o.FilledAmount, o.Amount)
+	s.rest.checkOrders()
+}
@@ -0,0 +1,91 @@
+package strategy
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRtmLevels_BuyPrice(t *testing.T) {
+	tests := []struct {
+		levels RtmLevels
+		close  float64
+		price  float64
+		ok     bool
+	}{
+		{RtmLevels{Mean: 100, ATR: 10, Trend: true}, 99, 95, true},
+		{RtmLevels{Mean: 100, ATR: 10, Trend: true}, 90, 90, true},
+		{RtmLevels{Mean: 100, ATR: 10, Trend: true}, 100, 0, false},
+		{RtmLevels{Mean: 100, ATR: 10, Trend: false}, 90, 0, false},
+	}
+	for i, tt := range tests {
+		price, ok := tt.levels.BuyPrice(tt.close)
+		if price != tt.price || ok != tt.ok {
+			t.Errorf("[%d] expect (%f, %t), got (%f, %t)", i, tt.price, tt.ok, price, ok)
+		}
+		if sell := tt.levels.SellPrice(); sell != 110 {
+			t.Errorf("[%d] expect sell price 110, got %f", i, sell)
+		}
+	}
+}
+
+func TestNeedReplace(t *testing.T) {
+	tests := []struct {
+		orderId uint64
+		placed  string
+		price   string
+		replace bool
+	}{
+		{0, "0", "1.23", true},
+		{1, "1.23", "1.23", false},
+		{1, "1.230", "1.23", false},
+		{1, "1.23", "1.24", true},
+		{1, "0", "1.23", true},
+	}
+	for i, tt := range tests {
+		if r := NeedReplace(tt.orderId, decimal.RequireFromString(tt.placed), decimal.RequireFromString(tt.price)); r != tt.replace {
+			t.Errorf("[%d] expect %t, got %t", i, tt.replace, r)
+		}
+	}
+}
+
+func TestClosedRtmLevels(t *testing.T) {
+	c := hs.NewCandle(100)
+	for i := int64(0); i < 50; i++ {
+		price := 100 + 10*math.Sin(float64(i)/5) + float64(i)
+		c.Append(hs.Ticker{Timestamp: i * 60, Open: price, High: price + 2, Low: price - 2, Close: price})
+	}
+	// the forming bar is ignored by NewRtmLevels
+	forming, ok1 := NewRtmLevels(c, 14, 2)
+	closed := hs.Candle{Timestamp: c.Timestamp[:49], High: c.High[:49], Low: c.Low[:49], Close: c.Close[:49]}
+	levels, ok2 := ClosedRtmLevels(closed, 14, 2)
+	if !ok1 || !ok2 {
+		t.Fatalf("want levels, got %t %t", ok1, ok2)
+	}
+	if forming != levels {
+		t.Errorf("want %+v, got %+v", forming, levels)
+	}
+	if _, ok := ClosedRtmLevels(hs.Candle{Close: closed.Close[:14]}, 14, 2); ok {
+		t.Error("want not enough candles")
+	}
+}
+
+func TestNewRtmSdk(t *testing.T) {
+	s, err := NewRtmSdk(RtmStrategyConf{Interval: "1h", Period: 14, Factor: 2, Squeeze: SqueezeStrategyConf{Interval: "4h"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub := s.Subscription(); len(sub.Intervals) != 2 || sub.Intervals[0] != time.Hour {
+		t.Errorf("want the rtm interval first and the squeeze interval, got %v", sub.Intervals)
+	}
+	for _, conf := range []RtmStrategyConf{
+		{Interval: "1h"},
+		{Interval: "1h", Period: 14, Factor: 2, Squeeze: SqueezeStrategyConf{Interval: "4h", CheckWeekly: true}},
+	} {
+		if _, err := NewRtmSdk(conf); err == nil {
+			t.Errorf("want error for %+v", conf)
+		}
+	}
+}
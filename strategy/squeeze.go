@@ -5,12 +5,16 @@ import (
 	indicator "github.com/xyths/go-indicators"
 	"github.com/xyths/hs"
 	"github.com/xyths/hs/exchange"
-	"github.com/xyths/qtr/exchange/huobi"
+	"github.com/xyths/qtr/mtf"
+	"github.com/xyths/qtr/rule"
 	"github.com/xyths/qtr/types"
 	"go.uber.org/zap"
 	"time"
 )
 
+// candleLookback is the number of bars for the indicators
+const candleLookback = 2000
+
 type SqueezeStrategyConf struct {
 	Total    float64
 	Interval string
@@ -22,6 +26,8 @@ type SqueezeStrategyConf struct {
 
 	CheckWeekly bool `json:"checkWeekly"`
 	CheckDaily  bool `json:"checkDaily"`
+	// Rule replace CheckWeekly and CheckDaily if not empty, eg. "squeeze(W).trend == 2 && supertrend(D, 3, 7).up"
+	Rule string `json:"rule"`
 }
 
 type SqueezeBase struct {
@@ -33,7 +39,8 @@ type SqueezeBase struct {
 	Sugar *zap.SugaredLogger
 	ex    exchange.RestAPIExchange
 
-	candle hs.Candle
+	candles *mtf.Manager // optional, share candles with other strategies
+	rule    *rule.Rule
 
 	handlerSqueezeOn func(last int, dry bool)
 	handlerTrendOn   func(up bool, last int, dry bool)
@@ -44,7 +51,6 @@ func NewSqueezeBase(config SqueezeStrategyConf, dry bool) *SqueezeBase {
 	s := &SqueezeBase{
 		config: config,
 		dry:    dry,
-		candle: hs.NewCandle(2000),
 	}
 	return s
 }
@@ -56,6 +62,13 @@ func (s *SqueezeBase) Init(logger *zap.SugaredLogger, ex exchange.RestAPIExchang
 		panic(err)
 	}
 	s.interval = interval
+	if s.config.Rule != "" {
+		r, err := rule.Parse(s.config.Rule)
+		if err != nil {
+			panic(err)
+		}
+		s.rule = r
+	}
 	s.Sugar = logger
 	s.symbol = symbol
 	s.ex = ex
@@ -64,7 +77,6 @@ func (s *SqueezeBase) Init(logger *zap.SugaredLogger, ex exchange.RestAPIExchang
 	s.handlerTrendOff = handlerTrendOff
 }
 
-// use candle not s.candle, for concurrency
 func (s *SqueezeBase) onTick(candle hs.Candle, finished bool) {
 	//s.Sugar.Debugf("onSqueezeTick")
 	if !finished {
@@ -102,19 +114,15 @@ func (s *SqueezeBase) onTick(candle hs.Candle, finished bool) {
 }
 
 func (s *SqueezeBase) isWeeklyUp() (up bool, err error) {
-	candle, err := s.ex.CandleBySize(s.symbol, time.Hour*24*7, 2000)
-	if err != nil {
-		return
-	}
-	r, _ := indicator.Squeeze(
-		s.config.BBL, s.config.KCL, s.config.BBF, s.config.KCF,
-		candle.High, candle.Low, candle.Close,
-	)
-	return r.Trend == 2, nil
+	return s.isUp(exchange.WEEK1)
 }
 
 func (s *SqueezeBase) isDailyUp() (up bool, err error) {
-	candle, err := s.ex.CandleBySize(s.symbol, time.Hour*24, 2000)
+	return s.isUp(exchange.DAY1)
+}
+
+func (s *SqueezeBase) isUp(period time.Duration) (up bool, err error) {
+	candle, err := s.candleOf(period)
 	if err != nil {
 		return
 	}
@@ -125,9 +133,19 @@ func (s *SqueezeBase) isDailyUp() (up bool, err error) {
 	return r.Trend == 2, nil
 }
 
+// candleOf return the candle of period from the candle manager if subscribed, or from exchange
+func (s *SqueezeBase) candleOf(period time.Duration) (hs.Candle, error) {
+	if s.candles != nil {
+		if candle, err := s.candles.Candle(s.symbol, period); err == nil {
+			return candle, nil
+		}
+	}
+	return s.ex.CandleBySize(s.symbol, period, candleLookback)
+}
+
 type SqueezeWs struct {
 	SqueezeBase
-	wsEx exchange.Exchange
+	subs []*mtf.Subscriber
 }
 
 func NewSqueezeWs(config SqueezeStrategyConf, dry bool) *SqueezeWs {
@@ -137,52 +155,61 @@ func NewSqueezeWs(config SqueezeStrategyConf, dry bool) *SqueezeWs {
 	return s
 }
 
-func (s *SqueezeWs) Init(logger *zap.SugaredLogger, ex exchange.Exchange, symbol string,
+func (s *SqueezeWs) Init(logger *zap.SugaredLogger, ex exchange.RestAPIExchange, candles *mtf.Manager, symbol string,
 	handlerSqueezeOn func(last int, dry bool), handlerTrendOn, handlerTrendOff func(up bool, last int, dry bool)) {
 	s.SqueezeBase.Init(logger, ex, symbol, handlerSqueezeOn, handlerTrendOn, handlerTrendOff)
-	s.wsEx = ex
+	s.candles = candles
 }
 
 func (s *SqueezeWs) Start() error {
-	{
-		candle, err := s.ex.CandleBySize(s.symbol, s.interval, 2000)
-		if err != nil {
+	sub, err := s.candles.Subscribe("squeeze-"+s.symbol, mtf.Request{
+		Symbol: s.symbol, Interval: s.interval, Lookback: candleLookback,
+	}, s.candleHandler)
+	if err != nil {
+		return err
+	}
+	s.subs = append(s.subs, sub)
+	// keep the weekly and daily candles in the manager, isUp read them by candleOf
+	if s.config.CheckWeekly {
+		if err := s.subscribeTrend(exchange.WEEK1); err != nil {
+			return err
+		}
+	}
+	if s.config.CheckDaily {
+		if err := s.subscribeTrend(exchange.DAY1); err != nil {
 			return err
 		}
-		s.candle.Add(candle)
-		s.onTick(s.candle, true)
 	}
-	s.wsEx.SubscribeCandlestick(s.symbol, "squeeze-tick", s.interval, s.tickerHandler)
+	candle, err := s.candles.Candle(s.symbol, s.interval)
+	if err != nil {
+		return err
+	}
+	s.onTick(candle, true)
 	return nil
 }
 
-func (s *SqueezeWs) Stop() {
-	s.wsEx.UnsubscribeCandlestick(s.symbol, "squeeze-tick", s.interval)
-}
-
-func (s *SqueezeWs) tickerHandler(resp interface{}) {
-	ticker, candle, err := huobi.CandlestickHandler(resp)
+func (s *SqueezeWs) subscribeTrend(period time.Duration) error {
+	sub, err := s.candles.Subscribe("squeeze-trend-"+s.symbol, mtf.Request{
+		Symbol: s.symbol, Interval: period, Lookback: candleLookback,
+	}, nil)
 	if err != nil {
-		s.Sugar.Info(err)
-		return
+		return err
 	}
-	if ticker != nil {
-		finished := false
-		if s.candle.Length() > 0 {
-			oldTime := s.candle.Timestamp[s.candle.Length()-1]
-			newTime := ticker.Timestamp
-			s.candle.Append(*ticker)
-			if oldTime != newTime {
-				s.Sugar.Info("candle is finished, ready for strategy")
-				finished = true
-			}
-			s.onTick(s.candle, finished)
-		} else {
-			s.Sugar.Info("candle is not ready for append")
-		}
+	s.subs = append(s.subs, sub)
+	return nil
+}
+
+func (s *SqueezeWs) Stop() {
+	for _, sub := range s.subs {
+		s.candles.Unsubscribe(sub)
 	}
-	if candle != nil {
-		s.Sugar.Info("tickerHandler has candle data, this should not append")
+	s.subs = nil
+}
+
+func (s *SqueezeWs) candleHandler(e mtf.Event) {
+	if e.Closed {
+		s.Sugar.Info("candle is finished, ready for strategy")
+		s.onTick(e.Candle, true)
 	}
 }
 
@@ -206,9 +233,20 @@ func (s *SqueezeRest) Run(ctx context.Context) {
 	s.doWork(ctx)
 }
 
+// doWork check the squeeze once, the orders are checked by the executor
 func (s *SqueezeRest) doWork(ctx context.Context) {
-	s.checkOrders(ctx)
-	if s.config.CheckWeekly {
+	if s.rule != nil {
+		on, err := s.rule.Eval(rule.ExchangeSource{Ex: s.ex, Size: candleLookback}, s.symbol)
+		if err != nil {
+			s.Sugar.Errorf("check rule error: %s", err)
+			return
+		}
+		if !on {
+			s.Sugar.Infof("rule `%s` is not satisfied, stop fighting", s.rule)
+			return
+		}
+		s.Sugar.Infof("rule `%s` is satisfied, it's safe to trade now", s.rule)
+	} else if s.config.CheckWeekly {
 		up, err := s.isWeeklyUp()
 		if err != nil {
 			s.Sugar.Errorf("check weekly error: %s", err)
@@ -234,7 +272,7 @@ func (s *SqueezeRest) doWork(ctx context.Context) {
 			s.Sugar.Infof("Daily Squeeze is uptrend, it's safe to trade now")
 		}
 	}
-	candle, err := s.ex.CandleBySize(s.symbol, s.interval, 2000)
+	candle, err := s.ex.CandleBySize(s.symbol, s.interval, candleLookback)
 	if err != nil {
 		s.Sugar.Error(err)
 		return
@@ -242,7 +280,3 @@ func (s *SqueezeRest) doWork(ctx context.Context) {
 
 	s.onTick(candle, true)
 }
-
-func (s *SqueezeRest) checkOrders(_ context.Context) {
-
-}
@@ -0,0 +1,96 @@
+package strategy
+
+import (
+	"errors"
+	"github.com/markcheno/go-talib"
+	"github.com/shopspring/decimal"
+	indicator "github.com/xyths/go-indicators"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/sdk"
+	"time"
+)
+
+type SuperTrendConf struct {
+	Name     string // instance name, it's the state namespace
+	Interval string
+	Factor   float64
+	Period   int
+}
+
+// SuperTrendStrategy is the SuperTrend on the strategy SDK, it can run in live, paper and backtest mode.
+// Long at market price in the up trend (all in, or sized by Runner.Sizing), sell all in the down trend.
+type SuperTrendStrategy struct {
+	sdk.BaseStrategy
+
+	config   SuperTrendConf
+	interval time.Duration
+	position int64 // 1 long, -1 short
+}
+
+func NewSuperTrendStrategy(config SuperTrendConf) (*SuperTrendStrategy, error) {
+	interval, err := time.ParseDuration(config.Interval)
+	if err != nil {
+		return nil, err
+	}
+	if config.Factor <= 0 || config.Period <= 0 {
+		return nil, errors.New("factor and period must be positive")
+	}
+	if config.Name == "" {
+		config.Name = "supertrend"
+	}
+	return &SuperTrendStrategy{config: config, interval: interval}, nil
+}
+
+func (s *SuperTrendStrategy) Name() string {
+	return s.config.Name
+}
+
+func (s *SuperTrendStrategy) Subscription() sdk.Subscription {
+	return sdk.Subscription{Intervals: []time.Duration{s.interval}}
+}
+
+func (s *SuperTrendStrategy) OnStart(c *sdk.Context) error {
+	if err := c.Store.Load(c.Ctx, "position", &s.position); err != nil {
+		return err
+	}
+	c.Sugar.Infof("SuperTrend started, position: %d", s.position)
+	return nil
+}
+
+func (s *SuperTrendStrategy) OnCandleClosed(c *sdk.Context, interval time.Duration, candle hs.Candle) {
+	l := candle.Length()
+	if l < 2 {
+		return
+	}
+	tsl, trend := indicator.SuperTrend(s.config.Factor, s.config.Period, candle.High, candle.Low, candle.Close)
+	c.Sugar.Debugf("SuperTrend = [..., (%f, %v), (%f, %v)]", tsl[l-2], trend[l-2], tsl[l-1], trend[l-1])
+	if trend[l-1] && s.position != 1 {
+		c.Sugar.Info("[Signal] BUY")
+		atr := talib.Atr(candle.High, candle.Low, candle.Close, s.config.Period)
+		if err := c.Executor.BuyTarget(decimal.NewFromFloat(atr[l-1])); err != nil {
+			c.Sugar.Errorf("buy error: %s", err)
+			return
+		}
+		s.setPosition(c, 1)
+	} else if !trend[l-1] && s.position == 1 {
+		c.Sugar.Info("[Signal] SELL")
+		if err := c.Executor.SellAllMarket(); err != nil {
+			c.Sugar.Errorf("sell error: %s", err)
+			return
+		}
+		s.setPosition(c, -1)
+	}
+}
+
+func (s *SuperTrendStrategy) OnOrderUpdate(c *sdk.Context, o exchange.Order) {
+	c.Sugar.Infof("order %d / %s %s, price: %s, filled: %s / %s",
+		o.Id, o.ClientOrderId, o.Status, o.FilledPrice, o.FilledAmount, o.Amount)
+}
+
+func (s *SuperTrendStrategy) setPosition(c *sdk.Context, position int64) {
+	s.position = position
+	if err := c.Store.Save(c.Ctx, "position", position); err != nil {
+		c.Sugar.Errorf("save position error: %s", err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/xyths/hs/exchange"
 	"github.com/xyths/hs/exchange/gateio"
 	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/rule"
 	"github.com/xyths/qtr/ta/grid"
 	"github.com/xyths/qtr/ta/natr"
 	"github.com/xyths/qtr/ta/squeeze"
@@ -198,6 +199,48 @@ func (a *Agent) Grid(ctx context.Context, symbols []string, size int64, output s
 	return g.WriteToCsv(ctx, r, output)
 }
 
+// Rule scan symbols by the rule text, write the matched symbols to csv in the order of 24h volume
+func (a *Agent) Rule(ctx context.Context, symbols []string, size int64, text string, output string) error {
+	r, err := rule.Parse(text)
+	if err != nil {
+		return err
+	}
+	symbols, err = a.fillSymbols(ctx, symbols)
+	if err != nil {
+		return err
+	}
+	symbols, _ = a.sortByVol24h(symbols)
+	src := rule.ExchangeSource{Ex: a.ex, Size: int(size)}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	defer w.Flush()
+	_ = w.Write([]string{"symbol"})
+	matched := 0
+	for _, symbol := range symbols {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		on, err := r.Eval(src, symbol)
+		if err != nil {
+			a.Sugar.Infof("skip %s: %s", symbol, err)
+			continue
+		}
+		if on {
+			matched++
+			_ = w.Write([]string{symbol})
+		}
+	}
+	a.Sugar.Infof("%d of %d symbols matched rule `%s`", matched, len(symbols), r)
+	return nil
+}
+
 func (a *Agent) fillSymbols(ctx context.Context, symbols []string) ([]string, error) {
 	// if no symbols, use all symbols available in the exchange
 	if len(symbols) == 0 {
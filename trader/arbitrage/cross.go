@@ -0,0 +1,389 @@
+package arbitrage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/broadcast"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/hs/exchange/gateio"
+	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/executor"
+	"go.uber.org/zap"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const crossStrategyName = "cross"
+
+// venue is the asset on one exchange
+type venue struct {
+	name   string
+	conf   hs.ExchangeConf
+	ex     exchange.RestAPIExchange
+	symbol exchange.Symbol
+	codec  executor.ClientIdCodec
+	source quoteSource
+}
+
+// CrossTrader monitor the spread of the same asset between exchanges, and arbitrage with the pre-funded inventory
+type CrossTrader struct {
+	config   CrossConfig
+	interval time.Duration
+	cooldown time.Duration
+	timeout  time.Duration
+	maxAge   time.Duration
+	amount   decimal.Decimal
+
+	Sugar  *zap.SugaredLogger
+	robots []broadcast.Broadcaster
+	venues map[string]*venue
+	costs  map[string]Cost
+
+	lock      sync.Mutex
+	quotes    map[string]Quote
+	lastAlert map[string]time.Time
+	executing bool
+}
+
+func NewCrossTrader(ctx context.Context, configFilename string) (*CrossTrader, error) {
+	cfg := CrossConfig{}
+	if err := hs.ParseJsonConfig(configFilename, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Exchanges) < 2 {
+		return nil, errors.New("cross exchange arbitrage needs 2 exchanges at least")
+	}
+	t := &CrossTrader{
+		config:    cfg,
+		amount:    decimal.NewFromFloat(cfg.Strategy.Amount),
+		venues:    make(map[string]*venue),
+		costs:     make(map[string]Cost),
+		quotes:    make(map[string]Quote),
+		lastAlert: make(map[string]time.Time),
+	}
+	var err error
+	if t.interval, err = parseDuration(cfg.Strategy.Interval, time.Second); err != nil {
+		return nil, err
+	}
+	if t.cooldown, err = parseDuration(cfg.Strategy.Cooldown, time.Minute); err != nil {
+		return nil, err
+	}
+	if t.timeout, err = parseDuration(cfg.Strategy.Timeout, 3*time.Second); err != nil {
+		return nil, err
+	}
+	if t.maxAge, err = parseDuration(cfg.Strategy.MaxAge, 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Strategy.Execute && !t.amount.IsPositive() {
+		return nil, errors.New("amount must be positive when execute")
+	}
+	if err := t.init(ctx); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseDuration(s string, d time.Duration) (time.Duration, error) {
+	if s == "" {
+		return d, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (t *CrossTrader) init(ctx context.Context) error {
+	l, err := hs.NewZapLogger(t.config.Log)
+	if err != nil {
+		return err
+	}
+	t.Sugar = l.Sugar()
+	t.Sugar.Info("Logger initialized")
+	for _, conf := range t.config.Exchanges {
+		v, err := t.newVenue(ctx, conf)
+		if err != nil {
+			return err
+		}
+		if _, ok := t.venues[v.name]; ok {
+			return fmt.Errorf("duplicate exchange %s, set different label", v.name)
+		}
+		t.venues[v.name] = v
+		fee, err := v.ex.GetFee(v.symbol.Symbol)
+		if err != nil {
+			return fmt.Errorf("get fee of %s error: %w", v.name, err)
+		}
+		w := t.config.Strategy.Withdraw[v.name]
+		t.costs[v.name] = Cost{
+			Taker:         fee.ActualTaker,
+			WithdrawBase:  decimal.NewFromFloat(w.Base),
+			WithdrawQuote: decimal.NewFromFloat(w.Quote),
+		}
+		t.Sugar.Infof("%s: %s, taker fee: %s, withdraw: %f %s / %f %s",
+			v.name, v.symbol.Symbol, fee.ActualTaker, w.Base, t.config.Strategy.Base, w.Quote, t.config.Strategy.Quote)
+	}
+	for _, conf := range t.config.Robots {
+		t.robots = append(t.robots, broadcast.New(conf))
+	}
+	t.Sugar.Info("Cross Trader initialized")
+	return nil
+}
+
+func (t *CrossTrader) newVenue(ctx context.Context, conf hs.ExchangeConf) (*venue, error) {
+	if len(conf.Symbols) == 0 {
+		return nil, fmt.Errorf("no symbol of %s", conf.Name)
+	}
+	v := &venue{name: conf.Label, conf: conf}
+	if v.name == "" {
+		v.name = conf.Name
+	}
+	symbol := conf.Symbols[0]
+	switch conf.Name {
+	case hs.GateIO:
+		g := gateio.New(conf.Key, conf.Secret, conf.Host, t.Sugar)
+		v.ex = g
+		v.source = &gateQuote{name: v.name, g: g, symbol: symbol, interval: t.interval, Sugar: t.Sugar}
+	case hs.Huobi:
+		c, err := huobi.New(conf.Label, conf.Key, conf.Secret, conf.Host)
+		if err != nil {
+			return nil, err
+		}
+		v.ex = c
+		v.source = &huobiQuote{name: v.name, c: c, symbol: symbol, Sugar: t.Sugar}
+	default:
+		return nil, fmt.Errorf("exchange %s is not supported", conf.Name)
+	}
+	var err error
+	if v.symbol, err = v.ex.GetSymbol(ctx, symbol); err != nil {
+		return nil, err
+	}
+	v.codec = executor.NewClientIdCodec(conf.Name, crossStrategyName, symbol)
+	return v, nil
+}
+
+func (t *CrossTrader) Close(ctx context.Context) {
+	if t.Sugar != nil {
+		t.Sugar.Info("Cross Trader closed")
+		_ = t.Sugar.Sync()
+	}
+}
+
+// Start stream the quotes of all exchanges, check the spread on every update
+func (t *CrossTrader) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, v := range t.venues {
+		wg.Add(1)
+		go func(v *venue) {
+			defer wg.Done()
+			v.source.Run(ctx, func(q Quote) {
+				t.onQuote(ctx, q)
+			})
+		}(v)
+	}
+	wg.Wait()
+	t.Sugar.Info(ctx.Err())
+	return nil
+}
+
+func (t *CrossTrader) onQuote(ctx context.Context, q Quote) {
+	t.lock.Lock()
+	t.quotes[q.Exchange] = q
+	quotes := t.freshQuotes(time.Now())
+	t.lock.Unlock()
+	if len(quotes) < 2 {
+		return
+	}
+	ops := Opportunities(quotes, t.costs, t.notional(quotes))
+	if len(ops) == 0 {
+		return
+	}
+	best := ops[0]
+	t.Sugar.Debugw("spread", "buy", best.Buy, "sell", best.Sell, "ask", best.BuyPrice, "bid", best.SellPrice,
+		"gross", best.Gross, "net", best.Net)
+	if best.Net.LessThan(decimal.NewFromFloat(t.config.Strategy.Threshold)) {
+		return
+	}
+	if !t.acquire(best) {
+		return
+	}
+	t.Broadcast("发现价差，%s 买入 %s，%s 卖出 %s，价差 %s%%，扣除手续费和提币费后 %s%%",
+		best.Buy, best.BuyPrice, best.Sell, best.SellPrice, best.Gross.Shift(2).Round(3), best.Net.Shift(2).Round(3))
+	if t.config.Strategy.Execute {
+		go func() {
+			defer t.release()
+			if err := t.arbitrage(ctx, best, t.quoteOf(best.Buy), t.quoteOf(best.Sell)); err != nil {
+				t.Sugar.Errorf("arbitrage error: %s", err)
+			}
+		}()
+	} else {
+		t.release()
+	}
+}
+
+// notional is the amount used to compute the net spread, the withdrawal cost depends on it
+func (t *CrossTrader) notional(quotes []Quote) decimal.Decimal {
+	if t.amount.IsPositive() {
+		return t.amount
+	}
+	// monitor only without amount, ignore the withdrawal cost by assuming a large amount
+	return decimal.NewFromInt(1e9).Div(quotes[0].Ask)
+}
+
+// freshQuotes return the valid quotes not older than maxAge, must hold the lock
+func (t *CrossTrader) freshQuotes(now time.Time) []Quote {
+	var quotes []Quote
+	for _, q := range t.quotes {
+		if q.Valid() && now.Sub(q.Time) <= t.maxAge {
+			quotes = append(quotes, q)
+		}
+	}
+	return quotes
+}
+
+func (t *CrossTrader) quoteOf(name string) Quote {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.quotes[name]
+}
+
+// acquire check the cooldown of the direction and that no arbitrage is executing
+func (t *CrossTrader) acquire(op Opportunity) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.executing {
+		return false
+	}
+	key := op.Buy + "->" + op.Sell
+	now := time.Now()
+	if now.Sub(t.lastAlert[key]) < t.cooldown {
+		return false
+	}
+	t.lastAlert[key] = now
+	t.executing = true
+	return true
+}
+
+func (t *CrossTrader) release() {
+	t.lock.Lock()
+	t.executing = false
+	t.lock.Unlock()
+}
+
+// arbitrage buy on the cheap exchange and sell on the dear one at the same time, with limit orders at the quote price.
+// The unfilled part is cancelled after timeout, the unmatched filled amount is reported.
+func (t *CrossTrader) arbitrage(ctx context.Context, op Opportunity, buyQuote, sellQuote Quote) error {
+	buy, sell := t.venues[op.Buy], t.venues[op.Sell]
+	quoteBalance, err := available(buy.ex, t.config.Strategy.Quote)
+	if err != nil {
+		return err
+	}
+	baseBalance, err := available(sell.ex, t.config.Strategy.Base)
+	if err != nil {
+		return err
+	}
+	amount := TradeAmount(t.amount, buyQuote, sellQuote, quoteBalance, baseBalance)
+	precision := buy.symbol.AmountPrecision
+	if sell.symbol.AmountPrecision < precision {
+		precision = sell.symbol.AmountPrecision
+	}
+	amount = amount.Truncate(precision)
+	if !tradable(buy.symbol, op.BuyPrice, amount) || !tradable(sell.symbol, op.SellPrice, amount) {
+		t.Sugar.Infof("amount %s is too small, quote balance on %s: %s, base balance on %s: %s",
+			amount, buy.name, quoteBalance, sell.name, baseBalance)
+		return nil
+	}
+	seq := time.Now().UnixNano() / int64(time.Millisecond)
+	var wg sync.WaitGroup
+	var bought, sold exchange.Order
+	var buyErr, sellErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		bought, buyErr = take(ctx, buy.ex, buy.symbol, buy.codec, true, op.BuyPrice, amount, seq, t.timeout, t.Sugar)
+	}()
+	go func() {
+		defer wg.Done()
+		sold, sellErr = take(ctx, sell.ex, sell.symbol, sell.codec, false, op.SellPrice, amount, seq, t.timeout, t.Sugar)
+	}()
+	wg.Wait()
+	if buyErr != nil {
+		t.Sugar.Errorf("buy on %s error: %s", buy.name, buyErr)
+	}
+	if sellErr != nil {
+		t.Sugar.Errorf("sell on %s error: %s", sell.name, sellErr)
+	}
+	profit := sold.FilledAmount.Mul(op.SellPrice).Sub(bought.FilledAmount.Mul(op.BuyPrice))
+	t.Broadcast("套利完成，%s 买入 %s @ %s，%s 卖出 %s @ %s，毛利 %s %s",
+		buy.name, bought.FilledAmount, op.BuyPrice, sell.name, sold.FilledAmount, op.SellPrice,
+		profit.Round(4), t.config.Strategy.Quote)
+	if diff := bought.FilledAmount.Sub(sold.FilledAmount); !diff.IsZero() {
+		t.Broadcast("套利两边成交不一致，差额 %s %s，请检查库存", diff, t.config.Strategy.Base)
+	}
+	return nil
+}
+
+// available return the available balance of currency, case insensitive
+func available(ex exchange.RestAPIExchange, currency string) (decimal.Decimal, error) {
+	balances, err := ex.SpotAvailableBalance()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return balanceOf(balances, currency), nil
+}
+
+func balanceOf(balances map[string]decimal.Decimal, currency string) decimal.Decimal {
+	for c, b := range balances {
+		if strings.EqualFold(c, currency) {
+			return b
+		}
+	}
+	return decimal.Zero
+}
+
+// Report print the inventory on every exchange, and the transfer to make it even
+func (t *CrossTrader) Report(ctx context.Context) error {
+	base, quote := t.config.Strategy.Base, t.config.Strategy.Quote
+	bases := make(map[string]decimal.Decimal)
+	quotes := make(map[string]decimal.Decimal)
+	for name, v := range t.venues {
+		balances, err := v.ex.SpotBalance()
+		if err != nil {
+			return fmt.Errorf("get balance of %s error: %w", name, err)
+		}
+		bases[name] = balanceOf(balances, base)
+		quotes[name] = balanceOf(balances, quote)
+	}
+	baseTransfer, quoteTransfer := Rebalance(bases), Rebalance(quotes)
+	log.Printf("Inventory of %s / %s", strings.ToUpper(base), strings.ToUpper(quote))
+	for name := range t.venues {
+		price, err := t.venues[name].ex.LastPrice(t.venues[name].symbol.Symbol)
+		if err != nil {
+			return err
+		}
+		log.Printf(`%s
+	%s: %s, transfer: %s
+	%s: %s, transfer: %s
+	price: %s, value: %s`,
+			name,
+			base, bases[name], baseTransfer[name].Truncate(8),
+			quote, quotes[name], quoteTransfer[name].Truncate(8),
+			price, bases[name].Mul(price).Add(quotes[name]).Round(2))
+	}
+	return nil
+}
+
+func (t *CrossTrader) Broadcast(format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	t.Sugar.Info(message)
+	beijing := time.FixedZone("Beijing Time", int((8 * time.Hour).Seconds()))
+	timeStr := time.Now().In(beijing).Format("2006-01-02 15:04:05")
+	symbol := strings.ToUpper(t.config.Strategy.Base + "/" + t.config.Strategy.Quote)
+	msg := fmt.Sprintf("%s [%s] [%s] %s", timeStr, crossStrategyName, symbol, message)
+	for _, robot := range t.robots {
+		if err := robot.SendText(msg); err != nil {
+			t.Sugar.Infof("broadcast error: %s", err)
+		}
+	}
+}
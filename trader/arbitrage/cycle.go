@@ -0,0 +1,128 @@
+package arbitrage
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"sort"
+	"strings"
+)
+
+// Leg convert From to To on Symbol, buy base with quote if Buy, otherwise sell base for quote
+type Leg struct {
+	Symbol exchange.Symbol
+	Buy    bool
+	From   string
+	To     string
+}
+
+// Cycle is 3 legs starting and ending with the same currency, eg. usdt -> btc -> eth -> usdt
+type Cycle [3]Leg
+
+func (c Cycle) String() string {
+	return strings.Join([]string{c[0].From, c[0].To, c[1].To, c[2].To}, "->")
+}
+
+// Cycles build the currency graph from the symbols, and return all the triangles from start
+func Cycles(symbols []exchange.Symbol, start string) []Cycle {
+	start = strings.ToLower(start)
+	// edges[from][to]
+	edges := make(map[string]map[string]Leg)
+	add := func(l Leg) {
+		if edges[l.From] == nil {
+			edges[l.From] = make(map[string]Leg)
+		}
+		edges[l.From][l.To] = l
+	}
+	for _, s := range symbols {
+		if s.Disabled {
+			continue
+		}
+		base, quote := strings.ToLower(s.BaseCurrency), strings.ToLower(s.QuoteCurrency)
+		add(Leg{Symbol: s, Buy: true, From: quote, To: base})
+		add(Leg{Symbol: s, Buy: false, From: base, To: quote})
+	}
+	var cycles []Cycle
+	for b, first := range edges[start] {
+		for c, second := range edges[b] {
+			if c == start {
+				continue
+			}
+			if third, ok := edges[c][start]; ok {
+				cycles = append(cycles, Cycle{first, second, third})
+			}
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i].String() < cycles[j].String()
+	})
+	return cycles
+}
+
+// Plan is the orders to run a cycle with the amount of start currency
+type Plan struct {
+	Cycle   Cycle
+	Prices  [3]decimal.Decimal // ask if buy, bid if sell
+	Amounts [3]decimal.Decimal // base amount of the orders
+	Start   decimal.Decimal
+	Final   decimal.Decimal
+	Return  decimal.Decimal // Final / Start - 1
+}
+
+func (p Plan) Key() string {
+	return p.Cycle.String()
+}
+
+// Evaluate simulate the cycle on the best bid/ask with the taker fee and precision rounding.
+// The top of book size (if known) and the min amount/total of every symbol are checked.
+func Evaluate(c Cycle, quotes map[string]Quote, fee, start decimal.Decimal) (Plan, error) {
+	p := Plan{Cycle: c, Start: start}
+	one := decimal.NewFromInt(1)
+	have := start
+	for i, l := range c {
+		q, ok := quotes[l.Symbol.Symbol]
+		if !ok || !q.Valid() {
+			return p, fmt.Errorf("no quote of %s", l.Symbol.Symbol)
+		}
+		var amount decimal.Decimal
+		if l.Buy {
+			p.Prices[i] = q.Ask
+			amount = have.Div(q.Ask).Truncate(l.Symbol.AmountPrecision)
+			if q.AskSize.IsPositive() && amount.GreaterThan(q.AskSize) {
+				return p, fmt.Errorf("%s ask size %s is less than %s", l.Symbol.Symbol, q.AskSize, amount)
+			}
+			// fee is paid in the base currency bought
+			have = amount.Mul(one.Sub(fee))
+		} else {
+			p.Prices[i] = q.Bid
+			amount = have.Truncate(l.Symbol.AmountPrecision)
+			if q.BidSize.IsPositive() && amount.GreaterThan(q.BidSize) {
+				return p, fmt.Errorf("%s bid size %s is less than %s", l.Symbol.Symbol, q.BidSize, amount)
+			}
+			have = amount.Mul(q.Bid).Mul(one.Sub(fee))
+		}
+		if !tradable(l.Symbol, p.Prices[i], amount) {
+			return p, fmt.Errorf("%s amount %s is too small", l.Symbol.Symbol, amount)
+		}
+		p.Amounts[i] = amount
+	}
+	p.Final = have
+	if start.IsPositive() {
+		p.Return = have.Div(start).Sub(one)
+	}
+	return p, nil
+}
+
+// Scan evaluate all the cycles, return the feasible plans sorted by return descending
+func Scan(cycles []Cycle, quotes map[string]Quote, fee, start decimal.Decimal) []Plan {
+	var plans []Plan
+	for _, c := range cycles {
+		if p, err := Evaluate(c, quotes, fee, start); err == nil {
+			plans = append(plans, p)
+		}
+	}
+	sort.SliceStable(plans, func(i, j int) bool {
+		return plans[i].Return.GreaterThan(plans[j].Return)
+	})
+	return plans
+}
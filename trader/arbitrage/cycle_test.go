@@ -0,0 +1,87 @@
+package arbitrage
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"testing"
+)
+
+func symbol(name, base, quote string) exchange.Symbol {
+	return exchange.Symbol{
+		Symbol:          name,
+		BaseCurrency:    base,
+		QuoteCurrency:   quote,
+		PricePrecision:  8,
+		AmountPrecision: 4,
+	}
+}
+
+var testSymbols = []exchange.Symbol{
+	symbol("btcusdt", "btc", "usdt"),
+	symbol("ethusdt", "eth", "usdt"),
+	symbol("ethbtc", "eth", "btc"),
+	symbol("htusdt", "ht", "usdt"),
+}
+
+func TestCycles(t *testing.T) {
+	cycles := Cycles(testSymbols, "USDT")
+	if len(cycles) != 2 {
+		t.Fatalf("expect 2 cycles, got %d", len(cycles))
+	}
+	expect := []string{"usdt->btc->eth->usdt", "usdt->eth->btc->usdt"}
+	for i, c := range cycles {
+		if c.String() != expect[i] {
+			t.Errorf("cycle %d: expect %s, got %s", i, expect[i], c)
+		}
+	}
+	c := cycles[0]
+	if !c[0].Buy || !c[1].Buy || c[2].Buy {
+		t.Errorf("wrong direction of %s: %v %v %v", c, c[0].Buy, c[1].Buy, c[2].Buy)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	quotes := map[string]Quote{
+		"btcusdt": {Bid: d("9999"), Ask: d("10000")},
+		"ethbtc":  {Bid: d("0.0199"), Ask: d("0.02")},
+		"ethusdt": {Bid: d("205"), Ask: d("205.1")},
+	}
+	cycles := Cycles(testSymbols, "usdt")
+	// usdt -> btc -> eth -> usdt, 1000 -> 0.1 btc -> 5 eth -> 1025 usdt
+	p, err := Evaluate(cycles[0], quotes, decimal.Zero, d("1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Final.Equal(d("1025")) || !p.Return.Equal(d("0.025")) {
+		t.Errorf("expect 1025 / 0.025, got %s / %s", p.Final, p.Return)
+	}
+	if !p.Amounts[0].Equal(d("0.1")) || !p.Amounts[1].Equal(d("5")) || !p.Amounts[2].Equal(d("5")) {
+		t.Errorf("wrong amounts: %v", p.Amounts)
+	}
+	// fee 0.1% on every leg, precision truncates the amounts
+	p, err = Evaluate(cycles[0], quotes, d("0.001"), d("1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0.1 * 0.999 = 0.0999 btc -> 4.995 eth -> 4.990005, truncated to 4.99, * 205 * 0.999
+	if !p.Amounts[2].Equal(d("4.99")) || !p.Final.Equal(d("1021.92705")) {
+		t.Errorf("expect 4.99 / 1021.92705, got %s / %s", p.Amounts[2], p.Final)
+	}
+	if _, err := Evaluate(cycles[0], map[string]Quote{}, decimal.Zero, d("1000")); err == nil {
+		t.Error("expect error without quotes")
+	}
+	quotes["ethbtc"] = Quote{Bid: d("0.0199"), Ask: d("0.02"), AskSize: d("1")}
+	if _, err := Evaluate(cycles[0], quotes, decimal.Zero, d("1000")); err == nil {
+		t.Error("expect error when ask size is not enough")
+	}
+}
+
+func TestFilterSymbols(t *testing.T) {
+	filtered := filterSymbols(testSymbols, []string{"USDT", "btc", "eth"})
+	if len(filtered) != 3 {
+		t.Errorf("expect 3 symbols, got %d", len(filtered))
+	}
+	if len(filterSymbols(testSymbols, nil)) != len(testSymbols) {
+		t.Error("empty list should keep all symbols")
+	}
+}
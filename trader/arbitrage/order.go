@@ -0,0 +1,80 @@
+package arbitrage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/executor"
+	"go.uber.org/zap"
+	"time"
+)
+
+// take place the limit order at the quote price, cancel it after timeout and return the final order, so it works like IOC.
+// It returns error if the order is not finished, the filled amount is unknown then.
+func take(ctx context.Context, ex exchange.RestAPIExchange, symbol exchange.Symbol, codec executor.ClientIdCodec,
+	buy bool, price, amount decimal.Decimal, seq int64, timeout time.Duration, sugar *zap.SugaredLogger) (o exchange.Order, err error) {
+	side := byte(executor.SideSell)
+	if buy {
+		side = executor.SideBuy
+	}
+	clientId, err := codec.Encode(side, executor.IntentEntry, -1, seq)
+	if err != nil {
+		return
+	}
+	price = price.Round(symbol.PricePrecision)
+	var orderId uint64
+	if buy {
+		orderId, err = ex.BuyLimit(symbol.Symbol, clientId, price, amount)
+	} else {
+		orderId, err = ex.SellLimit(symbol.Symbol, clientId, price, amount)
+	}
+	if err != nil {
+		return
+	}
+	if orderId == 0 {
+		return o, errors.New("order not placed, maybe insufficient balance")
+	}
+	sugar.Infof("order %d / %s placed, symbol: %s, price: %s, amount: %s", orderId, clientId, symbol.Symbol, price, amount)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		o, filled, err1 := ex.IsFullFilled(symbol.Symbol, orderId)
+		if err1 == nil && filled {
+			return o, nil
+		}
+		select {
+		case <-ctx.Done():
+			deadline = time.Now()
+		case <-time.After(timeout / 10):
+		}
+	}
+	if err = ex.CancelOrder(symbol.Symbol, orderId); err != nil {
+		sugar.Errorf("cancel order %d error: %s", orderId, err)
+	}
+	// the filled amount is final only after the cancel is done
+	for i := 0; i < finalChecks; i++ {
+		o, err = ex.GetOrderById(orderId, symbol.Symbol)
+		if err == nil && executor.OrderFinished(o.Status) {
+			return o, nil
+		}
+		select {
+		case <-ctx.Done():
+			return o, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	if err != nil {
+		return o, err
+	}
+	return o, fmt.Errorf("order %d is not finished after cancel, status: %s", orderId, o.Status)
+}
+
+// finalChecks is the times to check the order status after cancel, one second each
+const finalChecks = 5
+
+func tradable(symbol exchange.Symbol, price, amount decimal.Decimal) bool {
+	return amount.IsPositive() &&
+		amount.GreaterThanOrEqual(symbol.LimitOrderMinAmount) &&
+		amount.Mul(price).GreaterThanOrEqual(symbol.MinTotal)
+}
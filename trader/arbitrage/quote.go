@@ -0,0 +1,105 @@
+package arbitrage
+
+import (
+	"context"
+	"github.com/huobirdcenter/huobi_golang/pkg/client/marketwebsocketclient"
+	"github.com/huobirdcenter/huobi_golang/pkg/model/market"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange/gateio"
+	"github.com/xyths/hs/exchange/huobi"
+	"go.uber.org/zap"
+	"time"
+)
+
+type quoteHandler func(Quote)
+
+// quoteSource stream the best bid and ask of one symbol until ctx done
+type quoteSource interface {
+	Run(ctx context.Context, handler quoteHandler)
+}
+
+// huobiQuote subscribe the bbo topic of huobi websocket
+type huobiQuote struct {
+	name   string
+	c      *huobi.Client
+	symbol string
+	Sugar  *zap.SugaredLogger
+}
+
+func (h *huobiQuote) Run(ctx context.Context, handler quoteHandler) {
+	clientId := "arb-" + h.symbol
+	hb := new(marketwebsocketclient.BestBidOfferWebSocketClient).Init(h.c.Host)
+	hb.SetHandler(
+		func() {
+			hb.Subscribe(h.symbol, clientId)
+		},
+		func(response interface{}) {
+			resp, ok := response.(market.SubscribeBestBidOfferResponse)
+			if !ok {
+				h.Sugar.Warnf("unknown bbo response: %v", response)
+				return
+			}
+			if resp.Tick == nil {
+				return
+			}
+			handler(Quote{
+				Exchange: h.name,
+				Bid:      resp.Tick.Bid,
+				BidSize:  resp.Tick.BidSize,
+				Ask:      resp.Tick.Ask,
+				AskSize:  resp.Tick.AskSize,
+				Time:     time.Unix(0, resp.Tick.QuoteTime*int64(time.Millisecond)),
+			})
+		},
+	)
+	hb.Connect(true)
+	<-ctx.Done()
+	hb.UnSubscribe(h.symbol, clientId)
+	hb.Close()
+}
+
+// gateQuote poll the order book of gate, no best bid/ask in its websocket ticker
+type gateQuote struct {
+	name     string
+	g        *gateio.GateIO
+	symbol   string
+	interval time.Duration
+	Sugar    *zap.SugaredLogger
+}
+
+func (g *gateQuote) Run(ctx context.Context, handler quoteHandler) {
+	for {
+		if q, err := g.quote(); err != nil {
+			g.Sugar.Errorf("get %s order book error: %s", g.name, err)
+		} else {
+			handler(q)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(g.interval):
+		}
+	}
+}
+
+func (g *gateQuote) quote() (Quote, error) {
+	book, err := g.g.OrderBook(g.symbol)
+	if err != nil {
+		return Quote{}, err
+	}
+	q := Quote{Exchange: g.name, Time: time.Now()}
+	// don't rely on the order of levels
+	for _, a := range book.Asks {
+		price := decimal.NewFromFloat(a[0])
+		if q.Ask.IsZero() || price.LessThan(q.Ask) {
+			q.Ask, q.AskSize = price, decimal.NewFromFloat(a[1])
+		}
+	}
+	for _, b := range book.Bids {
+		price := decimal.NewFromFloat(b[0])
+		if price.GreaterThan(q.Bid) {
+			q.Bid, q.BidSize = price, decimal.NewFromFloat(b[1])
+		}
+	}
+	return q, nil
+}
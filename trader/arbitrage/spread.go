@@ -0,0 +1,86 @@
+package arbitrage
+
+import (
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+// NetSpread is the return rate of buying amount at buy.Ask and selling it at sell.Bid.
+// The base currency withdrawal fee of the buy side and the quote currency withdrawal fee of the sell side
+// are charged on every arbitrage, so the inventory can be moved back.
+func NetSpread(buy, sell Quote, buyCost, sellCost Cost, amount decimal.Decimal) (gross, net decimal.Decimal) {
+	if !buy.Ask.IsPositive() || !sell.Bid.IsPositive() || !amount.IsPositive() {
+		return
+	}
+	one := decimal.NewFromInt(1)
+	gross = sell.Bid.Sub(buy.Ask).Div(buy.Ask)
+	cost := amount.Mul(buy.Ask)
+	// fee of the buy side is paid in base currency
+	received := amount.Mul(one.Sub(buyCost.Taker))
+	proceeds := received.Mul(sell.Bid).Mul(one.Sub(sellCost.Taker))
+	withdraw := buyCost.WithdrawBase.Mul(buy.Ask).Add(sellCost.WithdrawQuote)
+	net = proceeds.Sub(cost).Sub(withdraw).Div(cost)
+	return
+}
+
+// Opportunities is all the directions between every two exchanges, sorted by net spread descending
+func Opportunities(quotes []Quote, costs map[string]Cost, amount decimal.Decimal) []Opportunity {
+	var ops []Opportunity
+	for _, buy := range quotes {
+		for _, sell := range quotes {
+			if buy.Exchange == sell.Exchange || !buy.Valid() || !sell.Valid() {
+				continue
+			}
+			gross, net := NetSpread(buy, sell, costs[buy.Exchange], costs[sell.Exchange], amount)
+			ops = append(ops, Opportunity{
+				Buy:       buy.Exchange,
+				Sell:      sell.Exchange,
+				BuyPrice:  buy.Ask,
+				SellPrice: sell.Bid,
+				Gross:     gross,
+				Net:       net,
+			})
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].Net.GreaterThan(ops[j].Net)
+	})
+	return ops
+}
+
+// TradeAmount is the amount can be traded now, limited by the top of book size (zero means unknown),
+// the quote balance of the buy side and the base balance of the sell side.
+func TradeAmount(max decimal.Decimal, buy, sell Quote, quoteBalance, baseBalance decimal.Decimal) decimal.Decimal {
+	amount := max
+	if buy.AskSize.IsPositive() {
+		amount = decimal.Min(amount, buy.AskSize)
+	}
+	if sell.BidSize.IsPositive() {
+		amount = decimal.Min(amount, sell.BidSize)
+	}
+	if buy.Ask.IsPositive() {
+		amount = decimal.Min(amount, quoteBalance.Div(buy.Ask))
+	}
+	amount = decimal.Min(amount, baseBalance)
+	if amount.IsNegative() {
+		return decimal.Zero
+	}
+	return amount
+}
+
+// Rebalance is the transfer to make the inventory even on all exchanges, positive means deposit and negative means withdraw
+func Rebalance(balances map[string]decimal.Decimal) map[string]decimal.Decimal {
+	if len(balances) == 0 {
+		return nil
+	}
+	total := decimal.Zero
+	for _, b := range balances {
+		total = total.Add(b)
+	}
+	target := total.Div(decimal.NewFromInt(int64(len(balances))))
+	transfer := make(map[string]decimal.Decimal, len(balances))
+	for name, b := range balances {
+		transfer[name] = target.Sub(b)
+	}
+	return transfer
+}
@@ -0,0 +1,77 @@
+package arbitrage
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func d(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func TestNetSpread(t *testing.T) {
+	tests := []struct {
+		buy, sell         Quote
+		buyCost, sellCost Cost
+		amount            string
+		gross, net        string
+	}{
+		{Quote{Ask: d("100")}, Quote{Bid: d("101")}, Cost{}, Cost{}, "1", "0.01", "0.01"},
+		{Quote{Ask: d("100")}, Quote{Bid: d("101")}, Cost{Taker: d("0.002")}, Cost{Taker: d("0.002")}, "1", "0.01", "0.00596404"},
+		// withdraw 0.01 base at 100 and 1 quote, 2 in total on cost 1000
+		{Quote{Ask: d("100")}, Quote{Bid: d("101")}, Cost{WithdrawBase: d("0.01")}, Cost{WithdrawQuote: d("1")}, "10", "0.01", "0.008"},
+		{Quote{Ask: d("100")}, Quote{Bid: d("99")}, Cost{}, Cost{}, "1", "-0.01", "-0.01"},
+		{Quote{}, Quote{Bid: d("99")}, Cost{}, Cost{}, "1", "0", "0"},
+	}
+	for i, tt := range tests {
+		gross, net := NetSpread(tt.buy, tt.sell, tt.buyCost, tt.sellCost, d(tt.amount))
+		if !gross.Equal(d(tt.gross)) || !net.Equal(d(tt.net)) {
+			t.Errorf("case %d: expect %s / %s, got %s / %s", i, tt.gross, tt.net, gross, net)
+		}
+	}
+}
+
+func TestOpportunities(t *testing.T) {
+	quotes := []Quote{
+		{Exchange: "gate", Bid: d("99"), Ask: d("100")},
+		{Exchange: "huobi", Bid: d("102"), Ask: d("103")},
+	}
+	ops := Opportunities(quotes, map[string]Cost{}, d("1"))
+	if len(ops) != 2 {
+		t.Fatalf("expect 2 opportunities, got %d", len(ops))
+	}
+	if ops[0].Buy != "gate" || ops[0].Sell != "huobi" || !ops[0].Net.Equal(d("0.02")) {
+		t.Errorf("best opportunity is wrong: %+v", ops[0])
+	}
+}
+
+func TestTradeAmount(t *testing.T) {
+	tests := []struct {
+		max         string
+		buy, sell   Quote
+		quote, base string
+		expect      string
+	}{
+		{"1", Quote{Ask: d("100")}, Quote{Bid: d("101")}, "1000", "10", "1"},
+		{"1", Quote{Ask: d("100"), AskSize: d("0.5")}, Quote{Bid: d("101"), BidSize: d("0.8")}, "1000", "10", "0.5"},
+		{"1", Quote{Ask: d("100")}, Quote{Bid: d("101")}, "30", "10", "0.3"},
+		{"1", Quote{Ask: d("100")}, Quote{Bid: d("101")}, "1000", "0.2", "0.2"},
+		{"1", Quote{Ask: d("100")}, Quote{Bid: d("101")}, "1000", "-1", "0"},
+	}
+	for i, tt := range tests {
+		got := TradeAmount(d(tt.max), tt.buy, tt.sell, d(tt.quote), d(tt.base))
+		if !got.Equal(d(tt.expect)) {
+			t.Errorf("case %d: expect %s, got %s", i, tt.expect, got)
+		}
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	transfer := Rebalance(map[string]decimal.Decimal{"gate": d("3"), "huobi": d("1")})
+	if !transfer["gate"].Equal(d("-1")) || !transfer["huobi"].Equal(d("1")) {
+		t.Errorf("wrong transfer: %v", transfer)
+	}
+	if Rebalance(nil) != nil {
+		t.Error("expect nil for no balance")
+	}
+}
@@ -0,0 +1,387 @@
+package arbitrage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/huobirdcenter/huobi_golang/pkg/client"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/broadcast"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/hs/exchange/gateio"
+	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/executor"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const triangleStrategyName = "tri"
+
+// TriangleTrader scan the triangular arbitrage on one exchange
+type TriangleTrader struct {
+	config   TriangleConfig
+	interval time.Duration
+	cooldown time.Duration
+	timeout  time.Duration
+	amount   decimal.Decimal
+	fee      decimal.Decimal
+
+	Sugar   *zap.SugaredLogger
+	robots  []broadcast.Broadcaster
+	ex      exchange.RestAPIExchange
+	tickers func() (map[string]Quote, error)
+	cycles  []Cycle
+	codec   map[string]executor.ClientIdCodec
+
+	lastAlert map[string]time.Time
+}
+
+func NewTriangleTrader(ctx context.Context, configFilename string) (*TriangleTrader, error) {
+	cfg := TriangleConfig{}
+	if err := hs.ParseJsonConfig(configFilename, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Strategy.Start == "" {
+		cfg.Strategy.Start = "usdt"
+	}
+	t := &TriangleTrader{
+		config:    cfg,
+		amount:    decimal.NewFromFloat(cfg.Strategy.Amount),
+		codec:     make(map[string]executor.ClientIdCodec),
+		lastAlert: make(map[string]time.Time),
+	}
+	if !t.amount.IsPositive() {
+		return nil, errors.New("amount must be positive")
+	}
+	var err error
+	if t.interval, err = parseDuration(cfg.Strategy.Interval, 2*time.Second); err != nil {
+		return nil, err
+	}
+	if t.cooldown, err = parseDuration(cfg.Strategy.Cooldown, time.Minute); err != nil {
+		return nil, err
+	}
+	if t.timeout, err = parseDuration(cfg.Strategy.Timeout, time.Second); err != nil {
+		return nil, err
+	}
+	if err := t.init(ctx); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *TriangleTrader) init(ctx context.Context) error {
+	l, err := hs.NewZapLogger(t.config.Log)
+	if err != nil {
+		return err
+	}
+	t.Sugar = l.Sugar()
+	t.Sugar.Info("Logger initialized")
+	if err := t.initEx(); err != nil {
+		return err
+	}
+	symbols, err := t.ex.AllSymbols(ctx)
+	if err != nil {
+		return err
+	}
+	t.cycles = Cycles(filterSymbols(symbols, t.config.Strategy.Currencies), t.config.Strategy.Start)
+	if len(t.cycles) == 0 {
+		return fmt.Errorf("no cycle from %s", t.config.Strategy.Start)
+	}
+	for _, c := range t.cycles {
+		for _, l := range c {
+			if _, ok := t.codec[l.Symbol.Symbol]; !ok {
+				t.codec[l.Symbol.Symbol] = executor.NewClientIdCodec(t.config.Exchange.Name, triangleStrategyName, l.Symbol.Symbol)
+			}
+		}
+	}
+	// the fee of the first cycle is used for all, the same on most exchanges
+	fee, err := t.ex.GetFee(t.cycles[0][0].Symbol.Symbol)
+	if err != nil {
+		return err
+	}
+	t.fee = fee.ActualTaker
+	for _, conf := range t.config.Robots {
+		t.robots = append(t.robots, broadcast.New(conf))
+	}
+	t.Sugar.Infof("Triangle Trader initialized, %d cycles from %s, taker fee: %s", len(t.cycles), t.config.Strategy.Start, t.fee)
+	return nil
+}
+
+func (t *TriangleTrader) initEx() error {
+	conf := t.config.Exchange
+	switch conf.Name {
+	case hs.GateIO:
+		t.ex = gateio.New(conf.Key, conf.Secret, conf.Host, t.Sugar)
+		t.tickers = func() (map[string]Quote, error) {
+			return gateTickers(conf.Host)
+		}
+	case hs.Huobi:
+		c, err := huobi.New(conf.Label, conf.Key, conf.Secret, conf.Host)
+		if err != nil {
+			return err
+		}
+		t.ex = c
+		t.tickers = func() (map[string]Quote, error) {
+			return huobiTickers(c.Host)
+		}
+	default:
+		return fmt.Errorf("exchange %s is not supported", conf.Name)
+	}
+	return nil
+}
+
+// filterSymbols keep the symbols of which both currencies are in the list, empty list keeps all
+func filterSymbols(symbols []exchange.Symbol, currencies []string) []exchange.Symbol {
+	if len(currencies) == 0 {
+		return symbols
+	}
+	set := make(map[string]bool)
+	for _, c := range currencies {
+		set[strings.ToLower(c)] = true
+	}
+	var filtered []exchange.Symbol
+	for _, s := range symbols {
+		if set[strings.ToLower(s.BaseCurrency)] && set[strings.ToLower(s.QuoteCurrency)] {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+func huobiTickers(host string) (map[string]Quote, error) {
+	hb := new(client.MarketClient).Init(host)
+	tickers, err := hb.GetAllSymbolsLast24hCandlesticksAskBid()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	quotes := make(map[string]Quote, len(tickers))
+	for _, t := range tickers {
+		quotes[t.Symbol] = Quote{Bid: t.Bid, BidSize: t.BidSize, Ask: t.Ask, AskSize: t.AskSize, Time: now}
+	}
+	return quotes, nil
+}
+
+type responseGateTicker struct {
+	CurrencyPair string `json:"currency_pair"`
+	LowestAsk    string `json:"lowest_ask"`
+	HighestBid   string `json:"highest_bid"`
+}
+
+// gateTickers get all the tickers from API v4 in one request, the size of best bid/ask is unknown
+func gateTickers(host string) (map[string]Quote, error) {
+	if host == "" {
+		host = "gateio.ws"
+	}
+	resp, err := http.Get("https://api." + host + "/api/v4/spot/tickers")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result []responseGateTicker
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("decode tickers error: %w", err)
+	}
+	now := time.Now()
+	quotes := make(map[string]Quote, len(result))
+	for _, r := range result {
+		bid, err1 := decimal.NewFromString(r.HighestBid)
+		ask, err2 := decimal.NewFromString(r.LowestAsk)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		quotes[strings.ToLower(r.CurrencyPair)] = Quote{Bid: bid, Ask: ask, Time: now}
+	}
+	return quotes, nil
+}
+
+func (t *TriangleTrader) Close(ctx context.Context) {
+	if t.Sugar != nil {
+		t.Sugar.Info("Triangle Trader closed")
+		_ = t.Sugar.Sync()
+	}
+}
+
+func (t *TriangleTrader) Start(ctx context.Context) error {
+	for {
+		t.scan(ctx)
+		select {
+		case <-ctx.Done():
+			t.Sugar.Info(ctx.Err())
+			return nil
+		case <-time.After(t.interval):
+		}
+	}
+}
+
+// Print scan once and print the best cycles
+func (t *TriangleTrader) Print(ctx context.Context) error {
+	quotes, err := t.tickers()
+	if err != nil {
+		return err
+	}
+	plans := Scan(t.cycles, quotes, t.fee, t.amount)
+	for i, p := range plans {
+		if i >= 10 {
+			break
+		}
+		fmt.Printf("%s\treturn: %s%%\tfinal: %s\n", p.Key(), p.Return.Shift(2).Round(4), p.Final.Round(8))
+	}
+	return nil
+}
+
+func (t *TriangleTrader) scan(ctx context.Context) {
+	quotes, err := t.tickers()
+	if err != nil {
+		t.Sugar.Errorf("get tickers error: %s", err)
+		return
+	}
+	plans := Scan(t.cycles, quotes, t.fee, t.amount)
+	if len(plans) == 0 {
+		return
+	}
+	best := plans[0]
+	t.Sugar.Debugw("best cycle", "cycle", best.Key(), "return", best.Return)
+	threshold := decimal.NewFromFloat(t.config.Strategy.Threshold)
+	for _, p := range plans {
+		if p.Return.LessThan(threshold) {
+			break
+		}
+		if time.Since(t.lastAlert[p.Key()]) < t.cooldown {
+			continue
+		}
+		t.lastAlert[p.Key()] = time.Now()
+		t.Broadcast("发现三角套利 %s，价格 %s / %s / %s，收益率 %s%%",
+			p.Key(), p.Prices[0], p.Prices[1], p.Prices[2], p.Return.Shift(2).Round(4))
+		if t.config.Strategy.Execute {
+			// quotes are changed after the first execution
+			if err := t.execute(ctx, p); err != nil {
+				t.Sugar.Errorf("execute cycle %s error: %s", p.Key(), err)
+			}
+			return
+		}
+	}
+}
+
+// execute run the legs one by one, every leg uses the amount received from the previous leg.
+// If a leg is not fully filled, the currency held is sold back to the start currency at market price.
+// If the state of a leg is unknown, it stops without unwinding.
+func (t *TriangleTrader) execute(ctx context.Context, p Plan) error {
+	one := decimal.NewFromInt(1)
+	seq := time.Now().UnixNano() / int64(time.Millisecond)
+	have := p.Start
+	for i, l := range p.Cycle {
+		amount := p.Amounts[i]
+		if i > 0 {
+			if l.Buy {
+				amount = have.Div(p.Prices[i]).Truncate(l.Symbol.AmountPrecision)
+			} else {
+				amount = have.Truncate(l.Symbol.AmountPrecision)
+			}
+		}
+		if !tradable(l.Symbol, p.Prices[i], amount) {
+			return t.unwind(ctx, p.Cycle, l.From, have, seq)
+		}
+		o, err := take(ctx, t.ex, l.Symbol, t.codec[l.Symbol.Symbol], l.Buy, p.Prices[i], amount, seq, t.timeout, t.Sugar)
+		if err != nil {
+			// the order may be still open or partly filled, don't trade on a guess
+			t.Broadcast("三角套利 %s 第%d步 %s 订单状态未知，已停止，请人工检查持仓 %s %s：%s",
+				p.Key(), i+1, l.Symbol.Symbol, have.Round(8), l.From, err)
+			return fmt.Errorf("leg %d %s error: %w", i, l.Symbol.Symbol, err)
+		}
+		price := o.FilledPrice
+		if !price.IsPositive() {
+			price = p.Prices[i]
+		}
+		if l.Buy {
+			left := have.Sub(o.FilledAmount.Mul(price))
+			have = o.FilledAmount.Mul(one.Sub(t.fee))
+			if !o.FilledAmount.Equal(amount) && i > 0 {
+				// the unspent part of the middle currency
+				if err := t.unwind(ctx, p.Cycle, l.From, left, seq); err != nil {
+					t.Sugar.Errorf("unwind %s error: %s", l.From, err)
+				}
+			}
+		} else {
+			left := have.Sub(o.FilledAmount)
+			have = o.FilledAmount.Mul(price).Mul(one.Sub(t.fee))
+			if !o.FilledAmount.Equal(amount) {
+				if err := t.unwind(ctx, p.Cycle, l.From, left, seq); err != nil {
+					t.Sugar.Errorf("unwind %s error: %s", l.From, err)
+				}
+			}
+		}
+		if !have.IsPositive() {
+			if i == 0 {
+				t.Sugar.Infof("first leg %s is not filled, give up", l.Symbol.Symbol)
+				return nil
+			}
+			return fmt.Errorf("leg %d %s is not filled", i, l.Symbol.Symbol)
+		}
+	}
+	profit := have.Sub(p.Start)
+	t.Broadcast("三角套利 %s 完成，投入 %s，收回 %s，盈利 %s %s",
+		p.Key(), p.Start, have.Round(8), profit.Round(8), p.Cycle[0].From)
+	return nil
+}
+
+// unwind convert the currency back to the start currency at market price, on the symbol between them in the cycle
+func (t *TriangleTrader) unwind(ctx context.Context, c Cycle, currency string, amount decimal.Decimal, seq int64) error {
+	start := c[0].From
+	if currency == start || !amount.IsPositive() {
+		return nil
+	}
+	for _, l := range c {
+		if l.From == currency && l.To == start || l.From == start && l.To == currency {
+			clientId, err := t.codec[l.Symbol.Symbol].Encode(executor.SideSell, executor.IntentStop, -1, seq)
+			if err != nil {
+				return err
+			}
+			var orderId uint64
+			if strings.EqualFold(l.Symbol.BaseCurrency, currency) {
+				amount = amount.Truncate(l.Symbol.AmountPrecision)
+				if amount.LessThan(l.Symbol.LimitOrderMinAmount) {
+					t.Sugar.Infof("%s %s is too small to unwind", amount, currency)
+					return nil
+				}
+				orderId, err = t.ex.SellMarket(l.Symbol, clientId, amount)
+			} else {
+				total := amount.Truncate(l.Symbol.PricePrecision)
+				if total.LessThan(l.Symbol.MinTotal) {
+					t.Sugar.Infof("%s %s is too small to unwind", total, currency)
+					return nil
+				}
+				orderId, err = t.ex.BuyMarket(l.Symbol, clientId, total)
+			}
+			if err != nil {
+				return err
+			}
+			t.Broadcast("三角套利 %s 未完全成交，%s %s 已按市价换回 %s，订单 %d", c, amount, currency, start, orderId)
+			return nil
+		}
+	}
+	return fmt.Errorf("no symbol between %s and %s in cycle %s", currency, start, c)
+}
+
+func (t *TriangleTrader) Broadcast(format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	t.Sugar.Info(message)
+	labels := []string{t.config.Exchange.Name, t.config.Exchange.Label}
+	beijing := time.FixedZone("Beijing Time", int((8 * time.Hour).Seconds()))
+	timeStr := time.Now().In(beijing).Format("2006-01-02 15:04:05")
+	msg := fmt.Sprintf("%s [%s] [%s] %s", timeStr, strings.Join(labels, "] ["), triangleStrategyName, message)
+	for _, robot := range t.robots {
+		if err := robot.SendText(msg); err != nil {
+			t.Sugar.Infof("broadcast error: %s", err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package arbitrage
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"time"
+)
+
+// CrossConfig monitor the same asset on several exchanges, Exchanges[i].Symbols[0] is the symbol in its own format
+type CrossConfig struct {
+	Exchanges []hs.ExchangeConf
+	Strategy  CrossConf
+	Log       hs.LogConf
+	Robots    []hs.BroadcastConf
+}
+
+type CrossConf struct {
+	Base  string // base currency, eg. btc
+	Quote string // quote currency, eg. usdt
+
+	Threshold float64 // alert when the net spread rate exceeds it, eg. 0.003
+	Execute   bool    // buy cheap and sell dear with the pre-funded balances
+	Amount    float64 // max base amount per arbitrage
+
+	Interval string `json:"interval"` // poll interval of the REST quotes, default 1s
+	Cooldown string `json:"cooldown"` // min interval between alerts (trades) of the same direction, default 1m
+	Timeout  string `json:"timeout"`  // wait time before cancel the unfilled orders, default 3s
+	MaxAge   string `json:"maxAge"`   // ignore quotes older than it, default 5s
+
+	// Withdraw cost of each exchange, keyed by exchange label (or name if no label)
+	Withdraw map[string]WithdrawConf
+}
+
+// WithdrawConf is the withdrawal fee, in base and quote currency
+type WithdrawConf struct {
+	Base  float64
+	Quote float64
+}
+
+// Quote is the best bid and ask of one exchange
+type Quote struct {
+	Exchange string
+	Bid      decimal.Decimal
+	BidSize  decimal.Decimal
+	Ask      decimal.Decimal
+	AskSize  decimal.Decimal
+	Time     time.Time
+}
+
+func (q Quote) Valid() bool {
+	return q.Bid.IsPositive() && q.Ask.IsPositive()
+}
+
+// Cost is the taker fee rate and the withdrawal fee of one exchange
+type Cost struct {
+	Taker         decimal.Decimal
+	WithdrawBase  decimal.Decimal
+	WithdrawQuote decimal.Decimal
+}
+
+// Opportunity is buying on Buy and selling on Sell
+type Opportunity struct {
+	Buy       string
+	Sell      string
+	BuyPrice  decimal.Decimal // ask of Buy
+	SellPrice decimal.Decimal // bid of Sell
+	Gross     decimal.Decimal // gross spread rate
+	Net       decimal.Decimal // spread rate net of fees and withdrawal cost
+}
+
+// TriangleConfig scan the triangles on one exchange
+type TriangleConfig struct {
+	Exchange hs.ExchangeConf
+	Strategy TriangleConf
+	Log      hs.LogConf
+	Robots   []hs.BroadcastConf
+}
+
+type TriangleConf struct {
+	Start      string   // start and end currency of the cycles, eg. usdt
+	Currencies []string // only these currencies are used in the cycles, empty means all
+	Amount     float64  // amount of start currency per arbitrage
+
+	Threshold float64 // alert when the return rate exceeds it, eg. 0.002
+	Execute   bool    // run the 3 legs and unwind to start currency if any leg is not filled
+
+	Interval string `json:"interval"` // scan interval, default 2s
+	Cooldown string `json:"cooldown"` // min interval between alerts (trades) of the same cycle, default 1m
+	Timeout  string `json:"timeout"`  // wait time before cancel the unfilled order of every leg, default 1s
+}
@@ -0,0 +1,404 @@
+package dca
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/broadcast"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/hs/exchange/gateio"
+	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/executor"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	collNameState = "state"
+	strategyName  = "dca"
+)
+
+// Trader buy a fixed (or indicator scaled) notional every interval, and take profit above the average cost
+type Trader struct {
+	config   Config
+	interval time.Duration
+	cooldown time.Duration // of take-profit
+	dry      bool
+
+	Sugar  *zap.SugaredLogger
+	db     *mongo.Database
+	ex     exchange.RestAPIExchange
+	symbol exchange.Symbol
+	robots []broadcast.Broadcaster
+
+	codec    executor.ClientIdCodec
+	sequence executor.Sequence
+
+	amount   decimal.Decimal // base currency held
+	cost     decimal.Decimal // quote currency spent for the amount held
+	realized decimal.Decimal
+	buys     int
+	sells    int
+	lastBuy  time.Time
+	lastSell time.Time
+}
+
+func NewTrader(ctx context.Context, configFilename string, dry bool) (*Trader, error) {
+	cfg := Config{}
+	if err := hs.ParseJsonConfig(configFilename, &cfg); err != nil {
+		return nil, err
+	}
+	interval, err := time.ParseDuration(cfg.Strategy.Interval)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Strategy.Amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	if cfg.Strategy.Name == "" {
+		cfg.Strategy.Name = strategyName
+	}
+	if m := cfg.Strategy.Modulation; m != nil {
+		if err := m.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if tp := cfg.Strategy.TakeProfit; tp != nil && (tp.Gain <= 0 || tp.Fraction <= 0 || tp.Fraction > 1) {
+		return nil, errors.New("take-profit gain must be positive and fraction must be in (0, 1]")
+	}
+	t := &Trader{
+		config:   cfg,
+		interval: interval,
+		cooldown: interval,
+		dry:      dry,
+	}
+	if tp := cfg.Strategy.TakeProfit; tp != nil && tp.Cooldown != "" {
+		if t.cooldown, err = time.ParseDuration(tp.Cooldown); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.init(ctx); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Trader) init(ctx context.Context) error {
+	l, err := hs.NewZapLogger(t.config.Log)
+	if err != nil {
+		return err
+	}
+	t.Sugar = l.Sugar()
+	t.Sugar.Info("Logger initialized")
+	db, err := hs.ConnectMongo(ctx, t.config.Mongo)
+	if err != nil {
+		return err
+	}
+	t.db = db
+	if err := t.initEx(ctx); err != nil {
+		return err
+	}
+	for _, conf := range t.config.Robots {
+		t.robots = append(t.robots, broadcast.New(conf))
+	}
+	t.codec = executor.NewClientIdCodec(t.config.Exchange.Name, t.config.Strategy.Name, t.symbol.Symbol)
+	t.sequence.Init(t.db.Collection(collNameState), "sequence")
+	if err := t.sequence.Load(ctx); err != nil {
+		return err
+	}
+	t.Sugar.Info("DCA Trader initialized")
+	return nil
+}
+
+func (t *Trader) initEx(ctx context.Context) (err error) {
+	cfg := t.config.Exchange
+	switch cfg.Name {
+	case "gate":
+		t.ex = gateio.New(cfg.Key, cfg.Secret, cfg.Host, t.Sugar)
+	case "huobi":
+		if t.ex, err = huobi.New(cfg.Label, cfg.Key, cfg.Secret, cfg.Host); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported exchange")
+	}
+	if t.symbol, err = t.ex.GetSymbol(ctx, cfg.Symbols[0]); err != nil {
+		return err
+	}
+	t.Sugar.Infof("Symbol: %s, PricePrecision: %d, AmountPrecision: %d, MinAmount: %s, MinTotal: %s",
+		t.symbol.Symbol, t.symbol.PricePrecision, t.symbol.AmountPrecision, t.symbol.LimitOrderMinAmount, t.symbol.MinTotal)
+	return nil
+}
+
+func (t *Trader) Close(ctx context.Context) {
+	if t.db != nil {
+		_ = t.db.Client().Disconnect(ctx)
+	}
+	if t.Sugar != nil {
+		t.Sugar.Info("DCA Trader closed")
+		_ = t.Sugar.Sync()
+	}
+}
+
+func (t *Trader) Print(ctx context.Context) error {
+	t.loadState(ctx)
+	price, err := t.ex.LastPrice(t.symbol.Symbol)
+	if err != nil {
+		return err
+	}
+	value := t.amount.Mul(price)
+	log.Printf(`State
+	Amount: %s
+	Cost: %s
+	Average Cost: %s
+	Last Price: %s
+	Value: %s
+	Unrealized PnL: %s
+	Realized PnL: %s
+	Buy times: %d, last: %s
+	Sell times: %d, last: %s`,
+		t.amount, t.cost.Round(2), averageCost(t.amount, t.cost, t.symbol.PricePrecision),
+		price, value.Round(2), value.Sub(t.cost).Round(2), t.realized.Round(2),
+		t.buys, t.lastBuy, t.sells, t.lastSell,
+	)
+	return nil
+}
+
+func (t *Trader) Clear(ctx context.Context) error {
+	if err := hs.DeleteKey(ctx, t.db.Collection(collNameState), "position"); err != nil {
+		return err
+	}
+	t.Sugar.Info("DCA state cleared")
+	return nil
+}
+
+func (t *Trader) Start(ctx context.Context) error {
+	t.loadState(ctx)
+	t.doWork(ctx)
+	wakeTime := alignedStart(time.Now(), t.interval, t.config.Exchange.Name).Add(t.interval)
+	sleepTime := time.Until(wakeTime)
+	t.Sugar.Debugf("next check time: %s", wakeTime.String())
+	for {
+		select {
+		case <-ctx.Done():
+			t.Sugar.Info(ctx.Err())
+			return nil
+		case <-time.After(sleepTime):
+			t.doWork(ctx)
+			wakeTime = wakeTime.Add(t.interval)
+			sleepTime = time.Until(wakeTime)
+			t.Sugar.Debugf("next check time: %s", wakeTime.String())
+		}
+	}
+}
+
+// alignedStart is the start of the interval now in, like RestTrader, gate's daily candle begins at 8:00
+func alignedStart(now time.Time, interval time.Duration, exchangeName string) time.Time {
+	if interval != time.Hour*24 {
+		return now.Truncate(interval)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if exchangeName == "gate" {
+		start = start.Add(time.Hour * 8)
+	}
+	if start.After(now) {
+		start = start.Add(-interval)
+	}
+	return start
+}
+
+// doWork take profit if the price is high enough, otherwise buy once in every interval
+func (t *Trader) doWork(ctx context.Context) {
+	price, err := t.ex.LastPrice(t.symbol.Symbol)
+	if err != nil {
+		t.Sugar.Errorf("get last price error: %s", err)
+		return
+	}
+	if t.takeProfit(ctx, price) {
+		return
+	}
+	start := alignedStart(time.Now(), t.interval, t.config.Exchange.Name)
+	if !t.lastBuy.Before(start) {
+		t.Sugar.Infof("already bought at %s in this interval", t.lastBuy)
+		return
+	}
+	total := t.buyTotal(ctx)
+	if total.LessThan(t.symbol.MinTotal) {
+		t.Sugar.Infof("buy total %s is less than min total %s", total, t.symbol.MinTotal)
+		return
+	}
+	if t.dry {
+		t.Sugar.Infof("[dry run] buy %s %s at %s", total, t.symbol.QuoteCurrency, price)
+		return
+	}
+	p := t.execute(ctx, exchange.TradeDirectionBuy, executor.IntentEntry, total, decimal.Zero)
+	if p.FilledAmount.IsZero() {
+		return
+	}
+	t.amount = t.amount.Add(p.FilledAmount)
+	t.cost = t.cost.Add(p.FilledTotal)
+	t.buys++
+	t.lastBuy = time.Now()
+	t.saveState(ctx)
+	t.Broadcast("定投买入 %s, 花费 %s, 持仓 %s, 平均成本 %s",
+		p.FilledAmount, p.FilledTotal.Round(2), t.amount, averageCost(t.amount, t.cost, t.symbol.PricePrecision))
+}
+
+// buyTotal is the configured amount scaled by the indicators, and limited by the total
+func (t *Trader) buyTotal(ctx context.Context) decimal.Decimal {
+	total := decimal.NewFromFloat(t.config.Strategy.Amount)
+	if m := t.config.Strategy.Modulation; m != nil {
+		period, _ := time.ParseDuration(m.Period)
+		candle, err := t.ex.CandleBySize(t.symbol.Symbol, period, m.Size)
+		if err != nil {
+			t.Sugar.Errorf("get candle error: %s, use the fixed amount", err)
+		} else {
+			s := m.signals(candle)
+			multiple := m.multiple(s)
+			t.Sugar.Infow("modulation", "superTrendDown", s.Down, "natr", s.Natr,
+				"squeezeTrend", s.SqueezeTrend, "squeezeLast", s.SqueezeLast, "multiple", multiple)
+			total = total.Mul(decimal.NewFromFloat(multiple))
+		}
+	}
+	if t.config.Strategy.Total > 0 {
+		left := decimal.NewFromFloat(t.config.Strategy.Total).Sub(t.cost)
+		if total.GreaterThan(left) {
+			total = left
+		}
+	}
+	return total.Truncate(t.symbol.PricePrecision)
+}
+
+// takeProfit sell part of the position if the price is Gain above the average cost, return true if sold.
+// The average cost is unchanged after selling, so it waits the cooldown before the next one.
+func (t *Trader) takeProfit(ctx context.Context, price decimal.Decimal) bool {
+	tp := t.config.Strategy.TakeProfit
+	if tp == nil {
+		return false
+	}
+	if time.Since(t.lastSell) < t.cooldown {
+		t.Sugar.Debugf("take profit is cooling down, last sell at %s", t.lastSell)
+		return false
+	}
+	amount := takeProfitAmount(t.amount, t.cost, price, decimal.NewFromFloat(tp.Gain), decimal.NewFromFloat(tp.Fraction), t.symbol.AmountPrecision)
+	if amount.LessThan(t.symbol.LimitOrderMinAmount) || amount.Mul(price).LessThan(t.symbol.MinTotal) {
+		return false
+	}
+	if t.dry {
+		t.Sugar.Infof("[dry run] take profit, sell %s at %s", amount, price)
+		return true
+	}
+	p := t.execute(ctx, exchange.TradeDirectionSell, executor.IntentTakeProfit, decimal.Zero, amount)
+	if p.FilledAmount.IsZero() {
+		return false
+	}
+	cost := t.cost.Mul(p.FilledAmount).DivRound(t.amount, 8)
+	profit := p.FilledTotal.Sub(cost)
+	t.amount = t.amount.Sub(p.FilledAmount)
+	t.cost = t.cost.Sub(cost)
+	t.realized = t.realized.Add(profit)
+	t.sells++
+	t.lastSell = time.Now()
+	t.saveState(ctx)
+	t.Broadcast("止盈卖出 %s, 收入 %s, 盈利 %s, 剩余持仓 %s", p.FilledAmount, p.FilledTotal.Round(2), profit.Round(2), t.amount)
+	return true
+}
+
+// takeProfitAmount is fraction of amount if price is gain above the average cost, otherwise zero
+func takeProfitAmount(amount, cost, price, gain, fraction decimal.Decimal, precision int32) decimal.Decimal {
+	if !amount.IsPositive() || !cost.IsPositive() {
+		return decimal.Zero
+	}
+	target := cost.Div(amount).Mul(decimal.NewFromInt(1).Add(gain))
+	if price.LessThan(target) {
+		return decimal.Zero
+	}
+	return amount.Mul(fraction).Truncate(precision)
+}
+
+func averageCost(amount, cost decimal.Decimal, precision int32) decimal.Decimal {
+	if !amount.IsPositive() {
+		return decimal.Zero
+	}
+	return cost.DivRound(amount, precision)
+}
+
+func (t *Trader) execute(ctx context.Context, direction string, intent executor.Intent, total, amount decimal.Decimal) executor.Progress {
+	conf := t.config.Strategy.Algo
+	// Gate不支持市价单，默认使用追单算法
+	if conf.Name == "" && t.config.Exchange.Name == "gate" {
+		conf.Name = executor.AlgoChase
+	}
+	job := executor.Job{Direction: direction, Total: total, Amount: amount}
+	algo, err := executor.NewAlgorithm(conf, t.ex, t.symbol, t.Sugar, nil)
+	if err != nil {
+		t.Sugar.Errorf("create algorithm error: %s", err)
+		return executor.Progress{Job: job}
+	}
+	side := byte(executor.SideBuy)
+	if direction == exchange.TradeDirectionSell {
+		side = executor.SideSell
+	}
+	sequence, err := t.sequence.Next(ctx)
+	if err != nil {
+		t.Sugar.Errorf("get sequence error: %s", err)
+		return executor.Progress{Job: job}
+	}
+	if job.ClientOrderId, err = t.codec.Encode(side, intent, -1, sequence); err != nil {
+		t.Sugar.Errorf("encode client order id error: %s", err)
+		return executor.Progress{Job: job}
+	}
+	p, err := algo.Execute(ctx, job)
+	if err != nil {
+		t.Sugar.Errorf("execute %s job %s error: %s", direction, job.ClientOrderId, err)
+	}
+	t.Sugar.Infof("%s job %s finished, filled amount: %s, filled total: %s", direction, job.ClientOrderId, p.FilledAmount, p.FilledTotal)
+	return p
+}
+
+func (t *Trader) loadState(ctx context.Context) {
+	var p position
+	if err := hs.LoadKey(ctx, t.db.Collection(collNameState), "position", &p); err != nil {
+		t.Sugar.Infof("no position loaded: %s", err)
+		return
+	}
+	t.amount, _ = decimal.NewFromString(p.Amount)
+	t.cost, _ = decimal.NewFromString(p.Cost)
+	t.realized, _ = decimal.NewFromString(p.Realized)
+	t.buys, t.sells = p.Buys, p.Sells
+	t.lastBuy, t.lastSell = p.LastBuy, p.LastSell
+}
+
+func (t *Trader) saveState(ctx context.Context) {
+	p := position{
+		Amount:   t.amount.String(),
+		Cost:     t.cost.String(),
+		Realized: t.realized.String(),
+		Buys:     t.buys,
+		Sells:    t.sells,
+		LastBuy:  t.lastBuy,
+		LastSell: t.lastSell,
+	}
+	if err := hs.SaveKey(ctx, t.db.Collection(collNameState), "position", p); err != nil {
+		t.Sugar.Errorf("save position error: %s", err)
+	}
+}
+
+func (t *Trader) Broadcast(format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	t.Sugar.Info(message)
+	labels := []string{t.config.Exchange.Name, t.config.Exchange.Label}
+	beijing := time.FixedZone("Beijing Time", int((8 * time.Hour).Seconds()))
+	timeStr := time.Now().In(beijing).Format("2006-01-02 15:04:05")
+	msg := fmt.Sprintf("%s [%s] [%s] %s", timeStr, strings.Join(labels, "] ["), t.symbol.Symbol, message)
+	for _, robot := range t.robots {
+		if err := robot.SendText(msg); err != nil {
+			t.Sugar.Infof("broadcast error: %s", err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package dca
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func TestTakeProfitAmount(t *testing.T) {
+	var tests = []struct {
+		amount, cost, price string
+		want                string
+	}{
+		{"2", "200", "120", "0"}, // average 100, target 130
+		{"2", "200", "130", "1"}, // sell half
+		{"2", "200", "150", "1"}, // sell half
+		{"0", "0", "150", "0"},   // empty
+		{"0.3", "30", "200", "0.15"},
+	}
+	for i, tt := range tests {
+		got := takeProfitAmount(decimal.RequireFromString(tt.amount), decimal.RequireFromString(tt.cost),
+			decimal.RequireFromString(tt.price), decimal.RequireFromString("0.3"), decimal.RequireFromString("0.5"), 4)
+		if !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("[%d] want %s, got %s", i, tt.want, got)
+		}
+	}
+}
+
+func TestAlignedStart(t *testing.T) {
+	loc := time.UTC
+	var tests = []struct {
+		now      time.Time
+		interval time.Duration
+		exchange string
+		want     time.Time
+	}{
+		{time.Date(2021, 3, 2, 10, 30, 0, 0, loc), time.Hour * 24, "huobi", time.Date(2021, 3, 2, 0, 0, 0, 0, loc)},
+		{time.Date(2021, 3, 2, 10, 30, 0, 0, loc), time.Hour * 24, "gate", time.Date(2021, 3, 2, 8, 0, 0, 0, loc)},
+		{time.Date(2021, 3, 2, 6, 30, 0, 0, loc), time.Hour * 24, "gate", time.Date(2021, 3, 1, 8, 0, 0, 0, loc)},
+		{time.Date(2021, 3, 2, 6, 30, 0, 0, loc), time.Hour * 4, "gate", time.Date(2021, 3, 2, 4, 0, 0, 0, loc)},
+	}
+	for i, tt := range tests {
+		if got := alignedStart(tt.now, tt.interval, tt.exchange); !got.Equal(tt.want) {
+			t.Errorf("[%d] want %s, got %s", i, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package dca
+
+import (
+	"github.com/markcheno/go-talib"
+	indicator "github.com/xyths/go-indicators"
+	"github.com/xyths/hs"
+	"time"
+)
+
+// signals is the indicator state of the last closed candle
+type signals struct {
+	Down         bool    // SuperTrend is down
+	Natr         float64 // NATR percent
+	SqueezeTrend int     // 1 squeeze, 2 up, -2 down, 0 stop
+	SqueezeLast  int     // candles since the squeeze trend began
+}
+
+func (c *ModulationConf) validate() error {
+	if c.Period == "" {
+		c.Period = "24h"
+	}
+	if c.Size <= 0 {
+		c.Size = 200
+	}
+	if c.Min <= 0 {
+		c.Min = 0.5
+	}
+	if c.Max <= 0 {
+		c.Max = 2
+	}
+	_, err := time.ParseDuration(c.Period)
+	return err
+}
+
+// signals compute the indicators on the candle
+func (c ModulationConf) signals(candle hs.Candle) signals {
+	var s signals
+	if conf := c.SuperTrend; conf != nil && candle.Length() > conf.Period+2 {
+		_, trend := indicator.SuperTrend(conf.Factor, conf.Period, candle.High, candle.Low, candle.Close)
+		s.Down = !trend[len(trend)-2]
+	}
+	if conf := c.Natr; conf != nil && candle.Length() > conf.Period+2 {
+		natr := talib.Natr(candle.High, candle.Low, candle.Close, conf.Period)
+		s.Natr = natr[len(natr)-2]
+	}
+	if conf := c.Squeeze; conf != nil && candle.Length() > conf.BBL+2 && candle.Length() > conf.KCL+2 {
+		r, _ := indicator.Squeeze(conf.BBL, conf.KCL, conf.BBF, conf.KCF, candle.High, candle.Low, candle.Close)
+		s.SqueezeTrend, s.SqueezeLast = r.Trend, r.Last
+	}
+	return s
+}
+
+// multiple is the scale of the buy amount on the signals
+func (c ModulationConf) multiple(s signals) float64 {
+	m := 1.0
+	if conf := c.SuperTrend; conf != nil && s.Down && conf.Multiple > 0 {
+		m *= conf.Multiple
+	}
+	if conf := c.Natr; conf != nil && s.Natr > conf.Threshold && conf.Multiple > 0 {
+		m *= conf.Multiple
+	}
+	if conf := c.Squeeze; conf != nil && s.SqueezeTrend == 2 && s.SqueezeLast >= conf.Last && conf.Multiple > 0 {
+		m *= conf.Multiple
+	}
+	if m < c.Min {
+		m = c.Min
+	}
+	if m > c.Max {
+		m = c.Max
+	}
+	return m
+}
@@ -0,0 +1,31 @@
+package dca
+
+import "testing"
+
+func TestMultiple(t *testing.T) {
+	conf := ModulationConf{
+		SuperTrend: &SuperTrendModulation{Multiple: 1.5},
+		Natr:       &NatrModulation{Threshold: 5, Multiple: 1.5},
+		Squeeze:    &SqueezeModulation{Last: 5, Multiple: 0.5},
+		Min:        0.5,
+		Max:        2,
+	}
+	var tests = []struct {
+		s    signals
+		want float64
+	}{
+		{signals{}, 1},
+		{signals{Down: true}, 1.5},
+		{signals{Down: true, Natr: 6}, 2}, // 2.25 limited by max
+		{signals{Natr: 4}, 1},
+		{signals{SqueezeTrend: 2, SqueezeLast: 5}, 0.5},
+		{signals{SqueezeTrend: 2, SqueezeLast: 3}, 1},
+		{signals{SqueezeTrend: -2, SqueezeLast: 8}, 1},
+		{signals{Natr: 6, SqueezeTrend: 2, SqueezeLast: 5}, 0.75},
+	}
+	for i, tt := range tests {
+		if got := conf.multiple(tt.s); got != tt.want {
+			t.Errorf("[%d] want %f, got %f", i, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package dca
+
+import (
+	"github.com/xyths/hs"
+	"github.com/xyths/qtr/executor"
+	"time"
+)
+
+type Config struct {
+	Exchange hs.ExchangeConf
+	Mongo    hs.MongoConf
+	Strategy StrategyConf
+	Log      hs.LogConf
+	Robots   []hs.BroadcastConf
+}
+
+type StrategyConf struct {
+	Name     string            // strategy instance name, encoded in client order id, default dca
+	Amount   float64           // quote currency to buy every interval, eg. 100 USDT
+	Interval string            // buy interval, eg. 24h, aligned to the candle start
+	Total    float64           // max quote currency invested (cost of the position), 0 means no limit
+	Algo     executor.AlgoConf // execution algorithm of the buy and sell, gate uses chase by default
+
+	Modulation *ModulationConf // scale the amount by indicators, nil means fixed amount
+	TakeProfit *TakeProfitConf `json:"takeProfit"` // nil means never sell
+}
+
+// ModulationConf scale the amount of every buy, the multiples are multiplied, then limited in [Min, Max]
+type ModulationConf struct {
+	Period string // candle period, default 24h
+	Size   int    // candle number, default 200
+
+	SuperTrend *SuperTrendModulation `json:"superTrend"` // buy more when the trend is down
+	Natr       *NatrModulation       // buy more when the volatility is high
+	Squeeze    *SqueezeModulation    // buy less in an extended up trend
+
+	Min float64 // min multiple, default 0.5
+	Max float64 // max multiple, default 2
+}
+
+type SuperTrendModulation struct {
+	Factor   float64
+	Period   int
+	Multiple float64 // multiple when the trend is down, eg. 1.5
+}
+
+type NatrModulation struct {
+	Period    int
+	Threshold float64 // natr percent, eg. 5
+	Multiple  float64 // multiple when natr is above threshold, eg. 1.5
+}
+
+type SqueezeModulation struct {
+	BBL      int     `json:"bbl"`
+	BBF      float64 `json:"bbf"`
+	KCL      int     `json:"kcl"`
+	KCF      float64 `json:"kcf"`
+	Last     int     // the up trend lasts at least Last candles is extended, eg. 5
+	Multiple float64 // multiple in the extended up trend, eg. 0.5
+}
+
+// TakeProfitConf sell Fraction of the position when the price is Gain above the average cost
+type TakeProfitConf struct {
+	Gain     float64 // 0.3 means 30% above the average cost
+	Fraction float64 // 0.5 means sell half of the position
+	Cooldown string  // min time between two take-profits, default the Interval
+}
+
+// position of the accumulated coin, decimals are saved as string
+type position struct {
+	Amount   string    `bson:"amount"`   // base currency held
+	Cost     string    `bson:"cost"`     // quote currency spent for the amount held
+	Realized string    `bson:"realized"` // realized profit of take-profit
+	Buys     int       `bson:"buys"`
+	Sells    int       `bson:"sells"`
+	LastBuy  time.Time `bson:"lastBuy"`
+	LastSell time.Time `bson:"lastSell"`
+}
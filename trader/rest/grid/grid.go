@@ -2,6 +2,7 @@ package grid
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/shopspring/decimal"
 	"github.com/xyths/hs"
@@ -9,6 +10,7 @@ import (
 	"github.com/xyths/hs/exchange"
 	"github.com/xyths/hs/exchange/gateio"
 	"github.com/xyths/hs/logger"
+	"github.com/xyths/qtr/executor"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"log"
@@ -22,6 +24,10 @@ type Config struct {
 	Mongo    hs.MongoConf
 	Strategy hs.RestGridStrategyConf
 	Robots   []hs.BroadcastConf
+	Futures  *executor.FuturesConf // place the grid orders on the perpetual contract, start from flat position
+	Range    RangeConf             // what to do when the price leaves the grid
+	Spacing  executor.SpacingConf  // level spacing and order size weight, default is geometric and even
+	Ledger   *executor.LedgerConf  // shared capital ledger, the limit is the grid total, spot only
 }
 
 type RestGridTrader struct {
@@ -40,6 +46,18 @@ type RestGridTrader struct {
 	base   int
 	cost   decimal.Decimal // average price
 	amount decimal.Decimal // amount held
+
+	outOfRange bool            // the price is out of the grid, logged once
+	profit     decimal.Decimal // realized profit of the filled sell orders
+	prefix     string          // collection name prefix, the grids of MultipleGridTrader share one database
+
+	codec    executor.ClientIdCodec
+	sequence executor.Sequence
+	futures  *executor.FuturesTrader
+
+	ledger    *executor.Ledger // owned ledger, nil when it's shared by MultipleGridTrader
+	reconcile time.Duration    // ledger reconcile interval
+	account   executor.Account
 }
 
 func New(configFilename string) *RestGridTrader {
@@ -71,11 +89,95 @@ func (r *RestGridTrader) Init(ctx context.Context) {
 	if err := r.initEx(ctx); err != nil {
 		logger.Sugar.Fatal(err)
 	}
-	r.initGrids(ctx)
+	if err := r.config.Spacing.Validate(); err != nil {
+		logger.Sugar.Fatal(err)
+	}
+	if err := r.initGrids(ctx); err != nil {
+		logger.Sugar.Fatal(err)
+	}
 	r.initRobots(ctx)
+	if err := r.initClientId(ctx); err != nil {
+		logger.Sugar.Fatal(err)
+	}
+	r.loadProfit(ctx)
+	if err := r.initLedger(ctx); err != nil {
+		logger.Sugar.Fatal(err)
+	}
 	r.stopCh = make(chan int, 1)
 }
 
+func (r *RestGridTrader) initLedger(ctx context.Context) error {
+	conf := r.config.Ledger
+	if conf == nil {
+		return nil
+	}
+	interval, err := conf.ReconcileInterval()
+	if err != nil {
+		return err
+	}
+	ledger, err := executor.NewLedger(ctx, *conf, logger.Sugar)
+	if err != nil {
+		return err
+	}
+	if err := r.useLedger(ctx, ledger, conf.Name); err != nil {
+		ledger.Close(ctx)
+		return err
+	}
+	r.ledger = ledger
+	r.reconcile = interval
+	return nil
+}
+
+// useLedger reserve capital for the buy orders in the shared ledger, the limit is the grid total
+func (r *RestGridTrader) useLedger(ctx context.Context, ledger *executor.Ledger, name string) error {
+	if r.futures != nil {
+		return errors.New("ledger can not be used with futures")
+	}
+	limit := decimal.NewFromFloat(r.config.Strategy.Total)
+	if err := r.account.Init(ctx, ledger, name, r.Symbol.QuoteCurrency, r.Symbol.Symbol, limit); err != nil {
+		return err
+	}
+	logger.Sugar.Infof("Ledger initialized, allocation: %s, limit: %s", name, limit)
+	return nil
+}
+
+func (r *RestGridTrader) collection(name string) *mongo.Collection {
+	return r.db.Collection(r.prefix + name)
+}
+
+func (r *RestGridTrader) loadProfit(ctx context.Context) {
+	var profit string
+	if err := hs.LoadKey(ctx, r.collection(collNameState), "profit", &profit); err != nil {
+		return
+	}
+	r.profit, _ = decimal.NewFromString(profit)
+}
+
+func (r *RestGridTrader) addProfit(ctx context.Context, profit decimal.Decimal) {
+	r.profit = r.profit.Add(profit)
+	if err := hs.SaveKey(ctx, r.collection(collNameState), "profit", r.profit.String()); err != nil {
+		logger.Sugar.Errorf("save profit error: %s", err)
+	}
+}
+
+func (r *RestGridTrader) initClientId(ctx context.Context) error {
+	r.codec = executor.NewClientIdCodec(r.config.Exchange.Name, strategyName, r.Symbol.Symbol)
+	r.sequence.Init(r.collection(collNameState), "sequence")
+	if err := r.sequence.Load(ctx); err != nil {
+		return fmt.Errorf("load sequence error: %w", err)
+	}
+	return nil
+}
+
+// clientOrderId return the grid order id, level < 0 means not a grid level order (eg. rebalance)
+func (r *RestGridTrader) clientOrderId(ctx context.Context, side byte, intent executor.Intent, level int) (string, error) {
+	sequence, err := r.sequence.Next(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get sequence error: %w", err)
+	}
+	return r.codec.Encode(side, intent, level, sequence)
+}
+
 func (r *RestGridTrader) initEx(ctx context.Context) error {
 	r.ex = gateio.New(r.config.Exchange.Key, r.config.Exchange.Secret, r.config.Exchange.Host, logger.Sugar)
 	symbol, err := r.ex.GetSymbol(ctx, r.config.Exchange.Symbols[0])
@@ -83,43 +185,61 @@ func (r *RestGridTrader) initEx(ctx context.Context) error {
 		return err
 	}
 	r.Symbol = symbol
+	if conf := r.config.Futures; conf != nil {
+		c := *conf
+		if c.Exchange == "" {
+			c.Exchange = r.config.Exchange.Name
+		}
+		ex, err := executor.NewFuturesExchange(c, r.config.Exchange.Key, r.config.Exchange.Secret)
+		if err != nil {
+			return err
+		}
+		if r.futures, err = executor.NewFuturesTrader(ctx, c, ex, logger.Sugar); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (r *RestGridTrader) initGrids(ctx context.Context) {
+func (r *RestGridTrader) initGrids(ctx context.Context) error {
 	maxPrice := r.config.Strategy.MaxPrice
 	minPrice := r.config.Strategy.MinPrice
 	number := r.config.Strategy.Number
 	total := r.config.Strategy.Total
 	//log.Debugf("init grids, MaxPrice: %f, MinPrice: %f, Grid Number: %d, total: %f",
 	//	maxPrice, minPrice, number, total)
-	r.scale = decimal.NewFromFloat(math.Pow(minPrice/maxPrice, 1.0/float64(number)))
-	preTotal := decimal.NewFromFloat(total / float64(number))
-	currentPrice := decimal.NewFromFloat(maxPrice)
-	currentGrid := hs.Grid{
-		Id:    0,
-		Price: currentPrice.Round(r.Symbol.PricePrecision),
-	}
-	r.grids = append(r.grids, currentGrid)
-	for i := 1; i <= number; i++ {
-		currentPrice = currentPrice.Mul(r.scale).Round(r.Symbol.PricePrecision)
-		amountBuy := preTotal.DivRound(currentPrice, r.Symbol.AmountPrecision)
-		if amountBuy.LessThan(r.Symbol.LimitOrderMinAmount) {
-			log.Fatalf("amount %s less than minAmount(%s)", amountBuy, r.Symbol.LimitOrderMinAmount)
+	if r.config.Spacing.Mode == executor.SpacingNatr {
+		var err error
+		if maxPrice, minPrice, _, err = r.natrRange(ctx, number); err != nil {
+			return err
 		}
-		realTotal := currentPrice.Mul(amountBuy)
-		if realTotal.LessThan(r.Symbol.MinTotal) {
-			log.Fatalf("total %s less than minTotal(%s)", realTotal, r.Symbol.MinTotal)
-		}
-		currentGrid = hs.Grid{
-			Id:        i,
-			Price:     currentPrice,
-			AmountBuy: amountBuy,
-			TotalBuy:  realTotal,
-		}
-		r.grids = append(r.grids, currentGrid)
-		r.grids[i-1].AmountSell = amountBuy
 	}
+	scale, grids, err := r.buildGrids(maxPrice, minPrice, number, total)
+	if err != nil {
+		return err
+	}
+	r.scale, r.grids = scale, grids
+	if r.config.Range.Mode == RangeTrailing && r.config.Range.BaseValue <= 0 {
+		return errors.New("baseValue must be positive in trailing mode")
+	}
+	return nil
+}
+
+// buildGrids return the ladder from maxPrice down to minPrice, spaced and weighted by the config.
+// The scale is the ratio of the first two levels for arithmetic spacing.
+func (r *RestGridTrader) buildGrids(maxPrice, minPrice float64, number int, total float64) (decimal.Decimal, []hs.Grid, error) {
+	spacing := r.config.Spacing
+	prices, err := executor.GridPrices(spacing.Mode, maxPrice, minPrice, number, r.Symbol.PricePrecision)
+	if err != nil {
+		return decimal.Zero, nil, err
+	}
+	scale := decimal.NewFromFloat(math.Pow(minPrice/maxPrice, 1.0/float64(number)))
+	if spacing.Mode == executor.SpacingArithmetic {
+		scale = prices[1].DivRound(prices[0], 8)
+	}
+	weights := executor.GridWeights(spacing.Weight, spacing.WeightRatio, number)
+	grids, err := executor.BuildGrids(prices, decimal.NewFromFloat(total), weights, r.Symbol)
+	return scale, grids, err
 }
 
 func (r *RestGridTrader) initRobots(ctx context.Context) {
@@ -145,18 +265,24 @@ func (r *RestGridTrader) Print(ctx context.Context) error {
 }
 
 func (r *RestGridTrader) Close(ctx context.Context) {
+	if r.ledger != nil {
+		r.ledger.Close(ctx)
+	}
 	if r.db != nil {
 		_ = r.db.Client().Disconnect(ctx)
 	}
 }
 
 const (
-	collNameGrid = "grid"
-	collNameBase = "base"
+	collNameGrid  = "grid"
+	collNameBase  = "base"
+	collNameState = "state"
+
+	strategyName = "grid"
 )
 
-func (r *RestGridTrader) saveGrids(ctx context.Context) {
-	collGrid := r.db.Collection(collNameGrid)
+func (r *RestGridTrader) saveGrids(ctx context.Context) error {
+	collGrid := r.collection(collNameGrid)
 	for _, g := range r.grids {
 		if _, err := collGrid.InsertOne(ctx, bson.D{
 			{"id", g.Id},
@@ -166,20 +292,21 @@ func (r *RestGridTrader) saveGrids(ctx context.Context) {
 			{"totalBuy", g.TotalBuy.String()},
 			{"order", g.Order},
 		}); err != nil {
-			logger.Sugar.Fatalf("error when save Grids: %s", err)
+			return fmt.Errorf("error when save Grids: %w", err)
 		}
 	}
-	collBase := r.db.Collection(collNameBase)
+	collBase := r.collection(collNameBase)
 	if _, err := collBase.InsertOne(ctx, bson.D{
 		{"symbol", r.Symbol.Symbol},
 		{"base", r.base},
 	}); err != nil {
-		logger.Sugar.Fatalf("error when save short base: %s", err)
+		return fmt.Errorf("error when save short base: %w", err)
 	}
+	return nil
 }
 
 func (r *RestGridTrader) loadGrids(ctx context.Context) bool {
-	collBase := r.db.Collection(collNameBase)
+	collBase := r.collection(collNameBase)
 	var base struct {
 		Symbol string
 		Base   int
@@ -189,7 +316,7 @@ func (r *RestGridTrader) loadGrids(ctx context.Context) bool {
 	}
 	r.base = base.Base
 
-	collGrid := r.db.Collection(collNameGrid)
+	collGrid := r.collection(collNameGrid)
 	cursor, err := collGrid.Find(ctx, bson.D{})
 	var items []struct {
 		Id                                     int
@@ -201,9 +328,13 @@ func (r *RestGridTrader) loadGrids(ctx context.Context) bool {
 		return false
 	}
 
+	// the saved ladder may be moved by recenter or trailing, it takes precedence over config
+	if len(items) > 0 {
+		r.grids = make([]hs.Grid, len(items))
+	}
 	for _, item := range items {
 		if item.Id < 0 || item.Id >= len(r.grids) {
-			logger.Sugar.Fatalw("loaded grid index out of range",
+			logger.Sugar.Errorw("loaded grid index out of range",
 				"id", item.Id,
 				"grids", len(r.grids),
 				"price", item.Price,
@@ -211,6 +342,11 @@ func (r *RestGridTrader) loadGrids(ctx context.Context) bool {
 			)
 			continue
 		}
+		price, _ := decimal.NewFromString(item.Price)
+		amountBuy, _ := decimal.NewFromString(item.AmountBuy)
+		amountSell, _ := decimal.NewFromString(item.AmountSell)
+		totalBuy, _ := decimal.NewFromString(item.TotalBuy)
+		r.grids[item.Id] = hs.Grid{Id: item.Id, Price: price, AmountBuy: amountBuy, AmountSell: amountSell, TotalBuy: totalBuy}
 		if item.Order != 0 {
 			r.grids[item.Id].Order = item.Order
 			logger.Sugar.Infow("grid loaded",
@@ -226,34 +362,62 @@ func (r *RestGridTrader) loadGrids(ctx context.Context) bool {
 			)
 		}
 	}
+	if len(r.grids) > 1 && r.grids[0].Price.IsPositive() {
+		r.scale = r.grids[1].Price.DivRound(r.grids[0].Price, 8)
+	}
 
 	return true
 }
 
 func (r *RestGridTrader) Start(ctx context.Context) error {
+	if err := r.setup(ctx); err != nil {
+		return err
+	}
+	return r.run(ctx)
+}
+
+// setup load the saved grid, or rebalance and place the grid orders for a new one
+func (r *RestGridTrader) setup(ctx context.Context) error {
+	if _, err := time.ParseDuration(r.config.Strategy.Interval); err != nil {
+		return fmt.Errorf("error interval format: %s", r.config.Strategy.Interval)
+	}
 	_ = r.Print(ctx)
-	if !r.loadGrids(ctx) {
-		logger.Sugar.Info("no order loaded")
-		// rebalance
-		if r.config.Strategy.Rebalance {
-			if err := r.ReBalance(ctx, false); err != nil {
-				log.Fatalf("error when rebalance: %s", err)
-			}
+	if r.loadGrids(ctx) {
+		return nil
+	}
+	logger.Sugar.Info("no order loaded")
+	// rebalance
+	if r.config.Strategy.Rebalance || r.futures != nil {
+		if err := r.ReBalance(ctx, false); err != nil {
+			return fmt.Errorf("error when rebalance: %w", err)
 		}
-		// setup all grid orders
-		r.setupGridOrders(ctx)
-		r.saveGrids(ctx)
 	}
+	// setup all grid orders
+	r.setupGridOrders(ctx)
+	return r.saveGrids(ctx)
+}
 
-	interval, err := time.ParseDuration(r.config.Strategy.Interval)
-	if err != nil {
-		logger.Sugar.Fatalf("error interval format: %s", r.config.Strategy.Interval)
+// run check the grid orders until stopped, the grid must be setup before
+func (r *RestGridTrader) run(ctx context.Context) error {
+	interval, _ := time.ParseDuration(r.config.Strategy.Interval)
+	// rebuild the natr grid periodically, nil channel never fires
+	var refresh <-chan time.Time
+	if r.config.Spacing.Mode == executor.SpacingNatr && r.config.Spacing.Refresh != "" {
+		d, _ := time.ParseDuration(r.config.Spacing.Refresh)
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+	if r.ledger != nil {
+		go r.ledger.Start(ctx, r.ex, r.reconcile)
 	}
 	logger.Sugar.Infof("grid (%s) is started", r.Symbol.Symbol)
 	r.Running = true
 	r.checkOrders(ctx)
 	for {
 		select {
+		case <-refresh:
+			r.respace(ctx, false)
 		case <-ctx.Done():
 			logger.Sugar.Info("context cancelled")
 			r.Running = false
@@ -273,9 +437,9 @@ func (r *RestGridTrader) Stop(ctx context.Context) error {
 }
 
 func (r *RestGridTrader) ReBalance(ctx context.Context, dryRun bool) error {
-	price, err := r.last()
+	price, err := r.last(ctx)
 	if err != nil {
-		logger.Sugar.Fatalf("get ticker error: %s", err)
+		return fmt.Errorf("get ticker error: %w", err)
 	}
 	r.base = 0
 	moneyNeed := decimal.NewFromInt(0)
@@ -289,9 +453,13 @@ func (r *RestGridTrader) ReBalance(ctx context.Context, dryRun bool) error {
 		}
 	}
 	logger.Sugar.Infof("now base = %d, moneyNeed = %s, coinNeed = %s", r.base, moneyNeed, coinNeed)
+	if r.futures != nil {
+		logger.Sugar.Info("futures grid starts from flat position, no need to rebalance")
+		return nil
+	}
 	balance, err := r.ex.SpotAvailableBalance()
 	if err != nil {
-		logger.Sugar.Fatalf("error when get balance in rebalance: %s", err)
+		return fmt.Errorf("get balance error: %w", err)
 	}
 	moneyHeld := balance[r.Symbol.QuoteCurrency]
 	coinHeld := balance[r.Symbol.BaseCurrency]
@@ -303,25 +471,31 @@ func (r *RestGridTrader) ReBalance(ctx context.Context, dryRun bool) error {
 	r.amount = coinNeed
 	direct, amount := r.assetRebalancing(moneyNeed, coinNeed, moneyHeld, coinHeld, price)
 	if direct == -2 || direct == 2 {
-		log.Fatalf("no enough money for rebalance, direct: %d", direct)
+		return fmt.Errorf("no enough money for rebalance, direct: %d", direct)
 	} else if direct == 0 {
 		logger.Sugar.Info("no need to rebalance")
 	} else if direct == -1 {
 		// place sell order
+		clientOrderId, err := r.clientOrderId(ctx, executor.SideSell, executor.IntentRebalance, -1)
+		if err != nil {
+			return err
+		}
 		r.base++
-		clientOrderId := fmt.Sprintf("pre-sell")
-		orderId, err := r.sell(price, amount, clientOrderId)
+		orderId, err := r.sell(ctx, price, amount, clientOrderId)
 		if err != nil {
-			log.Fatalf("error when rebalance: %s", err)
+			return fmt.Errorf("place rebalance sell order error: %w", err)
 		}
 		logger.Sugar.Debugf("rebalance: sell %s coin at price %s, orderId is %d, clientOrderId is %s",
 			amount, price, orderId, clientOrderId)
 	} else if direct == 1 {
 		// place buy order
-		clientOrderId := fmt.Sprintf("pre-buy")
-		orderId, err := r.buy(price, amount, clientOrderId)
+		clientOrderId, err := r.clientOrderId(ctx, executor.SideBuy, executor.IntentRebalance, -1)
 		if err != nil {
-			log.Fatalf("error when rebalance: %s", err)
+			return err
+		}
+		orderId, err := r.buy(ctx, price, amount, clientOrderId)
+		if err != nil {
+			return fmt.Errorf("place rebalance buy order error: %w", err)
 		}
 		logger.Sugar.Debugf("rebalance: buy %s coin at price %s, orderId is %d, clientOrderId is %s",
 			amount, price, orderId, clientOrderId)
@@ -335,13 +509,13 @@ func (r *RestGridTrader) Clear(ctx context.Context, dryRun bool) error {
 		logger.Sugar.Info("no order loaded, no need to clear")
 		return nil
 	}
-	collGrid := r.db.Collection(collNameGrid)
+	collGrid := r.collection(collNameGrid)
 	for _, g := range r.grids {
 		if g.Order == 0 {
 			logger.Sugar.Debugw("order id is 0", "grid", g.Id, "price", g.Price)
 		} else {
 			logger.Sugar.Debugw("cancel order", "symbol", r.Symbol.Symbol, "orderNumber", g.Order)
-			if err := r.ex.CancelOrder(r.Symbol.Symbol, g.Order); err != nil {
+			if err := r.cancelOrder(ctx, g.Order); err != nil {
 				logger.Sugar.Errorf("cancel order %d error: %s", g.Order, err)
 				continue
 			}
@@ -352,7 +526,7 @@ func (r *RestGridTrader) Clear(ctx context.Context, dryRun bool) error {
 		}
 	}
 	if r.base != 0 {
-		collBase := r.db.Collection(collNameBase)
+		collBase := r.collection(collNameBase)
 		if _, err := collBase.DeleteOne(ctx, bson.D{
 			{"symbol", r.Symbol.Symbol},
 			{"base", r.base},
@@ -363,11 +537,89 @@ func (r *RestGridTrader) Clear(ctx context.Context, dryRun bool) error {
 	return nil
 }
 
+// Value is the value of the grid now, the money in buy orders and the coin for sell orders.
+// The realized profit is not included.
+func (r *RestGridTrader) Value(ctx context.Context) (decimal.Decimal, error) {
+	price, err := r.last(ctx)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	value := decimal.Zero
+	for i, g := range r.grids {
+		if i < r.base {
+			value = value.Add(g.AmountSell.Mul(price))
+		} else if i > r.base {
+			value = value.Add(g.TotalBuy)
+		}
+	}
+	return value, nil
+}
+
+// Profit is the realized profit of the filled sell orders
+func (r *RestGridTrader) Profit() decimal.Decimal {
+	return r.profit
+}
+
+// Liquidate cancel all grid orders, sell the coin held by the grid, and delete the grid state.
+// The grid should be stopped before.
+func (r *RestGridTrader) Liquidate(ctx context.Context) error {
+	r.cancelAll(ctx)
+	amount := decimal.Zero
+	for i := 0; i < r.base && i < len(r.grids); i++ {
+		amount = amount.Add(r.grids[i].AmountSell)
+	}
+	if r.futures != nil {
+		clientOrderId, err := r.clientOrderId(ctx, executor.SideSell, executor.IntentRebalance, -1)
+		if err != nil {
+			return err
+		}
+		if _, err := r.futures.Target(ctx, 0, clientOrderId); err != nil {
+			return err
+		}
+	} else if amount.IsPositive() {
+		balance, err := r.ex.SpotAvailableBalance()
+		if err != nil {
+			return err
+		}
+		if held := balance[r.Symbol.BaseCurrency]; held.LessThan(amount) {
+			amount = held.Truncate(r.Symbol.AmountPrecision)
+		}
+		ticker, err := r.ex.Ticker(r.Symbol.Symbol)
+		if err != nil {
+			return err
+		}
+		if amount.GreaterThanOrEqual(r.Symbol.LimitOrderMinAmount) && amount.Mul(ticker.HighestBid).GreaterThanOrEqual(r.Symbol.MinTotal) {
+			clientOrderId, err := r.clientOrderId(ctx, executor.SideSell, executor.IntentRebalance, -1)
+			if err != nil {
+				return err
+			}
+			orderId, err := r.sell(ctx, ticker.HighestBid, amount, clientOrderId)
+			if err != nil {
+				return err
+			}
+			logger.Sugar.Infof("grid %s liquidated, sell %s at %s, order: %d", r.Symbol.Symbol, amount, ticker.HighestBid, orderId)
+		} else {
+			logger.Sugar.Infof("grid %s liquidated, amount %s is too small to sell", r.Symbol.Symbol, amount)
+		}
+	}
+	if _, err := r.collection(collNameGrid).DeleteMany(ctx, bson.D{}); err != nil {
+		return err
+	}
+	if _, err := r.collection(collNameBase).DeleteMany(ctx, bson.D{{"symbol", r.Symbol.Symbol}}); err != nil {
+		return err
+	}
+	return hs.DeleteKey(ctx, r.collection(collNameState), "profit")
+}
+
 func (r *RestGridTrader) setupGridOrders(ctx context.Context) {
 	for i := r.base - 1; i >= 0; i-- {
 		// sell
-		clientOrderId := fmt.Sprintf("s-%d", i)
-		orderId, err := r.sell(r.grids[i].Price, r.grids[i].AmountSell, clientOrderId)
+		clientOrderId, err := r.clientOrderId(ctx, executor.SideSell, executor.IntentGrid, i)
+		if err != nil {
+			logger.Sugar.Errorf("error when setupGridOrders, grid number: %d, err: %s", i, err)
+			continue
+		}
+		orderId, err := r.sell(ctx, r.grids[i].Price, r.grids[i].AmountSell, clientOrderId)
 		if err != nil {
 			logger.Sugar.Errorf("error when setupGridOrders, grid number: %d, err: %s", i, err)
 			continue
@@ -376,8 +628,12 @@ func (r *RestGridTrader) setupGridOrders(ctx context.Context) {
 	}
 	for i := r.base + 1; i < len(r.grids); i++ {
 		// buy
-		clientOrderId := fmt.Sprintf("b-%d", i)
-		orderId, err := r.buy(r.grids[i].Price, r.grids[i].AmountBuy, clientOrderId)
+		clientOrderId, err := r.clientOrderId(ctx, executor.SideBuy, executor.IntentGrid, i)
+		if err != nil {
+			logger.Sugar.Errorf("error when setupGridOrders, grid number: %d, err: %s", i, err)
+			continue
+		}
+		orderId, err := r.buy(ctx, r.grids[i].Price, r.grids[i].AmountBuy, clientOrderId)
 		if err != nil {
 			logger.Sugar.Errorf("error when setupGridOrders, grid number: %d, err: %s", i, err)
 			continue
@@ -426,9 +682,10 @@ func (r *RestGridTrader) assetRebalancing(moneyNeed, coinNeed, moneyHeld, coinHe
 		coinDelta := coinNeed.Sub(coinHeld).Round(r.Symbol.AmountPrecision)
 		buyTotal := coinDelta.Mul(price)
 		if moneyHeld.LessThan(moneyNeed.Add(buyTotal)) {
-			log.Fatalf("no enough money for rebalance: need hold %s and spend %s (%s in total)，only have %s",
+			logger.Sugar.Errorf("no enough money for rebalance: need hold %s and spend %s (%s in total)，only have %s",
 				moneyNeed, buyTotal, moneyNeed.Add(buyTotal), moneyHeld)
 			direct = 2
+			return
 		}
 		if coinDelta.LessThan(r.Symbol.LimitOrderMinAmount) {
 			logger.Sugar.Errorf("buy amount %s less than minAmount(%s), won't buy", coinDelta, r.Symbol.MinTotal)
@@ -457,8 +714,12 @@ func (r *RestGridTrader) up(ctx context.Context) {
 		return
 	}
 	// place buy order
-	clientOrderId := fmt.Sprintf("b-%d", r.base)
-	if orderId, err := r.buy(r.grids[r.base].Price, r.grids[r.base].AmountBuy, clientOrderId); err == nil {
+	clientOrderId, err := r.clientOrderId(ctx, executor.SideBuy, executor.IntentGrid, r.base)
+	if err != nil {
+		logger.Sugar.Errorf("client order id error: %s", err)
+		return
+	}
+	if orderId, err := r.buy(ctx, r.grids[r.base].Price, r.grids[r.base].AmountBuy, clientOrderId); err == nil {
 		r.grids[r.base].Order = orderId
 		if err := r.updateOrder(ctx, r.base, r.grids[r.base].Order); err != nil {
 			logger.Sugar.Errorf("update order error: %s", err)
@@ -489,8 +750,12 @@ func (r *RestGridTrader) down(ctx context.Context) {
 		return
 	}
 	// place sell order
-	clientOrderId := fmt.Sprintf("s-%d", r.base)
-	if orderId, err := r.sell(r.grids[r.base].Price, r.grids[r.base].AmountSell, clientOrderId); err == nil {
+	clientOrderId, err := r.clientOrderId(ctx, executor.SideSell, executor.IntentGrid, r.base)
+	if err != nil {
+		logger.Sugar.Errorf("client order id error: %s", err)
+		return
+	}
+	if orderId, err := r.sell(ctx, r.grids[r.base].Price, r.grids[r.base].AmountSell, clientOrderId); err == nil {
 		r.grids[r.base].Order = orderId
 		if err := r.updateOrder(ctx, r.base, r.grids[r.base].Order); err != nil {
 			logger.Sugar.Errorf("update order error: %s", err)
@@ -506,18 +771,91 @@ func (r *RestGridTrader) down(ctx context.Context) {
 	}
 }
 
-func (r *RestGridTrader) buy(price, amount decimal.Decimal, clientOrderId string) (uint64, error) {
+func (r *RestGridTrader) buy(ctx context.Context, price, amount decimal.Decimal, clientOrderId string) (uint64, error) {
 	logger.Sugar.Infof("[Order][buy] price: %s, amount: %s, clientOrderId: %s", price, amount, clientOrderId)
-	return r.ex.BuyLimit(r.Symbol.Symbol, clientOrderId, price, amount)
+	if r.futures != nil {
+		return r.futures.Limit(ctx, r.futures.Contracts(amount), price, clientOrderId)
+	}
+	if err := r.account.Reserve(clientOrderId, price.Mul(amount)); err != nil {
+		return 0, err
+	}
+	orderId, err := r.ex.BuyLimit(r.Symbol.Symbol, clientOrderId, price, amount)
+	r.account.Placed(clientOrderId, orderId, err)
+	return orderId, err
 }
 
-func (r *RestGridTrader) sell(price, amount decimal.Decimal, clientOrderId string) (uint64, error) {
+func (r *RestGridTrader) sell(ctx context.Context, price, amount decimal.Decimal, clientOrderId string) (uint64, error) {
 	logger.Sugar.Infof("[Order][sell] price: %s, amount: %s, clientOrderId: %s", price, amount, clientOrderId)
+	if r.futures != nil {
+		return r.futures.Limit(ctx, -r.futures.Contracts(amount), price, clientOrderId)
+	}
 	return r.ex.SellLimit(r.Symbol.Symbol, clientOrderId, price, amount)
 }
 
-// 最后成交价格
-func (r *RestGridTrader) last() (decimal.Decimal, error) {
+// isFullFilled check the spot or futures order, the futures size is converted to base amount
+func (r *RestGridTrader) isFullFilled(ctx context.Context, orderId uint64) (exchange.Order, bool, error) {
+	if r.futures == nil {
+		return r.ex.IsFullFilled(r.Symbol.Symbol, orderId)
+	}
+	o, err := r.futures.GetOrder(ctx, orderId)
+	if err != nil {
+		return exchange.Order{}, false, err
+	}
+	order := exchange.Order{
+		Id:           o.Id,
+		Symbol:       o.Contract,
+		Type:         "buy",
+		Price:        o.Price,
+		FilledAmount: decimal.NewFromInt(o.Filled).Mul(r.futures.Contract().Multiplier).Abs(),
+	}
+	if o.Size < 0 {
+		order.Type = "sell"
+	}
+	return order, o.Finished && o.Filled == o.Size, nil
+}
+
+func (r *RestGridTrader) cancelOrder(ctx context.Context, orderId uint64) error {
+	if r.futures != nil {
+		return r.futures.CancelOrder(ctx, orderId)
+	}
+	if err := r.ex.CancelOrder(r.Symbol.Symbol, orderId); err != nil {
+		return err
+	}
+	r.settle(orderId)
+	return nil
+}
+
+// settle the cancelled order in the shared ledger, the filled part of a buy order is spent, of a sell order is income
+func (r *RestGridTrader) settle(orderId uint64) {
+	if !r.account.Enabled() {
+		return
+	}
+	o, err := r.ex.GetOrderById(orderId, r.Symbol.Symbol)
+	if err != nil {
+		logger.Sugar.Errorf("get order %d error: %s", orderId, err)
+		return
+	}
+	// the reconcile of the ledger will settle it later
+	if !executor.OrderFinished(o.Status) {
+		return
+	}
+	r.filled(o)
+}
+
+// filled update the shared ledger by the finished order
+func (r *RestGridTrader) filled(o exchange.Order) {
+	if o.Type == "sell" {
+		r.account.Credit(o.FilledPrice.Mul(o.FilledAmount))
+	} else {
+		r.account.Settle(o)
+	}
+}
+
+// 最后成交价格，合约使用标记价格
+func (r *RestGridTrader) last(ctx context.Context) (decimal.Decimal, error) {
+	if r.futures != nil {
+		return r.futures.Exchange().MarkPrice(ctx, r.futures.Contract().Name)
+	}
 	if ticker, err := r.ex.Ticker(r.Symbol.Symbol); err != nil {
 		return decimal.Zero, err
 	} else {
@@ -526,6 +864,7 @@ func (r *RestGridTrader) last() (decimal.Decimal, error) {
 }
 
 func (r *RestGridTrader) checkOrders(ctx context.Context) {
+	r.checkRange(ctx)
 	top := r.base - 1
 	if top >= 0 {
 		logger.Sugar.Debugw("check order",
@@ -534,15 +873,17 @@ func (r *RestGridTrader) checkOrders(ctx context.Context) {
 			"type", "sell",
 			"order", r.grids[top].Order)
 		if r.grids[top].Order != 0 {
-			order, closed, err := r.ex.IsFullFilled(r.Symbol.Symbol, r.grids[top].Order)
+			order, closed, err := r.isFullFilled(ctx, r.grids[top].Order)
 			if err != nil {
 				logger.Sugar.Errorf("check order error: %s", err)
 				return
 			}
 			if closed {
+				r.filled(order)
 				go r.up(ctx)
-				profit := r.grids[top].Price.Mul(r.grids[top].AmountSell).Sub(r.grids[top+1].TotalBuy).String()
-				go r.Broadcast(ctx, order, profit)
+				profit := r.grids[top].Price.Mul(r.grids[top].AmountSell).Sub(r.grids[top+1].TotalBuy)
+				r.addProfit(ctx, profit)
+				go r.Broadcast(ctx, order, profit.String())
 				return
 			}
 		}
@@ -556,12 +897,13 @@ func (r *RestGridTrader) checkOrders(ctx context.Context) {
 			"type", "buy",
 			"order", r.grids[bottom].Order)
 		if r.grids[bottom].Order != 0 {
-			order, closed, err := r.ex.IsFullFilled(r.Symbol.Symbol, r.grids[bottom].Order)
+			order, closed, err := r.isFullFilled(ctx, r.grids[bottom].Order)
 			if err != nil {
 				logger.Sugar.Errorf("check order error: %s", err)
 				return
 			}
 			if closed {
+				r.filled(order)
 				go r.down(ctx)
 				go r.Broadcast(ctx, order, "-")
 			}
@@ -570,7 +912,7 @@ func (r *RestGridTrader) checkOrders(ctx context.Context) {
 }
 
 func (r *RestGridTrader) updateBase(ctx context.Context, newBase int) error {
-	coll := r.db.Collection(collNameBase)
+	coll := r.collection(collNameBase)
 	_, err := coll.UpdateOne(
 		ctx,
 		bson.D{
@@ -589,7 +931,7 @@ func (r *RestGridTrader) updateBase(ctx context.Context, newBase int) error {
 }
 
 func (r *RestGridTrader) updateOrder(ctx context.Context, id int, order uint64) error {
-	collGrid := r.db.Collection(collNameGrid)
+	collGrid := r.collection(collNameGrid)
 	_, err := collGrid.UpdateOne(
 		ctx,
 		bson.D{
@@ -2,6 +2,8 @@ package grid
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/markcheno/go-talib"
 	"github.com/shopspring/decimal"
 	"github.com/xyths/hs"
@@ -9,7 +11,9 @@ import (
 	"github.com/xyths/hs/exchange"
 	"github.com/xyths/hs/exchange/gateio"
 	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/executor"
 	"github.com/xyths/qtr/trader/rest/trigger"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 	"sort"
@@ -20,13 +24,26 @@ import (
 type MultipleGridConfig struct {
 	Exchange        hs.ExchangeConf
 	Mongo           hs.MongoConf
-	Total           float64
-	Number          int
+	Total           float64 // split across the running grids, Total / Number each
+	Number          int     // max number of running grids
 	Interval        string
 	VolumeThreshold float64 `json:"volumeThreshold"`
 	Trigger         trigger.Config
 	Log             hs.LogConf
 	Robots          []hs.BroadcastConf
+
+	GridNumber   int                  `json:"gridNumber"`   // levels of each grid, default 10
+	GridInterval string               `json:"gridInterval"` // order check interval of each grid, default 1m
+	Spacing      executor.SpacingConf // natr only, the grid is centered on the price when started
+	Range        RangeConf
+	Ledger       *executor.LedgerConf // shared capital ledger, every grid has its own allocation
+}
+
+// the running grid saved in database
+type gridRecord struct {
+	Symbol exchange.Symbol
+	Total  string
+	Start  time.Time
 }
 
 type MultipleGridTrader struct {
@@ -46,8 +63,13 @@ type MultipleGridTrader struct {
 	stopped []exchange.Symbol
 	started []exchange.Symbol
 
+	ledger    *executor.Ledger
+	reconcile time.Duration // ledger reconcile interval
+
 	trigger *trigger.Trigger
 	grids   map[string]*RestGridTrader
+	records map[string]gridRecord
+	done    map[string]chan struct{}
 }
 
 func NewMultipleGridTrader(ctx context.Context, configFilename string, dry bool) (*MultipleGridTrader, error) {
@@ -60,6 +82,28 @@ func NewMultipleGridTrader(ctx context.Context, configFilename string, dry bool)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.Number <= 0 {
+		return nil, errors.New("number must be positive")
+	}
+	if cfg.GridNumber <= 0 {
+		cfg.GridNumber = 10
+	}
+	if cfg.GridInterval == "" {
+		cfg.GridInterval = "1m"
+	}
+	if _, err := time.ParseDuration(cfg.GridInterval); err != nil {
+		return nil, err
+	}
+	// no price range in config, the grid is centered on the price by natr
+	if cfg.Spacing.Mode == "" {
+		cfg.Spacing.Mode = executor.SpacingNatr
+	}
+	if err := cfg.Spacing.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Spacing.Mode != executor.SpacingNatr {
+		return nil, fmt.Errorf("spacing mode %s is not supported, only natr", cfg.Spacing.Mode)
+	}
 	t := &MultipleGridTrader{
 		config:   cfg,
 		maxTotal: decimal.NewFromFloat(cfg.Total),
@@ -67,17 +111,30 @@ func NewMultipleGridTrader(ctx context.Context, configFilename string, dry bool)
 		interval: interval,
 		trigger:  trigger.NewTrigger(cfg.Trigger),
 		grids:    make(map[string]*RestGridTrader),
+		records:  make(map[string]gridRecord),
+		done:     make(map[string]chan struct{}),
 	}
 
-	err = t.init(ctx)
+	if err := t.init(ctx); err != nil {
+		return nil, err
+	}
 	return t, nil
 }
 
 func (t *MultipleGridTrader) Close(ctx context.Context) {
-
+	if t.ledger != nil {
+		t.ledger.Close(ctx)
+	}
+	if t.db != nil {
+		_ = t.db.Client().Disconnect(ctx)
+	}
 }
 
 func (t *MultipleGridTrader) Start(ctx context.Context) error {
+	if t.ledger != nil {
+		go t.ledger.Start(ctx, t.ex, t.reconcile)
+	}
+	t.resume(ctx)
 	t.doWork(ctx)
 	wakeTime := time.Now().Truncate(t.interval)
 	wakeTime = wakeTime.Add(t.interval)
@@ -85,7 +142,7 @@ func (t *MultipleGridTrader) Start(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil
 		case <-time.After(sleepTime):
 			t.doWork(ctx)
 			wakeTime = wakeTime.Add(t.interval)
@@ -110,7 +167,7 @@ func (t *MultipleGridTrader) doWork(ctx context.Context) {
 			t.Sugar.Infof("running grid %s keep running", s.Symbol)
 		} else {
 			newStopped = append(newStopped, s)
-			t.Sugar.Infof("running grid %s need stop", s.Symbol)
+			t.Sugar.Infof("running grid %s stopped", s.Symbol)
 		}
 	}
 	for _, s := range t.stopped {
@@ -133,11 +190,86 @@ func (t *MultipleGridTrader) doWork(ctx context.Context) {
 	t.Sugar.Info("multiple grid check finished")
 }
 
+// Stop stop all running grids, the orders are kept and the grids resume when started again
 func (t *MultipleGridTrader) Stop(ctx context.Context) error {
+	for symbol := range t.grids {
+		t.stopGrid(ctx, symbol)
+	}
 	t.Sugar.Info("MultipleGridTrader stopped")
 	return nil
 }
 
+// Print show the status and PnL of all running grids
+func (t *MultipleGridTrader) Print(ctx context.Context) error {
+	t.Sugar.Infof("Total: %s, running: %d/%d", t.maxTotal, len(t.records), t.config.Number)
+	t.Sugar.Info("Symbol\tStart\tTotal\tBase\tValue\tProfit\tPnL")
+	sum := decimal.Zero
+	for symbol, record := range t.records {
+		g, err := t.loadGrid(ctx, record)
+		if err != nil {
+			t.Sugar.Errorf("load grid %s error: %s", symbol, err)
+			continue
+		}
+		value, err := g.Value(ctx)
+		if err != nil {
+			t.Sugar.Errorf("get grid %s value error: %s", symbol, err)
+			continue
+		}
+		total, _ := decimal.NewFromString(record.Total)
+		pnl := value.Add(g.Profit()).Sub(total)
+		sum = sum.Add(pnl)
+		t.Sugar.Infof("%s\t%s\t%s\t%d/%d\t%s\t%s\t%s", symbol, record.Start.Format(time.RFC3339), record.Total,
+			g.base, len(g.grids)-1, value.Round(2), g.Profit().Round(2), pnl.Round(2))
+	}
+	t.Sugar.Infof("Total PnL: %s", sum.Round(2))
+	return nil
+}
+
+// Rebalance cancel the orders, rebalance the asset and place the grid orders again for all running grids
+func (t *MultipleGridTrader) Rebalance(ctx context.Context, dryRun bool) error {
+	for symbol, record := range t.records {
+		g, err := t.loadGrid(ctx, record)
+		if err != nil {
+			t.Sugar.Errorf("load grid %s error: %s", symbol, err)
+			continue
+		}
+		if dryRun {
+			if err := g.ReBalance(ctx, true); err != nil {
+				return err
+			}
+			continue
+		}
+		g.cancelAll(ctx)
+		if err := g.ReBalance(ctx, false); err != nil {
+			return err
+		}
+		g.setupGridOrders(ctx)
+		g.replaceGrids(ctx)
+	}
+	return nil
+}
+
+// Clear cancel the orders and liquidate all grids
+func (t *MultipleGridTrader) Clear(ctx context.Context, dryRun bool) error {
+	for symbol, record := range t.records {
+		t.Sugar.Infof("clear grid %s", symbol)
+		if dryRun {
+			continue
+		}
+		g, err := t.loadGrid(ctx, record)
+		if err != nil {
+			return err
+		}
+		if err := g.Liquidate(ctx); err != nil {
+			return err
+		}
+		if err := t.deleteRecord(ctx, symbol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (t *MultipleGridTrader) init(ctx context.Context) error {
 	if l, err := hs.NewZapLogger(t.config.Log); err != nil {
 		return err
@@ -156,8 +288,17 @@ func (t *MultipleGridTrader) init(ctx context.Context) error {
 		return err
 	}
 	t.Sugar.Info("executor initialized")
-	t.trigger.Init(t.Sugar, t.ex)
+	if err := t.initLedger(ctx); err != nil {
+		return err
+	}
+	if err := t.trigger.Init(t.Sugar, t.ex); err != nil {
+		return err
+	}
 	t.Sugar.Info("trigger initialized")
+	if err := t.loadRecords(ctx); err != nil {
+		return err
+	}
+	t.Sugar.Infof("%d running grids loaded", len(t.records))
 	t.Sugar.Info("Multiple Grid Trader initialized")
 	return nil
 }
@@ -179,7 +320,174 @@ func (t *MultipleGridTrader) initExecutor() (err error) {
 		}
 	case "gate":
 		t.ex = gateio.New(cfg.Key, cfg.Secret, cfg.Host, t.Sugar)
+	default:
+		return fmt.Errorf("unsupported exchange: %s", cfg.Name)
+	}
+	return nil
+}
+
+func (t *MultipleGridTrader) initLedger(ctx context.Context) error {
+	conf := t.config.Ledger
+	if conf == nil {
+		return nil
+	}
+	interval, err := conf.ReconcileInterval()
+	if err != nil {
+		return err
+	}
+	if t.ledger, err = executor.NewLedger(ctx, *conf, t.Sugar); err != nil {
+		return err
 	}
+	t.reconcile = interval
+	t.Sugar.Info("Ledger initialized")
+	return nil
+}
+
+const collNameMultiple = "multiple"
+
+func (t *MultipleGridTrader) loadRecords(ctx context.Context) error {
+	cursor, err := t.db.Collection(collNameMultiple).Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	var records []gridRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		t.records[r.Symbol.Symbol] = r
+	}
+	return nil
+}
+
+func (t *MultipleGridTrader) saveRecord(ctx context.Context, record gridRecord) error {
+	_, err := t.db.Collection(collNameMultiple).InsertOne(ctx, record)
+	if err == nil {
+		t.records[record.Symbol.Symbol] = record
+	}
+	return err
+}
+
+func (t *MultipleGridTrader) deleteRecord(ctx context.Context, symbol string) error {
+	_, err := t.db.Collection(collNameMultiple).DeleteOne(ctx, bson.D{{"symbol.symbol", symbol}})
+	if err == nil {
+		delete(t.records, symbol)
+	}
+	return err
+}
+
+// allocation is the fund of a new grid, the rest of total split evenly across the free slots
+func allocation(total, used decimal.Decimal, running, number int) decimal.Decimal {
+	if running >= number {
+		return decimal.Zero
+	}
+	rest := total.Sub(used)
+	if !rest.IsPositive() {
+		return decimal.Zero
+	}
+	return rest.Div(decimal.NewFromInt(int64(number - running))).Truncate(2)
+}
+
+func (t *MultipleGridTrader) used() decimal.Decimal {
+	used := decimal.Zero
+	for _, r := range t.records {
+		total, _ := decimal.NewFromString(r.Total)
+		used = used.Add(total)
+	}
+	return used
+}
+
+// newGrid create the grid of symbol with its own collections in the shared database
+func (t *MultipleGridTrader) newGrid(ctx context.Context, symbol string, total decimal.Decimal) (*RestGridTrader, error) {
+	if t.config.Exchange.Name != "gate" {
+		return nil, fmt.Errorf("grid on %s is not supported", t.config.Exchange.Name)
+	}
+	f, _ := total.Float64()
+	cfg := Config{
+		Exchange: t.config.Exchange,
+		Mongo:    t.config.Mongo,
+		Strategy: hs.RestGridStrategyConf{
+			Number:    t.config.GridNumber,
+			Total:     f,
+			Rebalance: true,
+			Interval:  t.config.GridInterval,
+		},
+		Robots:  t.config.Robots,
+		Range:   t.config.Range,
+		Spacing: t.config.Spacing,
+	}
+	cfg.Exchange.Symbols = []string{symbol}
+	g := &RestGridTrader{
+		config: cfg,
+		db:     t.db,
+		prefix: symbol + "_",
+		stopCh: make(chan int, 1),
+	}
+	if err := g.initEx(ctx); err != nil {
+		return nil, err
+	}
+	if err := g.initGrids(ctx); err != nil {
+		return nil, err
+	}
+	g.initRobots(ctx)
+	if err := g.initClientId(ctx); err != nil {
+		return nil, err
+	}
+	g.loadProfit(ctx)
+	if t.ledger != nil {
+		if err := g.useLedger(ctx, t.ledger, t.config.Ledger.Name+"-"+symbol); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// loadGrid create the grid of the record and load its state
+func (t *MultipleGridTrader) loadGrid(ctx context.Context, record gridRecord) (*RestGridTrader, error) {
+	total, _ := decimal.NewFromString(record.Total)
+	g, err := t.newGrid(ctx, record.Symbol.Symbol, total)
+	if err != nil {
+		return nil, err
+	}
+	if !g.loadGrids(ctx) {
+		return nil, fmt.Errorf("no grid of %s in database", record.Symbol.Symbol)
+	}
+	return g, nil
+}
+
+// resume restart the grids running before
+func (t *MultipleGridTrader) resume(ctx context.Context) {
+	for symbol, record := range t.records {
+		total, _ := decimal.NewFromString(record.Total)
+		g, err := t.newGrid(ctx, symbol, total)
+		if err != nil {
+			t.Sugar.Errorf("resume grid %s error: %s", symbol, err)
+			continue
+		}
+		if err := t.run(ctx, g); err != nil {
+			t.Sugar.Errorf("resume grid %s error: %s", symbol, err)
+			continue
+		}
+		t.started = append(t.started, record.Symbol)
+		t.Sugar.Infof("grid %s resumed", symbol)
+	}
+}
+
+// run setup the grid and start it in background, the grid failed to setup is not registered
+func (t *MultipleGridTrader) run(ctx context.Context, g *RestGridTrader) error {
+	symbol := g.Symbol.Symbol
+	if err := g.setup(ctx); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	t.grids[symbol] = g
+	t.done[symbol] = done
+	go func() {
+		defer close(done)
+		if err := g.run(ctx); err != nil {
+			t.Sugar.Errorf("grid %s error: %s", symbol, err)
+		}
+	}()
 	return nil
 }
 
@@ -263,61 +571,92 @@ func (t *MultipleGridTrader) allSymbols(ctx context.Context) ([]exchange.Symbol,
 func (t *MultipleGridTrader) checkSymbol(ctx context.Context, symbol exchange.Symbol) (started bool, err error) {
 	turnOn, err := t.trigger.Check(ctx, symbol.Symbol)
 	if err != nil {
-		t.Sugar.Errorf("check symbol %s error", symbol.Symbol, err)
-		return
-	}
-	g, err := t.getGridService(ctx, symbol)
-	if err != nil {
-		t.Sugar.Errorf("get grid %s error", symbol.Symbol, err)
+		t.Sugar.Errorf("check symbol %s error: %s", symbol.Symbol, err)
 		return
 	}
 	if turnOn {
-		return t.startGridService(ctx, g)
+		return t.startGridService(ctx, symbol)
 	}
-	_, err = t.stopGridService(ctx, g)
+	_, err = t.stopGridService(ctx, symbol)
 	return
 }
 
-func (t *MultipleGridTrader) getGridService(ctx context.Context, symbol exchange.Symbol) (g *RestGridTrader, err error) {
-	g, ok := t.grids[symbol.Symbol]
-	if ok {
-		t.Sugar.Debugf("recall grid %s from cache", symbol.Symbol)
-		return g, nil
+func (t *MultipleGridTrader) startGridService(ctx context.Context, symbol exchange.Symbol) (success bool, err error) {
+	if _, ok := t.grids[symbol.Symbol]; ok {
+		select {
+		case <-t.done[symbol.Symbol]:
+		default:
+			return true, nil
+		}
+		// the grid stopped but not liquidated, finish it before start again
+		if success, err = t.stopGridService(ctx, symbol); !success {
+			return
+		}
 	}
-	t.Sugar.Infof("new grid %s service", symbol.Symbol)
-	// dummy grid
-	g = &RestGridTrader{
-		Symbol: symbol,
+	total := allocation(t.maxTotal, t.used(), len(t.records), t.config.Number)
+	if !total.IsPositive() {
+		t.Sugar.Infof("no fund for grid %s", symbol.Symbol)
+		return false, nil
 	}
-	t.grids[symbol.Symbol] = g
-	return
+	if t.dry {
+		t.Sugar.Infof("[dry run] grid %s would start with total %s", symbol.Symbol, total)
+		return false, nil
+	}
+	g, err := t.newGrid(ctx, symbol.Symbol, total)
+	if err != nil {
+		t.Sugar.Errorf("new grid %s error: %s", symbol.Symbol, err)
+		return false, err
+	}
+	if err = t.saveRecord(ctx, gridRecord{Symbol: g.Symbol, Total: total.String(), Start: time.Now()}); err != nil {
+		return false, err
+	}
+	if err = t.run(ctx, g); err != nil {
+		t.Sugar.Errorf("start grid %s error: %s", symbol.Symbol, err)
+		// the grid is not running, release its allocation
+		if err1 := t.deleteRecord(ctx, symbol.Symbol); err1 != nil {
+			t.Sugar.Errorf("delete record %s error: %s", symbol.Symbol, err1)
+		}
+		return false, err
+	}
+	t.Sugar.Infof("grid %s is started, total: %s", symbol.Symbol, total)
+	return true, nil
 }
 
-func (t *MultipleGridTrader) startGridService(ctx context.Context, g *RestGridTrader) (success bool, err error) {
-	//if g.Running {
-	//	return true, nil
-	//}
-	//go func() {
-	//	if err := g.Start(ctx); err != nil {
-	//		t.Sugar.Errorf("start grid (symbol %s) error", g.Symbol.Symbol, err)
-	//	}
-	//}()
-	t.Sugar.Infof("grid %s is started", g.Symbol.Symbol)
+// stopGridService stop the grid, cancel its orders and sell the coin.
+// The grid is kept registered until liquidated, so the liquidation is retried in the next check.
+func (t *MultipleGridTrader) stopGridService(ctx context.Context, symbol exchange.Symbol) (success bool, err error) {
+	g, ok := t.grids[symbol.Symbol]
+	if !ok {
+		return true, nil
+	}
+	if t.dry {
+		t.Sugar.Infof("[dry run] grid %s would stop", symbol.Symbol)
+		return false, nil
+	}
+	t.stopGrid(ctx, symbol.Symbol)
+	if err = g.Liquidate(ctx); err != nil {
+		t.Sugar.Errorf("liquidate grid %s error: %s", symbol.Symbol, err)
+		return
+	}
+	if err = t.deleteRecord(ctx, symbol.Symbol); err != nil {
+		return
+	}
+	delete(t.grids, symbol.Symbol)
+	delete(t.done, symbol.Symbol)
+	t.Sugar.Infof("grid %s is stopped", symbol.Symbol)
 	return true, nil
 }
 
-func (t *MultipleGridTrader) stopGridService(ctx context.Context, g *RestGridTrader) (success bool, err error) {
-	//if !g.Running {
-	//	return true, nil
-	//}
-	//err = g.Stop(ctx)
-	//if err != nil {
-	//	t.Sugar.Errorf("stop grid (symbol %s) error", g.Symbol.Symbol, err)
-	//	return
-	//} else {
-	//	success = true
-	//}
-	//return
-	t.Sugar.Infof("grid %s is stopped", g.Symbol.Symbol)
-	return true, nil
+// stopGrid stop the running grid and wait it exit, the stopped grid is skipped
+func (t *MultipleGridTrader) stopGrid(ctx context.Context, symbol string) {
+	done, ok := t.done[symbol]
+	if !ok {
+		return
+	}
+	select {
+	case <-done:
+	default:
+		_ = t.grids[symbol].Stop(ctx)
+		<-done
+	}
 }
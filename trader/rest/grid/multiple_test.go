@@ -0,0 +1,27 @@
+package grid
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestAllocation(t *testing.T) {
+	var tests = []struct {
+		total, used     string
+		running, number int
+		want            string
+	}{
+		{"1000", "0", 0, 4, "250"},
+		{"1000", "250", 1, 4, "250"},
+		{"1000", "400", 1, 4, "200"},
+		{"1000", "1000", 3, 4, "0"},
+		{"1000", "750", 4, 4, "0"},
+		{"100", "0", 0, 3, "33.33"},
+	}
+	for i, tt := range tests {
+		got := allocation(decimal.RequireFromString(tt.total), decimal.RequireFromString(tt.used), tt.running, tt.number)
+		if !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("[%d] want %s, got %s", i, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package grid
+
+import (
+	"context"
+	"fmt"
+	"github.com/markcheno/go-talib"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/logger"
+	"github.com/xyths/qtr/executor"
+	"go.mongodb.org/mongo-driver/bson"
+	"math"
+	"time"
+)
+
+// keep 1% of the equity for fee and rounding when recenter
+var recenterRatio = decimal.NewFromFloat(0.99)
+
+// checkRange handle the price out of the grid, all orders on one side are filled then.
+func (r *RestGridTrader) checkRange(ctx context.Context) {
+	above := r.base == 0
+	below := r.base == len(r.grids)-1
+	if !above && !below {
+		r.outOfRange = false
+		return
+	}
+	price, err := r.last(ctx)
+	if err != nil {
+		logger.Sugar.Errorf("get last price error: %s", err)
+		return
+	}
+	if above && price.LessThanOrEqual(r.grids[0].Price) || below && price.GreaterThanOrEqual(r.grids[len(r.grids)-1].Price) {
+		r.outOfRange = false
+		return
+	}
+	switch {
+	case r.config.Range.Mode == RangeRecenter:
+		r.recenter(ctx, price)
+	case r.config.Range.Mode == RangeTrailing && above:
+		r.trail(ctx)
+	default:
+		if !r.outOfRange {
+			logger.Sugar.Infof("price %s is out of grid [%s, %s], grid paused",
+				price, r.grids[len(r.grids)-1].Price, r.grids[0].Price)
+		}
+	}
+	r.outOfRange = true
+}
+
+// recenter move the ladder to center on price with the same range ratio, or the new NATR spacing
+func (r *RestGridTrader) recenter(ctx context.Context, price decimal.Decimal) {
+	if r.config.Spacing.Mode == executor.SpacingNatr {
+		r.respace(ctx, true)
+		return
+	}
+	high, low := recenterRange(r.grids[0].Price, r.grids[len(r.grids)-1].Price, price)
+	r.rebuild(ctx, price, high, low)
+}
+
+// recenterRange is the range centered on price, with the same ratio of [min, max]
+func recenterRange(max, min, price decimal.Decimal) (high, low float64) {
+	maxPrice, _ := max.Float64()
+	minPrice, _ := min.Float64()
+	p, _ := price.Float64()
+	half := math.Sqrt(maxPrice / minPrice)
+	return p * half, p / half
+}
+
+// respace rebuild the grid with the latest NATR spacing, centered on the current price.
+// Unless forced, the grid is kept when the spacing changes less than the threshold.
+func (r *RestGridTrader) respace(ctx context.Context, force bool) {
+	high, low, spread, err := r.natrRange(ctx, len(r.grids)-1)
+	if err != nil {
+		logger.Sugar.Errorf("get natr range error: %s", err)
+		return
+	}
+	if current, _ := decimal.NewFromInt(1).Sub(r.scale).Float64(); !force && !r.config.Spacing.SpreadChanged(current, spread) {
+		logger.Sugar.Infof("spacing %f is close to the current %f, keep the grid", spread, current)
+		return
+	}
+	price, err := r.last(ctx)
+	if err != nil {
+		logger.Sugar.Errorf("get last price error: %s", err)
+		return
+	}
+	r.rebuild(ctx, price, high, low)
+}
+
+// natrRange is the range of number levels spaced by the NATR of the last closed candle, centered on the current price.
+// The spread is the relative spacing of the levels.
+func (r *RestGridTrader) natrRange(ctx context.Context, number int) (high, low, spread float64, err error) {
+	spacing := r.config.Spacing
+	interval, _ := time.ParseDuration(spacing.NatrInterval)
+	size := spacing.NatrPeriod * 10
+	var candle hs.Candle
+	if r.futures != nil {
+		candle, err = r.futures.Exchange().Candle(ctx, r.futures.Contract().Name, interval, size)
+	} else {
+		candle, err = r.ex.CandleBySize(r.Symbol.Symbol, interval, size)
+	}
+	if err != nil {
+		return
+	}
+	if candle.Length() <= spacing.NatrPeriod+1 {
+		return 0, 0, 0, fmt.Errorf("not enough candles for natr: %d", candle.Length())
+	}
+	natr := talib.Natr(candle.High, candle.Low, candle.Close, spacing.NatrPeriod)
+	scale, err := spacing.NatrSpacing(natr[len(natr)-2])
+	if err != nil {
+		return
+	}
+	price, err := r.last(ctx)
+	if err != nil {
+		return
+	}
+	p, _ := price.Float64()
+	high, low = executor.NatrRange(p, scale, number)
+	spread = 1 - scale
+	logger.Sugar.Infof("natr: %f, spacing: %f, range: [%f, %f]", natr[len(natr)-2], spread, low, high)
+	return
+}
+
+// rebuild cancel all orders, and build the new ladder in [low, high].
+// The total is the equity of the symbol now (not more than the config), so the realized inventory is re-used.
+func (r *RestGridTrader) rebuild(ctx context.Context, price decimal.Decimal, high, low float64) {
+	number := len(r.grids) - 1
+	r.cancelAll(ctx)
+	total := r.config.Strategy.Total
+	if r.futures == nil {
+		balance, err := r.ex.SpotAvailableBalance()
+		if err != nil {
+			logger.Sugar.Errorf("get balance error: %s", err)
+			return
+		}
+		equity := balance[r.Symbol.QuoteCurrency].Add(balance[r.Symbol.BaseCurrency].Mul(price)).Mul(recenterRatio)
+		if e, _ := equity.Float64(); e < total {
+			total = e
+		}
+	}
+	scale, grids, err := r.buildGrids(high, low, number, total)
+	if err != nil {
+		logger.Sugar.Errorf("rebuild grid error: %s", err)
+		return
+	}
+	logger.Sugar.Infof("rebuild grid on %s, [%s, %s], total: %f", price, grids[len(grids)-1].Price, grids[0].Price, total)
+	r.scale, r.grids = scale, grids
+	if err := r.ReBalance(ctx, false); err != nil {
+		logger.Sugar.Errorf("rebalance error: %s", err)
+	}
+	r.setupGridOrders(ctx)
+	r.replaceGrids(ctx)
+}
+
+// trail add a level above the top, and drop the bottom level, so the number of levels is fixed.
+// The sell amount keeps the base currency held at BaseValue, like the infinity grid.
+func (r *RestGridTrader) trail(ctx context.Context) {
+	top := r.grids[0].Price
+	newTop := top.Div(r.scale).Round(r.Symbol.PricePrecision)
+	if r.config.Spacing.Mode == executor.SpacingArithmetic {
+		newTop = top.Add(top.Sub(r.grids[1].Price))
+	}
+	amount := trailAmount(decimal.NewFromFloat(r.config.Range.BaseValue), top, newTop, r.Symbol.AmountPrecision)
+	if amount.LessThan(r.Symbol.LimitOrderMinAmount) || amount.Mul(newTop).LessThan(r.Symbol.MinTotal) {
+		logger.Sugar.Errorf("trailing amount %s at %s is too small, grid paused", amount, newTop)
+		return
+	}
+	clientOrderId, err := r.clientOrderId(ctx, executor.SideSell, executor.IntentGrid, 0)
+	if err != nil {
+		logger.Sugar.Errorf("client order id error: %s", err)
+		return
+	}
+	last := len(r.grids) - 1
+	if o := r.grids[last].Order; o != 0 {
+		if err := r.cancelOrder(ctx, o); err != nil {
+			logger.Sugar.Errorf("cancel order %d error: %s", o, err)
+			return
+		}
+	}
+	grids := append([]hs.Grid{{Price: newTop, AmountSell: amount}}, r.grids[:last]...)
+	grids[1].AmountBuy = amount
+	grids[1].TotalBuy = top.Mul(amount)
+	for i := range grids {
+		grids[i].Id = i
+	}
+	r.grids = grids
+	r.base = 1
+	orderId, err := r.sell(ctx, newTop, amount, clientOrderId)
+	if err != nil {
+		logger.Sugar.Errorf("place trailing order error: %s", err)
+	}
+	r.grids[0].Order = orderId
+	logger.Sugar.Infof("grid trailing up to %s, sell %s, order: %d", newTop, amount, orderId)
+	r.replaceGrids(ctx)
+}
+
+// trailAmount is the amount to sell when the price moves from price to newPrice, the value held keeps baseValue
+func trailAmount(baseValue, price, newPrice decimal.Decimal, precision int32) decimal.Decimal {
+	if !price.IsPositive() || !newPrice.IsPositive() {
+		return decimal.Zero
+	}
+	return baseValue.Div(price).Sub(baseValue.Div(newPrice)).Round(precision)
+}
+
+func (r *RestGridTrader) cancelAll(ctx context.Context) {
+	for i, g := range r.grids {
+		if g.Order == 0 {
+			continue
+		}
+		if err := r.cancelOrder(ctx, g.Order); err != nil {
+			logger.Sugar.Errorf("cancel order %d error: %s", g.Order, err)
+		}
+		r.grids[i].Order = 0
+	}
+}
+
+// replaceGrids save the new ladder and base
+func (r *RestGridTrader) replaceGrids(ctx context.Context) {
+	if _, err := r.collection(collNameGrid).DeleteMany(ctx, bson.D{}); err != nil {
+		logger.Sugar.Errorf("delete grids error: %s", err)
+		return
+	}
+	if _, err := r.collection(collNameBase).DeleteMany(ctx, bson.D{{"symbol", r.Symbol.Symbol}}); err != nil {
+		logger.Sugar.Errorf("delete base error: %s", err)
+		return
+	}
+	if err := r.saveGrids(ctx); err != nil {
+		logger.Sugar.Errorf("save grids error: %s", err)
+	}
+}
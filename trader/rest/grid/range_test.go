@@ -0,0 +1,47 @@
+package grid
+
+import (
+	"github.com/shopspring/decimal"
+	"math"
+	"testing"
+)
+
+func TestTrailAmount(t *testing.T) {
+	var tests = []struct {
+		baseValue, price, newPrice string
+		precision                  int32
+		want                       string
+	}{
+		{"100", "10", "20", 4, "5"},
+		{"100", "10", "11", 4, "0.9091"},
+		{"100", "10", "11", 2, "0.91"},
+		{"100", "10", "10", 4, "0"},
+		{"100", "0", "10", 4, "0"},
+		{"100", "10", "0", 4, "0"},
+	}
+	for i, tt := range tests {
+		got := trailAmount(decimal.RequireFromString(tt.baseValue), decimal.RequireFromString(tt.price),
+			decimal.RequireFromString(tt.newPrice), tt.precision)
+		if !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("[%d] want %s, got %s", i, tt.want, got)
+		}
+	}
+}
+
+func TestRecenterRange(t *testing.T) {
+	var tests = []struct {
+		max, min, price string
+		high, low       float64
+	}{
+		{"200", "50", "100", 200, 50},
+		{"200", "50", "400", 800, 200},
+		{"121", "100", "50", 55, 50 / 1.1},
+	}
+	for i, tt := range tests {
+		high, low := recenterRange(decimal.RequireFromString(tt.max), decimal.RequireFromString(tt.min),
+			decimal.RequireFromString(tt.price))
+		if math.Abs(high-tt.high) > 1e-9 || math.Abs(low-tt.low) > 1e-9 {
+			t.Errorf("[%d] want [%f, %f], got [%f, %f]", i, tt.low, tt.high, low, high)
+		}
+	}
+}
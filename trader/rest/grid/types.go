@@ -1 +1,13 @@
 package grid
+
+// the behavior when the price leaves [MinPrice, MaxPrice]
+const (
+	RangePause    = "pause"    // keep the orders and wait for the price back
+	RangeRecenter = "recenter" // move the whole ladder to center on the current price
+	RangeTrailing = "trailing" // add levels upward, keep the base currency of BaseValue
+)
+
+type RangeConf struct {
+	Mode      string  // pause (default), recenter or trailing
+	BaseValue float64 `json:"baseValue"` // trailing only, the value (in quote currency) of the base currency always held
+}
@@ -0,0 +1,89 @@
+package pairs
+
+import "math"
+
+type BacktestConf struct {
+	Window int
+	Entry  float64
+	Exit   float64
+	Stop   float64
+	Fee    float64 // fee rate of every leg, eg. 0.002
+	Total  float64 // initial equity
+}
+
+// Trade is the position change in backtest
+type Trade struct {
+	Timestamp int64
+	Position  int // the new position
+	Z         float64
+	Beta      float64
+	PriceY    float64
+	PriceX    float64
+	Equity    float64 // equity after the trade
+}
+
+type BacktestResult struct {
+	Trades      []Trade
+	Final       float64
+	Return      float64 // final / total - 1
+	MaxDrawdown float64 // the max drop from the peak equity, 0.1 means 10%
+}
+
+// Backtest trade the spread at the close of every candle, the series must be aligned
+func Backtest(ts []int64, y, x []float64, conf BacktestConf) BacktestResult {
+	r := BacktestResult{Final: conf.Total}
+	equity, peak := conf.Total, conf.Total
+	position := Flat
+	var qtyY, qtyX, entryY, entryX, base float64
+	mark := func(i int) float64 {
+		return base + qtyY*(y[i]-entryY) + qtyX*(x[i]-entryX)
+	}
+	for i := conf.Window - 1; i < len(y); i++ {
+		beta, z, ok := Rolling(y[:i+1], x[:i+1], conf.Window)
+		if !ok {
+			continue
+		}
+		if position != Flat {
+			equity = mark(i)
+		}
+		if equity > peak {
+			peak = equity
+		}
+		if peak > 0 && (peak-equity)/peak > r.MaxDrawdown {
+			r.MaxDrawdown = (peak - equity) / peak
+		}
+		target := Target(position, z, conf.Entry, conf.Exit, conf.Stop)
+		if target == position {
+			continue
+		}
+		if position != Flat {
+			equity -= conf.Fee * (math.Abs(qtyY)*y[i] + math.Abs(qtyX)*x[i])
+			qtyY, qtyX = 0, 0
+		}
+		if target != Flat {
+			valueY, valueX := LegValues(equity, beta)
+			qtyY = float64(target) * valueY / y[i]
+			qtyX = -float64(target) * valueX / x[i]
+			equity -= conf.Fee * (valueY + valueX)
+			entryY, entryX, base = y[i], x[i], equity
+		}
+		position = target
+		r.Trades = append(r.Trades, Trade{
+			Timestamp: ts[i],
+			Position:  position,
+			Z:         z,
+			Beta:      beta,
+			PriceY:    y[i],
+			PriceX:    x[i],
+			Equity:    equity,
+		})
+	}
+	if position != Flat {
+		equity = mark(len(y) - 1)
+	}
+	r.Final = equity
+	if conf.Total > 0 {
+		r.Return = equity/conf.Total - 1
+	}
+	return r
+}
@@ -0,0 +1,428 @@
+package pairs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/broadcast"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/executor"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	collNameState = "state"
+	strategyName  = "pairs"
+)
+
+// Trader is market neutral on two correlated symbols, long one and short the other (on margin) when the spread diverges
+type Trader struct {
+	config   Config
+	interval time.Duration
+	dry      bool
+
+	Sugar  *zap.SugaredLogger
+	db     *mongo.Database
+	ex     exchange.RestAPIExchange
+	robots []broadcast.Broadcaster
+
+	y, x           exchange.Symbol
+	shortY, shortX *executor.MarginShort
+	codec          map[string]executor.ClientIdCodec
+	sequence       executor.Sequence
+
+	legs legs
+}
+
+func NewTrader(ctx context.Context, configFilename string, dry bool) (*Trader, error) {
+	cfg := Config{}
+	if err := hs.ParseJsonConfig(configFilename, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Exchange.Symbols) != 2 {
+		return nil, errors.New("pairs trading needs 2 symbols")
+	}
+	interval, err := time.ParseDuration(cfg.Strategy.Interval)
+	if err != nil {
+		return nil, err
+	}
+	s := &cfg.Strategy
+	if s.Name == "" {
+		s.Name = strategyName
+	}
+	if s.Window <= 0 {
+		s.Window = 100
+	}
+	if s.Entry <= 0 {
+		s.Entry = 2
+	}
+	if s.Exit <= 0 {
+		s.Exit = 0.5
+	}
+	if s.Exit >= s.Entry || (s.Stop > 0 && s.Stop <= s.Entry) {
+		return nil, errors.New("must be exit < entry < stop")
+	}
+	t := &Trader{
+		config:   cfg,
+		interval: interval,
+		dry:      dry,
+		codec:    make(map[string]executor.ClientIdCodec),
+	}
+	if err := t.init(ctx); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Trader) init(ctx context.Context) error {
+	l, err := hs.NewZapLogger(t.config.Log)
+	if err != nil {
+		return err
+	}
+	t.Sugar = l.Sugar()
+	t.Sugar.Info("Logger initialized")
+	db, err := hs.ConnectMongo(ctx, t.config.Mongo)
+	if err != nil {
+		return err
+	}
+	t.db = db
+	if err := t.initEx(ctx); err != nil {
+		return err
+	}
+	for _, conf := range t.config.Robots {
+		t.robots = append(t.robots, broadcast.New(conf))
+	}
+	t.sequence.Init(t.db.Collection(collNameState), "sequence")
+	if err := t.sequence.Load(ctx); err != nil {
+		return err
+	}
+	t.Sugar.Info("Pairs Trader initialized")
+	return nil
+}
+
+// initEx init the exchange and the short side of both symbols, margin is only supported on huobi
+func (t *Trader) initEx(ctx context.Context) (err error) {
+	cfg := t.config.Exchange
+	if cfg.Name != "huobi" {
+		return errors.New("pairs trading needs margin short, only huobi is supported")
+	}
+	c, err := huobi.New(cfg.Label, cfg.Key, cfg.Secret, cfg.Host)
+	if err != nil {
+		return err
+	}
+	t.ex = c
+	if t.y, err = c.GetSymbol(ctx, cfg.Symbols[0]); err != nil {
+		return err
+	}
+	if t.x, err = c.GetSymbol(ctx, cfg.Symbols[1]); err != nil {
+		return err
+	}
+	if t.shortY, err = t.newShort(ctx, c, t.y); err != nil {
+		return err
+	}
+	if t.shortX, err = t.newShort(ctx, c, t.x); err != nil {
+		return err
+	}
+	for _, s := range []exchange.Symbol{t.y, t.x} {
+		t.codec[s.Symbol] = executor.NewClientIdCodec(cfg.Name, t.config.Strategy.Name, s.Symbol)
+	}
+	return nil
+}
+
+func (t *Trader) newShort(ctx context.Context, c *huobi.Client, symbol exchange.Symbol) (*executor.MarginShort, error) {
+	m, err := executor.NewHuobiMargin(c, t.config.Strategy.Margin.Mode, symbol.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	coll := t.db.Collection(executor.CollName(symbol.Symbol, collNameState))
+	short, err := executor.NewMarginShort(t.config.Strategy.Margin, m, symbol, coll, t.Sugar)
+	if err != nil {
+		return nil, err
+	}
+	if err := short.Load(ctx); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	return short, nil
+}
+
+func (t *Trader) Close(ctx context.Context) {
+	if t.db != nil {
+		_ = t.db.Client().Disconnect(ctx)
+	}
+	if t.Sugar != nil {
+		t.Sugar.Info("Pairs Trader closed")
+		_ = t.Sugar.Sync()
+	}
+}
+
+func (t *Trader) Print(ctx context.Context) error {
+	t.loadState(ctx)
+	beta, z, err := t.zscore()
+	if err != nil {
+		return err
+	}
+	log.Printf(`Spread %s / %s
+	Hedge Ratio: %f
+	Z-Score: %f`, t.y.Symbol, t.x.Symbol, beta, z)
+	if t.legs.Position == Flat {
+		log.Print("Position: flat")
+		return nil
+	}
+	log.Printf(`Position: %d
+	Long: %s, amount: %s, cost: %s
+	Entry Hedge Ratio: %f, Z-Score: %f
+	Opened: %s`,
+		t.legs.Position, t.legs.Long, t.legs.Amount, t.legs.Cost, t.legs.Beta, t.legs.Z, t.legs.Opened)
+	for _, s := range []*executor.MarginShort{t.shortY, t.shortX} {
+		if p := s.Position(); p.Active() {
+			log.Printf(`Short: loan %d, borrowed: %s, price: %s, income: %s, leverage: %s`,
+				p.LoanId, p.Borrowed, p.Price, p.Income, p.Leverage)
+		}
+	}
+	return nil
+}
+
+func (t *Trader) Clear(ctx context.Context) error {
+	if err := hs.DeleteKey(ctx, t.db.Collection(collNameState), "legs"); err != nil {
+		return err
+	}
+	t.Sugar.Info("pairs state cleared, the margin short is kept")
+	return nil
+}
+
+func (t *Trader) Start(ctx context.Context) error {
+	t.loadState(ctx)
+	t.doWork(ctx)
+	wakeTime := time.Now().Truncate(t.interval).Add(t.interval)
+	sleepTime := time.Until(wakeTime)
+	for {
+		select {
+		case <-ctx.Done():
+			t.Sugar.Info(ctx.Err())
+			return nil
+		case <-time.After(sleepTime):
+			t.doWork(ctx)
+			wakeTime = wakeTime.Add(t.interval)
+			sleepTime = time.Until(wakeTime)
+			t.Sugar.Debugf("next check time: %s", wakeTime.String())
+		}
+	}
+}
+
+// zscore is the hedge ratio and z-score of the closed candles
+func (t *Trader) zscore() (beta, z float64, err error) {
+	size := t.config.Strategy.Window + 10
+	cy, err := t.ex.CandleBySize(t.y.Symbol, t.interval, size)
+	if err != nil {
+		return
+	}
+	cx, err := t.ex.CandleBySize(t.x.Symbol, t.interval, size)
+	if err != nil {
+		return
+	}
+	// the last candle is not closed
+	_, y, x := Align(cy.Timestamp[:cy.Length()-1], cy.Close[:cy.Length()-1], cx.Timestamp[:cx.Length()-1], cx.Close[:cx.Length()-1])
+	beta, z, ok := Rolling(y, x, t.config.Strategy.Window)
+	if !ok {
+		return 0, 0, fmt.Errorf("not enough candles: %d / %d", len(y), t.config.Strategy.Window)
+	}
+	return
+}
+
+func (t *Trader) doWork(ctx context.Context) {
+	beta, z, err := t.zscore()
+	if err != nil {
+		t.Sugar.Errorf("get z-score error: %s", err)
+		return
+	}
+	conf := t.config.Strategy
+	target := Target(t.legs.Position, z, conf.Entry, conf.Exit, conf.Stop)
+	t.Sugar.Infow("spread", "beta", beta, "z", z, "position", t.legs.Position, "target", target)
+	if target == t.legs.Position {
+		return
+	}
+	if t.dry {
+		t.Sugar.Infof("[dry run] position %d -> %d", t.legs.Position, target)
+		return
+	}
+	if t.legs.Position != Flat {
+		if err := t.closeLegs(ctx); err != nil {
+			t.Sugar.Errorf("close legs error: %s", err)
+			return
+		}
+		t.Broadcast("价差回归，平仓，z: %.2f", z)
+	}
+	if target != Flat {
+		if err := t.openLegs(ctx, target, beta, z); err != nil {
+			t.Sugar.Errorf("open legs error: %s", err)
+			return
+		}
+	}
+}
+
+// openLegs open the short leg first, then buy the long leg. The short is closed if the buy failed.
+func (t *Trader) openLegs(ctx context.Context, position int, beta, z float64) error {
+	long, short, shortSymbol := t.y, t.shortX, t.x
+	valueY, valueX := LegValues(t.config.Strategy.Total, beta)
+	longValue, shortValue := valueY, valueX
+	if position == ShortSpread {
+		long, short, shortSymbol = t.x, t.shortY, t.y
+		longValue, shortValue = valueX, valueY
+	}
+	shortPrice, err := t.ex.LastPrice(shortSymbol.Symbol)
+	if err != nil {
+		return err
+	}
+	clientId, err := t.clientOrderId(ctx, shortSymbol.Symbol, executor.SideSell, executor.IntentEntry)
+	if err != nil {
+		return err
+	}
+	if err := short.Open(ctx, shortPrice, decimal.NewFromFloat(shortValue), clientId); err != nil {
+		// the loan may be borrowed, close it anyway
+		t.rollbackShort(ctx, short, shortSymbol)
+		return fmt.Errorf("open short leg %s error: %w", shortSymbol.Symbol, err)
+	}
+	total := decimal.NewFromFloat(longValue).Truncate(long.PricePrecision)
+	p := t.execute(ctx, long, exchange.TradeDirectionBuy, executor.Job{Total: total})
+	if p.FilledAmount.IsZero() {
+		t.rollbackShort(ctx, short, shortSymbol)
+		return fmt.Errorf("buy long leg %s failed, short leg rolled back", long.Symbol)
+	}
+	t.legs = legs{
+		Position: position,
+		Long:     long.Symbol,
+		Amount:   p.FilledAmount.String(),
+		Cost:     p.FilledTotal.String(),
+		Beta:     beta,
+		Z:        z,
+		Opened:   time.Now(),
+	}
+	t.saveState(ctx)
+	t.Broadcast("价差偏离，开仓，z: %.2f, 对冲比例: %.4f\n\t买入 %s %s, 花费 %s\n\t卖空 %s %s",
+		z, beta, long.Symbol, p.FilledAmount, p.FilledTotal.Round(2), shortSymbol.Symbol, short.Position().Borrowed)
+	return nil
+}
+
+func (t *Trader) rollbackShort(ctx context.Context, short *executor.MarginShort, symbol exchange.Symbol) {
+	if !short.Position().Active() {
+		return
+	}
+	price, err := t.ex.LastPrice(symbol.Symbol)
+	if err != nil {
+		t.Sugar.Errorf("get last price error: %s", err)
+		return
+	}
+	clientId, err := t.clientOrderId(ctx, symbol.Symbol, executor.SideBuy, executor.IntentExit)
+	if err == nil {
+		_, err = short.Close(ctx, price, clientId)
+	}
+	if err != nil {
+		t.Sugar.Errorf("rollback short leg %s error: %s", symbol.Symbol, err)
+		t.Broadcast("空头腿回滚失败，请手动处理: %s", err)
+	}
+}
+
+// closeLegs sell the long leg and buy back the short leg
+func (t *Trader) closeLegs(ctx context.Context) error {
+	long, short, shortSymbol := t.y, t.shortX, t.x
+	if t.legs.Position == ShortSpread {
+		long, short, shortSymbol = t.x, t.shortY, t.y
+	}
+	amount, _ := decimal.NewFromString(t.legs.Amount)
+	if amount.IsPositive() {
+		p := t.execute(ctx, long, exchange.TradeDirectionSell, executor.Job{Amount: amount.Truncate(long.AmountPrecision)})
+		left := amount.Sub(p.FilledAmount)
+		t.legs.Amount = left.String()
+		t.saveState(ctx)
+		if left.GreaterThanOrEqual(long.LimitOrderMinAmount) {
+			return fmt.Errorf("long leg %s is partly sold, left %s", long.Symbol, left)
+		}
+	}
+	price, err := t.ex.LastPrice(shortSymbol.Symbol)
+	if err != nil {
+		return err
+	}
+	clientId, err := t.clientOrderId(ctx, shortSymbol.Symbol, executor.SideBuy, executor.IntentExit)
+	if err != nil {
+		return err
+	}
+	profit, err := short.Close(ctx, price, clientId)
+	if err != nil {
+		return err
+	}
+	t.Sugar.Infof("short leg %s closed, profit: %s", shortSymbol.Symbol, profit)
+	t.legs = legs{}
+	if err := hs.DeleteKey(ctx, t.db.Collection(collNameState), "legs"); err != nil {
+		t.Sugar.Errorf("delete legs error: %s", err)
+	}
+	return nil
+}
+
+func (t *Trader) clientOrderId(ctx context.Context, symbol string, side byte, intent executor.Intent) (string, error) {
+	sequence, err := t.sequence.Next(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get sequence error: %w", err)
+	}
+	id, err := t.codec[symbol].Encode(side, intent, -1, sequence)
+	if err != nil {
+		return "", fmt.Errorf("encode client order id error: %w", err)
+	}
+	return id, nil
+}
+
+func (t *Trader) execute(ctx context.Context, symbol exchange.Symbol, direction string, job executor.Job) executor.Progress {
+	job.Direction = direction
+	side, intent := byte(executor.SideBuy), executor.IntentEntry
+	if direction == exchange.TradeDirectionSell {
+		side, intent = executor.SideSell, executor.IntentExit
+	}
+	clientId, err := t.clientOrderId(ctx, symbol.Symbol, side, intent)
+	if err != nil {
+		t.Sugar.Error(err)
+		return executor.Progress{Job: job}
+	}
+	job.ClientOrderId = clientId
+	algo, err := executor.NewAlgorithm(t.config.Strategy.Algo, t.ex, symbol, t.Sugar, nil)
+	if err != nil {
+		t.Sugar.Errorf("create algorithm error: %s", err)
+		return executor.Progress{Job: job}
+	}
+	p, err := algo.Execute(ctx, job)
+	if err != nil {
+		t.Sugar.Errorf("execute %s job %s error: %s", direction, job.ClientOrderId, err)
+	}
+	return p
+}
+
+func (t *Trader) loadState(ctx context.Context) {
+	if err := hs.LoadKey(ctx, t.db.Collection(collNameState), "legs", &t.legs); err != nil {
+		t.Sugar.Infof("no legs loaded: %s", err)
+	}
+}
+
+func (t *Trader) saveState(ctx context.Context) {
+	if err := hs.SaveKey(ctx, t.db.Collection(collNameState), "legs", t.legs); err != nil {
+		t.Sugar.Errorf("save legs error: %s", err)
+	}
+}
+
+func (t *Trader) Broadcast(format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	t.Sugar.Info(message)
+	labels := []string{t.config.Exchange.Name, t.config.Exchange.Label}
+	beijing := time.FixedZone("Beijing Time", int((8 * time.Hour).Seconds()))
+	timeStr := time.Now().In(beijing).Format("2006-01-02 15:04:05")
+	msg := fmt.Sprintf("%s [%s] [%s/%s] %s", timeStr, strings.Join(labels, "] ["), t.y.Symbol, t.x.Symbol, message)
+	for _, robot := range t.robots {
+		if err := robot.SendText(msg); err != nil {
+			t.Sugar.Infof("broadcast error: %s", err)
+		}
+	}
+}
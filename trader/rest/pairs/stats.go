@@ -0,0 +1,127 @@
+package pairs
+
+import "math"
+
+// position of the spread, long spread is long Y and short X
+const (
+	Flat        = 0
+	LongSpread  = 1
+	ShortSpread = -1
+)
+
+// Align keep the closes of the same timestamps in both series, the timestamps are ascending
+func Align(tsY []int64, y []float64, tsX []int64, x []float64) (ts []int64, ay, ax []float64) {
+	i, j := 0, 0
+	for i < len(tsY) && j < len(tsX) {
+		switch {
+		case tsY[i] == tsX[j]:
+			ts = append(ts, tsY[i])
+			ay = append(ay, y[i])
+			ax = append(ax, x[j])
+			i++
+			j++
+		case tsY[i] < tsX[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return
+}
+
+// HedgeRatio is the OLS regression of log(y) on log(x): log(y) = alpha + beta * log(x)
+func HedgeRatio(y, x []float64) (beta, alpha float64) {
+	n := float64(len(y))
+	if n < 2 || len(x) != len(y) {
+		return 0, 0
+	}
+	var sx, sy, sxx, sxy float64
+	for i := range y {
+		lx, ly := math.Log(x[i]), math.Log(y[i])
+		sx += lx
+		sy += ly
+		sxx += lx * lx
+		sxy += lx * ly
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0, 0
+	}
+	beta = (n*sxy - sx*sy) / d
+	alpha = (sy - beta*sx) / n
+	return
+}
+
+// Spread is log(y) - beta * log(x) - alpha of every point
+func Spread(y, x []float64, beta, alpha float64) []float64 {
+	s := make([]float64, len(y))
+	for i := range y {
+		s[i] = math.Log(y[i]) - beta*math.Log(x[i]) - alpha
+	}
+	return s
+}
+
+// ZScore of the last spread in the window
+func ZScore(spread []float64) float64 {
+	n := float64(len(spread))
+	if n < 2 {
+		return 0
+	}
+	mean := 0.0
+	for _, s := range spread {
+		mean += s
+	}
+	mean /= n
+	variance := 0.0
+	for _, s := range spread {
+		variance += (s - mean) * (s - mean)
+	}
+	std := math.Sqrt(variance / (n - 1))
+	// rounding error of a perfect fit
+	if std < 1e-12 {
+		return 0
+	}
+	return (spread[len(spread)-1] - mean) / std
+}
+
+// Rolling return the hedge ratio and z-score of the last window points
+func Rolling(y, x []float64, window int) (beta, z float64, ok bool) {
+	if window < 2 || len(y) < window || len(x) < window {
+		return 0, 0, false
+	}
+	wy, wx := y[len(y)-window:], x[len(x)-window:]
+	beta, alpha := HedgeRatio(wy, wx)
+	return beta, ZScore(Spread(wy, wx, beta, alpha)), true
+}
+
+// Target is the position on z-score: short the spread above entry, long below -entry,
+// flat when it reverts within exit, or diverges beyond stop (0 means no stop).
+func Target(position int, z, entry, exit, stop float64) int {
+	if stop > 0 && math.Abs(z) >= stop {
+		return Flat
+	}
+	switch position {
+	case LongSpread:
+		if z >= -exit {
+			return Flat
+		}
+	case ShortSpread:
+		if z <= exit {
+			return Flat
+		}
+	default:
+		if z >= entry {
+			return ShortSpread
+		}
+		if z <= -entry {
+			return LongSpread
+		}
+	}
+	return position
+}
+
+// LegValues split total into the value of Y and X, weighted by the hedge ratio
+func LegValues(total, beta float64) (valueY, valueX float64) {
+	b := math.Abs(beta)
+	return total / (1 + b), total * b / (1 + b)
+}
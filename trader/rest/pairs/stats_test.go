@@ -0,0 +1,88 @@
+package pairs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHedgeRatio(t *testing.T) {
+	// y = 2 * x^1.5
+	x := []float64{10, 20, 30, 40, 50}
+	y := make([]float64, len(x))
+	for i := range x {
+		y[i] = 2 * math.Pow(x[i], 1.5)
+	}
+	beta, alpha := HedgeRatio(y, x)
+	if math.Abs(beta-1.5) > 1e-9 || math.Abs(alpha-math.Log(2)) > 1e-9 {
+		t.Errorf("want beta 1.5, alpha %f, got %f, %f", math.Log(2), beta, alpha)
+	}
+	if z := ZScore(Spread(y, x, beta, alpha)); math.Abs(z) > 1e-6 {
+		t.Errorf("want z 0 for a perfect fit, got %f", z)
+	}
+}
+
+func TestZScore(t *testing.T) {
+	if z := ZScore([]float64{1, 2, 3, 4, 5}); math.Abs(z-1.2649110640673518) > 1e-9 {
+		t.Errorf("want 1.2649, got %f", z)
+	}
+	if z := ZScore([]float64{1, 1, 1}); z != 0 {
+		t.Errorf("want 0 for constant spread, got %f", z)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	var tests = []struct {
+		position int
+		z        float64
+		want     int
+	}{
+		{Flat, 1.5, Flat},
+		{Flat, 2.1, ShortSpread},
+		{Flat, -2.1, LongSpread},
+		{ShortSpread, 1, ShortSpread},
+		{ShortSpread, 0.4, Flat},
+		{LongSpread, -1, LongSpread},
+		{LongSpread, -0.4, Flat},
+		{LongSpread, -4.5, Flat}, // stop
+		{Flat, 4.5, Flat},
+	}
+	for i, tt := range tests {
+		if got := Target(tt.position, tt.z, 2, 0.5, 4); got != tt.want {
+			t.Errorf("[%d] want %d, got %d", i, tt.want, got)
+		}
+	}
+}
+
+func TestAlign(t *testing.T) {
+	ts, y, x := Align([]int64{1, 2, 3, 5}, []float64{10, 20, 30, 50}, []int64{2, 3, 4, 5}, []float64{2, 3, 4, 5})
+	if len(ts) != 3 || ts[0] != 2 || ts[2] != 5 || y[1] != 30 || x[2] != 5 {
+		t.Errorf("wrong align: %v %v %v", ts, y, x)
+	}
+}
+
+func TestLegValues(t *testing.T) {
+	y, x := LegValues(300, -2)
+	if y != 100 || x != 200 {
+		t.Errorf("want 100, 200, got %f, %f", y, x)
+	}
+}
+
+func TestBacktest(t *testing.T) {
+	// x is flat, y oscillates around 100, so the spread reverts
+	n := 200
+	ts := make([]int64, n)
+	y := make([]float64, n)
+	x := make([]float64, n)
+	for i := 0; i < n; i++ {
+		ts[i] = int64(i)
+		x[i] = 100 + float64(i%2)*0.01
+		y[i] = 100 + 5*math.Sin(float64(i)/5)
+	}
+	r := Backtest(ts, y, x, BacktestConf{Window: 30, Entry: 1.5, Exit: 0.2, Total: 1000})
+	if len(r.Trades) == 0 {
+		t.Fatal("want some trades")
+	}
+	if r.Final <= 1000 {
+		t.Errorf("want profit on a reverting spread, got %f", r.Final)
+	}
+}
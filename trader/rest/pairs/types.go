@@ -0,0 +1,40 @@
+package pairs
+
+import (
+	"github.com/xyths/hs"
+	"github.com/xyths/qtr/executor"
+	"time"
+)
+
+// Config trade Exchange.Symbols[0] (Y) against Exchange.Symbols[1] (X)
+type Config struct {
+	Exchange hs.ExchangeConf
+	Mongo    hs.MongoConf
+	Strategy StrategyConf
+	Log      hs.LogConf
+	Robots   []hs.BroadcastConf
+}
+
+type StrategyConf struct {
+	Name     string  // strategy instance name, encoded in client order id, default pairs
+	Total    float64 // value of both legs, split by the hedge ratio
+	Interval string  // candle period, the spread is checked at the start of every candle
+	Window   int     // rolling window of the hedge ratio and z-score, default 100
+	Entry    float64 // enter when |z| exceeds Entry, default 2
+	Exit     float64 // exit when |z| reverts within Exit, default 0.5
+	Stop     float64 // exit when |z| exceeds Stop, 0 means no stop
+
+	Algo   executor.AlgoConf   // execution algorithm of the long leg
+	Margin executor.MarginConf // the short leg, the collateral must be in the margin account of both symbols
+}
+
+// legs is the opened position, saved in state collection
+type legs struct {
+	Position int       `bson:"position"` // LongSpread, ShortSpread
+	Long     string    `bson:"long"`     // symbol of the long leg
+	Amount   string    `bson:"amount"`   // base currency bought of the long leg
+	Cost     string    `bson:"cost"`     // quote currency spent of the long leg
+	Beta     float64   `bson:"beta"`
+	Z        float64   `bson:"z"`
+	Opened   time.Time `bson:"opened"`
+}
@@ -0,0 +1,209 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs"
+	"github.com/xyths/hs/broadcast"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/hs/exchange/gateio"
+	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/executor"
+	"github.com/xyths/qtr/strategy"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"log"
+	"time"
+)
+
+type RtmConfig struct {
+	Exchange hs.ExchangeConf
+	Mongo    hs.MongoConf
+	Strategy strategy.RtmStrategyConf
+	Ledger   *executor.LedgerConf // shared capital ledger, nil means use the whole balance
+	Log      hs.LogConf
+	Robots   []hs.BroadcastConf
+	Paper    executor.PaperConf // virtual balance and fee, only used in paper mode
+	Algo     executor.AlgoConf  // execution algorithm of the market orders, default is market order, or chase on gate
+}
+
+// rtmName is the strategy name in client order id and the paper namespace
+const rtmName = "rtm"
+
+// RtmTrader run the RTM strategy on RESTful API, it works on all exchanges (huobi, gate)
+type RtmTrader struct {
+	config    RtmConfig
+	maxTotal  decimal.Decimal
+	paper     bool
+	namespace string // state namespace, only used in paper mode
+
+	Sugar  *zap.SugaredLogger
+	db     *mongo.Database
+	ex     *executor.RestExecutor
+	robots []broadcast.Broadcaster
+
+	ledger    *executor.Ledger
+	reconcile time.Duration // ledger reconcile interval
+
+	strategy *strategy.RtmRest
+}
+
+// NewRtmTrader create the RTM trader, the orders are simulated by live market data if paper is true
+func NewRtmTrader(ctx context.Context, configFilename string, paper bool) (*RtmTrader, error) {
+	cfg := RtmConfig{}
+	if err := hs.ParseJsonConfig(configFilename, &cfg); err != nil {
+		return nil, err
+	}
+	t := &RtmTrader{
+		config:   cfg,
+		maxTotal: decimal.NewFromFloat(cfg.Strategy.Total),
+		paper:    paper,
+		strategy: strategy.NewRtmRest(cfg.Strategy),
+	}
+	if paper {
+		// the paper account and state never mix with the live ones
+		t.namespace = rtmName + "_paper"
+	}
+	if err := t.init(ctx); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *RtmTrader) Start(ctx context.Context) error {
+	if err := t.ex.Load(ctx); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if t.ledger != nil {
+		go t.ledger.Start(ctx, t.ex.Exchange(), t.reconcile)
+	}
+	go t.ex.StartPaper(ctx)
+	t.Sugar.Info("RTM started")
+	t.strategy.Run(ctx)
+	t.Sugar.Info("RTM finished")
+	return nil
+}
+
+func (t *RtmTrader) Print(ctx context.Context) error {
+	if err := t.ex.Load(ctx); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	log.Printf(`State
+	Symbol: %s
+	Buy Order: %d
+	Sell Order: %d`,
+		t.ex.Symbol(), t.ex.GetBuyOrderId(), t.ex.GetSellOrderId())
+	if paper := t.ex.Paper(); paper != nil {
+		log.Print(paper.Summary())
+	}
+	return nil
+}
+
+// Clear cancel the pending orders
+func (t *RtmTrader) Clear(ctx context.Context) error {
+	if err := t.ex.Load(ctx); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	return t.ex.CancelAll()
+}
+
+func (t *RtmTrader) Close(ctx context.Context) {
+	if t.ledger != nil {
+		t.ledger.Close(ctx)
+	}
+	if t.db != nil {
+		_ = t.db.Client().Disconnect(ctx)
+	}
+	if t.Sugar != nil {
+		t.Sugar.Info("RTM Trader closed with log synced")
+		_ = t.Sugar.Sync()
+	}
+}
+
+func (t *RtmTrader) init(ctx context.Context) error {
+	l, err := hs.NewZapLogger(t.config.Log)
+	if err != nil {
+		return err
+	}
+	t.Sugar = l.Sugar()
+	t.Sugar.Info("Logger initialized")
+	db, err := hs.ConnectMongo(ctx, t.config.Mongo)
+	if err != nil {
+		return err
+	}
+	t.db = db
+	for _, conf := range t.config.Robots {
+		t.robots = append(t.robots, broadcast.New(conf))
+	}
+	if err := t.initExecutor(ctx); err != nil {
+		return err
+	}
+	if err := t.initLedger(ctx); err != nil {
+		return err
+	}
+	t.strategy.Init(t.Sugar, t.ex)
+
+	t.Sugar.Info("RTM restful Trader initialized")
+	return nil
+}
+
+func (t *RtmTrader) initExecutor(ctx context.Context) (err error) {
+	cfg := t.config.Exchange
+	var ex exchange.RestAPIExchange
+	switch cfg.Name {
+	case hs.Huobi:
+		if ex, err = huobi.New(cfg.Label, cfg.Key, cfg.Secret, cfg.Host); err != nil {
+			return
+		}
+	case hs.GateIO:
+		ex = gateio.New(cfg.Key, cfg.Secret, cfg.Host, t.Sugar)
+	default:
+		return errors.New("unsupported exchange " + cfg.Name)
+	}
+	symbol, err := ex.GetSymbol(ctx, cfg.Symbols[0])
+	if err != nil {
+		return
+	}
+	fee, err := ex.GetFee(symbol.Symbol)
+	if err != nil {
+		return
+	}
+	t.ex = &executor.RestExecutor{}
+	if t.paper {
+		t.ex.SetNamespace(t.namespace)
+		t.ex.UsePaper(t.config.Paper)
+	}
+	t.ex.Init(ex, t.Sugar, t.db, cfg.Name, cfg.Label, symbol, fee, t.maxTotal, t.robots)
+	t.ex.SetStrategy(rtmName)
+	t.ex.SetAlgo(t.config.Algo)
+	if paper := t.ex.Paper(); paper != nil {
+		paper.SetBroadcaster(t.ex.Broadcast)
+		t.Sugar.Infof("This is paper trading, namespace: %s", t.namespace)
+	}
+	return nil
+}
+
+// initLedger let the executor reserve capital for its buy orders in the shared ledger
+func (t *RtmTrader) initLedger(ctx context.Context) error {
+	conf := t.config.Ledger
+	if conf == nil {
+		return nil
+	}
+	interval, err := conf.ReconcileInterval()
+	if err != nil {
+		return err
+	}
+	ledger, err := executor.NewLedger(ctx, *conf, t.Sugar)
+	if err != nil {
+		return err
+	}
+	if err := t.ex.SetLedger(ctx, ledger, conf.Name); err != nil {
+		ledger.Close(ctx)
+		return err
+	}
+	t.ledger = ledger
+	t.reconcile = interval
+	t.Sugar.Infof("Ledger initialized, allocation: %s, limit: %s", conf.Name, t.maxTotal)
+	return nil
+}
@@ -2,6 +2,7 @@ package rest
 
 import (
 	"context"
+	"errors"
 	"github.com/shopspring/decimal"
 	"github.com/xyths/hs"
 	"github.com/xyths/hs/broadcast"
@@ -9,65 +10,183 @@ import (
 	"github.com/xyths/hs/exchange/gateio"
 	"github.com/xyths/hs/exchange/huobi"
 	"github.com/xyths/qtr/executor"
+	"github.com/xyths/qtr/rule"
 	"github.com/xyths/qtr/strategy"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
+	"log"
+	"time"
 )
 
 type SqueezeMomentumConfig struct {
 	Exchange hs.ExchangeConf
 	Mongo    hs.MongoConf
 	Strategy strategy.SqueezeStrategyConf
-	Log      hs.LogConf
-	Robots   []hs.BroadcastConf
+	// Rules send extra signals by the entry and exit rules, it's checked after the squeeze
+	Rules  rule.Conf
+	Log    hs.LogConf
+	Robots []hs.BroadcastConf
+	Paper  executor.PaperConf // virtual balance and fee, only used in paper mode
+	Algo   executor.AlgoConf  // execution algorithm of the market orders, default is market order, or chase on gate
 }
 
 type SqueezeMomentumTrader struct {
-	config   SqueezeMomentumConfig
-	maxTotal decimal.Decimal
+	config    SqueezeMomentumConfig
+	maxTotal  decimal.Decimal
+	interval  time.Duration
+	paper     bool
+	namespace string // state namespace, only used in paper mode
 
 	Sugar  *zap.SugaredLogger
 	db     *mongo.Database
 	ex     *executor.RestExecutor
 	robots []broadcast.Broadcaster
 
-	strategy *strategy.SqueezeRest
+	strategy  *strategy.SqueezeRest
+	evaluator *rule.Evaluator
 
 	trend int // 0: default (no squeeze/trend stop), 1 squeeze, 2 up trend on, -2 down trend on
 }
 
-func NewSqueezeMomentumTrader(ctx context.Context, configFilename string, dry bool) (*SqueezeMomentumTrader, error) {
+// signalQueueSize is the buffer of signals to executor, the strategy never blocks
+const signalQueueSize = 10
+
+// squeezeName is the strategy name in client order id and the paper namespace
+const squeezeName = "squeeze"
+
+// NewSqueezeMomentumTrader create the Squeeze trader, the orders are simulated by live market data if paper is true
+func NewSqueezeMomentumTrader(ctx context.Context, configFilename string, paper bool) (*SqueezeMomentumTrader, error) {
 	cfg := SqueezeMomentumConfig{}
 	err := hs.ParseJsonConfig(configFilename, &cfg)
 	if err != nil {
 		return nil, err
 	}
+	interval, err := time.ParseDuration(cfg.Strategy.Interval)
+	if err != nil {
+		return nil, err
+	}
 	t := &SqueezeMomentumTrader{
 		config:   cfg,
 		maxTotal: decimal.NewFromFloat(cfg.Strategy.Total),
-		strategy: strategy.NewSqueezeRest(cfg.Strategy, dry),
+		interval: interval,
+		paper:    paper,
+		strategy: strategy.NewSqueezeRest(cfg.Strategy, false),
+	}
+	if paper {
+		// the paper account and state never mix with the live ones
+		t.namespace = squeezeName + "_paper"
+	}
+	if cfg.Rules.Entry != "" || cfg.Rules.Exit != "" {
+		if t.evaluator, err = rule.NewEvaluator(cfg.Rules); err != nil {
+			return nil, err
+		}
 	}
 
-	err = t.init(ctx)
+	if err = t.init(ctx); err != nil {
+		return nil, err
+	}
 	return t, nil
 }
 
+// Run check the squeeze at the start of every candle, the executor places orders and checks fills
 func (t *SqueezeMomentumTrader) Run(ctx context.Context) {
 	t.Sugar.Info("Squeeze started")
 	// load previous state
-	t.Load(ctx)
-	t.Sugar.Debugf("old trend: %d", t.trend)
+	if err := t.Load(ctx); err != nil {
+		t.Sugar.Errorf("load state error: %s", err)
+		return
+	}
+	go func() {
+		if err := t.ex.Start(ctx); err != nil {
+			t.Sugar.Infof("executor stopped: %s", err)
+		}
+	}()
+	go t.ex.StartPaper(ctx)
+	t.doWork(ctx)
+	wakeTime := time.Now().Truncate(t.interval).Add(t.interval)
+	sleepTime := time.Until(wakeTime)
+	for {
+		select {
+		case <-ctx.Done():
+			t.Sugar.Info("Squeeze finished")
+			return
+		case <-time.After(sleepTime):
+			t.doWork(ctx)
+			wakeTime = wakeTime.Add(t.interval)
+			sleepTime = time.Until(wakeTime)
+			t.Sugar.Debugf("next check time: %s", wakeTime.String())
+		}
+	}
+}
+
+func (t *SqueezeMomentumTrader) doWork(ctx context.Context) {
+	old := t.trend
 	t.strategy.Run(ctx)
-	t.Sugar.Debugf("new trend: %d", t.trend)
-	t.Sugar.Info("Squeeze finished")
+	t.Sugar.Debugf("trend: %d -> %d", old, t.trend)
+	t.checkRules()
 }
 
-func (t *SqueezeMomentumTrader) Print(_ context.Context) {
-	t.Sugar.Info("print no implemented")
+// ruleLookback is the candles to evaluate the rules, it's the max size of huobi
+const ruleLookback = 2000
+
+// checkRules send the signal of the entry and exit rules to executor.
+// The entry rule only buys when the squeeze allows, not in the squeeze or the down trend.
+func (t *SqueezeMomentumTrader) checkRules() {
+	if t.evaluator == nil {
+		return
+	}
+	s, err := t.evaluator.Signal(rule.ExchangeSource{Ex: t.ex.Exchange(), Size: ruleLookback}, t.ex.Symbol())
+	if err != nil {
+		t.Sugar.Errorf("check rules error: %s", err)
+		return
+	}
+	t.Sugar.Debugf("rule signal: %s", s)
+	switch s {
+	case rule.SignalBuy:
+		if t.trend == 1 || t.trend == -2 {
+			t.Sugar.Infof("entry rule ignored, trend: %d", t.trend)
+			return
+		}
+		t.signal(1)
+	case rule.SignalSell:
+		t.signal(-1)
+	}
 }
 
-func (t *SqueezeMomentumTrader) Clear(ctx context.Context) {
-	t.Sugar.Info("clear no implemented")
+func (t *SqueezeMomentumTrader) Print(ctx context.Context) error {
+	if err := t.Load(ctx); err != nil {
+		return err
+	}
+	log.Printf(`State
+	Symbol: %s
+	Trend: %d
+	Executor State: %d
+	Buy Order: %d
+	Sell Order: %d`,
+		t.ex.Symbol(), t.trend, t.ex.State(), t.ex.GetBuyOrderId(), t.ex.GetSellOrderId())
+	if paper := t.ex.Paper(); paper != nil {
+		log.Print(paper.Summary())
+	}
+	return nil
+}
+
+// Clear cancel the pending orders and delete the trend, the coins are kept
+func (t *SqueezeMomentumTrader) Clear(ctx context.Context) error {
+	if err := t.Load(ctx); err != nil {
+		return err
+	}
+	if err := t.ex.CancelAll(); err != nil {
+		return err
+	}
+	// the coins are kept, so it's still open if holding
+	if err := t.ex.ResetState(); err != nil {
+		return err
+	}
+	if err := hs.DeleteKey(ctx, t.coll(collNameState), "trend"); err != nil {
+		return err
+	}
+	t.Sugar.Info("Squeeze state cleared")
+	return nil
 }
 
 func (t *SqueezeMomentumTrader) Close(ctx context.Context) {
@@ -120,13 +239,15 @@ func (t *SqueezeMomentumTrader) initExecutor() (err error) {
 	cfg := t.config.Exchange
 	var ex exchange.RestAPIExchange
 	switch cfg.Name {
-	case "huobi":
+	case hs.Huobi:
 		ex, err = huobi.New(cfg.Label, cfg.Key, cfg.Secret, cfg.Host)
 		if err != nil {
 			return
 		}
-	case "gate":
-		ex = gateio.New(cfg.Key, cfg.Secret, cfg.Host)
+	case hs.GateIO:
+		ex = gateio.New(cfg.Key, cfg.Secret, cfg.Host, t.Sugar)
+	default:
+		return errors.New("unsupported exchange " + cfg.Name)
 	}
 	symbol, err := ex.GetSymbol(context.Background(), cfg.Symbols[0])
 	if err != nil {
@@ -136,72 +257,106 @@ func (t *SqueezeMomentumTrader) initExecutor() (err error) {
 	if err != nil {
 		return
 	}
-	t.ex = &executor.RestExecutor{}
+	t.ex = &executor.RestExecutor{Receiver: make(chan executor.Signal, signalQueueSize)}
+	if t.paper {
+		t.ex.SetNamespace(t.namespace)
+		t.ex.UsePaper(t.config.Paper)
+	}
 	t.ex.Init(ex, t.Sugar, t.db, t.config.Exchange.Name, t.config.Exchange.Label, symbol, fee, t.maxTotal, t.robots)
+	t.ex.SetStrategy(squeezeName)
+	t.ex.SetAlgo(t.config.Algo)
+	if paper := t.ex.Paper(); paper != nil {
+		paper.SetBroadcaster(t.ex.Broadcast)
+		t.Sugar.Infof("This is paper trading, namespace: %s", t.namespace)
+	}
 	return nil
 }
 
-func (t *SqueezeMomentumTrader) Load(ctx context.Context) {
+// Load the trend and the executor state (orders)
+func (t *SqueezeMomentumTrader) Load(ctx context.Context) error {
 	t.loadTrend(ctx)
+	return t.ex.Load(ctx)
 }
 
 const collNameState = "state"
 
+func (t *SqueezeMomentumTrader) coll(name string) *mongo.Collection {
+	return t.db.Collection(executor.CollName(t.namespace, name))
+}
+
 func (t *SqueezeMomentumTrader) loadTrend(ctx context.Context) {
-	if err := hs.LoadKey(ctx, t.db.Collection(collNameState), "trend", &t.trend); err != nil {
+	if err := hs.LoadKey(ctx, t.coll(collNameState), "trend", &t.trend); err != nil {
 		t.Sugar.Errorf("load trend error: %s", err)
 	} else {
 		t.Sugar.Infof("load trend: %d", t.trend)
 	}
 }
 func (t *SqueezeMomentumTrader) saveTrend(ctx context.Context) {
-	if err := hs.SaveKey(ctx, t.db.Collection(collNameState), "trend", t.trend); err != nil {
+	if err := hs.SaveKey(ctx, t.coll(collNameState), "trend", t.trend); err != nil {
 		t.Sugar.Errorf("save trend error: %s", err)
 	} else {
 		t.Sugar.Infof("save trend: %d", t.trend)
 	}
 }
 
+// signal send the order signal to executor, 1: buy, -1: sell
+func (t *SqueezeMomentumTrader) signal(direction int) {
+	select {
+	case t.ex.Receiver <- executor.Signal{Direction: direction}:
+	default:
+		t.Sugar.Errorf("executor is busy, drop signal %d", direction)
+	}
+}
+
+// squeezeOn exit, the momentum is gone
 func (t *SqueezeMomentumTrader) squeezeOn(last int, dry bool) {
 	t.Sugar.Infof("squeeze on, last %d, wait for trend", last)
 	if t.trend != 1 {
+		if t.trend == 2 {
+			t.ex.Broadcast("进入挤压，卖出")
+		}
 		t.trend = 1
 		t.saveTrend(context.Background())
 	}
+	t.signal(-1)
 }
+
+// trendOn enter on the up trend release, exit on the down trend
 func (t *SqueezeMomentumTrader) trendOn(up bool, last int, dry bool) {
 	t.Sugar.Infof("trend fire off, up %v, last %d", up, last)
 	if up {
 		if t.trend != 2 {
 			t.Sugar.Infof("first time go to up trend")
+			t.ex.Broadcast("挤压释放，上涨趋势，买入")
 			t.trend = 2
 			t.saveTrend(context.Background())
 			// buy market
-			if !dry {
-				if err := t.ex.BuyAllMarket(); err != nil {
-					t.Sugar.Error(err)
-				}
-			}
+			t.signal(1)
 		}
 	} else {
 		if t.trend != -2 {
 			t.Sugar.Infof("first time go to down trend")
+			if t.trend == 2 {
+				t.ex.Broadcast("下跌趋势，卖出")
+			}
 			t.trend = -2
 			t.saveTrend(context.Background())
 		}
+		t.signal(-1)
 	}
 }
+
+// trendOff exit, the trend is stopped
 func (t *SqueezeMomentumTrader) trendOff(up bool, last int, dry bool) {
 	t.Sugar.Infof("trend stopped, up %v, last %d", up, last)
 	if t.trend != 0 {
 		t.Sugar.Infof("first time trend stopped")
+		if t.trend == 2 {
+			t.ex.Broadcast("趋势结束，卖出")
+		}
 		t.trend = 0
 		t.saveTrend(context.Background())
-		// sell market
-		if !dry {
-			if err := t.ex.SellAllMarket(); err != nil {
-				t.Sugar.Error(err)
-			}
-		}
 	}
+	// sell market
+	t.signal(-1)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	indicator "github.com/xyths/go-indicators"
 	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/rule"
 	"github.com/xyths/qtr/strategy/options"
 	"go.uber.org/zap"
 	"time"
@@ -13,6 +14,8 @@ type Config struct {
 	Squeeze    *options.SqueezeStrategyOption    `json:"squeeze"`
 	SuperTrend *options.SuperTrendStrategyOption `json:"superTrend"`
 	Size       int64
+	// Rule is checked after Squeeze and SuperTrend, eg. "squeeze(W).trend == 2 && natr(D, 14) > 3"
+	Rule string `json:"rule"`
 }
 
 type Rule struct {
@@ -26,6 +29,7 @@ type Trigger struct {
 	Sugar  *zap.SugaredLogger
 	ex     exchange.RestAPIExchange
 	rules  []Rule
+	rule   *rule.Rule
 }
 
 func NewTrigger(cfg Config, ) *Trigger {
@@ -34,24 +38,39 @@ func NewTrigger(cfg Config, ) *Trigger {
 	}
 }
 
-func (t *Trigger) Init(sugar *zap.SugaredLogger, ex exchange.RestAPIExchange) {
+func (t *Trigger) Init(sugar *zap.SugaredLogger, ex exchange.RestAPIExchange) error {
 	t.Sugar = sugar
 	t.ex = ex
 	// check rules
 	t.initCheckRules()
+	if t.config.Rule != "" {
+		r, err := rule.Parse(t.config.Rule)
+		if err != nil {
+			return err
+		}
+		t.rule = r
+	}
+	return nil
 }
 
 func (t *Trigger) Check(ctx context.Context, symbol string) (bool, error) {
 	allOn := false
 	for _, r := range t.rules {
 		if r.Squeeze || r.SuperTrend {
-			on, err := t.CheckPeriod(ctx, symbol, t.config.Size, exchange.MON1, r.Squeeze, r.SuperTrend)
+			on, err := t.CheckPeriod(ctx, symbol, t.config.Size, r.Period, r.Squeeze, r.SuperTrend)
 			if err != nil || !on {
 				return on, err
 			}
 			allOn = true
 		}
 	}
+	if t.rule != nil {
+		on, err := t.rule.Eval(rule.ExchangeSource{Ex: t.ex, Size: int(t.config.Size)}, symbol)
+		if err != nil || !on {
+			return on, err
+		}
+		allOn = true
+	}
 	return allOn, nil
 }
 
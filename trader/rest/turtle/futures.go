@@ -0,0 +1,177 @@
+package turtle
+
+import (
+	"context"
+	"fmt"
+	"github.com/markcheno/go-talib"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/logger"
+	"github.com/xyths/qtr/cmd/utils"
+	"github.com/xyths/qtr/executor"
+	"time"
+)
+
+// initFutures create the futures trader if configured, the contract is traded instead of spot.
+func (t *Trader) initFutures(ctx context.Context) {
+	conf := t.config.Strategy.Futures
+	if conf == nil {
+		return
+	}
+	c := *conf
+	if c.Exchange == "" {
+		c.Exchange = t.config.Exchange.Name
+	}
+	ex, err := executor.NewFuturesExchange(c, t.config.Exchange.Key, t.config.Exchange.Secret)
+	if err != nil {
+		logger.Sugar.Fatal(err)
+	}
+	futures, err := executor.NewFuturesTrader(ctx, c, ex, logger.Sugar)
+	if err != nil {
+		logger.Sugar.Fatal(err)
+	}
+	t.futures = futures
+}
+
+// doFuturesWork trade both sides: break the upper to long, break the lower to short.
+// state.Position is the units, positive for long and negative for short.
+func (t *Trader) doFuturesWork(ctx context.Context) {
+	contract := t.futures.Contract().Name
+	candle, err := t.futures.Exchange().Candle(ctx, contract, t.interval, 300)
+	if err != nil {
+		logger.Sugar.Errorf("get candle error: %s", err)
+		return
+	}
+	l := candle.Length()
+	if l <= t.config.Strategy.PeriodUpper || l <= t.config.Strategy.PeriodATR {
+		logger.Sugar.Errorf("candle is too short: %d", l)
+		return
+	}
+	atrs := talib.Atr(candle.High, candle.Low, candle.Close, t.config.Strategy.PeriodATR)
+	atr := atrs[len(atrs)-1]
+	uppers := talib.Max(candle.High, t.config.Strategy.PeriodUpper)
+	upper := uppers[len(uppers)-2]
+	lowers := talib.Min(candle.Low, t.config.Strategy.PeriodLower)
+	lower := lowers[len(lowers)-2]
+	logger.Sugar.Debugf("atr: %f, upper: %f, lower: %f", atr, upper, lower)
+
+	secondsEastOfUTC := int((8 * time.Hour).Seconds())
+	beijing := time.FixedZone("Beijing Time", secondsEastOfUTC)
+	datetime := time.Unix(candle.Timestamp[l-1], 0).In(beijing).Format(utils.TimeLayout)
+	high, low, last := candle.High[l-1], candle.Low[l-1], t.state.LastBuyPrice
+	price := decimal.NewFromFloat(candle.Close[l-1])
+
+	switch {
+	case low <= lower && t.state.Position >= 0:
+		logger.Sugar.Infof("突破下轨, Timestamp: %s, Lower: %f, Low: %f, 开空", datetime, lower, low)
+		t.futuresOpen(ctx, -1, price, atr)
+	case high >= upper && t.state.Position <= 0:
+		logger.Sugar.Infof("突破上轨, Timestamp: %s, Upper: %f, High: %f, 开多", datetime, upper, high)
+		t.futuresOpen(ctx, 1, price, atr)
+	case t.state.Position > 0 && low+2*atr <= last:
+		logger.Sugar.Infof("下跌超过2N, Timestamp: %s, N: %f, LastBuy: %f, Low: %f, 平多", datetime, atr, last, low)
+		t.futuresClear(ctx)
+	case t.state.Position < 0 && high-2*atr >= last:
+		logger.Sugar.Infof("上涨超过2N, Timestamp: %s, N: %f, LastSell: %f, High: %f, 平空", datetime, atr, last, high)
+		t.futuresClear(ctx)
+	case t.state.Position > 0 && high >= last+0.5*atr && t.state.BuyTimes < t.config.Strategy.MaxTimes:
+		logger.Sugar.Infof("上涨超过0.5N, Timestamp: %s, N: %f, LastBuy: %f, High: %f, 加多", datetime, atr, last, high)
+		t.futuresAdd(ctx, 1, price, atr)
+	case t.state.Position < 0 && low <= last-0.5*atr && t.state.BuyTimes < t.config.Strategy.MaxTimes:
+		logger.Sugar.Infof("下跌超过0.5N, Timestamp: %s, N: %f, LastSell: %f, Low: %f, 加空", datetime, atr, last, low)
+		t.futuresAdd(ctx, -1, price, atr)
+	}
+}
+
+// futuresUnit is the contracts of one unit, sized by the sizer, it's 0 if less than the min size
+func (t *Trader) futuresUnit(price decimal.Decimal, N float64) (int64, error) {
+	unit, err := t.sizer.Size(executor.SizingInput{
+		Equity: decimal.NewFromFloat(t.config.Strategy.Total),
+		Price:  price,
+		Atr:    decimal.NewFromFloat(N),
+	})
+	if err != nil {
+		return 0, err
+	}
+	c := t.futures.Contract()
+	size := unit.Div(c.Multiplier).IntPart()
+	if size < c.MinSize {
+		logger.Sugar.Infof("unit %s is less than the min size %d * %s", unit, c.MinSize, c.Multiplier)
+		return 0, nil
+	}
+	return size, nil
+}
+
+// futuresOpen close the opposite position and open one unit of direction
+func (t *Trader) futuresOpen(ctx context.Context, direction int, price decimal.Decimal, N float64) {
+	size, err := t.futuresUnit(price, N)
+	if err != nil {
+		logger.Sugar.Errorf("sizing error: %s", err)
+		return
+	}
+	if size == 0 {
+		// no entry, but still exit the opposite position
+		if t.state.Position != 0 {
+			t.futuresClear(ctx)
+		}
+		return
+	}
+	clientId := fmt.Sprintf("o-%d-%d", t.state.SellTimes, t.state.BuyTimes)
+	p, err := t.futures.Target(ctx, int64(direction)*size, clientId)
+	if err != nil {
+		logger.Sugar.Errorf("futures open error: %s", err)
+		return
+	}
+	logger.Sugar.Infof("开仓，订单号: %s, 持仓: %d 张, 开仓均价: %s", clientId, p.Size, p.EntryPrice)
+	if t.state.Position != 0 {
+		t.state.SellTimes++
+	}
+	t.state.Position = direction
+	t.state.BuyTimes = 1
+	t.state.LastBuyPrice, _ = price.Float64()
+	t.state.LastModified = time.Now()
+	t.saveState(ctx)
+}
+
+// futuresAdd add one unit to the position
+func (t *Trader) futuresAdd(ctx context.Context, direction int, price decimal.Decimal, N float64) {
+	size, err := t.futuresUnit(price, N)
+	if err != nil {
+		logger.Sugar.Errorf("sizing error: %s", err)
+		return
+	}
+	if size == 0 {
+		return
+	}
+	p, err := t.futures.Position(ctx)
+	if err != nil {
+		logger.Sugar.Errorf("get futures position error: %s", err)
+		return
+	}
+	clientId := fmt.Sprintf("a-%d-%d", t.state.SellTimes, t.state.BuyTimes)
+	p, err = t.futures.Target(ctx, p.Size+int64(direction)*size, clientId)
+	if err != nil {
+		logger.Sugar.Errorf("futures add error: %s", err)
+		return
+	}
+	logger.Sugar.Infof("加仓，订单号: %s, 持仓: %d 张, 开仓均价: %s", clientId, p.Size, p.EntryPrice)
+	t.state.Position += direction
+	t.state.BuyTimes++
+	t.state.LastBuyPrice, _ = price.Float64()
+	t.state.LastModified = time.Now()
+	t.saveState(ctx)
+}
+
+func (t *Trader) futuresClear(ctx context.Context) {
+	clientId := fmt.Sprintf("c-%d-0", t.state.SellTimes+1)
+	if _, err := t.futures.Target(ctx, 0, clientId); err != nil {
+		logger.Sugar.Errorf("futures clear error: %s", err)
+		return
+	}
+	logger.Sugar.Infof("清仓，订单号: %s", clientId)
+	t.state.BuyTimes = 0
+	t.state.SellTimes++
+	t.state.Position = 0
+	t.state.LastBuyPrice = 0
+	t.state.LastModified = time.Now()
+	t.saveState(ctx)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/xyths/hs/broadcast"
 	"github.com/xyths/hs/logger"
 	"github.com/xyths/qtr/cmd/utils"
+	"github.com/xyths/qtr/executor"
 	"github.com/xyths/qtr/gateio"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -33,6 +34,9 @@ type Trader struct {
 	minAmount       decimal.Decimal
 	minTotal        decimal.Decimal
 
+	sizer   executor.Sizer
+	futures *executor.FuturesTrader
+
 	state   state
 	balance map[string]decimal.Decimal
 }
@@ -46,9 +50,17 @@ func New(configFilename string) *Trader {
 	if err != nil {
 		logger.Sugar.Fatalf("error interval format: %s", cfg.Strategy.Interval)
 	}
+	if cfg.Strategy.Sizing.Policy == "" {
+		cfg.Strategy.Sizing = executor.SizingConf{Policy: executor.SizingAtr, Risk: 0.01}
+	}
+	sizer, err := executor.NewSizer(cfg.Strategy.Sizing)
+	if err != nil {
+		logger.Sugar.Fatalf("error sizing config: %s", err)
+	}
 	return &Trader{
 		config:   cfg,
 		interval: interval,
+		sizer:    sizer,
 	}
 }
 
@@ -59,6 +71,7 @@ func (t *Trader) Init(ctx context.Context) {
 	}
 	t.db = db
 	t.initEx(ctx)
+	t.initFutures(ctx)
 	t.initRobots(ctx)
 }
 
@@ -101,6 +114,12 @@ func (t *Trader) Close(ctx context.Context) {
 }
 
 func (t *Trader) Print(ctx context.Context) error {
+	if t.futures != nil {
+		t.loadState(ctx)
+		log.Printf("Position: %d, buy times: %d, sell times: %d, last price: %f",
+			t.state.Position, t.state.BuyTimes, t.state.SellTimes, t.state.LastBuyPrice)
+		return nil
+	}
 	t.getPosition(ctx)
 	return nil
 }
@@ -112,7 +131,7 @@ func (t *Trader) Clear(ctx context.Context) error {
 }
 
 func (t *Trader) Start(ctx context.Context) error {
-	if !t.loadState(ctx) {
+	if !t.loadState(ctx) && t.futures == nil {
 		t.getPosition(ctx)
 		t.saveState(ctx)
 	}
@@ -131,6 +150,10 @@ func (t *Trader) Start(ctx context.Context) error {
 }
 
 func (t *Trader) doWork(ctx context.Context) {
+	if t.futures != nil {
+		t.doFuturesWork(ctx)
+		return
+	}
 	// max 300 data
 	candle, err := t.ex.GetCandle(t.symbol, int(t.interval)/1000000000, int(300*t.interval/time.Hour)-1)
 	if err != nil {
@@ -281,7 +304,15 @@ func (t *Trader) openPosition(ctx context.Context, N float64) {
 	}
 	s1, _ := gateio.Sell1(ob.Asks)
 	price := decimal.NewFromFloat(s1)
-	unit := decimal.NewFromFloat(t.config.Strategy.Total * 0.01 / N)
+	unit, err := t.sizer.Size(executor.SizingInput{
+		Equity: decimal.NewFromFloat(t.config.Strategy.Total),
+		Price:  price,
+		Atr:    decimal.NewFromFloat(N),
+	})
+	if err != nil {
+		logger.Sugar.Errorf("sizing error: %s", err)
+		return
+	}
 	amount := unit.Round(t.amountPrecision)
 	total := price.Mul(amount)
 	cash := balance[t.quoteCurrency]
@@ -330,7 +361,15 @@ func (t *Trader) addPosition(ctx context.Context, N float64) {
 	}
 	s1, _ := gateio.Sell1(ob.Asks)
 	price := decimal.NewFromFloat(s1)
-	unit := decimal.NewFromFloat(t.config.Strategy.Total * 0.01 / N)
+	unit, err := t.sizer.Size(executor.SizingInput{
+		Equity: decimal.NewFromFloat(t.config.Strategy.Total),
+		Price:  price,
+		Atr:    decimal.NewFromFloat(N),
+	})
+	if err != nil {
+		logger.Sugar.Errorf("sizing error: %s", err)
+		return
+	}
 	amount := unit.Round(t.amountPrecision)
 	total := price.Mul(amount)
 	cash := balance[t.quoteCurrency]
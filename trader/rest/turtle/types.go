@@ -2,6 +2,7 @@ package turtle
 
 import (
 	"github.com/xyths/hs"
+	"github.com/xyths/qtr/executor"
 	"time"
 )
 
@@ -14,6 +15,10 @@ type RestTurtleStrategyConf struct {
 	PeriodATR   int `json:"periodATR"`   //periodATR   = 14
 	PeriodUpper int `json:"periodUpper"` //periodUpper = 20
 	PeriodLower int `json:"periodLower"` //periodLower = 10
+
+	Sizing executor.SizingConf // default is atr, risk 1% of total per N
+
+	Futures *executor.FuturesConf // trade the perpetual contract, break the lower to short
 }
 
 type Config struct {
@@ -24,7 +29,7 @@ type Config struct {
 }
 
 type state struct {
-	Position     int     `bson:"position"` // 0: empty, 1: open
+	Position     int     `bson:"position"` // 0: empty, 1: open, negative for futures short
 	BuyTimes     int     `bson:"buyTimes"`
 	SellTimes    int     `bson:"sellTimes"`
 	LastBuyPrice float64 `bson:"lastBuyPrice"`
@@ -10,6 +10,7 @@ import (
 	"github.com/xyths/hs/exchange"
 	"github.com/xyths/hs/exchange/gateio"
 	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/executor"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 	"strings"
@@ -24,6 +25,9 @@ type BaseTrader struct {
 	StopLoss  bool
 	Reinforce float64
 	dry       bool
+	paperMode bool
+	namespace string // collection name prefix, empty for single symbol in live mode
+	shared    bool   // the database, exchange and paper account are owned by the multi-symbol trader
 
 	Sugar  *zap.SugaredLogger
 	db     *mongo.Database
@@ -31,6 +35,7 @@ type BaseTrader struct {
 	symbol exchange.Symbol
 	fee    exchange.Fee
 	robots []broadcast.Broadcaster
+	paper  *executor.PaperExchange
 
 	maxTotal decimal.Decimal // max total for buy order, half total in config
 }
@@ -56,6 +61,14 @@ func NewBaseTraderFromConfig(ctx context.Context, cfg Config) (*BaseTrader, erro
 }
 
 func (t *BaseTrader) Init(ctx context.Context) error {
+	if err := t.initShared(ctx); err != nil {
+		return err
+	}
+	return t.initSymbol(t.config.Exchange.Symbols[0])
+}
+
+// initShared init the logger, database, exchange connection and robots, they can be shared by all symbols
+func (t *BaseTrader) initShared(ctx context.Context) error {
 	if err := t.initLogger(); err != nil {
 		return err
 	}
@@ -68,6 +81,60 @@ func (t *BaseTrader) Init(ctx context.Context) error {
 		return err
 	}
 	t.initRobots(ctx)
+	if t.paperMode {
+		if err := t.initPaper(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// share the resources of the base trader, and trade symbol in its own state namespace
+func (t *BaseTrader) share(b *BaseTrader, symbol, namespace string) error {
+	t.Sugar = b.Sugar.Named(symbol)
+	t.db = b.db
+	t.ex = b.ex
+	t.robots = b.robots
+	t.paper = b.paper
+	t.namespace = joinNamespace(b.namespace, namespace)
+	t.shared = true
+	return t.initSymbol(symbol)
+}
+
+// joinNamespace nest the symbol namespace in the base namespace, either can be empty
+func joinNamespace(base, namespace string) string {
+	if namespace == "" {
+		return base
+	}
+	return executor.CollName(base, namespace)
+}
+
+func (t *BaseTrader) coll(name string) *mongo.Collection {
+	return t.db.Collection(executor.CollName(t.namespace, name))
+}
+
+// UsePaper switch to paper trading, must be called before Init
+func (t *BaseTrader) UsePaper() {
+	t.paperMode = true
+}
+
+// initPaper use the paper exchange, the paper account and state are in the "name_paper" namespace,
+// so they never mix with the live ones in the same database
+func (t *BaseTrader) initPaper(ctx context.Context) error {
+	name := t.config.Strategy.Name
+	if name == "" {
+		name = defaultPaperName
+	}
+	t.namespace = name + "_paper"
+	t.paper = executor.NewPaperExchange(t.ex, t.config.Paper)
+	t.paper.SetNamespace(t.namespace)
+	t.paper.Init(t.Sugar, t.db)
+	if err := t.paper.Load(ctx); err != nil {
+		return err
+	}
+	t.paper.SetBroadcaster(t.Broadcast)
+	t.ex = t.paper
+	t.Sugar.Infof("Paper trading initialized, namespace: %s", t.namespace)
 	return nil
 }
 
@@ -81,20 +148,31 @@ func (t *BaseTrader) initLogger() error {
 	return nil
 }
 
-func (t *BaseTrader) initEx() error {
+func (t *BaseTrader) initEx() (err error) {
 	switch t.config.Exchange.Name {
 	case "gate":
-		if err := t.initGate(); err != nil {
-			return err
-		}
+		t.ex = gateio.New(t.config.Exchange.Key, t.config.Exchange.Secret, t.config.Exchange.Host, t.Sugar)
 	case "huobi":
-		if err := t.initHuobi(); err != nil {
+		t.ex, err = huobi.New(t.config.Exchange.Label, t.config.Exchange.Key, t.config.Exchange.Secret, t.config.Exchange.Host)
+		if err != nil {
 			return err
 		}
 	default:
 		return errors.New("unsupported exchange")
 	}
 	t.Sugar.Info("Exchange initialized")
+	return nil
+}
+
+func (t *BaseTrader) initSymbol(symbol string) (err error) {
+	t.symbol, err = t.ex.GetSymbol(context.Background(), symbol)
+	if err != nil {
+		return err
+	}
+	t.fee, err = t.ex.GetFee(t.Symbol())
+	if err != nil {
+		return err
+	}
 	t.Sugar.Infof(
 		"Symbol: %s, PricePrecision: %d, AmountPrecision: %d, MinAmount: %s, MinTotal: %s",
 		t.Symbol(),
@@ -109,30 +187,6 @@ func (t *BaseTrader) initEx() error {
 	return nil
 }
 
-func (t *BaseTrader) initGate() error {
-	t.ex = gateio.New(t.config.Exchange.Key, t.config.Exchange.Secret, t.config.Exchange.Host, t.Sugar)
-	symbol, err := t.ex.GetSymbol(context.Background(), t.config.Exchange.Symbols[0])
-	if err != nil {
-		return err
-	}
-	t.symbol = symbol
-	t.fee, err = t.ex.GetFee(t.Symbol())
-	return err
-}
-
-func (t *BaseTrader) initHuobi() (err error) {
-	t.ex, err = huobi.New(t.config.Exchange.Label, t.config.Exchange.Key, t.config.Exchange.Secret, t.config.Exchange.Host)
-	if err != nil {
-		return err
-	}
-	t.symbol, err = t.ex.GetSymbol(context.Background(), t.config.Exchange.Symbols[0])
-	if err != nil {
-		return err
-	}
-	t.fee, err = t.ex.GetFee(t.Symbol())
-	return err
-}
-
 func (t *BaseTrader) initRobots(ctx context.Context) {
 	for _, conf := range t.config.Robots {
 		t.robots = append(t.robots, broadcast.New(conf))
@@ -188,3 +242,41 @@ func (t *BaseTrader) ActualMakerFee() decimal.Decimal {
 func (t *BaseTrader) ActualTakerFee() decimal.Decimal {
 	return t.fee.ActualTaker
 }
+
+// superSignal returns 1 to buy, -1 to sell and 0 to hold, by the SuperTrend of the last two closed bars.
+// exited is true after a conditional order closed the position, then it only buys on the next up-flip,
+// so the protective exit is not bought back on the next bar.
+func superSignal(prev, last bool, position int64, exited bool) int {
+	switch {
+	case last && !prev:
+		return 1
+	case last && position != 1 && !exited:
+		return 1
+	case !last && prev:
+		return -1
+	}
+	return 0
+}
+
+// strategyName is the strategy instance name in client order id
+func strategyName(cfg Config) string {
+	if cfg.Strategy.Name == "" {
+		return defaultStrategyName
+	}
+	return cfg.Strategy.Name
+}
+
+// encodeClientId encode the client order id by the legacy prefix, the strategy instance and symbol are encoded in it
+func encodeClientId(cfg Config, symbol, prefix string, sequence int64) (string, error) {
+	codec := executor.NewClientIdCodec(cfg.Exchange.Name, strategyName(cfg), symbol)
+	return codec.EncodePrefix(prefix, sequence)
+}
+
+// belongs check if the order is placed by the strategy instance on the symbol, legacy ids always belong to it
+func belongs(cfg Config, symbol, clientOrderId string) bool {
+	id, err := executor.ParseClientId(clientOrderId)
+	if err != nil {
+		return true
+	}
+	return id.Belongs(strategyName(cfg), symbol)
+}
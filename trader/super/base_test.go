@@ -0,0 +1,57 @@
+package super
+
+import (
+	"github.com/xyths/hs"
+	"github.com/xyths/qtr/executor"
+	"testing"
+)
+
+func TestSuperSignal(t *testing.T) {
+	var tests = []struct {
+		name     string
+		prev     bool
+		last     bool
+		position int64
+		exited   bool
+		signal   int
+	}{
+		{"up-flip", false, true, -1, false, 1},
+		{"up, not full", true, true, -1, false, 1},
+		{"up, full", true, true, 1, false, 0},
+		{"down-flip", true, false, 1, false, -1},
+		{"down", false, false, -1, false, 0},
+		{"stop fired, next up bar", true, true, -1, true, 0},
+		{"stop fired, up-flip", false, true, -1, true, 1},
+		{"stop fired, down-flip", true, false, -1, true, -1},
+	}
+	for _, tt := range tests {
+		if got := superSignal(tt.prev, tt.last, tt.position, tt.exited); got != tt.signal {
+			t.Errorf("%s: want %d, got %d", tt.name, tt.signal, got)
+		}
+	}
+}
+
+func TestEncodeClientId(t *testing.T) {
+	var cfg Config
+	cfg.Exchange = hs.ExchangeConf{Name: "gate"}
+	s, err := encodeClientId(cfg, "btc_usdt", prefixBuyLimitOrder, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := executor.ParseClientId(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.Belongs(defaultStrategyName, "btc_usdt") {
+		t.Errorf("%s should belong to the default strategy name %s", s, defaultStrategyName)
+	}
+	if !belongs(cfg, "btc_usdt", s) || belongs(cfg, "eth_usdt", s) {
+		t.Errorf("%s should only belong to btc_usdt", s)
+	}
+	if !belongs(cfg, "eth_usdt", "bl-0-1-2") {
+		t.Error("the legacy id should belong to all symbols")
+	}
+	if _, err := encodeClientId(cfg, "btc_usdt", "xx", 1); err == nil {
+		t.Error("want error for unknown prefix")
+	}
+}
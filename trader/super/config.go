@@ -2,6 +2,7 @@ package super
 
 import (
 	"github.com/xyths/hs"
+	"github.com/xyths/qtr/executor"
 )
 
 // SuperTrendConfig
@@ -11,13 +12,25 @@ type Config struct {
 	Strategy StrategyConf
 	Log      hs.LogConf
 	Robots   []hs.BroadcastConf
+	Ledger   *executor.LedgerConf // shared capital ledger, nil means use the whole balance
+	Paper    executor.PaperConf   // virtual balance and fee, only used in paper mode
 }
 
 type StrategyConf struct {
+	Name      string // strategy instance name, encoded in client order id
 	Total     float64
 	Interval  string
 	Factor    float64
 	Period    int
 	StopLoss  bool `json:"stopLoss"`
 	Reinforce float64
+	Algo      executor.AlgoConf // execution algorithm for market buy/sell
+
+	// emulated conditional orders, placed after long
+	TakeProfit   float64 `json:"takeProfit"`   // take-profit percent, 0 means disabled
+	TrailPercent float64 `json:"trailPercent"` // trailing-stop distance in percent, 0 means disabled
+	TrailAtr     float64 `json:"trailAtr"`     // trailing-stop distance in ATR, used when TrailPercent is 0
+
+	Margin  *executor.MarginConf  // short on the margin account when the trend is down, nil means long only; huobi only, not in paper mode
+	Futures *executor.FuturesConf // trade the perpetual contract instead of spot, long and short with the trend
 }
@@ -1,10 +1,20 @@
 package super
 
-import "fmt"
+import (
+	"fmt"
+	"github.com/xyths/qtr/executor"
+)
 
 const (
 	collNameOrder = "order"
 	collNameState = "state"
+
+	collNameConditional = "conditional"
+
+	// strategy name in the paper namespace, when there is no name in config
+	defaultPaperName = "super"
+	// strategy name in client order id, when there is no name in config
+	defaultStrategyName = "st"
 )
 
 const (
@@ -17,8 +27,21 @@ const (
 	prefixSellLimitOrder     = "sl"
 	prefixSellStopOrder      = "ss"
 	prefixSellReinforceOrder = "sr"
+	prefixTakeProfitOrder    = "tp"
+	prefixTrailingStopOrder  = "ts"
+	prefixShortSellOrder     = "ms"
+	prefixShortBuyOrder      = "mb"
+	prefixFuturesLongOrder   = "fl"
+	prefixFuturesShortOrder  = "fs"
 )
 
+// client order id prefix of the conditional orders
+var conditionalPrefix = map[string]string{
+	executor.ConditionalStopLoss:     prefixSellStopOrder,
+	executor.ConditionalTakeProfit:   prefixTakeProfitOrder,
+	executor.ConditionalTrailingStop: prefixTrailingStopOrder,
+}
+
 func GetClientOrderId(sep, prefix string, short, long, unique int64) string {
 	return fmt.Sprintf("%[2]s%[1]s%[3]d%[1]s%[4]d%[1]s%[5]d", sep, prefix, short, long, unique)
 }
@@ -0,0 +1,38 @@
+package super
+
+import (
+	"github.com/xyths/qtr/executor"
+	"testing"
+)
+
+func TestClientOrderIdPrefix(t *testing.T) {
+	prefixes := []string{
+		prefixBuyMarketOrder, prefixBuyLimitOrder, prefixBuyStopOrder, prefixBuyReinforceOrder,
+		prefixSellMarketOrder, prefixSellLimitOrder, prefixSellStopOrder, prefixSellReinforceOrder,
+		prefixTakeProfitOrder, prefixTrailingStopOrder,
+		prefixShortSellOrder, prefixShortBuyOrder,
+		prefixFuturesLongOrder, prefixFuturesShortOrder,
+	}
+	codec := executor.NewClientIdCodec("gate", "supertrend", "btc_usdt")
+	actions := make(map[string]string)
+	for _, prefix := range prefixes {
+		s, err := codec.EncodePrefix(prefix, 1)
+		if err != nil {
+			t.Errorf("encode prefix %s error: %s", prefix, err)
+			continue
+		}
+		id, err := executor.ParseClientId(s)
+		if err != nil {
+			t.Errorf("parse %s error: %s", s, err)
+			continue
+		}
+		if !id.Belongs("supertrend", "btc_usdt") {
+			t.Errorf("%s should belong to supertrend/btc_usdt", s)
+		}
+		action := string([]byte{id.Side, byte(id.Intent)})
+		if other, ok := actions[action]; ok {
+			t.Errorf("prefix %s and %s have the same side and intent %s", other, prefix, action)
+		}
+		actions[action] = prefix
+	}
+}
@@ -0,0 +1,48 @@
+package super
+
+import (
+	"context"
+	"github.com/xyths/hs"
+	"github.com/xyths/qtr/exchange"
+	"github.com/xyths/qtr/executor"
+	"go.uber.org/zap"
+	"log"
+)
+
+// newFuturesTrader create the futures trader if futures is configured, it returns nil if not.
+// The key of the spot exchange is used, and the futures exchange is the spot one by default.
+func newFuturesTrader(ctx context.Context, conf *executor.FuturesConf, ex hs.ExchangeConf, sugar *zap.SugaredLogger) (*executor.FuturesTrader, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	c := *conf
+	if c.Exchange == "" {
+		c.Exchange = ex.Name
+	}
+	fex, err := executor.NewFuturesExchange(c, ex.Key, ex.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return executor.NewFuturesTrader(ctx, c, fex, sugar)
+}
+
+func printFutures(p exchange.FuturesPosition) {
+	log.Printf(`Futures position
+	Contract: %s
+	Size: %d
+	Leverage: %d (%s)
+	Entry Price: %s
+	Mark Price: %s
+	Liquidation Price: %s
+	Margin: %s
+	Unrealised PnL: %s`,
+		p.Contract,
+		p.Size,
+		p.Leverage, p.MarginMode,
+		p.EntryPrice,
+		p.MarkPrice,
+		p.LiqPrice,
+		p.Margin,
+		p.UnrealisedPnl,
+	)
+}
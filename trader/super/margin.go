@@ -0,0 +1,94 @@
+package super
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xyths/hs/exchange"
+	"github.com/xyths/hs/exchange/huobi"
+	"github.com/xyths/qtr/executor"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"log"
+	"time"
+)
+
+// check the leverage and liquidation price of the short position
+const shortCheckInterval = time.Minute
+
+// newMarginShort create the short side if margin is configured, it returns nil if not.
+// Only huobi is supported, and not in paper mode.
+func newMarginShort(ctx context.Context, conf *executor.MarginConf, ex interface{}, symbol exchange.Symbol,
+	coll *mongo.Collection, sugar *zap.SugaredLogger) (*executor.MarginShort, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	c, ok := ex.(*huobi.Client)
+	if !ok {
+		return nil, errors.New("margin short is only supported on huobi, and not in paper mode")
+	}
+	m, err := executor.NewHuobiMargin(c, conf.Mode, symbol.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	short, err := executor.NewMarginShort(*conf, m, symbol, coll, sugar)
+	if err != nil {
+		return nil, err
+	}
+	if err := short.Load(ctx); err != nil {
+		return nil, err
+	}
+	if p := short.Position(); p.Active() {
+		sugar.Infof("loaded short position, loan id: %d, borrowed: %s, price: %s", p.LoanId, p.Borrowed, p.Price)
+	}
+	return short, nil
+}
+
+// checkShort update the short position, close it when the leverage exceeds the limit.
+// It's called in the trader's own loop (or with the trader's lock), so it never races with the trend handler.
+func checkShort(ctx context.Context, short *executor.MarginShort, ex exchange.RestAPIExchange, symbol string,
+	sugar *zap.SugaredLogger, closeShort func(price decimal.Decimal, reason string)) {
+	if !short.Position().Active() {
+		return
+	}
+	price, err := ex.LastPrice(symbol)
+	if err != nil {
+		sugar.Errorf("get last price error: %s", err)
+		return
+	}
+	over, err := short.Update(ctx, price)
+	if err != nil {
+		sugar.Errorf("update short position error: %s", err)
+		return
+	}
+	if over {
+		p := short.Position()
+		closeShort(price, fmt.Sprintf("杠杆 %s 超过上限 %.2f，强平价格: %s", p.Leverage, short.MaxLeverage(), p.Liquidation))
+	}
+}
+
+func printShort(p executor.ShortPosition) {
+	if !p.Active() {
+		log.Print("Short position: none")
+		return
+	}
+	log.Printf(`Short position
+	Loan Id: %d
+	Borrowed: %s
+	Price: %s
+	Income: %s
+	Interest: %s
+	Leverage: %s
+	Liquidation Price: %s
+	Opened: %s`,
+		p.LoanId,
+		p.Borrowed,
+		p.Price,
+		p.Income,
+		p.Interest,
+		p.Leverage,
+		p.Liquidation,
+		p.Opened,
+	)
+}
@@ -0,0 +1,183 @@
+package super
+
+import (
+	"context"
+	"errors"
+	"github.com/xyths/hs"
+	"log"
+	"sync"
+)
+
+// MultiRestTrader run the RESTful SuperTrend on all symbols in config.
+// the logger, database, exchange connection and robots are shared,
+// every symbol has its own state namespace, candles and quota (Total in config is for each symbol).
+type MultiRestTrader struct {
+	BaseTrader
+	traders []*RestTrader
+}
+
+func NewMultiRestTraderFromConfig(ctx context.Context, cfg Config) (*MultiRestTrader, error) {
+	if len(cfg.Exchange.Symbols) == 0 {
+		return nil, errors.New("no symbol in config")
+	}
+	if len(cfg.Exchange.Symbols) > 1 && cfg.Strategy.Name == "" {
+		cfg.Strategy.Name = defaultStrategyName
+	}
+	b, err := NewBaseTraderFromConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiRestTrader{BaseTrader: *b}, nil
+}
+
+func (m *MultiRestTrader) Init(ctx context.Context) error {
+	if err := m.initShared(ctx); err != nil {
+		return err
+	}
+	for _, symbol := range m.config.Exchange.Symbols {
+		t, err := NewRestTraderFromConfig(ctx, m.config)
+		if err != nil {
+			return err
+		}
+		namespace := ""
+		if len(m.config.Exchange.Symbols) > 1 {
+			namespace = symbol
+		}
+		if err := t.share(&m.BaseTrader, symbol, namespace); err != nil {
+			return err
+		}
+		if err := t.initTrader(ctx); err != nil {
+			return err
+		}
+		m.traders = append(m.traders, t)
+	}
+	m.Sugar.Infof("Multiple SuperTrend Trader initialized, symbols: %v", m.config.Exchange.Symbols)
+	return nil
+}
+
+func (m *MultiRestTrader) Close(ctx context.Context) error {
+	for _, t := range m.traders {
+		_ = t.Close(ctx)
+	}
+	if m.db != nil {
+		_ = m.db.Client().Disconnect(ctx)
+	}
+	if m.Sugar != nil {
+		m.Sugar.Info("Multiple SuperTrend Trader stopped")
+		return m.Sugar.Sync()
+	}
+	return nil
+}
+
+func (m *MultiRestTrader) Start(ctx context.Context) {
+	if m.paper != nil {
+		go m.paper.Start(ctx)
+	}
+	wg := sync.WaitGroup{}
+	for _, t := range m.traders {
+		wg.Add(1)
+		go func(t *RestTrader) {
+			defer wg.Done()
+			t.Start(ctx)
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (m *MultiRestTrader) Print(ctx context.Context) error {
+	for _, t := range m.traders {
+		log.Printf("Symbol: %s", t.Symbol())
+		if err := t.Print(ctx); err != nil {
+			return err
+		}
+	}
+	if m.paper != nil {
+		log.Print(m.paper.Summary())
+	}
+	return nil
+}
+
+func (m *MultiRestTrader) Clear(ctx context.Context) error {
+	for _, t := range m.traders {
+		if err := t.Clear(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MultiWsTrader run the websocket SuperTrend on all symbols in config, like MultiRestTrader
+type MultiWsTrader struct {
+	WsTrader
+	traders []*WsTrader
+}
+
+func NewMultiWsTrader(ctx context.Context, configFilename string) (*MultiWsTrader, error) {
+	cfg := Config{}
+	if err := hs.ParseJsonConfig(configFilename, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Exchange.Symbols) == 0 {
+		return nil, errors.New("no symbol in config")
+	}
+	m := &MultiWsTrader{WsTrader: *newWsTrader(cfg)}
+	err := m.Init(ctx)
+	return m, err
+}
+
+func (m *MultiWsTrader) Init(ctx context.Context) error {
+	if err := m.initShared(ctx); err != nil {
+		return err
+	}
+	for _, symbol := range m.config.Exchange.Symbols {
+		t := newWsTrader(m.config)
+		namespace := ""
+		if len(m.config.Exchange.Symbols) > 1 {
+			namespace = symbol
+		}
+		if err := t.share(&m.WsTrader, symbol, namespace); err != nil {
+			return err
+		}
+		m.traders = append(m.traders, t)
+	}
+	m.Sugar.Infof("Multiple SuperTrendTrader initialized, symbols: %v", m.config.Exchange.Symbols)
+	return nil
+}
+
+func (m *MultiWsTrader) Close(ctx context.Context) {
+	for _, t := range m.traders {
+		t.Close(ctx)
+	}
+	m.WsTrader.Close(ctx)
+}
+
+func (m *MultiWsTrader) Start(ctx context.Context, dry bool) {
+	for _, t := range m.traders {
+		t.Start(ctx, dry)
+	}
+}
+
+func (m *MultiWsTrader) Stop() {
+	for _, t := range m.traders {
+		t.Stop()
+	}
+}
+
+func (m *MultiWsTrader) Print(ctx context.Context) error {
+	for _, t := range m.traders {
+		log.Printf("Symbol: %s", t.Symbol())
+		if err := t.Print(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MultiWsTrader) Clear(ctx context.Context) error {
+	for _, t := range m.traders {
+		if err := t.Clear(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
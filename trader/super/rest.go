@@ -2,11 +2,14 @@ package super
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/markcheno/go-talib"
 	"github.com/shopspring/decimal"
 	indicator "github.com/xyths/go-indicators"
 	"github.com/xyths/hs"
 	"github.com/xyths/hs/exchange"
+	"github.com/xyths/qtr/executor"
 	"github.com/xyths/qtr/types"
 	"log"
 	"math"
@@ -21,6 +24,7 @@ type RestTrader struct {
 	uniqueId   int64
 	position   int64 // 1 long (full), -1 short (clear)
 	trend      int64 // 1: long, -1 short
+	exited     bool  // the position is closed by a conditional order, no re-entry until the trend flips up
 	LongTimes  int64
 	ShortTimes int64
 
@@ -29,6 +33,22 @@ type RestTrader struct {
 	sellStopOrderId      uint64
 	reinforceBuyOrderId  uint64
 	reinforceSellOrderId uint64
+
+	conditional executor.ConditionalEngine
+	triggered   chan triggered // results of the conditional orders, handled in the trader loop
+	ledger      *executor.Ledger
+	reconcile   time.Duration // ledger reconcile interval
+	margin      *executor.MarginShort
+	futures     *executor.FuturesTrader
+}
+
+const conditionalInterval = time.Second * 10
+
+// triggered is the result of a fired conditional order
+type triggered struct {
+	order    executor.ConditionalOrder
+	progress executor.Progress
+	err      error
 }
 
 func NewRestTraderFromConfig(ctx context.Context, cfg Config) (*RestTrader, error) {
@@ -38,6 +58,7 @@ func NewRestTraderFromConfig(ctx context.Context, cfg Config) (*RestTrader, erro
 	}
 	s := &RestTrader{
 		BaseTrader: *b,
+		triggered:  make(chan triggered, 10),
 	}
 	//err = s.Init(ctx)
 	return s, err
@@ -47,12 +68,77 @@ func (t *RestTrader) Init(ctx context.Context) error {
 	if err := t.BaseTrader.Init(ctx); err != nil {
 		return err
 	}
+	return t.initTrader(ctx)
+}
+
+// initTrader init the symbol related parts
+func (t *RestTrader) initTrader(ctx context.Context) error {
 	//t.candle = hs.NewCandle(2000)
+	algo, err := t.newAlgorithm()
+	if err != nil {
+		return err
+	}
+	t.conditional.Init(t.ex, t.symbol, t.Sugar, t.coll(collNameConditional), algo, t.onTriggered)
+	if err := t.conditional.Load(ctx); err != nil {
+		return err
+	}
+	if err := t.initLedger(ctx); err != nil {
+		return err
+	}
+	margin, err := newMarginShort(ctx, t.config.Strategy.Margin, t.ex, t.symbol, t.coll(collNameState), t.Sugar)
+	if err != nil {
+		return err
+	}
+	t.margin = margin
+	futures, err := newFuturesTrader(ctx, t.config.Strategy.Futures, t.config.Exchange, t.Sugar)
+	if err != nil {
+		return err
+	}
+	if futures != nil && (margin != nil || t.paper != nil) {
+		return errors.New("futures can not be used with margin or in paper mode")
+	}
+	t.futures = futures
 	t.Sugar.Info("Rest SuperTrend Trader initialized")
 	return nil
 }
 
+func (t *RestTrader) initLedger(ctx context.Context) error {
+	conf := t.config.Ledger
+	if conf == nil {
+		return nil
+	}
+	interval, err := conf.ReconcileInterval()
+	if err != nil {
+		return err
+	}
+	ledger, err := executor.NewLedger(ctx, *conf, t.Sugar)
+	if err != nil {
+		return err
+	}
+	if err := ledger.Allocate(ctx, t.allocation(), t.QuoteCurrency(), t.maxTotal); err != nil {
+		return err
+	}
+	t.ledger = ledger
+	t.reconcile = interval
+	t.Sugar.Infof("Ledger initialized, allocation: %s, limit: %s", t.allocation(), t.maxTotal)
+	return nil
+}
+
+// allocation is the ledger allocation name, every symbol has its own allocation
+func (t *RestTrader) allocation() string {
+	if t.namespace == "" {
+		return t.config.Ledger.Name
+	}
+	return t.config.Ledger.Name + "-" + t.namespace
+}
+
 func (t *RestTrader) Close(ctx context.Context) error {
+	if t.ledger != nil {
+		t.ledger.Close(ctx)
+	}
+	if t.shared {
+		return nil
+	}
 	if t.db != nil {
 		_ = t.db.Client().Disconnect(ctx)
 	}
@@ -75,6 +161,33 @@ func (t *RestTrader) Print(ctx context.Context) error {
 		t.LongTimes,
 		t.ShortTimes,
 	)
+	if t.paper != nil && !t.shared {
+		log.Print(t.paper.Summary())
+	}
+	for _, o := range t.conditional.Pending() {
+		log.Printf(`Conditional order
+	Id: %s / %s
+	Type: %s
+	Direction: %s
+	Trigger Price: %s
+	Amount: %s`,
+			o.Id, o.Group,
+			o.Type,
+			o.Direction,
+			o.Price,
+			o.Amount,
+		)
+	}
+	if t.margin != nil {
+		printShort(t.margin.Position())
+	}
+	if t.futures != nil {
+		if p, err := t.futures.Position(ctx); err != nil {
+			log.Printf("get futures position error: %s", err)
+		} else {
+			printFutures(p)
+		}
+	}
 	//log.Printf(`Sell-stop order
 	//Id: %d / %t
 	//Price: %t
@@ -91,12 +204,25 @@ func (t *RestTrader) Print(ctx context.Context) error {
 
 func (t *RestTrader) Clear(ctx context.Context) error {
 	t.clearState(ctx)
-	return nil
+	return t.conditional.CancelAll(ctx)
 }
 
 func (t *RestTrader) Start(ctx context.Context) {
 	t.loadState(ctx)
 	t.checkState(ctx)
+	go t.conditional.Start(ctx, conditionalInterval)
+	if t.paper != nil && !t.shared {
+		go t.paper.Start(ctx)
+	}
+	if t.ledger != nil {
+		go t.ledger.Start(ctx, t.ex, t.reconcile)
+	}
+	var shortC <-chan time.Time
+	if t.margin != nil {
+		ticker := time.NewTicker(shortCheckInterval)
+		defer ticker.Stop()
+		shortC = ticker.C
+	}
 
 	t.doWork(ctx)
 	wakeTime := time.Now()
@@ -117,6 +243,12 @@ func (t *RestTrader) Start(ctx context.Context) {
 		case <-ctx.Done():
 			t.Sugar.Info(ctx.Err())
 			return
+		case r := <-t.triggered:
+			t.handleTriggered(r)
+			sleepTime = time.Until(wakeTime)
+		case <-shortC:
+			checkShort(ctx, t.margin, t.ex, t.Symbol(), t.Sugar, t.closeShort)
+			sleepTime = time.Until(wakeTime)
 		case <-time.After(sleepTime):
 			t.doWork(ctx)
 			wakeTime = wakeTime.Add(t.interval)
@@ -131,7 +263,13 @@ func (t *RestTrader) Start(ctx context.Context) {
 // 2. check candle state
 // 3. buy or sell (market price)
 func (t *RestTrader) doWork(ctx context.Context) {
-	candle, err := t.ex.CandleBySize(t.Symbol(), t.interval, 2000)
+	var candle hs.Candle
+	var err error
+	if t.futures != nil {
+		candle, err = t.futures.Exchange().Candle(ctx, t.futures.Contract().Name, t.interval, 2000)
+	} else {
+		candle, err = t.ex.CandleBySize(t.Symbol(), t.interval, 2000)
+	}
 	if err != nil {
 		t.Sugar.Errorf("get candle error: %s", err)
 		return
@@ -168,14 +306,18 @@ func (t *RestTrader) onTick(c hs.Candle, dry bool) {
 		t.Sugar.Infof("in sell channel, upper band price is %s, position is %d", stop, t.position)
 	}
 
-	if trend[l-2] && (!trend[l-3] || t.position != 1) {
+	switch superSignal(trend[l-3], trend[l-2], t.position, t.exited) {
+	case 1:
 		// false -> true, buy/long
 		t.Sugar.Info("[Signal] BUY")
 		t.trend = 1
+		if t.exited {
+			t.SetExited(false)
+		}
 		if !dry {
 			t.Long(price, stop)
 		}
-	} else if !trend[l-2] && trend[l-3] {
+	case -1:
 		// true -> false, sell/short
 		t.Sugar.Info("[Signal] SELL")
 		t.trend = -1
@@ -183,15 +325,29 @@ func (t *RestTrader) onTick(c hs.Candle, dry bool) {
 		if !dry {
 			t.Short(price, stop)
 		}
-	} else if t.StopLoss && t.position == 1 {
-		// update sell-stop-limit order
-		//go t.updateSellStop(stop, false)
+	default:
+		if t.position == 1 {
+			// update emulated stop-loss order
+			t.updateStop(stop)
+		} else if t.exited && trend[l-2] {
+			t.Sugar.Info("exited by conditional order, wait for the next up-flip")
+		}
+	}
+	if t.config.Strategy.TrailAtr > 0 {
+		atr := talib.Atr(c.High, c.Low, c.Close, t.Period)
+		t.conditional.UpdateAtr(context.Background(), decimal.NewFromFloat(atr[l-2]))
 	}
 }
 
 // Long buy maxTotal amount coin at market price
 func (t *RestTrader) Long(price, stop decimal.Decimal) {
+	if t.futures != nil {
+		t.futuresTarget(price, 1)
+		return
+	}
+	t.closeShort(price, "趋势转多")
 	t.MarketBuyAll(price)
+	t.placeConditional(price, stop)
 	if t.Reinforce > 0 {
 		// place reinforce order
 		p := decimal.NewFromInt(1).Sub(t.fee.ActualMaker.Mul(decimal.NewFromFloat(2 + t.Reinforce)))
@@ -216,120 +372,100 @@ func (t *RestTrader) MarketBuyAll(price decimal.Decimal) {
 	if maxTotal.GreaterThan(t.maxTotal) {
 		maxTotal = t.maxTotal
 	}
-	clientId := GetClientOrderId(sep, prefixBuyLimitOrder, t.ShortTimes, t.LongTimes+1, t.GetUniqueId())
-	switch t.config.Exchange.Name {
-	case "gate":
-		t.smoothBuy(t.symbol, clientId, maxTotal)
-	default:
-		total := maxTotal
-		orderId, err := t.ex.BuyMarket(t.symbol, clientId, total)
+	clientId, err := t.clientOrderId(prefixBuyLimitOrder)
+	if err != nil {
+		t.Sugar.Errorf("encode client order id error: %s", err)
+		return
+	}
+	if t.ledger != nil {
+		name := t.allocation()
+		available, err := t.ledger.Available(context.Background(), name)
 		if err != nil {
-			t.Sugar.Errorf("sell error: %s", err)
+			t.Sugar.Errorf("get available capital error: %s", err)
 			return
 		}
-		t.Sugar.Infof("市价买入，订单号: %d / %s, total: %s", orderId, clientId, total)
-		t.Broadcast("市价买入，订单号: %d / %s, 买入总金额: %s", orderId, clientId, total)
+		if maxTotal.GreaterThan(available) {
+			maxTotal = available
+		}
+		if err := t.ledger.Reserve(context.Background(), name, clientId, t.Symbol(), maxTotal); err != nil {
+			t.Sugar.Errorf("reserve capital error: %s", err)
+			return
+		}
+	}
+	t.Sugar.Infof("市价买入，订单号: %s, total: %s", clientId, maxTotal)
+	p, err := t.execute(executor.Job{Direction: exchange.TradeDirectionBuy, ClientOrderId: clientId, Total: maxTotal})
+	// settle with the filled part, the rest of the reservation is released
+	if t.ledger != nil {
+		if err := t.ledger.Settle(context.Background(), t.allocation(), clientId, p.FilledTotal); err != nil {
+			t.Sugar.Errorf("settle capital error: %s", err)
+		}
+	}
+	if err != nil || p.FilledAmount.IsZero() {
+		t.Sugar.Errorf("buy job %s not filled, error: %v", clientId, err)
+		return
 	}
 
 	t.SetPosition(1)
 	t.LongTimes++
-	if err := hs.SaveInt64(context.Background(), t.db.Collection(collNameState), "longTimes", t.LongTimes); err != nil {
+	if err := hs.SaveInt64(context.Background(), t.coll(collNameState), "longTimes", t.LongTimes); err != nil {
 		t.Sugar.Infof("save longTimes error: %s", err)
 	}
 }
 
-// 专为Gate优化的市价买入策略，因为Gate不支持市价单，所以从Ticker获得的最新价，不一定能成交，尤其是极端行情
-// 解决办法是：下单后等待20s，再次下单（20s才能更新一次Ticker）
-func (t *RestTrader) smoothBuy(symbol exchange.Symbol, clientId string, total decimal.Decimal) {
-	left := total
-	i := 0
-	for left.IsPositive() {
-		lastPrice, err := t.ex.LastPrice(symbol.Symbol)
-		if err != nil {
-			t.Sugar.Errorf("get last price error: %s", err)
-			continue
-		}
-		price := lastPrice.Round(t.PricePrecision())
-		amount := left.DivRound(price, t.AmountPrecision())
-		realTotal := price.Mul(amount)
-		for realTotal.GreaterThan(total) {
-			amount = amount.Add(t.MinAmount().Neg())
-			realTotal = price.Mul(amount)
-		}
-		orderId, err := t.ex.BuyLimit(t.Symbol(), fmt.Sprintf("%s-%d", clientId, i), price, amount)
-		i++
-		if err != nil {
-			t.Sugar.Errorf("buy error: %s", err)
-			return
-		}
+func (t *RestTrader) newAlgorithm() (executor.Algorithm, error) {
+	conf := t.config.Strategy.Algo
+	// Gate不支持市价单，默认使用追单算法
+	if conf.Name == "" && t.config.Exchange.Name == "gate" {
+		conf.Name = executor.AlgoChase
+	}
+	return executor.NewAlgorithm(conf, t.ex, t.symbol, t.Sugar, t.onProgress)
+}
 
-		t.Sugar.Infof("市价买入，订单号: %d / %s, total: %s", orderId, clientId, left)
-		// check order
-		time.Sleep(time.Second * 20)
-		o2, err := t.ex.GetOrderById(orderId, t.Symbol())
-		if o2.FilledAmount.IsPositive() {
-			// 成交或部分成交
-			t.Broadcast("市价买入，订单号: %d / %s\n\t下单价格: %s, 下单数量: %s\n\t成交价格: %s, 成交数量: %s\n\t下单总金额: %s, 成交总金额: %s",
-				orderId, o2.ClientOrderId,
-				o2.Price, o2.Amount,
-				o2.FilledPrice, o2.FilledAmount,
-				o2.Price.Mul(o2.Amount), o2.FilledPrice.Mul(o2.FilledAmount),
-			)
-		}
-		if o2.FilledAmount.Equal(o2.Amount) {
-			t.Sugar.Info("buy order full-filled")
-			break
-		}
-		if err := t.ex.CancelOrder(t.Symbol(), orderId); err != nil {
-			t.Sugar.Errorf("cancel order error: %s", err)
-			continue
-		}
-		left = left.Sub(o2.FilledPrice.Mul(o2.FilledAmount))
+// execute the job by the configured algorithm, the returned progress is valid even if err is not nil
+func (t *RestTrader) execute(job executor.Job) (executor.Progress, error) {
+	algo, err := t.newAlgorithm()
+	if err != nil {
+		t.Sugar.Errorf("create algorithm error: %s", err)
+		return executor.Progress{Job: job}, err
+	}
+	p, err := algo.Execute(context.Background(), job)
+	if err != nil {
+		t.Sugar.Errorf("execute %s job %s error: %s", job.Direction, job.ClientOrderId, err)
 	}
+	return p, err
 }
 
-// 专为Gate优化的市价买出策略，因为Gate不支持市价单，所以从Ticker获得的最新价，不一定能成交，尤其是极端行情
-// 解决办法是：下单后等待20s，再次下单（20s才能更新一次Ticker）
-func (t *RestTrader) smoothSell(symbol exchange.Symbol, clientId string, amount decimal.Decimal) {
-	left := amount
-	i := 0
-	for left.IsPositive() {
-		lastPrice, err := t.ex.LastPrice(symbol.Symbol)
-		if err != nil {
-			t.Sugar.Errorf("get last price error: %s", err)
-			continue
-		}
-		price := lastPrice.Round(t.PricePrecision())
-		text := fmt.Sprintf("%s-%d", clientId, i)
-		orderId, err := t.ex.SellLimit(t.Symbol(), text, price, left)
-		i++
-		if err != nil {
-			t.Sugar.Errorf("sell error: %s", err)
-			return
-		}
+// credit the sell income to the shared ledger
+func (t *RestTrader) credit(income decimal.Decimal) {
+	if t.ledger == nil {
+		return
+	}
+	if err := t.ledger.Credit(context.Background(), t.allocation(), income); err != nil {
+		t.Sugar.Errorf("credit capital error: %s", err)
+	}
+}
 
-		t.Sugar.Infof("尝试市价清仓，订单号: %d / %s, amount: %s", orderId, text, left)
-		// check order
-		time.Sleep(time.Second * 20)
-		o2, err := t.ex.GetOrderById(orderId, t.Symbol())
-		if o2.FilledAmount.IsPositive() {
-			// 成交或部分成交
-			t.Broadcast("市价清仓成交，订单号: %d / %s\n 下单价格: %s, 下单数量: %s\n 成交价格: %s, 成交数量: %s\n 下单总金额: %s, 成交总金额: %s",
-				orderId, o2.ClientOrderId,
-				o2.Price, o2.FilledPrice,
-				o2.Amount, o2.FilledAmount,
-				o2.Price.Mul(o2.Amount), o2.FilledPrice.Mul(o2.FilledAmount),
-			)
-		}
-		if o2.FilledAmount.Equal(o2.Amount) {
-			t.Sugar.Info("sell order full-filled")
-			break
-		}
-		if err := t.ex.CancelOrder(t.Symbol(), orderId); err != nil {
-			t.Sugar.Errorf("cancel order error: %s", err)
-			continue
-		}
-		left = left.Sub(o2.FilledAmount)
+func (t *RestTrader) onProgress(p executor.Progress) {
+	action := "买入"
+	if p.Job.Direction == exchange.TradeDirectionSell {
+		action = "卖出"
+	}
+	if p.Done {
+		t.Sugar.Infof("%s完成，订单号: %s, 子订单数: %d, 成交数量: %s, 成交总金额: %s",
+			action, p.Job.ClientOrderId, p.Orders, p.FilledAmount, p.FilledTotal)
+		t.Broadcast("%s完成，订单号: %s\n\t子订单数: %d\n\t成交数量: %s, 成交总金额: %s",
+			action, p.Job.ClientOrderId, p.Orders, p.FilledAmount, p.FilledTotal)
+		return
+	}
+	o := p.Order
+	if o.FilledAmount.IsPositive() {
+		// 成交或部分成交
+		t.Broadcast("%s成交，订单号: %d / %s\n\t下单价格: %s, 下单数量: %s\n\t成交价格: %s, 成交数量: %s\n\t下单总金额: %s, 成交总金额: %s",
+			action, o.Id, o.ClientOrderId,
+			o.Price, o.Amount,
+			o.FilledPrice, o.FilledAmount,
+			o.Price.Mul(o.Amount), o.FilledPrice.Mul(o.FilledAmount),
+		)
 	}
 }
 
@@ -357,14 +493,18 @@ func (t *RestTrader) reinforceBuy(price decimal.Decimal) {
 		t.Sugar.Infof("amount (%s / %s) is too small", amount, t.MinAmount())
 		return
 	}
-	clientId := GetClientOrderId(sep, prefixBuyReinforceOrder, t.ShortTimes, t.LongTimes+1, t.GetUniqueId())
+	clientId, err := t.clientOrderId(prefixBuyReinforceOrder)
+	if err != nil {
+		t.Sugar.Errorf("encode client order id error: %s", err)
+		return
+	}
 	orderId, err := t.ex.BuyLimit(t.Symbol(), clientId, price, amount)
 	if err != nil {
 		t.Sugar.Errorf("buy error: %s", err)
 		return
 	}
 	t.reinforceBuyOrderId = orderId
-	coll := t.db.Collection(collNameState)
+	coll := t.coll(collNameState)
 	if err := hs.SaveKey(context.Background(), coll, "reinforceBuyOrderId", t.reinforceBuyOrderId); err != nil {
 		t.Sugar.Errorf("save reinforceOrder error: %s", err)
 	}
@@ -382,7 +522,7 @@ func (t *RestTrader) realCancelReinforce(key string, orderId *uint64) error {
 
 	t.Sugar.Infof("cancelled %s %d", key, *orderId)
 	*orderId = 0
-	coll := t.db.Collection(collNameState)
+	coll := t.coll(collNameState)
 	if err := hs.SaveKey(context.Background(), coll, key, *orderId); err != nil {
 		return err
 	}
@@ -391,14 +531,194 @@ func (t *RestTrader) realCancelReinforce(key string, orderId *uint64) error {
 
 // Short sell all coins at market price
 func (t *RestTrader) Short(price, stop decimal.Decimal) {
-	if t.StopLoss {
-		t.cancelSellStop()
+	if t.futures != nil {
+		t.futuresTarget(price, -1)
+		return
+	}
+	if err := t.conditional.CancelAll(context.Background()); err != nil {
+		t.Sugar.Errorf("cancel conditional orders error: %s", err)
 	}
 	if t.Reinforce > 0 {
 		// cancel reinforce order
 		t.cancelReinforce()
 	}
 	t.MarketSellAll()
+	t.openShort(price)
+}
+
+// openShort borrow and sell the base currency on the margin account
+func (t *RestTrader) openShort(price decimal.Decimal) {
+	if t.margin == nil {
+		return
+	}
+	clientId, err := t.clientOrderId(prefixShortSellOrder)
+	if err != nil {
+		t.Sugar.Errorf("encode client order id error: %s", err)
+		return
+	}
+	if err := t.margin.Open(context.Background(), price, t.maxTotal, clientId); err != nil {
+		t.Sugar.Errorf("open short error: %s", err)
+		t.Broadcast("借币卖空失败，订单号: %s，错误: %s", clientId, err)
+		return
+	}
+	p := t.margin.Position()
+	t.Broadcast("借币卖空，订单号: %s\n\t借入数量: %s, 卖出均价: %s, 卖出总金额: %s\n\t杠杆: %s, 强平价格: %s",
+		clientId, p.Borrowed, p.Price, p.Income, p.Leverage, p.Liquidation)
+}
+
+// closeShort buy back and repay the loan, if there is a short position
+func (t *RestTrader) closeShort(price decimal.Decimal, reason string) {
+	if t.margin == nil || !t.margin.Position().Active() {
+		return
+	}
+	clientId, err := t.clientOrderId(prefixShortBuyOrder)
+	if err != nil {
+		t.Sugar.Errorf("encode client order id error: %s", err)
+		return
+	}
+	profit, err := t.margin.Close(context.Background(), price, clientId)
+	if err != nil {
+		t.Sugar.Errorf("close short error: %s", err)
+		t.Broadcast("平空失败（%s），订单号: %s，错误: %s", reason, clientId, err)
+		return
+	}
+	t.Broadcast("平空还币（%s），订单号: %s，收益: %s %s", reason, clientId, profit, t.QuoteCurrency())
+}
+
+// futuresTarget hold the full long (direction 1) or short (direction -1) position of the contract
+func (t *RestTrader) futuresTarget(price decimal.Decimal, direction int64) {
+	if t.position == direction {
+		t.Sugar.Infof("futures position is already %d", direction)
+		return
+	}
+	prefix, action := prefixFuturesLongOrder, "做多"
+	if direction < 0 {
+		prefix, action = prefixFuturesShortOrder, "做空"
+	}
+	size := t.futures.Size(t.maxTotal, price)
+	clientId, err := t.clientOrderId(prefix)
+	if err != nil {
+		t.Sugar.Errorf("encode client order id error: %s", err)
+		return
+	}
+	t.Sugar.Infof("合约%s，订单号: %s, size: %d", action, clientId, direction*size)
+	p, err := t.futures.Target(context.Background(), direction*size, clientId)
+	if err != nil {
+		t.Sugar.Errorf("futures %s error: %s", action, err)
+		t.Broadcast("合约%s失败，订单号: %s，错误: %s", action, clientId, err)
+		return
+	}
+	t.Broadcast("合约%s，订单号: %s\n\t合约: %s, 持仓: %d 张\n\t开仓均价: %s, 强平价格: %s",
+		action, clientId, p.Contract, p.Size, p.EntryPrice, p.LiqPrice)
+
+	t.SetPosition(direction)
+	key, times := "longTimes", &t.LongTimes
+	if direction < 0 {
+		key, times = "shortTimes", &t.ShortTimes
+	}
+	*times++
+	if err := hs.SaveInt64(context.Background(), t.coll(collNameState), key, *times); err != nil {
+		t.Sugar.Infof("save %s error: %s", key, err)
+	}
+}
+
+// placeConditional place emulated stop-loss, take-profit and trailing-stop as an OCO group
+func (t *RestTrader) placeConditional(price, stop decimal.Decimal) {
+	if !t.StopLoss && t.config.Strategy.TakeProfit <= 0 && t.config.Strategy.TrailPercent <= 0 && t.config.Strategy.TrailAtr <= 0 {
+		return
+	}
+	balance, err := t.ex.SpotAvailableBalance()
+	if err != nil {
+		t.Sugar.Errorf("get available balance error: %s", err)
+		return
+	}
+	amount := balance[t.BaseCurrency()].Truncate(t.AmountPrecision())
+	if amount.LessThan(t.MinAmount()) {
+		t.Sugar.Infof("amount too small: %s", amount)
+		return
+	}
+	var orders []executor.ConditionalOrder
+	if t.StopLoss {
+		orders = append(orders, executor.ConditionalOrder{
+			Type:      executor.ConditionalStopLoss,
+			Direction: exchange.TradeDirectionSell,
+			Price:     stop,
+			Amount:    amount,
+		})
+	}
+	if t.config.Strategy.TakeProfit > 0 {
+		p := decimal.NewFromFloat(1 + t.config.Strategy.TakeProfit/100)
+		orders = append(orders, executor.ConditionalOrder{
+			Type:      executor.ConditionalTakeProfit,
+			Direction: exchange.TradeDirectionSell,
+			Price:     price.Mul(p).Round(t.PricePrecision()),
+			Amount:    amount,
+		})
+	}
+	if t.config.Strategy.TrailPercent > 0 || t.config.Strategy.TrailAtr > 0 {
+		orders = append(orders, executor.ConditionalOrder{
+			Type:         executor.ConditionalTrailingStop,
+			Direction:    exchange.TradeDirectionSell,
+			Amount:       amount,
+			TrailPercent: t.config.Strategy.TrailPercent,
+			TrailAtr:     t.config.Strategy.TrailAtr,
+			Extreme:      price,
+		})
+	}
+	for i := range orders {
+		if orders[i].Id, err = t.clientOrderId(conditionalPrefix[orders[i].Type]); err != nil {
+			t.Sugar.Errorf("encode client order id error: %s", err)
+			return
+		}
+	}
+	group := fmt.Sprintf("oco%s%d", sep, t.LongTimes)
+	if err := t.conditional.AddOCO(context.Background(), group, orders...); err != nil {
+		t.Sugar.Errorf("add conditional orders error: %s", err)
+		return
+	}
+	t.Broadcast("设置条件单 %d 个，止损价: %s，数量: %s", len(orders), stop, amount)
+}
+
+// updateStop move the emulated stop-loss up to the new SuperTrend stop price
+func (t *RestTrader) updateStop(stop decimal.Decimal) {
+	for _, o := range t.conditional.Pending() {
+		if o.Type != executor.ConditionalStopLoss || !stop.GreaterThan(o.Price) {
+			continue
+		}
+		if err := t.conditional.UpdatePrice(context.Background(), o.Id, stop); err != nil {
+			t.Sugar.Errorf("update stop-loss order error: %s", err)
+			continue
+		}
+		t.Sugar.Infof("stop-loss order %s moved from %s to %s", o.Id, o.Price, stop)
+	}
+}
+
+// onTriggered is called in the conditional engine goroutine, the position is updated in the trader loop
+func (t *RestTrader) onTriggered(o executor.ConditionalOrder, p executor.Progress, err error) {
+	t.triggered <- triggered{order: o, progress: p, err: err}
+}
+
+func (t *RestTrader) handleTriggered(r triggered) {
+	o, p, err := r.order, r.progress, r.err
+	if err != nil {
+		if p.FilledAmount.IsZero() {
+			// the engine restored the order and its OCO group
+			t.Broadcast("条件单触发失败，已恢复，下次触发时重试，类型: %s，订单号: %s，触发价格: %s，错误: %s", o.Type, o.Id, o.Price, err)
+			return
+		}
+		t.Broadcast("条件单触发失败，部分成交，类型: %s，订单号: %s，触发价格: %s，错误: %s", o.Type, o.Id, o.Price, err)
+	}
+	t.Broadcast("条件单触发，类型: %s，订单号: %s，触发价格: %s，成交数量: %s，成交总金额: %s",
+		o.Type, o.Id, o.Price, p.FilledAmount, p.FilledTotal)
+	if o.Direction == exchange.TradeDirectionSell && p.FilledAmount.IsPositive() {
+		t.credit(p.FilledTotal)
+		t.SetPosition(-1)
+		t.SetExited(true)
+		t.ShortTimes++
+		if err := hs.SaveInt64(context.Background(), t.coll(collNameState), "shortTimes", t.ShortTimes); err != nil {
+			t.Sugar.Infof("save shortTimes error: %s", err)
+		}
+	}
 }
 
 func (t *RestTrader) cancelSellStop() {
@@ -442,23 +762,22 @@ func (t *RestTrader) MarketSellAll() {
 		t.SetPosition(-1)
 		return
 	}
-	clientId := GetClientOrderId(sep, prefixSellMarketOrder, t.ShortTimes+1, t.LongTimes, t.GetUniqueId())
-	switch t.config.Exchange.Name {
-	case "gate":
-		t.smoothSell(t.symbol, clientId, amount)
-	default:
-		orderId, err := t.ex.SellMarket(t.symbol, clientId, amount)
-		if err != nil {
-			t.Sugar.Errorf("sell error: %s", err)
-			return
-		}
-		t.Sugar.Infof("市价清仓，订单号: %d / %s, amount: %s", orderId, clientId, amount)
-		t.Broadcast("市价清仓，订单号: %d / %s, 卖出数量: %s", orderId, clientId, amount)
+	clientId, err := t.clientOrderId(prefixSellMarketOrder)
+	if err != nil {
+		t.Sugar.Errorf("encode client order id error: %s", err)
+		return
+	}
+	t.Sugar.Infof("市价清仓，订单号: %s, amount: %s", clientId, amount)
+	p, err := t.execute(executor.Job{Direction: exchange.TradeDirectionSell, ClientOrderId: clientId, Amount: amount})
+	t.credit(p.FilledTotal)
+	if err != nil || p.FilledAmount.IsZero() {
+		t.Sugar.Errorf("sell job %s not filled, error: %v", clientId, err)
+		return
 	}
 
 	t.SetPosition(-1)
 	t.ShortTimes++
-	if err := hs.SaveInt64(context.Background(), t.db.Collection(collNameState), "shortTimes", t.ShortTimes); err != nil {
+	if err := hs.SaveInt64(context.Background(), t.coll(collNameState), "shortTimes", t.ShortTimes); err != nil {
 		t.Sugar.Infof("save shortTimes error: %s", err)
 	}
 }
@@ -468,7 +787,7 @@ func (t *RestTrader) Stop() {
 }
 
 func (t *RestTrader) loadState(ctx context.Context) {
-	coll := t.db.Collection(collNameState)
+	coll := t.coll(collNameState)
 	if uniqueId, err := hs.LoadInt64(ctx, coll, "uniqueId"); err != nil {
 		t.Sugar.Fatalf("load UniqueId error: %t", err)
 	} else if uniqueId != 0 {
@@ -493,6 +812,12 @@ func (t *RestTrader) loadState(ctx context.Context) {
 		t.ShortTimes = shortTimes
 		t.Sugar.Infof("loaded shortTimes: %d", shortTimes)
 	}
+	if exited, err := hs.LoadInt64(ctx, coll, "exited"); err != nil {
+		t.Sugar.Errorf("load exited error: %s", err)
+	} else if exited != 0 {
+		t.exited = true
+		t.Sugar.Info("loaded exited, wait for the next up-flip")
+	}
 	//if t.StopLoss() {
 	//	sellStopOrder := emptySellStopOrder
 	//	if err := hs.LoadKey(ctx, coll, "sellStopOrder", &sellStopOrder); err != nil {
@@ -521,22 +846,41 @@ func (t *RestTrader) loadState(ctx context.Context) {
 }
 
 func (t *RestTrader) GetUniqueId() int64 {
-	t.uniqueId = (t.uniqueId + 1) % 10000
-	coll := t.db.Collection(collNameState)
+	t.uniqueId++
+	coll := t.coll(collNameState)
 	if err := hs.SaveInt64(context.Background(), coll, "uniqueId", t.uniqueId); err != nil {
 		t.Sugar.Errorf("save uniqueId error: %t", err)
 	}
 	return t.uniqueId
 }
+
+// clientOrderId encode the client order id with the next unique id
+func (t *RestTrader) clientOrderId(prefix string) (string, error) {
+	return encodeClientId(t.config, t.Symbol(), prefix, t.GetUniqueId())
+}
+
 func (t *RestTrader) SetPosition(newPosition int64) {
 	t.position = newPosition
-	coll := t.db.Collection(collNameState)
+	coll := t.coll(collNameState)
 	if err := hs.SaveInt64(context.Background(), coll, "position", t.position); err != nil {
 		t.Sugar.Errorf("save position error: %t", err)
 	}
 }
+
+// SetExited save whether the position is closed by a conditional order
+func (t *RestTrader) SetExited(exited bool) {
+	t.exited = exited
+	var v int64
+	if exited {
+		v = 1
+	}
+	if err := hs.SaveInt64(context.Background(), t.coll(collNameState), "exited", v); err != nil {
+		t.Sugar.Errorf("save exited error: %s", err)
+	}
+}
+
 func (t *RestTrader) SetSellStopOrder(newOrderId uint64) {
-	coll := t.db.Collection(collNameState)
+	coll := t.coll(collNameState)
 	t.sellStopOrderId = newOrderId
 	if err := hs.SaveKey(context.Background(), coll, "sellStopOrderId", t.sellStopOrderId); err != nil {
 		t.Sugar.Errorf("save sellStopOrder error: %t", err)
@@ -544,7 +888,7 @@ func (t *RestTrader) SetSellStopOrder(newOrderId uint64) {
 }
 
 func (t *RestTrader) clearState(ctx context.Context) {
-	coll := t.db.Collection(collNameState)
+	coll := t.coll(collNameState)
 	if err := hs.DeleteInt64(ctx, coll, "longTimes"); err != nil {
 		t.Sugar.Errorf("delete longTimes error: %t", err)
 	} else {
@@ -560,6 +904,11 @@ func (t *RestTrader) clearState(ctx context.Context) {
 	} else {
 		t.Sugar.Info("delete sellStopOrder from database")
 	}
+	if err := hs.DeleteInt64(ctx, coll, "exited"); err != nil {
+		t.Sugar.Errorf("delete exited error: %s", err)
+	} else {
+		t.Sugar.Info("delete exited from database")
+	}
 }
 
 func (t *RestTrader) checkState(ctx context.Context) {
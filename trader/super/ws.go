@@ -4,8 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/huobirdcenter/huobi_golang/pkg/model/market"
 	"github.com/huobirdcenter/huobi_golang/pkg/model/order"
+	"github.com/markcheno/go-talib"
 	"github.com/shopspring/decimal"
 	"github.com/xyths/go-indicators"
 	"github.com/xyths/hs"
@@ -15,6 +15,7 @@ import (
 	"github.com/xyths/hs/exchange/huobi"
 	"github.com/xyths/hs/logger"
 	"github.com/xyths/qtr/executor"
+	"github.com/xyths/qtr/hub"
 	"github.com/xyths/qtr/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -28,9 +29,11 @@ import (
 )
 
 type WsTrader struct {
-	config   Config
-	interval time.Duration
-	dry      bool
+	config    Config
+	interval  time.Duration
+	dry       bool
+	namespace string // collection name prefix, empty for single symbol
+	shared    bool   // the database and exchange are owned by the multi-symbol trader
 
 	Sugar  *zap.SugaredLogger
 	db     *mongo.Database
@@ -39,21 +42,36 @@ type WsTrader struct {
 	fee    exchange.Fee
 	robots []broadcast.Broadcaster
 
+	hub      *hub.Hub
+	consumer *hub.Consumer
+
 	maxTotal decimal.Decimal // max total for buy order, half total in config
 
 	candle     hs.Candle
 	uniqueId   int64
 	position   int64 // 1 long (full), -1 short (clear)
 	trend      int64 // 1: long, -1 short
+	exited     bool  // the position is closed by a conditional order, no re-entry until the trend flips up
 	LongTimes  int64
 	ShortTimes int64
 
+	// lock the state changed by the events and the short watcher
+	lock sync.Mutex
+
 	sellStopLock  sync.Mutex
-	sellStopOrder executor.SellStopOrder
+	sellStopOrder executor.SellStopOrder // legacy native sell-stop-limit order, only cancelled
+
+	conditional executor.ConditionalEngine
 
 	reinforceLock      sync.Mutex
 	reinforceBuyOrder  executor.ReinforceOrder
 	reinforceSellOrder executor.ReinforceOrder
+
+	margin *executor.MarginShort
+
+	ledger    *executor.Ledger
+	reconcile time.Duration // ledger reconcile interval
+	account   executor.Account
 }
 
 var (
@@ -67,11 +85,17 @@ func NewWsTrader(ctx context.Context, configFilename string) (*WsTrader, error)
 	if err := hs.ParseJsonConfig(configFilename, &cfg); err != nil {
 		return nil, err
 	}
+	s := newWsTrader(cfg)
+	err := s.Init(ctx)
+	return s, err
+}
+
+func newWsTrader(cfg Config) *WsTrader {
 	interval, err := time.ParseDuration(cfg.Strategy.Interval)
 	if err != nil {
 		logger.Sugar.Fatalf("error interval format: %s", cfg.Strategy.Interval)
 	}
-	s := &WsTrader{
+	return &WsTrader{
 		config:   cfg,
 		interval: interval,
 		maxTotal: decimal.NewFromFloat(cfg.Strategy.Total / 2),
@@ -80,11 +104,21 @@ func NewWsTrader(ctx context.Context, configFilename string) (*WsTrader, error)
 		reinforceBuyOrder:  emptyReinforceBuyOrder,
 		reinforceSellOrder: emptyReinforceSellOrder,
 	}
-	err = s.Init(ctx)
-	return s, err
 }
 
 func (s *WsTrader) Init(ctx context.Context) error {
+	if err := s.initShared(ctx); err != nil {
+		return err
+	}
+	if err := s.initSymbol(s.config.Exchange.Symbols[0]); err != nil {
+		return err
+	}
+	s.Sugar.Info("SuperTrendTrader initialized")
+	return nil
+}
+
+// initShared init the logger, database, exchange connection and robots, they can be shared by all symbols
+func (s *WsTrader) initShared(ctx context.Context) error {
 	if err := s.initLogger(); err != nil {
 		return err
 	}
@@ -93,16 +127,41 @@ func (s *WsTrader) Init(ctx context.Context) error {
 		return err
 	}
 	s.db = db
-	s.candle = hs.NewCandle(2000)
 	if err := s.initEx(); err != nil {
 		return err
 	}
 	s.initRobots(ctx)
-	s.Sugar.Info("SuperTrendTrader initialized")
 	return nil
 }
 
+// share the resources of the base trader, and trade symbol in its own state namespace
+func (s *WsTrader) share(b *WsTrader, symbol, namespace string) error {
+	s.Sugar = b.Sugar.Named(symbol)
+	s.db = b.db
+	s.ex = b.ex
+	s.hub = b.hub
+	s.robots = b.robots
+	s.namespace = namespace
+	s.shared = true
+	return s.initSymbol(symbol)
+}
+
+// clientOrderId encode the client order id with the next unique id
+func (s *WsTrader) clientOrderId(prefix string) (string, error) {
+	return encodeClientId(s.config, s.Symbol(), prefix, s.GetUniqueId())
+}
+
+func (s *WsTrader) coll(name string) *mongo.Collection {
+	return s.db.Collection(executor.CollName(s.namespace, name))
+}
+
 func (s *WsTrader) Close(ctx context.Context) {
+	if s.ledger != nil {
+		s.ledger.Close(ctx)
+	}
+	if s.shared {
+		return
+	}
 	if s.db != nil {
 		_ = s.db.Client().Disconnect(ctx)
 	}
@@ -124,23 +183,30 @@ func (s *WsTrader) Print(ctx context.Context) error {
 		s.LongTimes,
 		s.ShortTimes,
 	)
-	log.Printf(`Sell-stop order
-	Id: %d / %s
-	Price: %s
-	Amount: %s
-	Create Time: %s`,
-		s.sellStopOrder.Id, s.sellStopOrder.ClientOrderId,
-		s.sellStopOrder.Price,
-		s.sellStopOrder.Amount,
-		s.sellStopOrder.Time,
-	)
+	for _, o := range s.conditional.Pending() {
+		log.Printf(`Conditional order
+	Id: %s / %s
+	Type: %s
+	Direction: %s
+	Trigger Price: %s
+	Amount: %s`,
+			o.Id, o.Group,
+			o.Type,
+			o.Direction,
+			o.Price,
+			o.Amount,
+		)
+	}
+	if s.margin != nil {
+		printShort(s.margin.Position())
+	}
 
 	return nil
 }
 
 func (s *WsTrader) Clear(ctx context.Context) error {
 	s.clearState(ctx)
-	return nil
+	return s.conditional.CancelAll(ctx)
 }
 
 func (s *WsTrader) Start(ctx context.Context, dry bool) {
@@ -152,21 +218,51 @@ func (s *WsTrader) Start(ctx context.Context, dry bool) {
 	}
 
 	// setup subscriber
-	s.ex.SubscribeOrder(s.Symbol(), "super-order", s.OrderUpdateHandler)
+	s.consumer = s.hub.Register("super-"+s.Symbol(), hub.DefaultQueueSize, s.eventHandler)
+	s.hub.SubscribeOrder(s.consumer, s.Symbol())
 
 	{
 		candle, err := s.ex.CandleBySize(s.Symbol(), s.interval, 2000)
 		if err != nil {
 			s.Sugar.Fatalf("get candle error: %s", err)
 		}
+		s.lock.Lock()
 		s.candle.Add(candle)
 		s.onTick(s.dry)
+		s.lock.Unlock()
+	}
+	s.hub.SubscribeCandle(s.consumer, s.Symbol(), s.interval)
+	if !s.dry {
+		go s.conditional.Watch(ctx)
+	}
+	if s.margin != nil {
+		go s.watchShort(ctx)
+	}
+	if s.ledger != nil {
+		go s.ledger.Start(ctx, s.ex, s.reconcile)
+	}
+}
+
+// watchShort check the short position periodically, with the lock of the event handler
+func (s *WsTrader) watchShort(ctx context.Context) {
+	ticker := time.NewTicker(shortCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			checkShort(ctx, s.margin, s.ex, s.Symbol(), s.Sugar, s.closeShort)
+			s.lock.Unlock()
+		}
 	}
-	s.ex.SubscribeCandlestick(s.Symbol(), "super-tick", s.interval, s.tickerHandler)
 }
 func (s *WsTrader) Stop() {
-	s.ex.UnsubscribeCandlestick(s.Symbol(), "super-tick", s.interval)
-	s.ex.UnsubscribeOrder(s.Symbol(), "super-order")
+	if s.consumer != nil {
+		s.hub.Unregister(s.consumer)
+		s.consumer = nil
+	}
 }
 
 func (s *WsTrader) initLogger() error {
@@ -179,18 +275,35 @@ func (s *WsTrader) initLogger() error {
 	return nil
 }
 
-func (s *WsTrader) initEx() error {
+func (s *WsTrader) initEx() (err error) {
 	switch s.config.Exchange.Name {
 	case "gate":
-		s.initGate()
+		s.ex = gateio.New(s.config.Exchange.Key, s.config.Exchange.Secret, s.config.Exchange.Host, s.Sugar)
 	case "huobi":
-		if err := s.initHuobi(); err != nil {
+		s.ex, err = huobi.New(s.config.Exchange.Label, s.config.Exchange.Key, s.config.Exchange.Secret, s.config.Exchange.Host)
+		if err != nil {
 			return err
 		}
 	default:
 		return errors.New("unsupported exchange")
 	}
+	s.hub = hub.New(s.ex)
+	s.hub.Init(s.Sugar)
 	s.Sugar.Info("Exchange initialized")
+	return nil
+}
+
+// initSymbol init the symbol, fee and candle buffer
+func (s *WsTrader) initSymbol(symbol string) (err error) {
+	s.candle = hs.NewCandle(2000)
+	s.symbol, err = s.ex.GetSymbol(context.Background(), symbol)
+	if err != nil {
+		return err
+	}
+	s.fee, err = s.ex.GetFee(s.Symbol())
+	if err != nil {
+		return err
+	}
 	s.Sugar.Infof(
 		"Symbol: %s, PricePrecision: %d, AmountPrecision: %d, MinAmount: %s, MinTotal: %s",
 		s.Symbol(),
@@ -202,32 +315,61 @@ func (s *WsTrader) initEx() error {
 		s.BaseMakerFee(), s.BaseTakerFee(),
 		s.ActualMakerFee(), s.ActualTakerFee(),
 	)
-	return nil
+	if err := s.initConditional(context.Background()); err != nil {
+		return err
+	}
+	s.margin, err = newMarginShort(context.Background(), s.config.Strategy.Margin, s.ex, s.symbol, s.coll(collNameState), s.Sugar)
+	if err != nil {
+		return err
+	}
+	return s.initLedger(context.Background())
 }
 
-func (s *WsTrader) initGate() error {
-	s.ex = gateio.New(s.config.Exchange.Key, s.config.Exchange.Secret, s.config.Exchange.Host, s.Sugar)
-	var err error
-	s.symbol, err = s.ex.GetSymbol(context.Background(), s.config.Exchange.Symbols[0])
+// initConditional load the emulated stop-loss, take-profit and trailing-stop orders, they are fired by the candle events
+func (s *WsTrader) initConditional(ctx context.Context) error {
+	conf := s.config.Strategy.Algo
+	// Gate不支持市价单，默认使用追单算法
+	if conf.Name == "" && s.config.Exchange.Name == "gate" {
+		conf.Name = executor.AlgoChase
+	}
+	algo, err := executor.NewAlgorithm(conf, s.ex, s.symbol, s.Sugar, nil)
 	if err != nil {
 		return err
 	}
-	s.fee, err = s.ex.GetFee(s.Symbol())
-	return err
+	s.conditional.Init(s.ex, s.symbol, s.Sugar, s.coll(collNameConditional), algo, s.onTriggered)
+	return s.conditional.Load(ctx)
 }
 
-func (s *WsTrader) initHuobi() error {
-	var err error
-	s.ex, err = huobi.New(s.config.Exchange.Label, s.config.Exchange.Key, s.config.Exchange.Secret, s.config.Exchange.Host)
+// initLedger reserve capital for the buy orders in the shared ledger
+func (s *WsTrader) initLedger(ctx context.Context) error {
+	conf := s.config.Ledger
+	if conf == nil {
+		return nil
+	}
+	interval, err := conf.ReconcileInterval()
 	if err != nil {
 		return err
 	}
-	s.symbol, err = s.ex.GetSymbol(context.Background(), s.config.Exchange.Symbols[0])
+	ledger, err := executor.NewLedger(ctx, *conf, s.Sugar)
 	if err != nil {
 		return err
 	}
-	s.fee, err = s.ex.GetFee(s.Symbol())
-	return err
+	if err := s.account.Init(ctx, ledger, s.allocation(), s.QuoteCurrency(), s.Symbol(), s.maxTotal); err != nil {
+		ledger.Close(ctx)
+		return err
+	}
+	s.ledger = ledger
+	s.reconcile = interval
+	s.Sugar.Infof("Ledger initialized, allocation: %s, limit: %s", s.allocation(), s.maxTotal)
+	return nil
+}
+
+// allocation is the ledger allocation name, every symbol has its own allocation
+func (s *WsTrader) allocation() string {
+	if s.namespace == "" {
+		return s.config.Ledger.Name
+	}
+	return s.config.Ledger.Name + "-" + s.namespace
 }
 
 func (s *WsTrader) initRobots(ctx context.Context) {
@@ -293,6 +435,7 @@ func (s *WsTrader) buy(symbol string, price, stopPrice decimal.Decimal, amountPr
 	if maxTotal.GreaterThan(s.maxTotal) {
 		maxTotal = s.maxTotal
 	}
+	maxTotal = s.account.Limit(maxTotal)
 	amount := maxTotal.DivRound(price, amountPrecision)
 	total := amount.Mul(price)
 	if amount.LessThan(minAmount) { //|| total.LessThan(minTotal) or total (%s / %s), total, minTotal
@@ -301,8 +444,17 @@ func (s *WsTrader) buy(symbol string, price, stopPrice decimal.Decimal, amountPr
 		s.SetPosition(1)
 		return
 	}
-	clientId := GetClientOrderId(sep, prefixBuyLimitOrder, s.ShortTimes, s.LongTimes+1, s.GetUniqueId())
+	clientId, err := s.clientOrderId(prefixBuyLimitOrder)
+	if err != nil {
+		s.Sugar.Errorf("encode client order id error: %s", err)
+		return
+	}
+	if err := s.account.Reserve(clientId, total); err != nil {
+		s.Sugar.Errorf("reserve capital error: %s", err)
+		return
+	}
 	orderId, err := s.ex.BuyLimit(symbol, clientId, price, amount)
+	s.account.Placed(clientId, orderId, err)
 	if err != nil {
 		s.Sugar.Errorf("buy error: %s", err)
 		return
@@ -320,7 +472,7 @@ func (s *WsTrader) buy(symbol string, price, stopPrice decimal.Decimal, amountPr
 
 	s.SetPosition(1)
 	s.LongTimes++
-	if err := hs.SaveInt64(context.Background(), s.db.Collection(collNameState), "longTimes", s.LongTimes); err != nil {
+	if err := hs.SaveInt64(context.Background(), s.coll(collNameState), "longTimes", s.LongTimes); err != nil {
 		s.Sugar.Infof("save longTimes error: %s", err)
 	}
 }
@@ -347,7 +499,11 @@ func (s *WsTrader) sell(symbol exchange.Symbol) {
 		s.SetPosition(-1)
 		return
 	}
-	clientId := GetClientOrderId(sep, prefixSellMarketOrder, s.ShortTimes+1, s.LongTimes, s.GetUniqueId())
+	clientId, err := s.clientOrderId(prefixSellMarketOrder)
+	if err != nil {
+		s.Sugar.Errorf("encode client order id error: %s", err)
+		return
+	}
 	orderId, err := s.ex.SellMarket(symbol, clientId, amount)
 	if err != nil {
 		s.Sugar.Errorf("sell error: %s", err)
@@ -360,13 +516,14 @@ func (s *WsTrader) sell(symbol exchange.Symbol) {
 
 	s.SetPosition(-1)
 	s.ShortTimes++
-	if err := hs.SaveInt64(context.Background(), s.db.Collection(collNameState), "shortTimes", s.ShortTimes); err != nil {
+	if err := hs.SaveInt64(context.Background(), s.coll(collNameState), "shortTimes", s.ShortTimes); err != nil {
 		s.Sugar.Infof("save shortTimes error: %s", err)
 	}
 }
 
 func (s *WsTrader) long(price, stopPrice decimal.Decimal) {
 	s.Sugar.Infof("long %s, price %s, stopPrice %s", s.Symbol(), price, stopPrice)
+	s.closeShort(price, "趋势转多")
 	s.buy(s.Symbol(), price, stopPrice, s.AmountPrecision(), s.MinAmount(), s.MinTotal())
 	if s.Reinforce() > 0 {
 		// place reinforce order
@@ -379,74 +536,58 @@ func (s *WsTrader) long(price, stopPrice decimal.Decimal) {
 
 func (s *WsTrader) short(price, stopPrice decimal.Decimal) {
 	s.Sugar.Infof("short %s, price %s, stopPrice %s", s.Symbol(), price, stopPrice)
-	if s.StopLoss() {
-		s.cancelSellStop()
+	s.cancelSellStop()
+	if err := s.conditional.CancelAll(context.Background()); err != nil {
+		s.Sugar.Errorf("cancel conditional orders error: %s", err)
 	}
 	if s.Reinforce() > 0 {
 		// cancel reinforce order
 		s.cancelReinforce()
 	}
 	s.sell(s.symbol)
+	s.openShort(price)
 }
 
-func (s *WsTrader) sellStopLimit(symbol, currency string, price decimal.Decimal,
-	amountPrecision int32, minAmount, minTotal decimal.Decimal) (*executor.SellStopOrder, error) {
-	if s.position == -1 {
-		s.Sugar.Info("position clear, no need sell-stop")
-		return nil, nil
+// openShort borrow and sell the base currency on the margin account
+func (s *WsTrader) openShort(price decimal.Decimal) {
+	if s.margin == nil {
+		return
 	}
-	balance, err := s.ex.SpotAvailableBalance()
+	clientId, err := s.clientOrderId(prefixShortSellOrder)
 	if err != nil {
-		s.Sugar.Errorf("get available balance error: %s", err)
-		return nil, err
+		s.Sugar.Errorf("encode client order id error: %s", err)
+		return
 	}
-	s.Sugar.Infof("balance: %v", balance)
-	// sell all balance
-	amount := balance[currency].Round(amountPrecision)
-	if amount.GreaterThan(balance[currency]) {
-		amount = amount.Sub(minAmount)
-	}
-	s.Sugar.Debugf("try to sell %s, balance: %v, amount: %s, price: %s, total: %s",
-		symbol, balance, amount, price, price.Mul(amount))
-	if amount.LessThan(minAmount) {
-		s.Sugar.Infof("amount too small: %s (need %s)", amount, minAmount)
-		s.SetPosition(-1)
-		s.Sugar.Info("update position to `clear`")
-		return nil, nil
+	if err := s.margin.Open(context.Background(), price, s.maxTotal, clientId); err != nil {
+		s.Sugar.Errorf("open short error: %s", err)
+		s.Broadcast("借币卖空失败，订单号: %s，错误: %s", clientId, err)
+		return
 	}
-	total := price.Mul(amount)
-	if total.LessThan(minTotal) {
-		s.Sugar.Infof("total too small: %s (need %s)", total, minTotal)
-		s.SetPosition(-1)
-		s.Sugar.Info("update position to `clear`")
-		return nil, nil
+	p := s.margin.Position()
+	s.Broadcast("借币卖空，订单号: %s\n\t借入数量: %s, 卖出均价: %s, 卖出总金额: %s\n\t杠杆: %s, 强平价格: %s",
+		clientId, p.Borrowed, p.Price, p.Income, p.Leverage, p.Liquidation)
+}
+
+// closeShort buy back and repay the loan, if there is a short position
+func (s *WsTrader) closeShort(price decimal.Decimal, reason string) {
+	if s.margin == nil || !s.margin.Position().Active() {
+		return
 	}
-	clientId := GetClientOrderId(sep, prefixSellStopOrder, s.ShortTimes, s.LongTimes, s.GetUniqueId())
-	orderId, err := s.ex.SellStopLimit(symbol, clientId, price, amount, price)
+	clientId, err := s.clientOrderId(prefixShortBuyOrder)
 	if err != nil {
-		s.Sugar.Errorf("sell-stop error: %s", err)
-		return nil, err
+		s.Sugar.Errorf("encode client order id error: %s", err)
+		return
 	}
-	o := executor.Order{
-		Id:            orderId,
-		ClientOrderId: clientId,
-		Type:          "sell-stop-limit",
+	profit, err := s.margin.Close(context.Background(), price, clientId)
+	if err != nil {
+		s.Sugar.Errorf("close short error: %s", err)
+		s.Broadcast("平空失败（%s），订单号: %s，错误: %s", reason, clientId, err)
+		return
 	}
-	s.addOrder(context.Background(), o)
-	s.Sugar.Infof("止损单，订单号: %d / %s, amount: %s", orderId, clientId, amount)
-	sso := emptySellStopOrder
-	sso.Id = orderId
-	sso.ClientOrderId = clientId
-	sso.Price = price.String()
-	sso.StopPrice = price.String()
-	sso.Amount = amount.String()
-	sso.Total = total.String()
-	sso.Time = time.Now().String()
-	sso.Status = "created"
-
-	return &sso, nil
+	s.Broadcast("平空还币（%s），订单号: %s，收益: %s %s", reason, clientId, profit, s.QuoteCurrency())
 }
 
+// cancelSellStop cancel the legacy native sell-stop-limit order, placed before the conditional orders
 func (s *WsTrader) cancelSellStop() {
 	s.sellStopLock.Lock()
 	defer s.sellStopLock.Unlock()
@@ -461,35 +602,115 @@ func (s *WsTrader) cancelSellStop() {
 	}
 }
 
-func (s *WsTrader) updateSellStop(price decimal.Decimal, force bool) {
-	s.sellStopLock.Lock()
-	defer s.sellStopLock.Unlock()
-	if !force && s.sellStopOrder.Price != "" {
-		oldStopPrice := decimal.RequireFromString(s.sellStopOrder.Price)
-		if price.Equal(oldStopPrice) {
-			s.Sugar.Debug("same stop price, no need to update")
+// placeConditional place emulated stop-loss, take-profit and trailing-stop as an OCO group,
+// the old group is replaced, so it's safe to call on every fill of the buy order
+func (s *WsTrader) placeConditional(price, stop decimal.Decimal) {
+	strategy := s.config.Strategy
+	if !s.StopLoss() && strategy.TakeProfit <= 0 && strategy.TrailPercent <= 0 && strategy.TrailAtr <= 0 {
+		return
+	}
+	if s.position == -1 {
+		s.Sugar.Info("position clear, no need conditional orders")
+		return
+	}
+	balance, err := s.ex.SpotAvailableBalance()
+	if err != nil {
+		s.Sugar.Errorf("get available balance error: %s", err)
+		return
+	}
+	amount := balance[s.BaseCurrency()].Truncate(s.AmountPrecision())
+	if amount.LessThan(s.MinAmount()) {
+		s.Sugar.Infof("amount too small: %s", amount)
+		return
+	}
+	if err := s.conditional.CancelAll(context.Background()); err != nil {
+		s.Sugar.Errorf("cancel conditional orders error: %s", err)
+		return
+	}
+	var orders []executor.ConditionalOrder
+	if s.StopLoss() {
+		orders = append(orders, executor.ConditionalOrder{
+			Type:      executor.ConditionalStopLoss,
+			Direction: exchange.TradeDirectionSell,
+			Price:     stop,
+			Amount:    amount,
+		})
+	}
+	if strategy.TakeProfit > 0 {
+		p := decimal.NewFromFloat(1 + strategy.TakeProfit/100)
+		orders = append(orders, executor.ConditionalOrder{
+			Type:      executor.ConditionalTakeProfit,
+			Direction: exchange.TradeDirectionSell,
+			Price:     price.Mul(p).Round(s.PricePrecision()),
+			Amount:    amount,
+		})
+	}
+	if strategy.TrailPercent > 0 || strategy.TrailAtr > 0 {
+		orders = append(orders, executor.ConditionalOrder{
+			Type:         executor.ConditionalTrailingStop,
+			Direction:    exchange.TradeDirectionSell,
+			Amount:       amount,
+			TrailPercent: strategy.TrailPercent,
+			TrailAtr:     strategy.TrailAtr,
+			Extreme:      price,
+		})
+	}
+	for i := range orders {
+		if orders[i].Id, err = s.clientOrderId(conditionalPrefix[orders[i].Type]); err != nil {
+			s.Sugar.Errorf("encode client order id error: %s", err)
 			return
 		}
 	}
-	s.Sugar.Debugf("try to place sell-stop-limit order, price %s", price)
-	// clear old order
-	if s.sellStopOrder.Id != 0 {
-		if err := s.ex.CancelOrder(s.Symbol(), uint64(s.sellStopOrder.Id)); err != nil {
-			s.Sugar.Errorf("cancel sell-stop-limit order error: %s", err)
-		} else {
-			s.Sugar.Infof("cancelled sell-stop-limit order %s / %d", s.sellStopOrder.ClientOrderId, s.sellStopOrder.Id)
-			s.SetSellStopOrder(emptySellStopOrder)
+	group := fmt.Sprintf("oco%s%d", sep, s.LongTimes)
+	if err := s.conditional.AddOCO(context.Background(), group, orders...); err != nil {
+		s.Sugar.Errorf("add conditional orders error: %s", err)
+		return
+	}
+	s.Broadcast("设置条件单 %d 个，止损价: %s，数量: %s", len(orders), stop, amount)
+}
+
+// updateStop move the emulated stop-loss up to the new SuperTrend stop price
+func (s *WsTrader) updateStop(stop decimal.Decimal) {
+	for _, o := range s.conditional.Pending() {
+		if o.Type != executor.ConditionalStopLoss || !stop.GreaterThan(o.Price) {
+			continue
+		}
+		if err := s.conditional.UpdatePrice(context.Background(), o.Id, stop); err != nil {
+			s.Sugar.Errorf("update stop-loss order error: %s", err)
+			continue
 		}
+		s.Sugar.Infof("stop-loss order %s moved from %s to %s", o.Id, o.Price, stop)
 	}
+}
 
-	// place new order
-	if sso, err := s.sellStopLimit(
-		s.Symbol(), s.BaseCurrency(), price,
-		s.AmountPrecision(), s.MinAmount(), s.MinTotal(),
-	); err != nil {
-		s.Sugar.Errorf("sell-stop error: %s", err)
-	} else if sso != nil {
-		s.SetSellStopOrder(*sso)
+// onTriggered is called in the conditional engine goroutine, the position is updated with the lock of the event handler
+func (s *WsTrader) onTriggered(o executor.ConditionalOrder, p executor.Progress, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handleTriggered(o, p, err)
+}
+
+func (s *WsTrader) handleTriggered(o executor.ConditionalOrder, p executor.Progress, err error) {
+	if err != nil {
+		if p.FilledAmount.IsZero() {
+			// the engine restored the order and its OCO group
+			s.Broadcast("条件单触发失败，已恢复，下次触发时重试，类型: %s，订单号: %s，触发价格: %s，错误: %s", o.Type, o.Id, o.Price, err)
+			return
+		}
+		s.Broadcast("条件单触发失败，部分成交，类型: %s，订单号: %s，触发价格: %s，错误: %s", o.Type, o.Id, o.Price, err)
+	}
+	s.Broadcast("条件单触发，类型: %s，订单号: %s，触发价格: %s，成交数量: %s，成交总金额: %s",
+		o.Type, o.Id, o.Price, p.FilledAmount, p.FilledTotal)
+	if o.Direction == exchange.TradeDirectionSell && p.FilledAmount.IsPositive() {
+		if s.ledger != nil {
+			s.account.Credit(p.FilledTotal)
+		}
+		s.SetPosition(-1)
+		s.SetExited(true)
+		s.ShortTimes++
+		if err := hs.SaveInt64(context.Background(), s.coll(collNameState), "shortTimes", s.ShortTimes); err != nil {
+			s.Sugar.Infof("save shortTimes error: %s", err)
+		}
 	}
 }
 
@@ -519,14 +740,24 @@ func (s *WsTrader) reinforceBuy(price decimal.Decimal) {
 	if maxTotal.GreaterThan(s.maxTotal) {
 		maxTotal = s.maxTotal
 	}
+	maxTotal = s.account.Limit(maxTotal)
 	amount := maxTotal.DivRound(price, s.AmountPrecision())
 	total := amount.Mul(price)
 	if amount.LessThan(s.MinAmount()) {
 		s.Sugar.Infof("amount (%s / %s) is too small", amount, s.MinAmount())
 		return
 	}
-	clientId := GetClientOrderId(sep, prefixBuyReinforceOrder, s.ShortTimes, s.LongTimes+1, s.GetUniqueId())
+	clientId, err := s.clientOrderId(prefixBuyReinforceOrder)
+	if err != nil {
+		s.Sugar.Errorf("encode client order id error: %s", err)
+		return
+	}
+	if err := s.account.Reserve(clientId, total); err != nil {
+		s.Sugar.Errorf("reserve capital error: %s", err)
+		return
+	}
 	orderId, err := s.ex.BuyLimit(s.Symbol(), clientId, price, amount)
+	s.account.Placed(clientId, orderId, err)
 	if err != nil {
 		s.Sugar.Errorf("buy error: %s", err)
 		return
@@ -538,7 +769,7 @@ func (s *WsTrader) reinforceBuy(price decimal.Decimal) {
 		Updated:       time.Now(),
 	}
 	s.reinforceBuyOrder.Order = o
-	coll := s.db.Collection(collNameState)
+	coll := s.coll(collNameState)
 	if err := hs.SaveKey(context.Background(), coll, s.reinforceBuyOrder.Name, s.reinforceBuyOrder); err != nil {
 		s.Sugar.Errorf("save reinforceOrder error: %s", err)
 	}
@@ -575,7 +806,11 @@ func (s *WsTrader) reinforceSell(price, amount decimal.Decimal) {
 		return
 	}
 	price = price.Round(s.PricePrecision())
-	clientId := GetClientOrderId(sep, prefixSellReinforceOrder, s.ShortTimes+1, s.LongTimes, s.GetUniqueId())
+	clientId, err := s.clientOrderId(prefixSellReinforceOrder)
+	if err != nil {
+		s.Sugar.Errorf("encode client order id error: %s", err)
+		return
+	}
 	orderId, err := s.ex.SellLimit(s.Symbol(), clientId, price, amount)
 	if err != nil {
 		s.Sugar.Errorf("sell error: %s", err)
@@ -583,7 +818,7 @@ func (s *WsTrader) reinforceSell(price, amount decimal.Decimal) {
 	}
 	o := executor.Order{Id: orderId, ClientOrderId: clientId}
 	s.reinforceSellOrder.Order = o
-	coll := s.db.Collection(collNameState)
+	coll := s.coll(collNameState)
 	if err := hs.SaveKey(context.Background(), coll, s.reinforceSellOrder.Name, s.reinforceSellOrder); err != nil {
 		s.Sugar.Errorf("save reinforceOrder error: %s", err)
 	}
@@ -602,7 +837,7 @@ func (s *WsTrader) realCancelReinforce(o *executor.ReinforceOrder) {
 		s.Sugar.Infof("cancelled reinforce order %s / %d",
 			o.ClientOrderId, o.Id)
 		o.Clear()
-		coll := s.db.Collection(collNameState)
+		coll := s.coll(collNameState)
 		if err := hs.SaveKey(context.Background(), coll, o.Name, o); err != nil {
 			s.Sugar.Errorf("save reinforceOrder error: %s", err)
 		}
@@ -625,54 +860,45 @@ func (s *WsTrader) Broadcast(format string, a ...interface{}) {
 	}
 }
 
-func (s *WsTrader) tickerHandler(resp interface{}) {
-	candlestickResponse, ok := resp.(market.SubscribeCandlestickResponse)
-	if ok {
-		if &candlestickResponse != nil {
-			if candlestickResponse.Tick != nil {
-				tick := candlestickResponse.Tick
-				//s.Sugar.Debugf("Tick, id: %d, count: %v, amount: %v, volume: %v, OHLC[%v, %v, %v, %v]",
-				//	tick.Id, tick.Count, tick.Amount, tick.Vol, tick.Open, tick.High, tick.Low, tick.Close)
-				ticker := hs.Ticker{
-					Timestamp: tick.Id,
-				}
-				ticker.Open, _ = tick.Open.Float64()
-				ticker.High, _ = tick.High.Float64()
-				ticker.Low, _ = tick.Low.Float64()
-				ticker.Close, _ = tick.Close.Float64()
-				ticker.Volume, _ = tick.Vol.Float64()
-				if s.candle.Length() > 0 {
-					oldTime := s.candle.Timestamp[s.candle.Length()-1]
-					newTime := ticker.Timestamp
-					s.candle.Append(ticker)
-					if oldTime != newTime {
-						s.onTick(s.dry)
-					}
-				} else {
-					s.Sugar.Info("candle is not ready for append")
-				}
-			}
+func (s *WsTrader) eventHandler(e hub.Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch e.Type {
+	case hub.EventOrder:
+		s.OrderUpdateHandler(e.Raw)
+	case hub.EventCandleUpdate, hub.EventCandleClosed:
+		s.candleHandler(e)
+	}
+}
 
-			if candlestickResponse.Data != nil {
-				//s.Sugar.Debugf("Candlestick(candle) update, last timestamp: %d", candlestickResponse.Data[len(candlestickResponse.Data)-1].Id)
-				for _, tick := range candlestickResponse.Data {
-					//s.Sugar.Infof("Candlestick data[%d], id: %d, count: %v, volume: %v, OHLC[%v, %v, %v, %v]",
-					//	i, tick.Id, tick.Count, tick.Vol, tick.Open, tick.High, tick.Low, tick.Close)
-					ticker := hs.Ticker{
-						Timestamp: tick.Id,
-					}
-					ticker.Open, _ = tick.Open.Float64()
-					ticker.High, _ = tick.High.Float64()
-					ticker.Low, _ = tick.Low.Float64()
-					ticker.Close, _ = tick.Close.Float64()
-					ticker.Volume, _ = tick.Vol.Float64()
-					s.candle.Append(ticker)
-				}
-				s.onCandle(s.dry)
-			}
+func (s *WsTrader) candleHandler(e hub.Event) {
+	if e.Candle != nil {
+		//s.Sugar.Debugf("Candlestick(candle) update, length: %d", e.Candle.Length())
+		c := e.Candle
+		for i := 0; i < c.Length(); i++ {
+			s.candle.Append(hs.Ticker{
+				Timestamp: c.Timestamp[i],
+				Open:      c.Open[i],
+				High:      c.High[i],
+				Low:       c.Low[i],
+				Close:     c.Close[i],
+				Volume:    c.Volume[i],
+			})
+		}
+		s.onCandle(s.dry)
+		return
+	}
+	if s.candle.Length() > 0 {
+		s.candle.Append(e.Ticker)
+		if !s.dry && len(s.conditional.Pending()) > 0 {
+			// fired in the watch goroutine, the handler need the lock
+			s.conditional.Feed(decimal.NewFromFloat(e.Ticker.Close))
+		}
+		if e.Type == hub.EventCandleClosed {
+			s.onTick(s.dry)
 		}
 	} else {
-		s.Sugar.Warn("Unknown response: %v", resp)
+		s.Sugar.Info("candle is not ready for append")
 	}
 }
 
@@ -703,6 +929,10 @@ func (s *WsTrader) OrderUpdateHandler(response interface{}) {
 			s.Sugar.Debugf("no clientOrderId, not my order %d", o.OrderId)
 			return
 		}
+		if !belongs(s.config, s.Symbol(), o.ClientOrderId) {
+			s.Sugar.Debugf("order %d / %s belongs to other strategy", o.OrderId, o.ClientOrderId)
+			return
+		}
 		//s.Sugar.Debugf("Order update, event: %s, symbol: %s, type: %s, id: %d, clientId: %s, status: %s",
 		//	o.EventType, o.Symbol, o.Type, o.OrderId, o.ClientOrderId, o.OrderStatus)
 		o2 := executor.Order{
@@ -778,28 +1008,40 @@ func (s *WsTrader) onTick(dry bool) {
 	price := decimal.NewFromFloat(math.Min(s.candle.Close[l-1], s.candle.Close[l-2]))
 	stop := decimal.NewFromFloat(tsl[l-2]).Round(s.PricePrecision())
 
-	if trend[l-2] && (!trend[l-3] || s.position != 1) {
+	switch superSignal(trend[l-3], trend[l-2], s.position, s.exited) {
+	case 1:
 		// false -> true, buy/long
 		s.Sugar.Info("[Signal] BUY")
 		s.trend = 1
+		if s.exited {
+			s.SetExited(false)
+		}
 		if !dry {
 			go s.long(price, stop)
 		}
-	} else if !trend[l-2] && trend[l-3] {
+	case -1:
 		// true -> false, sell/short
 		s.Sugar.Info("[Signal] SELL")
 		s.trend = -1
 		if !dry {
 			go s.short(price, stop)
 		}
-	} else if s.StopLoss() && s.position == 1 {
-		// update sell-stop-limit order
-		go s.updateSellStop(stop, false)
+	default:
+		if s.position == 1 {
+			// update emulated stop-loss order
+			s.updateStop(stop)
+		} else if s.exited && trend[l-2] {
+			s.Sugar.Info("exited by conditional order, wait for the next up-flip")
+		}
+	}
+	if s.config.Strategy.TrailAtr > 0 {
+		atr := talib.Atr(s.candle.High, s.candle.Low, s.candle.Close, s.config.Strategy.Period)
+		s.conditional.UpdateAtr(context.Background(), decimal.NewFromFloat(atr[l-2]))
 	}
 }
 
 func (s *WsTrader) loadState(ctx context.Context) {
-	coll := s.db.Collection(collNameState)
+	coll := s.coll(collNameState)
 	if uniqueId, err := hs.LoadInt64(ctx, coll, "uniqueId"); err != nil {
 		s.Sugar.Fatalf("load UniqueId error: %s", err)
 	} else if uniqueId != 0 {
@@ -824,6 +1066,12 @@ func (s *WsTrader) loadState(ctx context.Context) {
 		s.ShortTimes = shortTimes
 		s.Sugar.Infof("loaded shortTimes: %d", shortTimes)
 	}
+	if exited, err := hs.LoadInt64(ctx, coll, "exited"); err != nil {
+		s.Sugar.Errorf("load exited error: %s", err)
+	} else if exited != 0 {
+		s.exited = true
+		s.Sugar.Info("loaded exited, wait for the next up-flip")
+	}
 	if s.StopLoss() {
 		sellStopOrder := emptySellStopOrder
 		if err := hs.LoadKey(ctx, coll, "sellStopOrder", &sellStopOrder); err != nil {
@@ -852,8 +1100,8 @@ func (s *WsTrader) loadState(ctx context.Context) {
 }
 
 func (s *WsTrader) GetUniqueId() int64 {
-	s.uniqueId = (s.uniqueId + 1) % 10000
-	coll := s.db.Collection(collNameState)
+	s.uniqueId++
+	coll := s.coll(collNameState)
 	if err := hs.SaveInt64(context.Background(), coll, "uniqueId", s.uniqueId); err != nil {
 		s.Sugar.Errorf("save uniqueId error: %s", err)
 	}
@@ -861,13 +1109,26 @@ func (s *WsTrader) GetUniqueId() int64 {
 }
 func (s *WsTrader) SetPosition(newPosition int64) {
 	s.position = newPosition
-	coll := s.db.Collection(collNameState)
+	coll := s.coll(collNameState)
 	if err := hs.SaveInt64(context.Background(), coll, "position", s.position); err != nil {
 		s.Sugar.Errorf("save position error: %s", err)
 	}
 }
+
+// SetExited save whether the position is closed by a conditional order
+func (s *WsTrader) SetExited(exited bool) {
+	s.exited = exited
+	var v int64
+	if exited {
+		v = 1
+	}
+	if err := hs.SaveInt64(context.Background(), s.coll(collNameState), "exited", v); err != nil {
+		s.Sugar.Errorf("save exited error: %s", err)
+	}
+}
+
 func (s *WsTrader) SetSellStopOrder(newOrder executor.SellStopOrder) {
-	coll := s.db.Collection(collNameState)
+	coll := s.coll(collNameState)
 	s.sellStopOrder = newOrder
 	if err := hs.SaveKey(context.Background(), coll, s.sellStopOrder.Name, s.sellStopOrder); err != nil {
 		s.Sugar.Errorf("save sellStopOrder error: %s", err)
@@ -875,7 +1136,7 @@ func (s *WsTrader) SetSellStopOrder(newOrder executor.SellStopOrder) {
 }
 
 func (s *WsTrader) clearState(ctx context.Context) {
-	coll := s.db.Collection(collNameState)
+	coll := s.coll(collNameState)
 	if err := hs.DeleteInt64(ctx, coll, "longTimes"); err != nil {
 		s.Sugar.Errorf("delete longTimes error: %s", err)
 	} else {
@@ -891,6 +1152,11 @@ func (s *WsTrader) clearState(ctx context.Context) {
 	} else {
 		s.Sugar.Info("delete sellStopOrder from database")
 	}
+	if err := hs.DeleteInt64(ctx, coll, "exited"); err != nil {
+		s.Sugar.Errorf("delete exited error: %s", err)
+	} else {
+		s.Sugar.Info("delete exited from database")
+	}
 }
 
 func (s *WsTrader) checkState(ctx context.Context) {
@@ -899,14 +1165,14 @@ func (s *WsTrader) checkState(ctx context.Context) {
 }
 
 func (s *WsTrader) addOrder(ctx context.Context, o executor.Order) {
-	coll := s.db.Collection(collNameOrder)
+	coll := s.coll(collNameOrder)
 	if _, err := coll.InsertOne(ctx, o); err != nil {
 		s.Sugar.Errorf("add order error: %s", err)
 	}
 }
 
 func (s *WsTrader) createOrder(ctx context.Context, o executor.Order) {
-	coll := s.db.Collection(collNameOrder)
+	coll := s.coll(collNameOrder)
 	option := options.FindOneAndUpdate().SetUpsert(true)
 	if r := coll.FindOneAndUpdate(
 		ctx,
@@ -931,7 +1197,7 @@ func (s *WsTrader) createOrder(ctx context.Context, o executor.Order) {
 func (s *WsTrader) fillOrder(ctx context.Context, o executor.Order, t executor.Trade) {
 	s.Broadcast("订单成交(%s), 订单号: %d / %s, 价格: %s, 数量: %s, 交易额: %s",
 		o.Status, o.Id, o.ClientOrderId, t.Price, t.Amount, t.Total)
-	coll := s.db.Collection(collNameOrder)
+	coll := s.coll(collNameOrder)
 	option := options.FindOneAndUpdate().SetUpsert(true)
 	r := coll.FindOneAndUpdate(
 		ctx,
@@ -952,19 +1218,18 @@ func (s *WsTrader) fillOrder(ctx context.Context, o executor.Order, t executor.T
 	if r.Err() != nil {
 		s.Sugar.Errorf("fill order error: %s", r.Err())
 	}
+	s.credit(o, t)
+	s.settle(o)
 
-	if strings.HasPrefix(o.ClientOrderId, prefixSellStopOrder) {
+	if executor.IsPrefix(o.ClientOrderId, prefixSellStopOrder) {
 		if o.Status == "filled" {
 			s.SetPosition(-1)
 			s.Sugar.Infof("sell-stop order %d is filled, change position to -1 (clear)", o.Id)
 			s.SetSellStopOrder(emptySellStopOrder)
 		}
 		// place buy-stop order?
-	} else if strings.HasPrefix(o.ClientOrderId, prefixBuyLimitOrder) {
+	} else if executor.IsPrefix(o.ClientOrderId, prefixBuyLimitOrder) {
 		s.Sugar.Infof("buy order %d / %s %s", o.Id, o.ClientOrderId, o.Status)
-		if !s.StopLoss() {
-			return
-		}
 		// find stopPrice
 		old := executor.Order{}
 		if err := r.Decode(&old); err != nil {
@@ -981,8 +1246,13 @@ func (s *WsTrader) fillOrder(ctx context.Context, o executor.Order, t executor.T
 			s.Sugar.Errorf("parse stopPrice error: %s", err1)
 			return
 		}
-		s.updateSellStop(stopPrice, true)
-	} else if strings.HasPrefix(o.ClientOrderId, prefixBuyReinforceOrder) {
+		price, err2 := decimal.NewFromString(t.Price)
+		if err2 != nil {
+			s.Sugar.Errorf("bad trade price: %s", err2)
+			return
+		}
+		s.placeConditional(price, stopPrice)
+	} else if executor.IsPrefix(o.ClientOrderId, prefixBuyReinforceOrder) {
 		// place sell
 		price, err := decimal.NewFromString(t.Price)
 		if err != nil {
@@ -998,7 +1268,7 @@ func (s *WsTrader) fillOrder(ctx context.Context, o executor.Order, t executor.T
 		s.Sugar.Debugf("p is %s", p)
 		price = price.DivRound(p.Mul(p), s.PricePrecision())
 		s.reinforceSell(price, amount)
-	} else if strings.HasPrefix(o.ClientOrderId, prefixSellReinforceOrder) {
+	} else if executor.IsPrefix(o.ClientOrderId, prefixSellReinforceOrder) {
 		// place buy
 		price, err := decimal.NewFromString(t.Price)
 		if err != nil {
@@ -1013,12 +1283,48 @@ func (s *WsTrader) fillOrder(ctx context.Context, o executor.Order, t executor.T
 }
 func (s *WsTrader) cancelOrder(ctx context.Context, o executor.Order) {
 	s.updateOrderStatus(ctx, o)
+	s.settle(o)
 }
 func (s *WsTrader) deleteOrder(ctx context.Context, o executor.Order) {
 	s.updateOrderStatus(ctx, o)
+	s.settle(o)
+}
+
+// settle the finished buy order in the shared ledger, the unfilled part is released
+func (s *WsTrader) settle(o executor.Order) {
+	if s.ledger == nil || !executor.OrderFinished(o.Status) {
+		return
+	}
+	if !executor.IsPrefix(o.ClientOrderId, prefixBuyLimitOrder) && !executor.IsPrefix(o.ClientOrderId, prefixBuyReinforceOrder) {
+		return
+	}
+	o2, err := s.ex.GetOrderById(o.Id, s.Symbol())
+	if err != nil {
+		s.Sugar.Errorf("get order %d error: %s", o.Id, err)
+		return
+	}
+	o2.ClientOrderId = o.ClientOrderId
+	s.account.Settle(o2)
+}
+
+// credit the sell income to the shared ledger
+func (s *WsTrader) credit(o executor.Order, t executor.Trade) {
+	if s.ledger == nil {
+		return
+	}
+	// the fired conditional orders are credited in handleTriggered
+	if !executor.IsPrefix(o.ClientOrderId, prefixSellMarketOrder) && !executor.IsPrefix(o.ClientOrderId, prefixSellReinforceOrder) {
+		return
+	}
+	income, err := decimal.NewFromString(t.Total)
+	if err != nil {
+		s.Sugar.Errorf("bad trade total: %s", err)
+		return
+	}
+	s.account.Credit(income)
 }
 func (s *WsTrader) updateOrderStatus(ctx context.Context, o executor.Order) {
-	coll := s.db.Collection(collNameOrder)
+	coll := s.coll(collNameOrder)
 	option := options.FindOneAndUpdate().SetUpsert(true)
 	if r := coll.FindOneAndUpdate(
 		ctx,
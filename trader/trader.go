@@ -2,14 +2,14 @@ package trader
 
 import "context"
 
+// Trader is the lifecycle shared by the traders and the strategy runner
 type Trader interface {
+	// Init allocate the resource, connect to database and exchange
+	Init(ctx context.Context) error
 	// Close release resource allocated by New or Init
 	Close(ctx context.Context)
-	Print(ctx context.Context)
-	Clear(ctx context.Context)
-	// ws, start / stop
-	Start(ctx context.Context)
-	Stop(ctx context.Context)
-	// Restful, run once
-	Run(ctx context.Context)
+	Print(ctx context.Context) error
+	Clear(ctx context.Context) error
+	// Start run until ctx is done
+	Start(ctx context.Context) error
 }
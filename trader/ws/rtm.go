@@ -18,8 +18,11 @@ type RtmTraderConfig struct {
 	Exchange hs.ExchangeConf
 	Mongo    hs.MongoConf
 	Strategy strategy.RtmStrategyConf
+	Ledger   *executor.LedgerConf // shared capital ledger, nil means use the whole balance
 	Log      hs.LogConf
 	Robots   []hs.BroadcastConf
+	Paper    executor.PaperConf // virtual balance and fee, only used in paper mode
+	Algo     executor.AlgoConf  // execution algorithm of the market orders, default is market order, or chase on gate
 }
 
 var (
@@ -28,19 +31,23 @@ var (
 )
 
 type RtmTrader struct {
-	config   RtmTraderConfig
-	maxTotal decimal.Decimal
-	dry      bool
+	config    RtmTraderConfig
+	maxTotal  decimal.Decimal
+	dry       bool
+	namespace string // state namespace, only used in paper mode
 
 	Sugar  *zap.SugaredLogger
 	db     *mongo.Database
 	ex     *executor.Executor
 	robots []broadcast.Broadcaster
 
+	ledger    *executor.Ledger
+	reconcile time.Duration // ledger reconcile interval
+
 	strategy *strategy.RTMStrategy
 }
 
-func NewRtmTrader(ctx context.Context, configFilename string, dry bool) (*RtmTrader, error) {
+func NewRtmTrader(ctx context.Context, configFilename string, dry, paper bool) (*RtmTrader, error) {
 	cfg := RtmTraderConfig{}
 	err := hs.ParseJsonConfig(configFilename, &cfg)
 	if err != nil {
@@ -56,6 +63,12 @@ func NewRtmTrader(ctx context.Context, configFilename string, dry bool) (*RtmTra
 	if err != nil {
 		return nil, err
 	}
+	if paper {
+		// the paper account and state never mix with the live ones
+		s.namespace = rtmName + "_paper"
+		s.ex.SetNamespace(s.namespace)
+		s.ex.UsePaper(cfg.Paper)
+	}
 	err = s.Init(ctx)
 	return s, err
 }
@@ -71,6 +84,15 @@ func (t *RtmTrader) Init(ctx context.Context) error {
 	t.db = db
 	t.initRobots()
 	t.ex.Init(t.Sugar, t.db, t.maxTotal)
+	t.ex.SetStrategy(rtmName)
+	t.ex.SetAlgo(t.config.Algo)
+	if paper := t.ex.Paper(); paper != nil {
+		paper.SetBroadcaster(t.Broadcast)
+		t.Sugar.Infof("This is paper trading, namespace: %s", t.namespace)
+	}
+	if err := t.initLedger(ctx); err != nil {
+		return err
+	}
 	t.strategy.Init(t.Sugar, t.ex)
 
 	t.Sugar.Info("RTM Trader initialized")
@@ -78,6 +100,9 @@ func (t *RtmTrader) Init(ctx context.Context) error {
 }
 
 func (t *RtmTrader) Close(ctx context.Context) {
+	if t.ledger != nil {
+		t.ledger.Close(ctx)
+	}
 	if t.db != nil {
 		_ = t.db.Client().Disconnect(ctx)
 	}
@@ -130,6 +155,9 @@ func (t *RtmTrader) Start(ctx context.Context, dry bool) error {
 	if t.dry {
 		t.Sugar.Info("This is dry-run")
 	}
+	if t.ledger != nil {
+		go t.ledger.Start(ctx, t.ex.Exchange(), t.reconcile)
+	}
 
 	// setup order subscriber
 
@@ -169,6 +197,30 @@ func (t *RtmTrader) initLogger() error {
 //	return nil
 //}
 
+// initLedger let the executor reserve capital for its buy orders in the shared ledger
+func (t *RtmTrader) initLedger(ctx context.Context) error {
+	conf := t.config.Ledger
+	if conf == nil {
+		return nil
+	}
+	interval, err := conf.ReconcileInterval()
+	if err != nil {
+		return err
+	}
+	ledger, err := executor.NewLedger(ctx, *conf, t.Sugar)
+	if err != nil {
+		return err
+	}
+	if err := t.ex.SetLedger(ctx, ledger, conf.Name); err != nil {
+		ledger.Close(ctx)
+		return err
+	}
+	t.ledger = ledger
+	t.reconcile = interval
+	t.Sugar.Infof("Ledger initialized, allocation: %s, limit: %s", conf.Name, t.maxTotal)
+	return nil
+}
+
 func (t *RtmTrader) initRobots() {
 	for _, conf := range t.config.Robots {
 		t.robots = append(t.robots, broadcast.New(conf))
@@ -199,13 +251,17 @@ func (t *RtmTrader) loadState(ctx context.Context) error {
 	return nil
 }
 
-const collNameState = "state"
+const (
+	collNameState = "state"
+
+	rtmName = "rtm"
+)
 
 func (t *RtmTrader) clearState(ctx context.Context) {
 	//t.ClientIdManager.LongReset(ctx)
 	//t.ClientIdManager.ShortReset(ctx)
 
-	coll := t.db.Collection(collNameState)
+	coll := t.db.Collection(executor.CollName(t.namespace, collNameState))
 	if err := hs.DeleteInt64(ctx, coll, "sellStopOrder"); err != nil {
 		t.Sugar.Errorf("delete sellStopOrder error: %s", err)
 	} else {